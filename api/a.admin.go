@@ -0,0 +1,51 @@
+package api
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-feesh/logger"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// bearer token check. Admin api is hidden if the token is not configured
+func (a *Api) adminAuth(c *fiber.Ctx) error {
+	token := a.core.Cfg.AdminToken
+	if token == "" {
+		return apiError(c, http.StatusNotFound)
+	}
+	got, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return apiError(c, http.StatusUnauthorized)
+	}
+	return c.Next()
+}
+
+// @Summary Download mempool snapshot
+// @Description Current pool as gzip compressed json lines: a header with the format version, network and height,
+// @Description then a tx per line, newest first. Same format as POOL_SNAPSHOT_FILE
+// @Tags admin
+// @Produce  application/gzip
+// @Security AdminToken
+// @Success 200 {file} file
+// @Failure 401 {object} APIError
+// @Router /admin/pool/snapshot [get]
+func (a *Api) PoolSnapshot(c *fiber.Ctx) error {
+	log := logger.Log.WithField("context", "[api]")
+	snap := a.core.Snapshot()
+	name := fmt.Sprintf("feesh-mempool-%d-%d.jsonl.gz", snap.Height, time.Now().Unix())
+	c.Set(fiber.HeaderContentType, "application/gzip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, name))
+	// streamed, status is sent before the pool is written
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		if err := a.core.ExportPool(w); err != nil {
+			log.Errorf("error on pool snapshot export: %v\n", err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/1F47E/go-feesh/core"
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type BlockStatsResponse struct {
+	mblock.Block
+	Fullness     float64 `json:"fullness"`
+	SegwitShare  float64 `json:"segwit_share"`
+	TaprootShare float64 `json:"taproot_share"`
+}
+
+func newBlockStatsResponse(b mblock.Block) BlockStatsResponse {
+	return BlockStatsResponse{
+		Block:        b,
+		Fullness:     b.Fullness(),
+		SegwitShare:  b.SegwitShare(),
+		TaprootShare: b.TaprootShare(),
+	}
+}
+
+// max blocks and block txs per page
+const (
+	blocksMaxLimit   = 1000
+	blockTxsMaxLimit = 1000
+	// above the tx count of any valid block
+	blockTxsMaxOffset = 100_000
+)
+
+type BlockTxsResponse struct {
+	Hash   string   `json:"hash"`
+	Total  int      `json:"total"` // txs in the block
+	Offset int      `json:"offset"`
+	Txs    []mtx.Tx `json:"txs"` // txs not parsed yet have the hash only
+}
+
+// @Summary Get parsed blocks stats
+// @Description Get stats of the parsed blocks, newest first. Kept for the last BLOCKS_HISTORY blocks.
+// @Description Next page is the blocks before the height of the last one
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param before query int false "Blocks below the height"
+// @Param limit query int false "Page size, 100 by default, up to 1000"
+// @Success 200 {array} BlockStatsResponse
+// @Failure 400 {object} APIError
+// @Router /blocks [get]
+func (a *Api) Blocks(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > blocksMaxLimit {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", blocksMaxLimit))
+	}
+	before := math.MaxInt
+	if s := c.Query("before"); s != "" {
+		h, err := strconv.Atoi(s)
+		if err != nil || h < 0 {
+			return apiError(c, http.StatusBadRequest, "Invalid before, use block height")
+		}
+		before = h
+	}
+	ret := make([]BlockStatsResponse, 0)
+	for _, b := range a.core.GetBlocks() {
+		if len(ret) >= limit {
+			break
+		}
+		if b.Height < before {
+			ret = append(ret, newBlockStatsResponse(b))
+		}
+	}
+	return apiSuccess(c, ret)
+}
+
+// @Summary Get block stats
+// @Description Get stats of the parsed block by hash or height
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param hash path string true "Block hash or height"
+// @Success 200 {object} BlockStatsResponse
+// @Failure 404 {object} APIError
+// @Router /blocks/{hash} [get]
+func (a *Api) Block(c *fiber.Ctx) error {
+	b, ok := a.blockByHashOrHeight(c.Params("hash"))
+	if !ok {
+		return apiError(c, http.StatusNotFound, "Block not found")
+	}
+	return apiSuccess(c, newBlockStatsResponse(b))
+}
+
+// @Summary Get block txs
+// @Description Parsed txs of the block in the block order, coinbase first.
+// @Description Kept for the last BLOCKS_PARSING_DEPTH blocks
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param hash path string true "Block hash"
+// @Param offset query int false "Position in the block to start from, up to 100000"
+// @Param limit query int false "Page size, 100 by default, up to 1000"
+// @Success 200 {object} BlockTxsResponse
+// @Failure 400 {object} APIError
+// @Failure 404 {object} APIError
+// @Failure 500 {object} APIError
+// @Router /blocks/{hash}/txs [get]
+func (a *Api) BlockTxs(c *fiber.Ctx) error {
+	hash := c.Params("hash")
+	offset := c.QueryInt("offset", 0)
+	limit := c.QueryInt("limit", 100)
+	if offset < 0 || offset > blockTxsMaxOffset {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid offset, use 0 to %d", blockTxsMaxOffset))
+	}
+	if limit < 1 || limit > blockTxsMaxLimit {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", blockTxsMaxLimit))
+	}
+	txs, total, err := a.core.GetBlockTxs(hash, offset, limit)
+	if errors.Is(err, core.ErrBlockNotFound) {
+		return apiError(c, http.StatusNotFound, "Block not found")
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	}
+	return apiSuccess(c, BlockTxsResponse{Hash: hash, Total: total, Offset: offset, Txs: txs})
+}
+
+// block from the window by hash or height
+func (a *Api) blockByHashOrHeight(s string) (mblock.Block, bool) {
+	if len(s) < 64 {
+		h, err := strconv.Atoi(s)
+		if err != nil {
+			return mblock.Block{}, false
+		}
+		return a.core.GetBlockByHeight(h)
+	}
+	return a.core.GetBlockByHash(s)
+}
+
+// @Summary Get block audit
+// @Description Compare the mined block with the txs projected to fit in it just before it arrived
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param hash path string true "Block hash"
+// @Success 200 {object} mblock.Audit
+// @Failure 404 {object} APIError
+// @Failure 500 {object} APIError
+// @Router /blocks/{hash}/audit [get]
+func (a *Api) BlockAudit(c *fiber.Ctx) error {
+	audit, err := a.core.GetBlockAudit(c.Params("hash"))
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	}
+	if audit == nil {
+		return apiError(c, http.StatusNotFound, "Audit not found")
+	}
+	return apiSuccess(c, audit)
+}
+
+// @Summary Get blocks audits
+// @Description Audits of the last blocks, newest first. Kept for the last BLOCKS_HISTORY blocks
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param limit query int false "Blocks to look at, 100 by default, up to 1000"
+// @Success 200 {array} mblock.Audit
+// @Failure 400 {object} APIError
+// @Failure 500 {object} APIError
+// @Router /audits [get]
+func (a *Api) BlockAudits(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > blocksMaxLimit {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", blocksMaxLimit))
+	}
+	audits, err := a.core.GetBlockAudits(limit)
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	}
+	return apiSuccess(c, audits)
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/mempool"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// esplora compatible endpoints, same paths and JSON shapes as https://github.com/Blockstream/esplora/blob/master/API.md
+// for the light wallets read paths. Data comes from the node and the storage, errors are plain text like upstream
+
+// txs in the recent pool list
+const esploraRecentLimit = 10
+
+// parent txs fetched from the node per tx, known ones are not counted
+const esploraPrevoutsFetchLimit = 25
+
+// coinbase input prevout
+const coinbaseVout = math.MaxUint32
+
+var coinbaseTxid = strings.Repeat("0", 64)
+
+// node script types to esplora ones
+var esploraScriptTypes = map[string]string{
+	"pubkey":                "p2pk",
+	"pubkeyhash":            "p2pkh",
+	"scripthash":            "p2sh",
+	"witness_v0_keyhash":    "v0_p2wpkh",
+	"witness_v0_scripthash": "v0_p2wsh",
+	"witness_v1_taproot":    "v1_p2tr",
+	"nulldata":              "op_return",
+}
+
+type EsploraTx struct {
+	Txid     string           `json:"txid"`
+	Version  int              `json:"version"`
+	Locktime int              `json:"locktime"`
+	Vin      []EsploraVin     `json:"vin"`
+	Vout     []EsploraVout    `json:"vout"`
+	Size     int              `json:"size"`
+	Weight   int              `json:"weight"`
+	Fee      uint64           `json:"fee"`
+	Status   TxStatusResponse `json:"status"`
+}
+
+type EsploraVin struct {
+	Txid         string       `json:"txid"`
+	Vout         uint32       `json:"vout"`
+	Prevout      *EsploraVout `json:"prevout"` // null for coinbase and unknown parents
+	ScriptSig    string       `json:"scriptsig"`
+	ScriptSigAsm string       `json:"scriptsig_asm"`
+	Witness      []string     `json:"witness,omitempty"`
+	IsCoinbase   bool         `json:"is_coinbase"`
+	Sequence     uint64       `json:"sequence"`
+}
+
+type EsploraVout struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyAsm     string `json:"scriptpubkey_asm"`
+	ScriptPubKeyType    string `json:"scriptpubkey_type"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address,omitempty"`
+	Value               uint64 `json:"value"`
+}
+
+type EsploraBlock struct {
+	ID                string  `json:"id"`
+	Height            int     `json:"height"`
+	Version           int     `json:"version"`
+	Timestamp         int     `json:"timestamp"`
+	TxCount           int     `json:"tx_count"`
+	Size              int     `json:"size"`
+	Weight            int     `json:"weight"`
+	MerkleRoot        string  `json:"merkle_root"`
+	PreviousBlockHash string  `json:"previousblockhash"`
+	MedianTime        int     `json:"mediantime"`
+	Nonce             int     `json:"nonce"`
+	Bits              uint32  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+}
+
+type EsploraRecentTx struct {
+	Txid  string `json:"txid"`
+	Fee   uint64 `json:"fee"`
+	VSize uint32 `json:"vsize"`
+	Value uint64 `json:"value"`
+}
+
+func (a *Api) esploraRoutes(r fiber.Router) {
+	r.Get("/tx/:txid", a.EsploraTx)
+	r.Get("/tx/:txid/hex", a.EsploraTxHex)
+	r.Get("/block/:hash", a.EsploraBlock)
+	r.Get("/block-height/:height", a.EsploraBlockHeight)
+	r.Get("/blocks/tip/hash", a.EsploraTipHash)
+	r.Get("/mempool/recent", a.EsploraMempoolRecent)
+	r.Get("/fee-estimates", a.EsploraFeeEstimates)
+}
+
+// GET /api/tx/:txid
+func (a *Api) EsploraTx(c *fiber.Ctx) error {
+	info, err := a.nodeTx(c.Params("txid"))
+	if err != nil {
+		return esploraError(c, err)
+	}
+	t := info.Decoded
+	prevouts := a.core.GetPrevouts(t, esploraPrevoutsFetchLimit)
+	ret := EsploraTx{
+		Txid:     t.Txid,
+		Version:  t.Version,
+		Locktime: t.Locktime,
+		Vin:      make([]EsploraVin, len(t.Vin)),
+		Vout:     make([]EsploraVout, len(t.Vout)),
+		Size:     t.Size,
+		Weight:   t.Weight,
+		Status:   newTxStatus(info),
+	}
+	// fee from the pool or the storage, otherwise from the prevouts
+	known := true
+	var in uint64
+	for i, vin := range t.Vin {
+		if vin.Coinbase != "" {
+			ret.Vin[i] = EsploraVin{
+				Txid:       coinbaseTxid,
+				Vout:       coinbaseVout,
+				ScriptSig:  vin.Coinbase,
+				Witness:    vin.Txinwitness,
+				IsCoinbase: true,
+				Sequence:   vin.Sequence,
+			}
+			known = false
+			continue
+		}
+		ret.Vin[i] = EsploraVin{
+			Txid:         vin.Txid,
+			Vout:         uint32(vin.Vout),
+			ScriptSig:    vin.ScriptSig.Hex,
+			ScriptSigAsm: vin.ScriptSig.Asm,
+			Witness:      vin.Txinwitness,
+			Sequence:     vin.Sequence,
+		}
+		if p := prevouts[i]; p != nil {
+			out := newEsploraVout(*p)
+			ret.Vin[i].Prevout = &out
+			in += out.Value
+		} else {
+			known = false
+		}
+	}
+	for i, vout := range t.Vout {
+		ret.Vout[i] = newEsploraVout(vout)
+	}
+	if info.Tx != nil && info.Tx.Fee > 0 {
+		ret.Fee = info.Tx.Fee
+	} else if out := t.GetTotalOut(); known && in > out {
+		ret.Fee = in - out
+	}
+	return c.JSON(ret)
+}
+
+// GET /api/tx/:txid/hex
+func (a *Api) EsploraTxHex(c *fiber.Ctx) error {
+	info, err := a.nodeTx(c.Params("txid"))
+	if err != nil {
+		return esploraError(c, err)
+	}
+	if info.Decoded.Hex == "" {
+		return c.Status(http.StatusNotFound).SendString("Transaction hex not available")
+	}
+	return c.SendString(info.Decoded.Hex)
+}
+
+// GET /api/block/:hash
+func (a *Api) EsploraBlock(c *fiber.Ctx) error {
+	hash := c.Params("hash")
+	if _, ok := mempool.ParseID(hash); !ok {
+		return c.Status(http.StatusBadRequest).SendString("Invalid hex string")
+	}
+	b, err := a.core.GetNodeBlock(hash)
+	if errors.Is(err, core.ErrNoNode) {
+		return esploraError(c, err)
+	}
+	if err != nil {
+		return c.Status(http.StatusNotFound).SendString("Block not found")
+	}
+	bits, _ := strconv.ParseUint(b.Bits, 16, 32)
+	return c.JSON(EsploraBlock{
+		ID:                b.Hash,
+		Height:            b.Height,
+		Version:           b.Version,
+		Timestamp:         b.Time,
+		TxCount:           len(b.Transactions),
+		Size:              b.Size,
+		Weight:            b.Weight,
+		MerkleRoot:        b.Merkleroot,
+		PreviousBlockHash: b.Previousblockhash,
+		MedianTime:        b.Mediantime,
+		Nonce:             b.Nonce,
+		Bits:              uint32(bits),
+		Difficulty:        b.Difficulty,
+	})
+}
+
+// GET /api/block-height/:height
+// Known for the parsed blocks window and the tip only
+func (a *Api) EsploraBlockHeight(c *fiber.Ctx) error {
+	height, err := strconv.Atoi(c.Params("height"))
+	if err != nil || height < 0 {
+		return c.Status(http.StatusBadRequest).SendString("Invalid block height")
+	}
+	hash, err := a.core.GetBlockHash(height)
+	if err != nil {
+		return esploraError(c, err)
+	}
+	return c.SendString(hash)
+}
+
+// GET /api/blocks/tip/hash
+func (a *Api) EsploraTipHash(c *fiber.Ctx) error {
+	tip, err := a.core.GetTip()
+	if err != nil {
+		return esploraError(c, err)
+	}
+	return c.SendString(tip.Hash)
+}
+
+// GET /api/mempool/recent
+func (a *Api) EsploraMempoolRecent(c *fiber.Ctx) error {
+	txs := a.core.Snapshot().Txs
+	if len(txs) > esploraRecentLimit {
+		txs = txs[:esploraRecentLimit]
+	}
+	ret := make([]EsploraRecentTx, len(txs))
+	for i, t := range txs {
+		ret[i] = EsploraRecentTx{
+			Txid:  t.Hash,
+			Fee:   t.Fee,
+			VSize: t.VSize(),
+			Value: t.AmountOut,
+		}
+	}
+	return c.JSON(ret)
+}
+
+// GET /api/fee-estimates
+func (a *Api) EsploraFeeEstimates(c *fiber.Ctx) error {
+	estimates := a.core.FeeEstimates()
+	ret := make(map[string]float64, len(estimates))
+	for target, rate := range estimates {
+		ret[strconv.Itoa(target)] = rate
+	}
+	return c.JSON(ret)
+}
+
+// tx known to the node, the decoded tx is required for the esplora shape
+func (a *Api) nodeTx(txid string) (*core.TxInfo, error) {
+	if _, ok := mempool.ParseID(txid); !ok {
+		return nil, errInvalidTxid
+	}
+	info, err := a.core.GetTx(txid)
+	if err != nil {
+		return nil, err
+	}
+	if info.Decoded == nil {
+		return nil, core.ErrTxNotFound
+	}
+	return info, nil
+}
+
+var errInvalidTxid = errors.New("invalid txid")
+
+// plain text error like esplora
+func esploraError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errInvalidTxid):
+		return c.Status(http.StatusBadRequest).SendString("Invalid hex string")
+	case errors.Is(err, core.ErrTxNotFound):
+		return c.Status(http.StatusNotFound).SendString("Transaction not found")
+	case errors.Is(err, core.ErrBlockNotFound):
+		return c.Status(http.StatusNotFound).SendString("Block not found")
+	case errors.Is(err, core.ErrNoNode):
+		return c.Status(http.StatusServiceUnavailable).SendString(err.Error())
+	}
+	return c.Status(http.StatusInternalServerError).SendString(err.Error())
+}
+
+func newEsploraVout(v tx.Vout) EsploraVout {
+	ret := EsploraVout{
+		ScriptPubKey:     v.ScriptPubKey.Hex,
+		ScriptPubKeyAsm:  v.ScriptPubKey.Asm,
+		ScriptPubKeyType: esploraScriptTypes[v.ScriptPubKey.Type],
+		Value:            uint64(math.Round(v.Value * 1e8)),
+	}
+	if ret.ScriptPubKeyType == "" {
+		ret.ScriptPubKeyType = "unknown"
+	}
+	ret.ScriptPubKeyAddress = v.ScriptPubKey.Address
+	if ret.ScriptPubKeyAddress == "" && len(v.ScriptPubKey.Addresses) > 0 {
+		ret.ScriptPubKeyAddress = v.ScriptPubKey.Addresses[0]
+	}
+	return ret
+}
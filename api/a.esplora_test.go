@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// targets as esplora returns them
+func TestEsploraFeeEstimates(t *testing.T) {
+	a := newTestApi(t)
+	status, body := a.get(t, "/api/fee-estimates")
+	if status != http.StatusOK {
+		t.Fatalf("status %d", status)
+	}
+	want := make([]string, 0)
+	for i := 1; i <= 25; i++ {
+		want = append(want, strconv.Itoa(i))
+	}
+	want = sortedKeys(append(want, "144", "504", "1008")...)
+	if got := jsonKeys(t, body); !reflect.DeepEqual(got, want) {
+		t.Fatalf("targets: got %v, want %v", got, want)
+	}
+
+	var rates map[string]float64
+	if err := json.Unmarshal(body, &rates); err != nil {
+		t.Fatal(err)
+	}
+	// longer targets never pay more
+	targets := make([]int, 0, len(rates))
+	for k := range rates {
+		n, _ := strconv.Atoi(k)
+		targets = append(targets, n)
+	}
+	sort.Ints(targets)
+	for i, n := range targets {
+		rate := rates[strconv.Itoa(n)]
+		if rate < 1 {
+			t.Fatalf("target %d: rate %v below the relay fee", n, rate)
+		}
+		if i > 0 && rate > rates[strconv.Itoa(targets[i-1])] {
+			t.Fatalf("target %d: rate %v over the shorter target", n, rate)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"net/http"
 	"os"
 	"runtime"
 
+	"github.com/1F47E/go-feesh/queue"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/supervisor"
+
 	fiber "github.com/gofiber/fiber/v2"
 )
 
@@ -34,11 +39,13 @@ func (a *Api) NodeInfo(c *fiber.Ctx) error {
 }
 
 type StatsResponse struct {
-	Goroutines int    `json:"goroutines"`
-	MemAllocMb uint64 `json:"mem_alloc_mb"`
+	Goroutines  int           `json:"goroutines"`
+	MemAllocMb  uint64        `json:"mem_alloc_mb"`
+	ParserQueue queue.Stats   `json:"parser_queue"`
+	Storage     storage.Stats `json:"storage"`
 }
 
-// @Summary Some status about the system. G count and memory
+// @Summary Some status about the system. G count, memory, parser queue and storage
 // @Description Get information about the current state of the system memory
 // @Tags etc
 // @Accept  json
@@ -52,8 +59,40 @@ func (a *Api) Stats(c *fiber.Ctx) error {
 	gCnt := runtime.NumGoroutine()
 	alloc := mem.Alloc / 1024 / 1024
 	ret := StatsResponse{
-		Goroutines: gCnt,
-		MemAllocMb: alloc,
+		Goroutines:  gCnt,
+		MemAllocMb:  alloc,
+		ParserQueue: a.core.GetQueueStats(),
+		Storage:     a.core.GetStorageStats(),
 	}
 	return apiSuccess(c, ret)
 }
+
+// @Summary Liveness probe
+// @Description Fails if some worker is stuck for too long and the service should be restarted
+// @Tags etc
+// @Produce  json
+// @Success 200 {object} supervisor.Health
+// @Failure 503 {object} supervisor.Health
+// @Router /healthz [get]
+func (a *Api) Healthz(c *fiber.Ctx) error {
+	h := a.core.Health()
+	if h.Status == supervisor.StatusDown {
+		return c.Status(http.StatusServiceUnavailable).JSON(h)
+	}
+	return c.JSON(h)
+}
+
+// @Summary Readiness probe
+// @Description Fails until all workers produced fresh data or if the data is stale
+// @Tags etc
+// @Produce  json
+// @Success 200 {object} supervisor.Health
+// @Failure 503 {object} supervisor.Health
+// @Router /readyz [get]
+func (a *Api) Readyz(c *fiber.Ctx) error {
+	h := a.core.Health()
+	if !a.core.Ready() {
+		return c.Status(http.StatusServiceUnavailable).JSON(h)
+	}
+	return c.JSON(h)
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1F47E/go-feesh/timeseries"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// default query range
+const historyDefaultRange = time.Hour
+
+type HistoryResolution struct {
+	Name      string `json:"name"`
+	Step      int64  `json:"step"`      // seconds
+	Retention int64  `json:"retention"` // seconds
+}
+
+type HistoryMetricsResponse struct {
+	Metrics     []timeseries.Metric `json:"metrics"`
+	Resolutions []HistoryResolution `json:"resolutions"`
+}
+
+type HistoryPoint struct {
+	Time   int64     `json:"time"`
+	Values []float64 `json:"values"`
+}
+
+type HistoryResponse struct {
+	Metric     string         `json:"metric"`
+	Unit       string         `json:"unit"`
+	Labels     []string       `json:"labels,omitempty"`
+	Resolution string         `json:"resolution"`
+	From       int64          `json:"from"`
+	To         int64          `json:"to"`
+	Points     []HistoryPoint `json:"points"`
+}
+
+// @Summary List history metrics
+// @Description Recorded pool metrics and available resolutions with their retention
+// @Tags history
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} HistoryMetricsResponse
+// @Router /history [get]
+func (a *Api) HistoryMetrics(c *fiber.Ctx) error {
+	ret := HistoryMetricsResponse{
+		Metrics:     a.core.HistoryMetrics(),
+		Resolutions: make([]HistoryResolution, 0, len(timeseries.Resolutions)),
+	}
+	for _, r := range timeseries.Resolutions {
+		ret.Resolutions = append(ret.Resolutions, HistoryResolution{
+			Name:      r.Name,
+			Step:      int64(r.Step / time.Second),
+			Retention: int64(r.Retention / time.Second),
+		})
+	}
+	return apiSuccess(c, ret)
+}
+
+// @Summary Get metric history
+// @Description Metric points averaged per resolution step, oldest first. The last point can be a step still being filled.
+// @Description Resolution is picked by the range if not set
+// @Tags history
+// @Accept  json
+// @Produce  json
+// @Param metric path string true "Metric: pool_size, pool_vsize, pool_fees, fee_buckets, next_block_min_fee"
+// @Param from query int false "Unix time, default is an hour before to"
+// @Param to query int false "Unix time, default is now"
+// @Param resolution query string false "10s, 1m, 1h or 1d"
+// @Success 200 {object} HistoryResponse
+// @Failure 400 {object} APIError
+// @Failure 404 {object} APIError
+// @Router /history/{metric} [get]
+func (a *Api) History(c *fiber.Ctx) error {
+	name := c.Params("metric")
+	var metric *timeseries.Metric
+	for _, m := range a.core.HistoryMetrics() {
+		if m.Name == name {
+			metric = &m
+			break
+		}
+	}
+	if metric == nil {
+		return apiError(c, http.StatusNotFound, "Unknown metric")
+	}
+
+	to := time.Now()
+	if s := c.Query("to"); s != "" {
+		t, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "Invalid to, use unix time")
+		}
+		to = time.Unix(t, 0)
+	}
+	from := to.Add(-historyDefaultRange)
+	if s := c.Query("from"); s != "" {
+		t, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "Invalid from, use unix time")
+		}
+		from = time.Unix(t, 0)
+	}
+	if !from.Before(to) {
+		return apiError(c, http.StatusBadRequest, "from should be before to")
+	}
+
+	points, res, err := a.core.GetHistory(name, c.Query("resolution"), from, to)
+	switch {
+	case errors.Is(err, timeseries.ErrUnknownResolution):
+		return apiError(c, http.StatusBadRequest, "Unknown resolution, use 10s, 1m, 1h or 1d")
+	case errors.Is(err, timeseries.ErrTooManyPoints):
+		return apiError(c, http.StatusBadRequest, err.Error())
+	case err != nil:
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+	ret := HistoryResponse{
+		Metric:     metric.Name,
+		Unit:       metric.Unit,
+		Labels:     metric.Labels,
+		Resolution: res.Name,
+		From:       from.Unix(),
+		To:         to.Unix(),
+		Points:     make([]HistoryPoint, len(points)),
+	}
+	for i, p := range points {
+		ret.Points[i] = HistoryPoint{Time: p.Time.Unix(), Values: p.Values}
+	}
+	return apiSuccess(c, ret)
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/mempool"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// mempool.space compatible endpoints, same paths and JSON shapes as https://mempool.space/docs/api/rest
+// so wallets and bots can use feesh as their backend. Errors are plain text like upstream
+
+type MempoolFeesResponse struct {
+	FastestFee  uint64 `json:"fastestFee"`
+	HalfHourFee uint64 `json:"halfHourFee"`
+	HourFee     uint64 `json:"hourFee"`
+	EconomyFee  uint64 `json:"economyFee"`
+	MinimumFee  uint64 `json:"minimumFee"`
+}
+
+type MempoolBlockResponse struct {
+	BlockSize  uint64    `json:"blockSize"`
+	BlockVSize float64   `json:"blockVSize"`
+	NTx        int       `json:"nTx"`
+	TotalFees  uint64    `json:"totalFees"`
+	MedianFee  float64   `json:"medianFee"`
+	FeeRange   []float64 `json:"feeRange"`
+}
+
+type MempoolResponse struct {
+	Count        int          `json:"count"`
+	VSize        uint64       `json:"vsize"`
+	TotalFee     uint64       `json:"total_fee"`
+	FeeHistogram [][2]float64 `json:"fee_histogram"` // fee rate and vsize, highest rate first
+}
+
+// esplora tx status, mempool.space uses the same
+type TxStatusResponse struct {
+	Confirmed   bool   `json:"confirmed"`
+	BlockHeight int    `json:"block_height,omitempty"`
+	BlockHash   string `json:"block_hash,omitempty"`
+	BlockTime   int64  `json:"block_time,omitempty"`
+}
+
+type MempoolDifficultyResponse struct {
+	ProgressPercent       float64  `json:"progressPercent"`
+	DifficultyChange      float64  `json:"difficultyChange"`
+	EstimatedRetargetDate int64    `json:"estimatedRetargetDate"` // unix ms
+	RemainingBlocks       int      `json:"remainingBlocks"`
+	RemainingTime         int64    `json:"remainingTime"`              // ms
+	PreviousRetarget      *float64 `json:"previousRetarget,omitempty"` // percent, omitted if the epoch start is not in the blocks window
+	PreviousTime          int64    `json:"previousTime"`               // unix
+	NextRetargetHeight    int      `json:"nextRetargetHeight"`
+	TimeAvg               int64    `json:"timeAvg"` // ms
+	AdjustedTimeAvg       int64    `json:"adjustedTimeAvg"`
+	TimeOffset            int64    `json:"timeOffset"` // ms, testnet only
+	ExpectedBlocks        float64  `json:"expectedBlocks"`
+}
+
+func (a *Api) mempoolRoutes(r fiber.Router) {
+	r.Get("/v1/fees/recommended", a.MempoolFees)
+	r.Get("/v1/fees/mempool-blocks", a.MempoolBlocks)
+	r.Get("/v1/difficulty-adjustment", a.MempoolDifficulty)
+	r.Get("/mempool", a.Mempool)
+	r.Get("/blocks/tip/height", a.MempoolTipHeight)
+}
+
+// GET /api/v1/fees/recommended
+func (a *Api) MempoolFees(c *fiber.Ctx) error {
+	f := a.core.RecommendedFees()
+	return c.JSON(MempoolFeesResponse{
+		FastestFee:  f.Fastest,
+		HalfHourFee: f.HalfHour,
+		HourFee:     f.Hour,
+		EconomyFee:  f.Economy,
+		MinimumFee:  f.Minimum,
+	})
+}
+
+// GET /api/v1/fees/mempool-blocks
+func (a *Api) MempoolBlocks(c *fiber.Ctx) error {
+	blocks := a.core.Snapshot().PoolBlocks
+	ret := make([]MempoolBlockResponse, len(blocks))
+	for i, b := range blocks {
+		ret[i] = MempoolBlockResponse{
+			BlockSize:  b.Size,
+			BlockVSize: float64(b.Weight) / 4,
+			NTx:        b.Txs,
+			TotalFees:  b.Fee,
+			MedianFee:  b.MedianFeeRate,
+			FeeRange:   b.FeeRange,
+		}
+	}
+	return c.JSON(ret)
+}
+
+// GET /api/mempool
+func (a *Api) Mempool(c *fiber.Ctx) error {
+	stats := a.core.GetPoolStats()
+	return c.JSON(MempoolResponse{
+		Count:        stats.Count,
+		VSize:        stats.Weight / 4,
+		TotalFee:     stats.Fee,
+		FeeHistogram: histogramPairs(a.core.Snapshot().Histogram),
+	})
+}
+
+// GET /api/blocks/tip/height
+func (a *Api) MempoolTipHeight(c *fiber.Ctx) error {
+	return c.SendString(strconv.Itoa(a.core.Snapshot().Height))
+}
+
+// GET /api/tx/:txid/status
+func (a *Api) TxStatus(c *fiber.Ctx) error {
+	txid := c.Params("txid")
+	if _, ok := mempool.ParseID(txid); !ok {
+		return c.Status(http.StatusBadRequest).SendString("Invalid hex string")
+	}
+	info, err := a.core.GetTx(txid)
+	if errors.Is(err, core.ErrTxNotFound) {
+		return c.Status(http.StatusNotFound).SendString("Transaction not found")
+	}
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+	return c.JSON(newTxStatus(info))
+}
+
+// GET /api/v1/difficulty-adjustment
+func (a *Api) MempoolDifficulty(c *fiber.Ctx) error {
+	d := a.core.GetDifficulty()
+	remaining := time.Until(d.RetargetTime)
+	return c.JSON(MempoolDifficultyResponse{
+		ProgressPercent:       d.Progress,
+		DifficultyChange:      d.Change,
+		EstimatedRetargetDate: d.RetargetTime.UnixMilli(),
+		RemainingBlocks:       d.RemainingBlocks,
+		RemainingTime:         remaining.Milliseconds(),
+		PreviousTime:          d.EpochStart.Unix(),
+		NextRetargetHeight:    d.RetargetHeight,
+		TimeAvg:               d.BlockTime.Milliseconds(),
+		AdjustedTimeAvg:       d.BlockTime.Milliseconds(),
+		PreviousRetarget:      d.PreviousRetarget,
+		TimeOffset:            d.TimeOffset.Milliseconds(),
+		ExpectedBlocks:        math.Round(d.ExpectedBlocks*100) / 100,
+	})
+}
+
+func newTxStatus(info *core.TxInfo) TxStatusResponse {
+	if info.Status != core.TxStatusConfirmed || info.Block == nil {
+		return TxStatusResponse{}
+	}
+	return TxStatusResponse{
+		Confirmed:   true,
+		BlockHeight: info.Block.Height,
+		BlockHash:   info.Block.Hash,
+		BlockTime:   info.Block.Time,
+	}
+}
+
+func histogramPairs(bins []mempool.HistogramBin) [][2]float64 {
+	ret := make([][2]float64, len(bins))
+	for i, b := range bins {
+		ret[i] = [2]float64{b.FeeRate, float64(b.VSize)}
+	}
+	return ret
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sorted keys of the json object
+func jsonKeys(t *testing.T, body []byte) []string {
+	t.Helper()
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil {
+		t.Fatalf("%v: %s", err, body)
+	}
+	ret := make([]string, 0, len(obj))
+	for k := range obj {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sortedKeys(keys ...string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// field names as mempool.space returns them
+func TestMempoolFieldNames(t *testing.T) {
+	a := newTestApi(t)
+
+	status, body := a.get(t, "/api/v1/fees/recommended")
+	if status != http.StatusOK {
+		t.Fatalf("recommended: status %d", status)
+	}
+	want := sortedKeys("fastestFee", "halfHourFee", "hourFee", "economyFee", "minimumFee")
+	if got := jsonKeys(t, body); !reflect.DeepEqual(got, want) {
+		t.Fatalf("recommended: got %v, want %v", got, want)
+	}
+
+	status, body = a.get(t, "/api/v1/fees/mempool-blocks")
+	if status != http.StatusOK {
+		t.Fatalf("mempool-blocks: status %d", status)
+	}
+	var blocks []json.RawMessage
+	if err := json.Unmarshal(body, &blocks); err != nil || len(blocks) == 0 {
+		t.Fatalf("mempool-blocks: %v, %s", err, body)
+	}
+	want = sortedKeys("blockSize", "blockVSize", "nTx", "totalFees", "medianFee", "feeRange")
+	if got := jsonKeys(t, blocks[0]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mempool-blocks: got %v, want %v", got, want)
+	}
+
+	status, body = a.get(t, "/api/v1/difficulty-adjustment")
+	if status != http.StatusOK {
+		t.Fatalf("difficulty-adjustment: status %d", status)
+	}
+	// the sim window has no epoch start, previousRetarget is omitted
+	want = sortedKeys("progressPercent", "difficultyChange", "estimatedRetargetDate", "remainingBlocks", "remainingTime",
+		"previousTime", "nextRetargetHeight", "timeAvg", "adjustedTimeAvg", "timeOffset", "expectedBlocks")
+	if got := jsonKeys(t, body); !reflect.DeepEqual(got, want) {
+		t.Fatalf("difficulty-adjustment: got %v, want %v", got, want)
+	}
+
+	status, body = a.get(t, "/api/mempool")
+	if status != http.StatusOK {
+		t.Fatalf("mempool: status %d", status)
+	}
+	want = sortedKeys("count", "vsize", "total_fee", "fee_histogram")
+	if got := jsonKeys(t, body); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mempool: got %v, want %v", got, want)
+	}
+}
+
+func TestTxStatus(t *testing.T) {
+	a := newTestApi(t)
+	snap := a.core.Snapshot()
+	tip := snap.Blocks[0]
+	mined, _, err := a.core.GetBlockTxs(tip.Hash, 0, 1)
+	if err != nil || len(mined) == 0 {
+		t.Fatalf("no mined txs: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		txid   string
+		status int
+		keys   []string
+	}{
+		{"pool tx", snap.Txs[0].Hash, http.StatusOK, []string{"confirmed"}},
+		{"mined tx", mined[0].Hash, http.StatusOK, sortedKeys("confirmed", "block_height", "block_hash", "block_time")},
+		{"unknown tx", strings.Repeat("0", 64), http.StatusNotFound, nil},
+		{"invalid txid", "xyz", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := a.get(t, "/api/tx/"+tt.txid+"/status")
+			if status != tt.status {
+				t.Fatalf("status: got %d, want %d, %s", status, tt.status, body)
+			}
+			if tt.keys == nil {
+				return
+			}
+			if got := jsonKeys(t, body); !reflect.DeepEqual(got, tt.keys) {
+				t.Fatalf("got %v, want %v", got, tt.keys)
+			}
+			var resp TxStatusResponse
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Confirmed && (resp.BlockHash != tip.Hash || resp.BlockHeight != tip.Height) {
+				t.Fatalf("block: got %d %s, want %d %s", resp.BlockHeight, resp.BlockHash, tip.Height, tip.Hash)
+			}
+		})
+	}
+}
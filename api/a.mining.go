@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/1F47E/go-feesh/mining"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+var miningPeriods = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+}
+
+type MiningPoolsResponse struct {
+	Period string         `json:"period"`
+	Blocks int            `json:"blocks"`
+	Pools  []mining.Stats `json:"pools"`
+}
+
+// @Summary Get mining pools stats
+// @Description Share of blocks, fees earned and empty blocks per mining pool.
+// @Description Limited by the blocks history, BLOCKS_HISTORY blocks (a week by default) built up while running
+// @Tags mining
+// @Accept  json
+// @Produce  json
+// @Param period query string false "Period, 24h or 1w" default(24h)
+// @Success 200 {object} MiningPoolsResponse
+// @Failure 400 {object} APIError
+// @Router /mining/pools [get]
+func (a *Api) MiningPools(c *fiber.Ctx) error {
+	period := c.Query("period", "24h")
+	d, ok := miningPeriods[period]
+	if !ok {
+		return apiError(c, http.StatusBadRequest, "Unknown period, use 24h or 1w")
+	}
+	pools := a.core.GetMiningStats(d)
+	ret := MiningPoolsResponse{
+		Period: period,
+		Pools:  pools,
+	}
+	for _, p := range pools {
+		ret.Blocks += p.Blocks
+	}
+	return apiSuccess(c, ret)
+}
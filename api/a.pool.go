@@ -1,21 +1,27 @@
 package api
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
 	mtx "github.com/1F47E/go-feesh/entity/models/tx"
-	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
 
 	fiber "github.com/gofiber/fiber/v2"
 )
 
 type BlockWrapper struct {
-	// Height int    `json:"height"`
-	Hash   string `json:"hash"`
-	Fee    uint64 `json:"fee"`
-	Weight uint64 `json:"weight"`
-	Size   uint64 `json:"size"`
-	// TxCount int `json:"tx_count"`
+	Height  int    `json:"height"`
+	Hash    string `json:"hash"`
+	Fee     uint64 `json:"fee"`
+	Weight  uint64 `json:"weight"`
+	Size    uint64 `json:"size"`
+	TxCount uint64 `json:"tx_count"`
 }
 
 type FeeBucket struct {
@@ -37,46 +43,193 @@ type PoolResponse struct {
 	Blocks     []BlockWrapper `json:"blocks"`
 }
 
+// max txs in the pool response, the snapshot keeps no more
+const poolMaxLimit = 1000
+
 // @Summary Get pool information
 // @Description Get information about the current state of the pool
 // @Tags pool
 // @Accept  json
 // @Produce  json
-// @Param limit query int false "Limit the number of transactions returned"
+// @Param limit query int false "Limit the number of transactions returned, 100 by default, up to 1000"
 // @Success 200 {object} PoolResponse
+// @Failure 400 {object} APIError
 // @Failure 500 {object} APIError
 // @Router /pool [get]
 func (a *Api) Pool(c *fiber.Ctx) error {
-	log := c.Locals("logger").(logger.LoggerEntry)
+	// read everything from the same snapshot to have consistent data
+	snap := a.core.Snapshot()
 
 	limit := c.QueryInt("limit", 100)
-	txs, err := a.core.GetPool(limit)
-	if err != nil {
-		log.Errorf("error on getpool: %v\n", err)
-		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	if limit < 1 || limit > poolMaxLimit {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", poolMaxLimit))
+	}
+	txs := snap.Txs
+	if len(txs) > limit {
+		txs = txs[:limit]
 	}
-	// remap blocks
+	// remap blocks, the parsed ones only
 	blocks := make([]BlockWrapper, 0)
-	for _, b := range a.core.GetBlocks() {
+	for _, b := range snap.Blocks[:min(len(snap.Blocks), a.core.Cfg.BlocksParsingDepth)] {
 		blocks = append(blocks, BlockWrapper{
-			// Height: b.Height,
-			Hash:   b.Hash,
-			Fee:    b.Fee,
-			Weight: b.Weight,
+			Height:  b.Height,
+			Hash:    b.Hash,
+			Fee:     b.Fee,
+			Weight:  b.Weight,
+			Size:    b.Size,
+			TxCount: b.Txs,
 		})
 	}
 
 	ret := PoolResponse{
-		Height:      a.core.GetHeight(),
-		Size:        a.core.GetPoolSize(),
-		SizeHistory: a.core.GetPoolSizeHistory(),
-		Amount:      a.core.GetTotalAmount(),
-		Weight:      a.core.GetTotalSize(),
-		Fee:         a.core.GetFeeTotal(),
-		FeeAvg:      a.core.GetFeeAvg(),
-		FeeBuckets:  a.core.GetFeeBuckets(),
+		Height:      snap.Height,
+		Size:        snap.PoolSize,
+		SizeHistory: snap.PoolSizeHistory,
+		Amount:      snap.Amount,
+		Weight:      snap.SizeKb(),
+		Fee:         snap.FeeTotal,
+		FeeAvg:      snap.FeeAvg,
+		FeeBuckets:  snap.FeeBuckets,
 		Txs:         txs,
 		Blocks:      blocks,
 	}
 	return apiSuccess(c, ret)
 }
+
+// max txs per page
+const poolTxsMaxLimit = 1000
+
+type PoolTxsResponse struct {
+	Txs   []mtx.Tx `json:"txs"`
+	Total int      `json:"total"`          // txs matching the filters
+	Next  string   `json:"next,omitempty"` // cursor of the next page, empty on the last one
+}
+
+// @Summary Browse pool transactions
+// @Description Pool txs filtered and sorted, paginated with the cursor from the previous page.
+// @Description Value, type and rbf filters match parsed txs only
+// @Tags pool
+// @Accept  json
+// @Produce  json
+// @Param sort query string false "time (default), fee, feerate, value or size"
+// @Param order query string false "desc (default) or asc"
+// @Param cursor query string false "Next cursor of the previous page"
+// @Param limit query int false "Page size, 100 by default, up to 1000"
+// @Param min_fee_rate query number false "sat/vB"
+// @Param max_fee_rate query number false "sat/vB"
+// @Param min_value query int false "Output amount, sat"
+// @Param max_value query int false "Output amount, sat"
+// @Param fits query bool false "Projected to fit in the next block"
+// @Param type query string false "legacy, segwit or taproot"
+// @Param rbf query bool false "Signals replace by fee"
+// @Success 200 {object} PoolTxsResponse
+// @Failure 400 {object} APIError
+// @Router /pool/txs [get]
+func (a *Api) PoolTxs(c *fiber.Ctx) error {
+	q := mempool.Query{
+		Sort:  c.Query("sort", mempool.SortTime),
+		Limit: c.QueryInt("limit", 100),
+	}
+	switch c.Query("order", "desc") {
+	case "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return apiError(c, http.StatusBadRequest, "Invalid order, use asc or desc")
+	}
+	if q.Limit < 1 || q.Limit > poolTxsMaxLimit {
+		return apiError(c, http.StatusBadRequest, fmt.Sprintf("Invalid limit, use 1 to %d", poolTxsMaxLimit))
+	}
+	var err error
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{{"min_fee_rate", &q.MinFeeRate}, {"max_fee_rate", &q.MaxFeeRate}} {
+		if s := c.Query(f.name); s != "" {
+			if *f.dst, err = strconv.ParseFloat(s, 64); err != nil || *f.dst < 0 {
+				return apiError(c, http.StatusBadRequest, "Invalid "+f.name)
+			}
+		}
+	}
+	for _, f := range []struct {
+		name string
+		dst  *uint64
+	}{{"min_value", &q.MinValue}, {"max_value", &q.MaxValue}} {
+		if s := c.Query(f.name); s != "" {
+			if *f.dst, err = strconv.ParseUint(s, 10, 64); err != nil {
+				return apiError(c, http.StatusBadRequest, "Invalid "+f.name)
+			}
+		}
+	}
+	for _, f := range []struct {
+		name string
+		dst  **bool
+	}{{"fits", &q.Fits}, {"rbf", &q.RBF}} {
+		if s := c.Query(f.name); s != "" {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return apiError(c, http.StatusBadRequest, "Invalid "+f.name+", use true or false")
+			}
+			*f.dst = &v
+		}
+	}
+	switch q.Type = c.Query("type"); q.Type {
+	case "", mempool.TypeLegacy, mempool.TypeSegwit, mempool.TypeTaproot:
+	default:
+		return apiError(c, http.StatusBadRequest, "Invalid type, use legacy, segwit or taproot")
+	}
+	if s := c.Query("cursor"); s != "" {
+		q.After, err = decodeCursor(s, q.Sort, q.Asc)
+		if err != nil {
+			return apiError(c, http.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	page, err := a.core.QueryPool(q)
+	if errors.Is(err, mempool.ErrSort) {
+		return apiError(c, http.StatusBadRequest, "Invalid sort, use time, fee, feerate, value or size")
+	}
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	ret := PoolTxsResponse{Txs: page.Txs, Total: page.Total}
+	if page.Next != nil {
+		ret.Next = encodeCursor(page.Next, q.Sort, q.Asc)
+	}
+	return apiSuccess(c, ret)
+}
+
+// opaque cursor, bound to the sort it was made for: feerate:desc:12.5:txid
+func encodeCursor(cur *mempool.Cursor, sort string, asc bool) string {
+	s := strings.Join([]string{sort, order(asc), strconv.FormatFloat(cur.Key, 'g', -1, 64), cur.Txid}, ":")
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodeCursor(s string, sort string, asc bool) (*mempool.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(string(b), ":")
+	if len(parts) != 4 || parts[0] != sort || parts[1] != order(asc) {
+		return nil, errors.New("cursor of another sort")
+	}
+	key, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(key) || math.IsInf(key, 0) {
+		return nil, errors.New("cursor key out of range")
+	}
+	if _, ok := mempool.ParseID(parts[3]); !ok {
+		return nil, errors.New("cursor txid is invalid")
+	}
+	return &mempool.Cursor{Key: key, Txid: parts[3]}, nil
+}
+
+func order(asc bool) string {
+	if asc {
+		return "asc"
+	}
+	return "desc"
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/mempool"
+)
+
+const cursorTxid = "0000000000000000000000000000000000000000000000000000000000000abc"
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		sort string
+		asc  bool
+		key  float64
+	}{
+		{mempool.SortTime, false, 1700000000},
+		{mempool.SortFee, true, 0},
+		{mempool.SortFeeRate, false, 12.5},
+		{mempool.SortFeeRate, true, 0.001},
+		{mempool.SortValue, false, 2_100_000_000_000_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort+":"+order(tt.asc), func(t *testing.T) {
+			s := encodeCursor(&mempool.Cursor{Key: tt.key, Txid: cursorTxid}, tt.sort, tt.asc)
+			if strings.ContainsAny(s, "+/=") {
+				t.Fatalf("cursor %s is not url safe", s)
+			}
+			cur, err := decodeCursor(s, tt.sort, tt.asc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cur.Key != tt.key || cur.Txid != cursorTxid {
+				t.Fatalf("got %v %s, want %v %s", cur.Key, cur.Txid, tt.key, cursorTxid)
+			}
+		})
+	}
+}
+
+func TestCursorTamper(t *testing.T) {
+	raw := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "!!!"},
+		{"another sort", raw("time:desc:1:" + cursorTxid)},
+		{"another order", raw("fee:asc:1:" + cursorTxid)},
+		{"key is not a number", raw("fee:desc:one:" + cursorTxid)},
+		{"key is nan", raw("fee:desc:NaN:" + cursorTxid)},
+		{"key is inf", raw("fee:desc:+Inf:" + cursorTxid)},
+		{"missing part", raw("fee:desc:1")},
+		{"extra part", raw("fee:desc:1:" + cursorTxid + ":x")},
+		{"short txid", raw("fee:desc:1:abc")},
+		{"txid is not hex", raw("fee:desc:1:" + strings.Repeat("z", 64))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor, mempool.SortFee, false); err == nil {
+				t.Fatal("tampered cursor is accepted")
+			}
+		})
+	}
+}
+
+func TestPoolTxsCursor(t *testing.T) {
+	a := newTestApi(t)
+	type page struct {
+		Data PoolTxsResponse `json:"data"`
+	}
+	get := func(query url.Values) (int, page) {
+		t.Helper()
+		status, body := a.get(t, "/v0/pool/txs?"+query.Encode())
+		var p page
+		if status == http.StatusOK {
+			if err := json.Unmarshal(body, &p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return status, p
+	}
+
+	// the pool is live, pages keep going on from the cursor
+	// in the fee order with the txid tie break, nothing is repeated
+	query := url.Values{"sort": {"fee"}, "order": {"asc"}, "limit": {"7"}}
+	var last *mtx.Tx
+	seen := make(map[string]bool)
+	for pages := 0; ; pages++ {
+		if pages > 1000 {
+			t.Fatal("pagination does not end")
+		}
+		status, p := get(query)
+		if status != http.StatusOK {
+			t.Fatalf("status %d", status)
+		}
+		for i := range p.Data.Txs {
+			tx := &p.Data.Txs[i]
+			if seen[tx.Hash] {
+				t.Fatalf("tx %s is repeated", tx.Hash)
+			}
+			seen[tx.Hash] = true
+			if last != nil && (tx.Fee < last.Fee || tx.Fee == last.Fee && tx.Hash < last.Hash) {
+				t.Fatalf("tx %s fee %d is after %s fee %d", tx.Hash, tx.Fee, last.Hash, last.Fee)
+			}
+			last = tx
+		}
+		if p.Data.Next == "" {
+			break
+		}
+		query.Set("cursor", p.Data.Next)
+	}
+	if len(seen) == 0 {
+		t.Fatal("pool is empty")
+	}
+
+	// cursor is bound to its sort and order
+	query = url.Values{"sort": {"fee"}, "limit": {"1"}}
+	_, p := get(query)
+	if p.Data.Next == "" {
+		t.Fatal("no next cursor")
+	}
+	for _, q := range []url.Values{
+		{"sort": {"time"}, "cursor": {p.Data.Next}},
+		{"sort": {"fee"}, "order": {"asc"}, "cursor": {p.Data.Next}},
+		{"sort": {"fee"}, "cursor": {p.Data.Next + "x"}},
+	} {
+		if status, _ := get(q); status != http.StatusBadRequest {
+			t.Fatalf("%s: status %d, want %d", q.Encode(), status, http.StatusBadRequest)
+		}
+	}
+}
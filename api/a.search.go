@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/mempool"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// search result types
+const (
+	SearchBlock = "block"
+	SearchTx    = "tx"
+)
+
+type SearchResponse struct {
+	Type   string `json:"type"` // block or tx
+	Hash   string `json:"hash"`
+	Height int    `json:"height,omitempty"` // blocks only
+}
+
+// @Summary Search
+// @Description Resolve a block height, block hash or txid.
+// @Description Blocks are searched in the parsed window, txs in the pool, storage and the node
+// @Tags blocks
+// @Accept  json
+// @Produce  json
+// @Param q query string true "Height, block hash or txid"
+// @Success 200 {object} SearchResponse
+// @Failure 400 {object} APIError
+// @Failure 404 {object} APIError
+// @Failure 500 {object} APIError
+// @Router /search [get]
+func (a *Api) Search(c *fiber.Ctx) error {
+	q := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if q == "" {
+		return apiError(c, http.StatusBadRequest, "Empty query")
+	}
+	if b, ok := a.blockByHashOrHeight(q); ok {
+		return apiSuccess(c, SearchResponse{Type: SearchBlock, Hash: b.Hash, Height: b.Height})
+	}
+	if _, ok := mempool.ParseID(q); !ok {
+		return apiError(c, http.StatusNotFound, "Nothing found")
+	}
+	_, err := a.core.GetTx(q)
+	if errors.Is(err, core.ErrTxNotFound) {
+		return apiError(c, http.StatusNotFound, "Nothing found")
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	}
+	return apiSuccess(c, SearchResponse{Type: SearchTx, Hash: q})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/mempool"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type TxPackage struct {
+	Count  int      `json:"count"`
+	Txids  []string `json:"txids"`
+	Fee    uint64   `json:"fee"`
+	Weight uint64   `json:"weight"`
+}
+
+type TxMempoolResponse struct {
+	Position       int       `json:"position"` // by fee rate, 1 - the highest
+	PoolSize       int       `json:"pool_size"`
+	WeightAhead    uint64    `json:"weight_ahead"`
+	Block          int       `json:"block"` // projected block, 1 - the next one
+	Eta            int64     `json:"eta"`   // seconds
+	FeeRate        float64   `json:"fee_rate"`
+	PackageFeeRate float64   `json:"package_fee_rate"` // with the unconfirmed ancestors
+	Ancestors      TxPackage `json:"ancestors"`
+	Descendants    TxPackage `json:"descendants"`
+}
+
+type TxBlockResponse struct {
+	Hash          string `json:"hash"`
+	Height        int    `json:"height"`
+	Time          int64  `json:"time"`
+	Confirmations int    `json:"confirmations"`
+}
+
+type TxRBFResponse struct {
+	Signals    bool     `json:"signals"`
+	ReplacedBy string   `json:"replaced_by,omitempty"`
+	Replaces   []string `json:"replaces,omitempty"`
+}
+
+type TxResponse struct {
+	Status  string             `json:"status"` // mempool, confirmed, replaced, removed or unknown
+	Tx      *mtx.Tx            `json:"tx,omitempty"`
+	Decoded *tx.Transaction    `json:"decoded,omitempty"`
+	Mempool *TxMempoolResponse `json:"mempool,omitempty"`
+	Block   *TxBlockResponse   `json:"block,omitempty"`
+	RBF     TxRBFResponse      `json:"rbf"`
+}
+
+func newTxPackage(p mempool.Package) TxPackage {
+	ret := TxPackage{Count: len(p.Txs), Txids: p.Txs, Fee: p.Fee, Weight: p.Weight}
+	if ret.Txids == nil {
+		ret.Txids = make([]string, 0)
+	}
+	return ret
+}
+
+// @Summary Get transaction
+// @Description Stored and decoded tx. Pool txs come with the position by fee rate, projected block,
+// @Description eta and unconfirmed ancestors and descendants, confirmed ones with the block
+// @Tags tx
+// @Accept  json
+// @Produce  json
+// @Param txid path string true "Transaction id"
+// @Success 200 {object} TxResponse
+// @Failure 400 {object} APIError
+// @Failure 404 {object} APIError
+// @Failure 500 {object} APIError
+// @Router /tx/{txid} [get]
+func (a *Api) Tx(c *fiber.Ctx) error {
+	txid := c.Params("txid")
+	if _, ok := mempool.ParseID(txid); !ok {
+		return apiError(c, http.StatusBadRequest, "Invalid txid")
+	}
+	info, err := a.core.GetTx(txid)
+	if errors.Is(err, core.ErrTxNotFound) {
+		return apiError(c, http.StatusNotFound, "Transaction not found")
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, "Something went wrong", err.Error())
+	}
+	ret := TxResponse{
+		Status:  info.Status,
+		Tx:      info.Tx,
+		Decoded: info.Decoded,
+		RBF: TxRBFResponse{
+			Signals:    info.RBF.Signals,
+			ReplacedBy: info.RBF.ReplacedBy,
+			Replaces:   info.RBF.Replaces,
+		},
+	}
+	if m := info.Mempool; m != nil {
+		ret.Mempool = &TxMempoolResponse{
+			Position:       m.Position.Rank,
+			PoolSize:       m.PoolSize,
+			WeightAhead:    m.Position.WeightAhead,
+			Block:          m.Position.Block,
+			Eta:            int64(m.ETA.Seconds()),
+			FeeRate:        m.FeeRate,
+			PackageFeeRate: m.PackageFeeRate,
+			Ancestors:      newTxPackage(m.Ancestors),
+			Descendants:    newTxPackage(m.Descendants),
+		}
+	}
+	if b := info.Block; b != nil {
+		ret.Block = &TxBlockResponse{
+			Hash:          b.Hash,
+			Height:        b.Height,
+			Time:          b.Time,
+			Confirmations: b.Confirmations,
+		}
+	}
+	return apiSuccess(c, ret)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/1F47E/go-feesh/core"
@@ -42,6 +44,10 @@ func NewApi(core *core.Core, notificator *notificator.Notificator) *Api {
 
 	a := Api{app, core, notificator}
 
+	// kubernetes probes
+	a.app.Get("/healthz", a.Healthz)
+	a.app.Get("/readyz", a.Readyz)
+
 	// setup routes
 	api := a.app.Group("/v0")
 	api.Get("/swagger/*", swagger.HandlerDefault) // default
@@ -51,16 +57,44 @@ func NewApi(core *core.Core, notificator *notificator.Notificator) *Api {
 	api.Get("/ping", a.Ping)
 	api.Get("/version", a.Version)
 	api.Get("/pool", a.Pool)
+	api.Get("/pool/txs", a.PoolTxs)
+	api.Get("/tx/:txid", a.Tx)
+	api.Get("/blocks", a.Blocks)
+	api.Get("/blocks/:hash", a.Block)
+	api.Get("/blocks/:hash/audit", a.BlockAudit)
+	api.Get("/blocks/:hash/txs", a.BlockTxs)
+	api.Get("/search", a.Search)
+	api.Get("/audits", a.BlockAudits)
+	api.Get("/mining/pools", a.MiningPools)
+	api.Get("/history", a.HistoryMetrics)
+	api.Get("/history/:metric", a.History)
+
+	admin := api.Group("/admin", a.adminAuth)
+	admin.Get("/pool/snapshot", a.PoolSnapshot)
+
+	// mempool.space and esplora compatible, sharing the tx status
+	if a.core.Cfg.MempoolApi || a.core.Cfg.EsploraApi {
+		compat := a.app.Group("/api")
+		compat.Get("/tx/:txid/status", a.TxStatus)
+		if a.core.Cfg.MempoolApi {
+			a.mempoolRoutes(compat)
+		}
+		if a.core.Cfg.EsploraApi {
+			a.esploraRoutes(compat)
+		}
+	}
 
 	// websockets
 	api.Get("/ws", websocket.New(func(c *websocket.Conn) {
 		defer func() {
-			a.notificator.UnregisterCh <- c
+			a.notificator.Unregister(c)
 			c.Close()
 		}()
 
-		// register new client
-		a.notificator.RegisterCh <- c
+		// register new client, refuse if shutting down
+		if !a.notificator.Register(c) {
+			return
+		}
 
 		for {
 			messageType, message, err := c.ReadMessage()
@@ -92,17 +126,16 @@ func (a *Api) Listen() error {
 	a.notificator.Start()
 
 	log.Info("Starting http server...")
-	err := a.app.Listen(a.core.Cfg.ApiHost)
-	if err != nil {
-		log.Fatalf("error on listen: %v", err)
-	}
-	return nil
+	return a.app.Listen(a.core.Cfg.ApiHost)
 }
 
-// shutdown
-func (a *Api) Shutdown() error {
+// close websocket clients and wait for active requests until ctx is done
+func (a *Api) Shutdown(ctx context.Context) error {
 	logger.Log.Info("Shutting down server...")
-	return a.app.Shutdown()
+	if err := a.notificator.Stop(ctx); err != nil {
+		return fmt.Errorf("error on stopping WS service: %w", err)
+	}
+	return a.app.ShutdownWithContext(ctx)
 }
 
 type APISuccess struct {
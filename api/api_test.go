@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/sim"
+	"github.com/1F47E/go-feesh/storage"
+	smap "github.com/1F47E/go-feesh/storage/map"
+)
+
+// api over a core synced with the simulated node, the pool is parsed and the blocks window filled
+func newTestApi(t *testing.T) *Api {
+	t.Helper()
+	sc, err := sim.Load("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Pool = 500
+	ctx, cancel := context.WithCancel(context.Background())
+	node := sim.New(sc, time.Now())
+	// real time, no blocks are mined during the test
+	node.Start(ctx, 1)
+
+	cfg := &config.Config{
+		RpcLimit:           4,
+		BlocksParsingDepth: 3,
+		ParserQueueSize:    10_000,
+		ParserQueuePolicy:  "block",
+		MempoolApi:         true,
+		EsploraApi:         true,
+	}
+	broadcastCh := make(chan notificator.Msg, 100)
+	blocksCh := make(chan notificator.BlockMsg, 100)
+	c := core.NewCore(ctx, cfg, node, smap.New(storage.Retention{}), broadcastCh, blocksCh)
+	a := NewApi(c, notificator.New(broadcastCh, blocksCh))
+	c.Start(ctx)
+	t.Cleanup(func() {
+		stopCtx, cancelStop := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelStop()
+		if err := c.Stop(stopCtx); err != nil {
+			t.Error(err)
+		}
+		cancel()
+	})
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		snap := c.Snapshot()
+		if len(snap.Txs) > 0 && len(snap.Blocks) >= cfg.BlocksParsingDepth && len(snap.PoolBlocks) > 0 {
+			return a
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("core is not synced")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (a *Api) get(t *testing.T, path string) (int, []byte) {
+	t.Helper()
+	resp, err := a.app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestPoolLimit(t *testing.T) {
+	a := newTestApi(t)
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"?limit=1", http.StatusOK},
+		{"?limit=1000", http.StatusOK},
+		{"?limit=0", http.StatusBadRequest},
+		{"?limit=-1", http.StatusBadRequest},
+		{"?limit=1001", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			status, body := a.get(t, "/v0/pool"+tt.query)
+			if status != tt.status {
+				t.Fatalf("status: got %d, want %d, %s", status, tt.status, body)
+			}
+		})
+	}
+
+	_, body := a.get(t, "/v0/pool?limit=1")
+	var resp struct {
+		Data PoolResponse `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Data.Txs) != 1 {
+		t.Fatalf("txs: got %d, want 1", len(resp.Data.Txs))
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
 	"github.com/1F47E/go-feesh/entity/btc/info"
 	"github.com/1F47E/go-feesh/entity/btc/peer"
 	"github.com/1F47E/go-feesh/entity/btc/tx"
@@ -550,6 +551,34 @@ func (c *Client) GetBlock(blockHash string) (*block.Block, error) {
 	return ret, nil
 }
 
+// get block stats by hash
+// curl -X POST -H 'Content-Type: application/json' -u 'rpcuser:rpcpass' -d '{"jsonrpc":"1.0","method":"getblockstats","params":["00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054"],"id":1}' http://localhost:8332
+// NOTE: not supported by btcd
+func (c *Client) GetBlockStats(blockHash string) (*blockstats.BlockStats, error) {
+	r := NewRPCRequest("getblockstats", []interface{}{blockHash})
+	data, err := c.doRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	// check type of result
+	if _, ok := data.Result.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("unexpected type for result")
+	}
+	// Convert back to raw JSON
+	rawJson, err := json.Marshal(data.Result)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse into struct
+	ret := new(blockstats.BlockStats)
+	err = json.Unmarshal(rawJson, ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
 // get transaction
 // curl -X POST -H 'Content-Type: application/json' -u 'rpcuser:rpcpass' -d '{"jsonrpc":"1.0","method":"getrawtransaction","params":["6dcf241891cd43d3508ef6ee8f260fe5a9f3b0337f83874c4123bf6eb2c17454"],"id":1}' http://localhost:18334
 func (c *Client) TransactionGet(txid string) (*tx.Transaction, error) {
@@ -0,0 +1,151 @@
+// Storage conformance check.
+// Runs the storagetest suite against the backends, exits with 1 if any case fails.
+// Map, bolt and sqlite are checked on temp files, redis and postgres need a server.
+//
+//	go run ./cmd/storagecheck -backends map,bolt,sqlite,redis -redis localhost:6379
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1F47E/go-feesh/storage"
+	sbolt "github.com/1F47E/go-feesh/storage/bolt"
+	smap "github.com/1F47E/go-feesh/storage/map"
+	sredis "github.com/1F47E/go-feesh/storage/redis"
+	ssql "github.com/1F47E/go-feesh/storage/sql"
+	"github.com/1F47E/go-feesh/storage/storagetest"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	backendsStr := flag.String("backends", "map,bolt,sqlite", "comma separated backends: map, bolt, sqlite, redis, postgres")
+	redisAddr := flag.String("redis", "localhost:6379", "redis address")
+	postgresDsn := flag.String("postgres", "", "postgres dsn, all tables are dropped after every case")
+	flag.Parse()
+
+	dir, err := os.MkdirTemp("", "storagecheck")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	failed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range strings.Split(*backendsStr, ",") {
+		name = strings.TrimSpace(name)
+		var f storagetest.Factory
+		switch name {
+		case "map":
+			f = mapFactory()
+		case "bolt":
+			f = boltFactory(dir)
+		case "sqlite":
+			f = sqliteFactory(dir)
+		case "redis":
+			f = redisFactory(*redisAddr)
+		case "postgres":
+			f = postgresFactory(*postgresDsn)
+		default:
+			fmt.Fprintf(os.Stderr, "unknown backend %q\n", name)
+			os.Exit(1)
+		}
+		for _, r := range storagetest.Run(f) {
+			status := "ok"
+			if r.Err != nil {
+				status = "FAIL: " + r.Err.Error()
+				failed = true
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, r.Name, r.Duration.Round(time.Millisecond), status)
+		}
+	}
+	w.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func mapFactory() storagetest.Factory {
+	return func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		return smap.New(r), func() {}, nil
+	}
+}
+
+func boltFactory(dir string) storagetest.Factory {
+	n := 0
+	return func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("check%d.db", n))
+		s, err := sbolt.New(path, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			os.Remove(path)
+		}, nil
+	}
+}
+
+func sqliteFactory(dir string) storagetest.Factory {
+	n := 0
+	return func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("check%d.sqlite", n))
+		s, err := ssql.New(ssql.DriverSqlite, path, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			os.Remove(path)
+		}, nil
+	}
+}
+
+// every case gets its own prefix, keys are deleted after
+func redisFactory(addr string) storagetest.Factory {
+	return func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		ctx := context.Background()
+		prefix := fmt.Sprintf("feeshcheck:%d:", time.Now().UnixNano())
+		s, err := sredis.New(ctx, sredis.Options{Addr: addr, Prefix: prefix, Retention: r})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			db := redis.NewClient(&redis.Options{Addr: addr})
+			defer db.Close()
+			iter := db.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+			for iter.Next(ctx) {
+				db.Del(ctx, iter.Val())
+			}
+		}, nil
+	}
+}
+
+func postgresFactory(dsn string) storagetest.Factory {
+	return func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		if dsn == "" {
+			return nil, nil, fmt.Errorf("postgres dsn is not set")
+		}
+		s, err := ssql.New(ssql.DriverPostgres, dsn, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			if err := ssql.Drop(ssql.DriverPostgres, dsn); err != nil {
+				fmt.Fprintf(os.Stderr, "error on dropping tables: %v\n", err)
+			}
+		}, nil
+	}
+}
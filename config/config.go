@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 
 	log "github.com/1F47E/go-feesh/logger"
 )
@@ -16,8 +17,38 @@ type Config struct {
 	BtcGetblock        string // For services like GetBlock where auth token is in URL
 	UseGetblock        bool   // Flag to indicate if we should use GetBlock style auth
 	ApiHost            string
-	RpcLimit           int // btc node config should be updated to allow more connections
-	BlocksParsingDepth int
+	RpcLimit           int     // btc node config should be updated to allow more connections
+	BlocksParsingDepth int     // last blocks with the txs kept
+	BlocksHistory      int     // last blocks with the stats and audits kept, not less than the parsing depth
+	BlocksWindowFile   string  // optional, persist parsed blocks window between restarts
+	PoolSnapshotFile   string  // optional, save the pool on shutdown and load it on startup
+	AdminToken         string  // optional, admin api is disabled without it
+	PoolsFile          string  // optional, mining pools definitions to use instead of embedded, reloaded on SIGHUP
+	ParserQueueSize    int     // max txs waiting to be parsed
+	ParserQueuePolicy  string  // block, reject or evict when the parser queue is full
+	RecordFile         string  // optional, append node events to the log for replay
+	ReplayFile         string  // optional, play the event log instead of connecting to the node
+	ReplaySpeed        float64 // replay speed factor, 1 - real time
+	SimScenario        string  // optional, run the simulated node with the scenario file or bundled name
+	SimSpeed           float64 // simulation speed factor, 1 - real time
+	MempoolApi         bool    // serve mempool.space compatible endpoints under /api
+	EsploraApi         bool    // serve esplora compatible endpoints under /api
+	ElectrumHost       string  // optional, electrum protocol listener for the fee methods
+	ElectrumTLSCert    string  // tls for the electrum listener if both cert and key are set
+	ElectrumTLSKey     string
+	// storage
+	Storage             string        // map, redis, bolt or sql
+	StorageEvictedGrace time.Duration // keep txs left the pool without being mined
+	StorageMemLimitMb   int           // 0 - no limit
+	StorageTxTTL        time.Duration // max tx lifetime in persistent storages, 0 - no limit
+	BoltFile            string
+	RedisAddr           string
+	RedisUser           string
+	RedisPass           string
+	RedisDB             int
+	RedisPrefix         string // network is appended, feesh:mainnet:
+	SqlDriver           string // sqlite or postgres
+	SqlDsn              string
 }
 
 func NewConfig() *Config {
@@ -25,9 +56,13 @@ func NewConfig() *Config {
 	btcGetblock := os.Getenv("BTC_GETBLOCK")
 	useGetblock := btcGetblock != ""
 
+	// replay and simulation modes do not connect to the node
+	replayFile := os.Getenv("REPLAY_FILE")
+	simScenario := os.Getenv("SIM_SCENARIO")
+
 	// If we're using GetBlock, we don't need RPC credentials
 	var rpcUser, rpcPass, rpcHost string
-	if !useGetblock {
+	if !useGetblock && replayFile == "" && simScenario == "" {
 		rpcUser = os.Getenv("RPC_USER")
 		if rpcUser == "" {
 			log.Log.Fatal("RPC_USER env var is required when not using BTC_GETBLOCK")
@@ -40,7 +75,7 @@ func NewConfig() *Config {
 		if rpcHost == "" {
 			log.Log.Fatal("RPC_HOST env var is required when not using BTC_GETBLOCK")
 		}
-	} else {
+	} else if useGetblock {
 		rpcHost = btcGetblock
 	}
 
@@ -50,7 +85,7 @@ func NewConfig() *Config {
 	}
 	rpcLimit, err := strconv.Atoi(rpcStr)
 	if err != nil {
-		log.Log.Fatalf("error on parse RPC_LIMIT env var:", err)
+		log.Log.Fatalf("error on parse RPC_LIMIT env var: %v", err)
 	}
 	if rpcLimit < 1 {
 		log.Log.Fatal("RPC_LIMIT env var should be greater than 0")
@@ -67,7 +102,150 @@ func NewConfig() *Config {
 	}
 	blocksDepth, err := strconv.Atoi(blocksDepthStr)
 	if err != nil {
-		log.Log.Fatalf("error on parse BLOCKS_PARSING_DEPTH env var:", err)
+		log.Log.Fatalf("error on parse BLOCKS_PARSING_DEPTH env var: %v", err)
+	}
+	if blocksDepth < 1 {
+		log.Log.Fatal("BLOCKS_PARSING_DEPTH env var should be greater than 0")
+	}
+
+	// optional
+	// a week of blocks for the mining pools stats
+	blocksHistory := 1008
+	if s := os.Getenv("BLOCKS_HISTORY"); s != "" {
+		blocksHistory, err = strconv.Atoi(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse BLOCKS_HISTORY env var: %v", err)
+		}
+	}
+	blocksHistory = max(blocksHistory, blocksDepth)
+	blocksWindowFile := os.Getenv("BLOCKS_WINDOW_FILE")
+	poolSnapshotFile := os.Getenv("POOL_SNAPSHOT_FILE")
+	poolsFile := os.Getenv("MINING_POOLS_FILE")
+	if poolsFile == "" {
+		// older name
+		poolsFile = os.Getenv("POOLS_FILE")
+	}
+
+	parserQueueSize := 200_000
+	if s := os.Getenv("PARSER_QUEUE_SIZE"); s != "" {
+		parserQueueSize, err = strconv.Atoi(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse PARSER_QUEUE_SIZE env var: %v", err)
+		}
+		if parserQueueSize < 1 {
+			log.Log.Fatal("PARSER_QUEUE_SIZE env var should be greater than 0")
+		}
+	}
+	parserQueuePolicy := os.Getenv("PARSER_QUEUE_POLICY")
+	if parserQueuePolicy == "" {
+		parserQueuePolicy = "block"
+	}
+
+	replaySpeed := 1.0
+	if s := os.Getenv("REPLAY_SPEED"); s != "" {
+		replaySpeed, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Log.Fatalf("error on parse REPLAY_SPEED env var: %v", err)
+		}
+		if replaySpeed <= 0 {
+			log.Log.Fatal("REPLAY_SPEED env var should be greater than 0")
+		}
+	}
+
+	simSpeed := 1.0
+	if s := os.Getenv("SIM_SPEED"); s != "" {
+		simSpeed, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Log.Fatalf("error on parse SIM_SPEED env var: %v", err)
+		}
+		if simSpeed <= 0 {
+			log.Log.Fatal("SIM_SPEED env var should be greater than 0")
+		}
+	}
+
+	var mempoolApi bool
+	if s := os.Getenv("MEMPOOL_API"); s != "" {
+		mempoolApi, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse MEMPOOL_API env var: %v", err)
+		}
+	}
+
+	var esploraApi bool
+	if s := os.Getenv("ESPLORA_API"); s != "" {
+		esploraApi, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse ESPLORA_API env var: %v", err)
+		}
+	}
+
+	electrumTLSCert := os.Getenv("ELECTRUM_TLS_CERT")
+	electrumTLSKey := os.Getenv("ELECTRUM_TLS_KEY")
+	if (electrumTLSCert == "") != (electrumTLSKey == "") {
+		log.Log.Fatal("ELECTRUM_TLS_CERT and ELECTRUM_TLS_KEY env vars should be set together")
+	}
+
+	storageEvictedGrace := 1 * time.Hour
+	if s := os.Getenv("STORAGE_EVICTED_GRACE"); s != "" {
+		storageEvictedGrace, err = time.ParseDuration(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse STORAGE_EVICTED_GRACE env var: %v", err)
+		}
+	}
+	storageMemLimitMb := 1024
+	if s := os.Getenv("STORAGE_MEMORY_LIMIT_MB"); s != "" {
+		storageMemLimitMb, err = strconv.Atoi(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse STORAGE_MEMORY_LIMIT_MB env var: %v", err)
+		}
+		if storageMemLimitMb < 0 {
+			log.Log.Fatal("STORAGE_MEMORY_LIMIT_MB env var should not be negative")
+		}
+	}
+
+	storageType := os.Getenv("STORAGE")
+	if storageType == "" {
+		storageType = "map"
+	}
+	boltFile := os.Getenv("BOLT_FILE")
+	if boltFile == "" {
+		boltFile = "feesh.db"
+	}
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	redisDB := 0
+	if s := os.Getenv("REDIS_DB"); s != "" {
+		redisDB, err = strconv.Atoi(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse REDIS_DB env var: %v", err)
+		}
+	}
+	redisPrefix := os.Getenv("REDIS_PREFIX")
+	if redisPrefix == "" {
+		redisPrefix = "feesh"
+	}
+	sqlDriver := os.Getenv("SQL_DRIVER")
+	if sqlDriver == "" {
+		sqlDriver = "sqlite"
+	}
+	if sqlDriver != "sqlite" && sqlDriver != "postgres" {
+		log.Log.Fatalf("unknown SQL_DRIVER: %s", sqlDriver)
+	}
+	sqlDsn := os.Getenv("SQL_DSN")
+	if sqlDsn == "" {
+		if sqlDriver == "postgres" {
+			log.Log.Fatal("SQL_DSN env var is required for postgres")
+		}
+		sqlDsn = "feesh.sqlite"
+	}
+	storageTxTTL := 14 * 24 * time.Hour
+	if s := os.Getenv("STORAGE_TX_TTL"); s != "" {
+		storageTxTTL, err = time.ParseDuration(s)
+		if err != nil {
+			log.Log.Fatalf("error on parse STORAGE_TX_TTL env var: %v", err)
+		}
 	}
 
 	return &Config{
@@ -79,5 +257,35 @@ func NewConfig() *Config {
 		RpcLimit:           rpcLimit,
 		ApiHost:            apiHost,
 		BlocksParsingDepth: blocksDepth,
+		BlocksHistory:      blocksHistory,
+		BlocksWindowFile:   blocksWindowFile,
+		PoolSnapshotFile:   poolSnapshotFile,
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		PoolsFile:          poolsFile,
+		ParserQueueSize:    parserQueueSize,
+		ParserQueuePolicy:  parserQueuePolicy,
+		RecordFile:         os.Getenv("RECORD_FILE"),
+		ReplayFile:         replayFile,
+		ReplaySpeed:        replaySpeed,
+		SimScenario:        simScenario,
+		SimSpeed:           simSpeed,
+		MempoolApi:         mempoolApi,
+		EsploraApi:         esploraApi,
+		ElectrumHost:       os.Getenv("ELECTRUM_HOST"),
+		ElectrumTLSCert:    electrumTLSCert,
+		ElectrumTLSKey:     electrumTLSKey,
+
+		Storage:             storageType,
+		StorageEvictedGrace: storageEvictedGrace,
+		StorageMemLimitMb:   storageMemLimitMb,
+		StorageTxTTL:        storageTxTTL,
+		BoltFile:            boltFile,
+		RedisAddr:           redisAddr,
+		RedisUser:           os.Getenv("REDIS_USER"),
+		RedisPass:           os.Getenv("REDIS_PASS"),
+		RedisDB:             redisDB,
+		RedisPrefix:         redisPrefix,
+		SqlDriver:           sqlDriver,
+		SqlDsn:              sqlDsn,
 	}
 }
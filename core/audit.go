@@ -0,0 +1,82 @@
+package core
+
+import (
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+// how many blocks back projected templates are kept
+const templatesLimit = 3
+
+// txs projected to fit in the next block on top of the best block
+type blockTemplate struct {
+	best   string // best block hash the pool was projected on
+	height int    // best block height
+	txs    map[string]struct{}
+	fee    uint64
+	time   time.Time
+}
+
+// keep the last projected template for the best block, called by pool sorter.
+// Must be called with c.mu locked
+func (c *Core) setTemplate(t blockTemplate) {
+	c.templates[t.best] = t
+	for hash, old := range c.templates {
+		if old.height <= t.height-templatesLimit {
+			delete(c.templates, hash)
+		}
+	}
+}
+
+// compare the mined block with the template projected on its parent.
+// Returns false if there is no template for the parent
+func (c *Core) auditBlock(b mblock.Block, raw *block.Block) (mblock.Audit, bool) {
+	c.mu.Lock()
+	t, ok := c.templates[raw.Previousblockhash]
+	c.mu.Unlock()
+	if !ok {
+		return mblock.Audit{}, false
+	}
+
+	ret := mblock.Audit{
+		Hash:        b.Hash,
+		Height:      b.Height,
+		Time:        time.Now().Unix(),
+		ExpectedTxs: len(t.txs),
+		Missing:     make([]string, 0),
+		Added:       make([]string, 0),
+		Unseen:      make([]string, 0),
+		ExpectedFee: t.fee,
+		ActualFee:   b.Fee,
+		FeeDelta:    int64(b.Fee) - int64(t.fee),
+	}
+	mined := make(map[string]struct{}, len(raw.Transactions))
+	for i, txid := range raw.Transactions {
+		mined[txid] = struct{}{}
+		if _, ok := t.txs[txid]; ok {
+			ret.MatchedTxs++
+			continue
+		}
+		// coinbase is never projected
+		if i == 0 {
+			continue
+		}
+		if c.pool.Seen(txid) {
+			ret.Added = append(ret.Added, txid)
+		} else {
+			ret.Unseen = append(ret.Unseen, txid)
+		}
+	}
+	for txid := range t.txs {
+		if _, ok := mined[txid]; !ok {
+			ret.Missing = append(ret.Missing, txid)
+		}
+	}
+	ret.Health = 100
+	if ret.ExpectedTxs > 0 {
+		ret.Health = float64(ret.MatchedTxs) / float64(ret.ExpectedTxs) * 100
+	}
+	return ret, true
+}
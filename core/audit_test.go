@@ -0,0 +1,52 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+func TestAuditBlockUsesParentTemplate(t *testing.T) {
+	c, _ := newTestCore(t, newFakeNode(), 3)
+	a100, b100 := testHash("a100"), testHash("b100")
+
+	// competing tips at the same height, the pool was projected on both
+	c.mu.Lock()
+	c.setTemplate(blockTemplate{best: a100, height: 100, txs: map[string]struct{}{testHash("tx1"): {}}, time: time.Now()})
+	c.setTemplate(blockTemplate{best: b100, height: 100, txs: map[string]struct{}{testHash("tx2"): {}}, time: time.Now()})
+	c.mu.Unlock()
+
+	raw := &block.Block{
+		Hash:              testHash("b101"),
+		Height:            101,
+		Previousblockhash: b100,
+		Transactions:      []string{testHash("cb101"), testHash("tx2")},
+	}
+	audit, ok := c.auditBlock(mblock.Block{Hash: raw.Hash, Height: raw.Height}, raw)
+	if !ok {
+		t.Fatal("no template for the parent block")
+	}
+	if audit.MatchedTxs != 1 || len(audit.Missing) != 0 {
+		t.Fatalf("audited against the wrong template: matched %d, missing %v", audit.MatchedTxs, audit.Missing)
+	}
+
+	raw.Previousblockhash = testHash("c100")
+	if _, ok := c.auditBlock(mblock.Block{Hash: raw.Hash, Height: raw.Height}, raw); ok {
+		t.Fatal("block with an unknown parent is audited")
+	}
+}
+
+func TestSetTemplateTrimsOldTips(t *testing.T) {
+	c, _ := newTestCore(t, newFakeNode(), 3)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for height := 100; height < 110; height++ {
+		c.setTemplate(blockTemplate{best: testHash(fmt.Sprintf("a%d", height)), height: height})
+	}
+	if len(c.templates) != templatesLimit {
+		t.Fatalf("templates: got %d, want %d", len(c.templates), templatesLimit)
+	}
+}
@@ -2,19 +2,28 @@ package core
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/1F47E/go-feesh/client"
 	"github.com/1F47E/go-feesh/config"
 	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
+	"github.com/1F47E/go-feesh/mining"
 	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/queue"
 	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/supervisor"
+	"github.com/1F47E/go-feesh/timeseries"
 
 	"sync"
+	"sync/atomic"
 
+	"github.com/1F47E/go-feesh/entity/btc/block"
 	"github.com/1F47E/go-feesh/entity/btc/info"
-	"github.com/1F47E/go-feesh/entity/btc/txpool"
 	mblock "github.com/1F47E/go-feesh/entity/models/block"
 	mtx "github.com/1F47E/go-feesh/entity/models/tx"
 )
@@ -22,55 +31,97 @@ import (
 type Core struct {
 	mu      *sync.Mutex
 	Cfg     *config.Config
-	cli     *client.Client
+	cli     Node
 	storage storage.PoolRepository
 	// ws
 	broadcastCh chan notificator.Msg
+	blocksCh    chan notificator.BlockMsg
 
+	miners *mining.Matcher
+
+	// chain tip as seen by the pool puller, guarded by mu
 	height int
+	best   string
+	// mainnet or testnet, guarded by mu
+	network string
+
+	pool *mempool.Pool
+
+	// published state for readers
+	snapshot   atomic.Pointer[Snapshot]
+	snapshotMu sync.Mutex
 
-	// because total fee in sat will overflow uint64, sat in 1000 sats
-	poolFeeTotal uint64
-	poolFeeAvg   uint64
+	templates map[string]blockTemplate // projected next block by best block hash
 
-	totalAmount uint64
-	// totalWeight uint64
-	totalSize uint64
+	blockDepth    int                     // how deep to scan the blocks from the top
+	blocks        *blockWindow            // last processed blocks by height, the txs are kept for blockDepth of them
+	blocksPending map[string]pendingBlock // fetched blocks waiting for txs to be parsed
 
-	feeBucketsMap map[uint]uint
-	feeBuckets    []uint
+	parserQueue *queue.Queue
 
-	poolCopy        []txpool.TxPool
-	poolCopyMap     map[string]txpool.TxPool
-	poolSorted      []mtx.Tx
-	poolSizeHistory []uint
+	// prevouts sources and the node calls limit for them
+	outputs        *outputsCache
+	prevoutFetches *budget
 
-	blockDepth  int      // how deep to scan the blocks from the top
-	blocksIndex []string // keep track of parsed blocks
-	blocks      []mblock.Block
+	history *timeseries.Store
 
-	// blocks      []*mblock.Block
-	parserJobCh chan string
+	sup *supervisor.Supervisor
+	// parsers are stopped separately to drain the queue on shutdown
+	stopIngest  context.CancelFunc
+	stopParsers context.CancelFunc
 }
 
-func NewCore(ctx context.Context, cfg *config.Config, cli *client.Client, s storage.PoolRepository, broadcastCh chan notificator.Msg) *Core {
-	return &Core{
+// supervised workers names
+const (
+	workerParserBlocks    = "workerParserBlocks"
+	workerBlocksProcessor = "workerBlocksProcessor"
+	workerTxParser        = "workerTxParser"
+	workerPoolPuller      = "workerPoolPuller"
+	workerPoolSorter      = "workerPoolSorter"
+	workerPoolSizeHistory = "workerPoolSizeHistory"
+	workerHistory         = "workerHistory"
+)
+
+func NewCore(ctx context.Context, cfg *config.Config, cli Node, s storage.PoolRepository, broadcastCh chan notificator.Msg, blocksCh chan notificator.BlockMsg) *Core {
+	miners, err := mining.New(cfg.PoolsFile)
+	if err != nil {
+		logger.Log.Errorf("error on loading pools file %s, using embedded: %v\n", cfg.PoolsFile, err)
+		miners, _ = mining.New("")
+	}
+	policy, err := queue.ParsePolicy(cfg.ParserQueuePolicy)
+	if err != nil {
+		logger.Log.Errorf("%v, using %s\n", err, queue.PolicyBlock)
+		policy = queue.PolicyBlock
+	}
+	c := &Core{
 		mu:          &sync.Mutex{},
 		Cfg:         cfg,
 		cli:         cli,
 		storage:     s,
 		broadcastCh: broadcastCh,
+		blocksCh:    blocksCh,
+		miners:      miners,
 
-		poolCopy:        make([]txpool.TxPool, 0),
-		poolCopyMap:     make(map[string]txpool.TxPool),
-		poolSorted:      make([]mtx.Tx, 0),
-		poolSizeHistory: make([]uint, 0),
-		// blocks:      make([]*mblock.Block, 0),
-		blockDepth:  cfg.BlocksParsingDepth,
-		blocksIndex: make([]string, 0),
-		// block:       make(map[string]string),
-		parserJobCh: make(chan string),
+		pool:           mempool.New(),
+		templates:      make(map[string]blockTemplate),
+		blockDepth:     cfg.BlocksParsingDepth,
+		blocks:         newBlockWindow(max(cfg.BlocksParsingDepth, cfg.BlocksHistory)),
+		blocksPending:  make(map[string]pendingBlock),
+		parserQueue:    queue.New(cfg.ParserQueueSize, policy),
+		outputs:        newOutputsCache(outputsCacheLimit),
+		prevoutFetches: newBudget(prevoutsFetchRate),
+		history:        newHistory(s),
+		sup:            supervisor.New(),
 	}
+	c.snapshot.Store(&Snapshot{
+		Time:            time.Now(),
+		PoolSizeHistory: make([]uint, 0),
+		FeeBuckets:      make([]uint, len(mempool.Buckets)),
+		FeeBucketsMap:   make(map[uint]uint),
+		Txs:             make([]mtx.Tx, 0),
+		Blocks:          make([]mblock.Block, 0),
+	})
+	return c
 }
 
 func (c *Core) Start(ctx context.Context) {
@@ -86,87 +137,341 @@ func (c *Core) Start(ctx context.Context) {
 	} else {
 		// even if its fails - having block 0 will update pool txs list every time
 		// its just for performance reasons
-		c.height = info.Blocks
+		c.setTip(info.Blocks, "")
+		c.mu.Lock()
+		c.network = "mainnet"
+		if info.Testnet {
+			c.network = "testnet"
+		}
+		c.mu.Unlock()
 	}
 
-	go c.workerParserBlocks(ctx, 3*time.Second)
-	go c.workerBlocksProcessor(ctx, 1*time.Second)
+	c.bootstrap()
+	c.bootstrapHistory()
+	c.bootstrapPool()
+
+	parseCtx, stopParsers := context.WithCancel(ctx)
+	c.stopParsers = stopParsers
+	ctx, stopIngest := context.WithCancel(ctx)
+	c.stopIngest = stopIngest
+
+	// workers are restarted on crash, no beats for the stale period marks the service degraded.
+	// blocks parser beats on every fetched block, the startup backfill can take a while
+	c.sup.Go(ctx, workerParserBlocks, 10*time.Minute, func(ctx context.Context) {
+		c.workerParserBlocks(ctx, 3*time.Second)
+	})
+	c.sup.Go(ctx, workerBlocksProcessor, 1*time.Minute, func(ctx context.Context) {
+		c.workerBlocksProcessor(ctx, 1*time.Second)
+	})
 
 	// make a batch of parsers
 	// each parse makes a new RPC connection on every job
 	for i := 0; i < c.Cfg.RpcLimit; i++ {
-		go c.workerTxParser(ctx, i+1)
+		n := i + 1
+		c.sup.Go(parseCtx, fmt.Sprintf("%s #%d", workerTxParser, n), 0, func(ctx context.Context) {
+			c.workerTxParser(ctx, n)
+		})
 	}
 
-	if os.Getenv("DEBUG") == "WS" {
-		go c.workerPoolDebug(ctx, 1*time.Second)
-		return
+	c.sup.Go(ctx, workerPoolPuller, 30*time.Second, func(ctx context.Context) {
+		c.workerPoolPuller(ctx, 1*time.Second)
+	})
+	c.sup.Go(ctx, workerPoolSorter, 30*time.Second, func(ctx context.Context) {
+		c.workerPoolSorter(ctx, 1*time.Second)
+	})
+	c.sup.Go(ctx, workerPoolSizeHistory, 15*time.Minute, func(ctx context.Context) {
+		c.workerPoolSizeHistory(ctx, poolSizeHistoryPeriod)
+	})
+	c.sup.Go(ctx, workerHistory, time.Minute, func(ctx context.Context) {
+		c.workerHistory(ctx, timeseries.Resolutions[0].Step)
+	})
+}
+
+// stop pulling the pool and blocks, let parsers drain the queue
+// and wait for all workers to exit until ctx is done
+func (c *Core) Stop(ctx context.Context) error {
+	log := logger.Log.WithField("context", "[core]")
+	if c.stopIngest == nil {
+		return nil
+	}
+	c.stopIngest()
+	if left := c.drain(ctx); left > 0 {
+		log.Warnf("abandoned %d txs in the parser queue\n", left)
+	}
+	c.stopParsers()
+	return c.sup.Wait(ctx)
+}
+
+// wait for the parser queue to be empty.
+// Takes half of the time left to leave some for the workers to exit,
+// returns the number of txs left
+func (c *Core) drain(ctx context.Context) int {
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+		defer cancel()
 	}
-	go c.workerPoolPuller(ctx, 1*time.Second)
-	go c.workerPoolSorter(ctx, 1*time.Second)
-	go c.workerPoolSizeHistory(ctx, 5*time.Minute)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		left := c.parserQueue.Pending()
+		if left == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return left
+		case <-ticker.C:
+		}
+	}
+}
+
+// flush the state to disk and close the storage.
+// Should be called after Stop
+func (c *Core) Close() error {
+	log := logger.Log.WithField("context", "[core]")
+	var ret error
+	if c.Cfg.BlocksWindowFile != "" && c.blocks.Len() > 0 {
+		if err := c.blocks.Save(c.Cfg.BlocksWindowFile); err != nil {
+			ret = errors.Join(ret, fmt.Errorf("error on saving blocks window: %w", err))
+		} else {
+			log.Infof("saved %d blocks to %s\n", c.blocks.Len(), c.Cfg.BlocksWindowFile)
+		}
+	}
+	if c.Cfg.PoolSnapshotFile != "" && c.pool.Len() > 0 {
+		if err := c.savePool(c.Cfg.PoolSnapshotFile); err != nil {
+			ret = errors.Join(ret, fmt.Errorf("error on saving pool snapshot: %w", err))
+		} else {
+			log.Infof("saved %d pool txs to %s\n", c.pool.Len(), c.Cfg.PoolSnapshotFile)
+		}
+	}
+	if err := c.history.Flush(); err != nil {
+		ret = errors.Join(ret, fmt.Errorf("error on saving metrics history: %w", err))
+	}
+	if closer, ok := c.storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			ret = errors.Join(ret, fmt.Errorf("error on closing storage: %w", err))
+		}
+	}
+	return ret
+}
+
+// workers liveness
+func (c *Core) Health() supervisor.Health {
+	return c.sup.Health()
+}
+
+// all workers produced fresh data
+func (c *Core) Ready() bool {
+	return c.sup.Ready()
 }
 
 func (c *Core) GetNodeInfo() (*info.Info, error) {
+	if c.cli == nil {
+		return nil, ErrNoNode
+	}
 	return c.cli.GetInfo()
 }
 
-// parse last N blocks
-//
-//nolint:unused
+// restore blocks window persisted on the previous run, from the file or the storage.
+// Missing blocks (last N from the best one) are fetched by workerParserBlocks
 func (c *Core) bootstrap() {
-	// TODO: bootstap blocks
-	// get best block
-	// download header, get prev block, repeat N times
-	// parse every block
+	log := logger.Log.WithField("context", "[bootstrap]")
+	if c.Cfg.BlocksWindowFile != "" {
+		if err := c.blocks.Load(c.Cfg.BlocksWindowFile); err != nil {
+			log.Errorf("error on loading blocks window: %v\n", err)
+		}
+	}
+	if c.blocks.Len() == 0 {
+		blocks, err := c.storage.BlockStatsList()
+		if err != nil {
+			log.Errorf("error on loading blocks from the storage: %v\n", err)
+		}
+		for _, b := range blocks {
+			c.dropBlocks(c.blocks.Add(b))
+		}
+	}
+	c.trimBlockTxs()
+	if c.blocks.Len() == 0 {
+		return
+	}
+	if tip, ok := c.blocks.Tip(); ok {
+		log.Infof("restored %d blocks, tip %d %s\n", c.blocks.Len(), tip.Height, tip.Hash)
+	}
+	blocks := c.blocks.List()
+	c.publish(func(s *Snapshot) {
+		s.Blocks = blocks
+	})
 }
 
-func (c *Core) GetPool(limit int) ([]mtx.Tx, error) {
-	if len(c.poolSorted) <= limit {
-		return c.poolSorted, nil
+// restore pool size and metrics history if the storage keeps it
+func (c *Core) bootstrapHistory() {
+	log := logger.Log.WithField("context", "[bootstrap]")
+	hs, ok := c.storage.(storage.HistoryRepository)
+	if !ok {
+		return
 	}
-	return c.poolSorted[:limit], nil
+	now := time.Now()
+	if err := c.history.Load(now); err != nil {
+		log.Errorf("error on loading metrics history: %v\n", err)
+	}
+	points, err := hs.HistoryRange(seriesPoolSize, now.Add(-time.Duration(poolSizeHistoryLimit)*poolSizeHistoryPeriod), now)
+	if err != nil {
+		log.Errorf("error on loading pool size history: %v\n", err)
+		return
+	}
+	history := make([]uint, 0, len(points))
+	for _, p := range points {
+		if len(p.Values) > 0 {
+			history = append(history, uint(p.Values[0]))
+		}
+	}
+	if len(history) > poolSizeHistoryLimit {
+		history = history[len(history)-poolSizeHistoryLimit:]
+	}
+	log.Infof("restored %d pool size history points\n", len(history))
+	c.publish(func(s *Snapshot) {
+		s.PoolSizeHistory = history
+	})
 }
 
-func (c *Core) GetHeight() int {
-	return c.height
+// set the chain tip, returns true if changed
+func (c *Core) setTip(height int, hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.height == height && c.best == hash {
+		return false
+	}
+	c.height = height
+	c.best = hash
+	return true
 }
 
-func (c *Core) GetPoolSize() int {
-	return len(c.poolSorted)
+// parsed blocks, newest first
+func (c *Core) GetBlocks() []mblock.Block {
+	return c.Snapshot().Blocks
 }
 
-func (c *Core) GetPoolSizeHistory() []uint {
-	return c.poolSizeHistory
+func (c *Core) GetBlockByHash(hash string) (mblock.Block, bool) {
+	return c.Snapshot().BlockByHash(hash)
 }
 
-func (c *Core) GetTotalAmount() uint64 {
-	return c.totalAmount
+func (c *Core) GetBlockByHeight(height int) (mblock.Block, bool) {
+	return c.Snapshot().BlockByHeight(height)
 }
 
-func (c *Core) GetFeeTotal() uint64 {
-	return c.poolFeeTotal
+// block header and txids from the node
+func (c *Core) GetNodeBlock(hash string) (*block.Block, error) {
+	if c.cli == nil {
+		return nil, ErrNoNode
+	}
+	return c.cli.GetBlock(hash)
 }
 
-func (c *Core) GetFeeAvg() uint64 {
-	return c.poolFeeAvg
+// block header without txids from the node
+func (c *Core) GetNodeBlockHeader(hash string) (*block.Block, error) {
+	if c.cli == nil {
+		return nil, ErrNoNode
+	}
+	return c.cli.GetBlockHeader(hash)
 }
 
-func (c *Core) GetFeeBucketsMap() map[uint]uint {
-	return c.feeBucketsMap
+// best block hash and height from the node
+func (c *Core) GetTip() (*client.ResponseGetBestBlock, error) {
+	if c.cli == nil {
+		return nil, ErrNoNode
+	}
+	return c.cli.GetBestBlock()
 }
 
-func (c *Core) GetFeeBuckets() []uint {
-	return c.feeBuckets
+// hash of the block at the height, known for the window blocks and the tip only
+func (c *Core) GetBlockHash(height int) (string, error) {
+	if b, ok := c.GetBlockByHeight(height); ok {
+		return b.Hash, nil
+	}
+	tip, err := c.GetTip()
+	if err != nil {
+		return "", err
+	}
+	if tip.Height != height {
+		return "", ErrBlockNotFound
+	}
+	return tip.Hash, nil
 }
 
-func (c *Core) GetTotalSize() uint64 {
-	sizeBytes := c.totalSize
-	sizeKb := sizeBytes / 1024
-	// sizeMb := sizeKb / 1024
-	return sizeKb
+// page of the block txs in the block order, total is the block txs count.
+// Txs not parsed yet have the hash only
+func (c *Core) GetBlockTxs(hash string, offset, limit int) ([]mtx.Tx, int, error) {
+	txids, err := c.storage.BlockGet(hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	if txids == nil {
+		return nil, 0, ErrBlockNotFound
+	}
+	offset = min(max(offset, 0), len(txids))
+	page := txids[offset : offset+min(max(limit, 0), len(txids)-offset)]
+	parsed, err := c.storage.TxGetMany(page)
+	if err != nil {
+		return nil, 0, err
+	}
+	ret := make([]mtx.Tx, len(page))
+	for i, txid := range page {
+		if tx, ok := parsed[txid]; ok {
+			ret[i] = tx
+		} else {
+			ret[i] = mtx.Tx{Hash: txid}
+		}
+	}
+	return ret, len(txids), nil
 }
 
-func (c *Core) GetBlocks() []mblock.Block {
-	return c.blocks
+// reload the mining pools definitions from the file, the current ones are kept on error.
+// New blocks are attributed with the new definitions, processed ones keep their pools
+func (c *Core) ReloadMiningPools() error {
+	return c.miners.Load(c.Cfg.PoolsFile)
+}
+
+// mining pools stats for the blocks mined within the period.
+// Limited by the blocks history
+func (c *Core) GetMiningStats(period time.Duration) []mining.Stats {
+	return mining.Aggregate(c.Snapshot().Blocks, time.Now().Add(-period))
+}
+
+func (c *Core) GetBlockAudit(hash string) (*mblock.Audit, error) {
+	return c.storage.AuditGet(hash)
+}
+
+// audits of the last blocks in the window, newest first
+func (c *Core) GetBlockAudits(limit int) ([]mblock.Audit, error) {
+	ret := make([]mblock.Audit, 0)
+	blocks := c.Snapshot().Blocks
+	for _, b := range blocks[:min(max(limit, 0), len(blocks))] {
+		a, err := c.storage.AuditGet(b.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if a != nil {
+			ret = append(ret, *a)
+		}
+	}
+	return ret, nil
+}
+
+// filtered and sorted pool txs page
+func (c *Core) QueryPool(q mempool.Query) (mempool.Page, error) {
+	return c.pool.Query(q)
+}
+
+// pool running aggregates
+func (c *Core) GetPoolStats() mempool.Stats {
+	return c.pool.Stats()
+}
+
+func (c *Core) GetQueueStats() queue.Stats {
+	return c.parserQueue.Stats()
+}
+
+func (c *Core) GetStorageStats() storage.Stats {
+	return c.storage.Stats()
 }
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/mining"
+)
+
+func TestGetBlockTxsPaging(t *testing.T) {
+	c, s := newTestCore(t, newFakeNode(), 3)
+	hash := testHash("a100")
+	txids := []string{testHash("cb100"), testHash("tx1"), testHash("tx2")}
+	if err := s.BlockAdd(hash, txids); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		offset, limit int
+		want          int
+	}{
+		{0, 2, 2},
+		{1, 100, 2},
+		{3, 100, 0},
+		{math.MaxInt - 1, 100, 0},
+		{1, math.MaxInt, 2},
+		{-1, 1, 1},
+	} {
+		txs, total, err := c.GetBlockTxs(hash, tc.offset, tc.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != len(txids) || len(txs) != tc.want {
+			t.Fatalf("offset %d limit %d: got %d of %d txs, want %d", tc.offset, tc.limit, len(txs), total, tc.want)
+		}
+	}
+}
+
+// node serving the coinbase txs of the test blocks
+type coinbaseNode struct {
+	*fakeNode
+	coinbases map[string]*tx.Transaction
+}
+
+func (n *coinbaseNode) TransactionGet(txid string) (*tx.Transaction, error) {
+	if t, ok := n.coinbases[txid]; ok {
+		return t, nil
+	}
+	return nil, errFakeNotFound
+}
+
+func TestGetMiningStats(t *testing.T) {
+	node := &coinbaseNode{fakeNode: newFakeNode(), coinbases: make(map[string]*tx.Transaction)}
+	c, _ := newTestCore(t, node, 3)
+	c.blocks = newBlockWindow(1008)
+
+	now := time.Now()
+	for i, tc := range []struct {
+		ago       time.Duration
+		scriptSig string
+		address   string
+	}{
+		{time.Hour, "/AntPool/ Mined by pool1g", ""},
+		{2 * time.Hour, "Foundry USA Pool #dropgold/", ""},
+		{3 * time.Hour, "/AntPool/", ""},
+		{4 * time.Hour, "/solo/", "bc1qunknownpayout"},
+		{3 * 24 * time.Hour, "/ViaBTC/Mined by 260786/", ""},
+		{8 * 24 * time.Hour, "/F2Pool/", ""},
+	} {
+		height := 800_000 - i
+		cb := testHash(fmt.Sprintf("cb%d", height))
+		script := append([]byte{0x03, byte(height), byte(height >> 8), byte(height >> 16)}, tc.scriptSig...)
+		coinbase := &tx.Transaction{Txid: cb, Vin: []tx.Vin{{Coinbase: hex.EncodeToString(script)}}}
+		if tc.address != "" {
+			coinbase.Vout = []tx.Vout{{Value: 3.125, ScriptPubKey: tx.ScriptPubKey{Address: tc.address}}}
+		}
+		node.coinbases[cb] = coinbase
+		raw := &block.Block{Hash: testHash(fmt.Sprintf("b%d", height)), Height: height, Transactions: []string{cb, testHash("tx")}}
+		b := mblock.Block{Hash: raw.Hash, Height: height, Time: now.Add(-tc.ago).Unix(), Txs: 2, Fee: 1000}
+		c.attributeBlock(&b, raw)
+		c.blocks.Add(b)
+	}
+	blocks := c.blocks.List()
+	c.publish(func(s *Snapshot) {
+		s.Blocks = blocks
+	})
+
+	names := func(stats []mining.Stats) map[string]int {
+		ret := make(map[string]int)
+		for _, s := range stats {
+			ret[s.Name] = s.Blocks
+		}
+		return ret
+	}
+	for _, tc := range []struct {
+		period time.Duration
+		want   map[string]int
+	}{
+		{24 * time.Hour, map[string]int{"AntPool": 2, "Foundry USA": 1, mining.Unknown: 1}},
+		{7 * 24 * time.Hour, map[string]int{"AntPool": 2, "Foundry USA": 1, mining.Unknown: 1, "ViaBTC": 1}},
+	} {
+		stats := c.GetMiningStats(tc.period)
+		if got := names(stats); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("period %s: got %v, want %v", tc.period, got, tc.want)
+		}
+		if stats[0].Name != "AntPool" {
+			t.Fatalf("period %s: most blocks first, got %s", tc.period, stats[0].Name)
+		}
+	}
+}
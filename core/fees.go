@@ -0,0 +1,164 @@
+package core
+
+import (
+	"math"
+	"time"
+
+	"github.com/1F47E/go-feesh/config"
+)
+
+// min fee rate relayed by the nodes, sat/vB
+const MinRelayFeeRate = 1
+
+// blocks between the difficulty adjustments
+const retargetBlocks = 2016
+
+// testnet min difficulty block is allowed after this time without blocks
+const testnetMaxBlockTime = 20 * time.Minute
+
+// projected blocks below this vsize are not worth paying above the minimum
+const (
+	halfBlockVSize = 500_000
+	fullBlockVSize = 950_000
+)
+
+// confirmation targets in blocks for the fee estimates
+var FeeEstimateTargets = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20,
+	21, 22, 23, 24, 25, 144, 504, 1008}
+
+// recommended fee rates in sat/vB
+type Fees struct {
+	Fastest  uint64 // next block
+	HalfHour uint64 // within 3 blocks
+	Hour     uint64 // within 6 blocks
+	Economy  uint64
+	Minimum  uint64
+}
+
+// progress of the current difficulty epoch
+type Difficulty struct {
+	Progress        float64 // percent of the epoch blocks mined
+	Change          float64 // estimated difficulty change in percent
+	RetargetTime    time.Time
+	RetargetHeight  int
+	RemainingBlocks int
+	EpochStart      time.Time // estimated by the average block time if the epoch start is not in the window
+	BlockTime       time.Duration
+	ExpectedBlocks  float64 // blocks expected since the epoch start at the target time
+	// change of the last retarget in percent,
+	// nil if the epoch start or the block before it is not in the window
+	PreviousRetarget *float64
+	// testnet only, negative time since the tip if the next block can be min difficulty
+	TimeOffset time.Duration
+}
+
+// fee rates to get confirmed by the projected pool blocks.
+// Each estimate is a median of the projected block smoothed with the previous one,
+// not full blocks lower it to the minimum
+func (c *Core) RecommendedFees() Fees {
+	blocks := c.Snapshot().PoolBlocks
+	minimum := float64(MinRelayFeeRate)
+	median := func(i int, prev float64) float64 {
+		if i >= len(blocks) {
+			return minimum
+		}
+		b := blocks[i]
+		fee := b.MedianFeeRate
+		if prev > 0 {
+			fee = (fee + prev) / 2
+		}
+		vsize := float64(b.Weight / 4)
+		switch {
+		case vsize <= halfBlockVSize:
+			return minimum
+		case vsize <= fullBlockVSize && i == len(blocks)-1:
+			// last block, the pool is cleared before it is full
+			return math.Max(math.Round(fee*(vsize-halfBlockVSize)/halfBlockVSize), minimum)
+		}
+		return math.Max(math.Round(fee), minimum)
+	}
+	first := median(0, 0)
+	second := median(1, first)
+	third := median(2, second)
+	// slower estimates never exceed the faster ones
+	ret := Fees{Fastest: uint64(first), Minimum: uint64(minimum)}
+	ret.HalfHour = min(uint64(second), ret.Fastest)
+	ret.Hour = min(uint64(third), ret.HalfHour)
+	ret.Economy = min(uint64(2*minimum), ret.Hour)
+	return ret
+}
+
+// current epoch progress and the estimated adjustment.
+// Block time is measured from the epoch start if it is in the blocks window,
+// otherwise from the window blocks
+func (c *Core) GetDifficulty() Difficulty {
+	snap := c.Snapshot()
+	now := time.Now()
+	start := snap.Height - snap.Height%retargetBlocks
+	mined := snap.Height - start
+	ret := Difficulty{
+		Progress:        float64(mined) / retargetBlocks * 100,
+		RetargetHeight:  start + retargetBlocks,
+		RemainingBlocks: retargetBlocks - mined,
+		BlockTime:       blockInterval(snap.Blocks),
+	}
+	if b, ok := snap.BlockByHeight(start); ok {
+		ret.EpochStart = time.Unix(b.Time, 0)
+		if mined > 0 {
+			ret.BlockTime = now.Sub(ret.EpochStart) / time.Duration(mined)
+		}
+	} else {
+		ret.EpochStart = now.Add(-time.Duration(mined) * ret.BlockTime)
+	}
+	if b, ok := snap.BlockByHeight(start); ok {
+		if prev, ok := snap.BlockByHeight(start - 1); ok && prev.Difficulty > 0 && b.Difficulty > 0 {
+			change := (b.Difficulty/prev.Difficulty - 1) * 100
+			ret.PreviousRetarget = &change
+		}
+	}
+	c.mu.Lock()
+	testnet := c.network == "testnet"
+	c.mu.Unlock()
+	if testnet && len(snap.Blocks) > 0 {
+		// same as mempool.space, the 20 minutes rule makes the blocks faster
+		ret.BlockTime = min(ret.BlockTime, testnetMaxBlockTime)
+		sinceTip := now.Sub(time.Unix(snap.Blocks[0].Time, 0))
+		if sinceTip+ret.BlockTime > testnetMaxBlockTime {
+			ret.TimeOffset = -min(sinceTip, testnetMaxBlockTime)
+		}
+	}
+	ret.ExpectedBlocks = now.Sub(ret.EpochStart).Minutes() / defaultBlockInterval.Minutes()
+	ret.RetargetTime = now.Add(time.Duration(ret.RemainingBlocks) * ret.BlockTime)
+	if ret.BlockTime > 0 {
+		// retarget is limited to 4x in both directions
+		change := (float64(defaultBlockInterval)/float64(ret.BlockTime) - 1) * 100
+		ret.Change = math.Max(math.Min(change, 300), -75)
+	}
+	return ret
+}
+
+// fee rate in sat/vB to get confirmed within the target blocks, by FeeEstimateTargets.
+// Min rate of the projected pool block, the minimum relay fee if the block is not full.
+// Targets beyond the projected blocks keep the last rate while the pool is not cleared
+func (c *Core) FeeEstimates() map[int]float64 {
+	blocks := c.Snapshot().PoolBlocks
+	// the last projected block takes the rest of the pool
+	overflow := len(blocks) > 0 && blocks[len(blocks)-1].Weight > config.BLOCK_SIZE
+	if overflow {
+		blocks = blocks[:len(blocks)-1]
+	}
+	ret := make(map[int]float64, len(FeeEstimateTargets))
+	for _, target := range FeeEstimateTargets {
+		rate := float64(MinRelayFeeRate)
+		switch {
+		case target <= len(blocks):
+			if b := blocks[target-1]; b.Weight/4 > fullBlockVSize {
+				rate = b.FeeRange[0]
+			}
+		case overflow && len(blocks) > 0:
+			rate = blocks[len(blocks)-1].FeeRange[0]
+		}
+		ret[target] = max(rate, MinRelayFeeRate)
+	}
+	return ret
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+func TestGetDifficulty(t *testing.T) {
+	const start = 400 * retargetBlocks
+	now := time.Now()
+	// blocks from the height down to the tip, 10 minutes apart, the tip mined sinceTip ago
+	window := func(tip, from int, sinceTip time.Duration) []mblock.Block {
+		ret := make([]mblock.Block, 0)
+		for h := tip; h >= from; h-- {
+			d := 100.0
+			if h >= start {
+				d = 110
+			}
+			at := now.Add(-sinceTip - time.Duration(tip-h)*10*time.Minute)
+			ret = append(ret, mblock.Block{Hash: testHash(fmt.Sprintf("b%d", h)), Height: h, Time: at.Unix(), Difficulty: d})
+		}
+		return ret
+	}
+	tests := []struct {
+		name     string
+		network  string
+		blocks   []mblock.Block
+		previous float64 // -1 if unknown
+		offset   time.Duration
+	}{
+		{"epoch start and the block before", "mainnet", window(start+10, start-1, time.Minute), 10, 0},
+		{"no block before the epoch start", "mainnet", window(start+10, start, time.Minute), -1, 0},
+		{"epoch start is out of the window", "mainnet", window(start+10, start+1, time.Minute), -1, 0},
+		{"old window without difficulty", "mainnet", func() []mblock.Block {
+			b := window(start+10, start-1, time.Minute)
+			for i := range b {
+				b[i].Difficulty = 0
+			}
+			return b
+		}(), -1, 0},
+		{"mainnet tip is late", "mainnet", window(start+10, start-1, time.Hour), 10, 0},
+		{"testnet tip is fresh", "testnet", window(start+10, start-1, 5*time.Minute), 10, 0},
+		{"testnet min difficulty is close", "testnet", window(start+10, start-1, 15*time.Minute), 10, -15 * time.Minute},
+		{"testnet min difficulty is allowed", "testnet", window(start+10, start-1, time.Hour), 10, -testnetMaxBlockTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestCore(t, newFakeNode(), 3)
+			c.network = tt.network
+			c.publish(func(s *Snapshot) {
+				s.Height = tt.blocks[0].Height
+				s.Blocks = tt.blocks
+			})
+			d := c.GetDifficulty()
+			switch {
+			case tt.previous < 0 && d.PreviousRetarget != nil:
+				t.Fatalf("previous retarget: got %v, want none", *d.PreviousRetarget)
+			case tt.previous >= 0 && (d.PreviousRetarget == nil || *d.PreviousRetarget < tt.previous-1e-9 || *d.PreviousRetarget > tt.previous+1e-9):
+				t.Fatalf("previous retarget: got %v, want %v", d.PreviousRetarget, tt.previous)
+			}
+			// block times are in seconds
+			if diff := d.TimeOffset - tt.offset; diff < -time.Second || diff > time.Second {
+				t.Fatalf("time offset: got %s, want %s", d.TimeOffset, tt.offset)
+			}
+			if tt.network == "testnet" && d.BlockTime > testnetMaxBlockTime {
+				t.Fatalf("testnet block time %s is over %s", d.BlockTime, testnetMaxBlockTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+// bitcoin node calls the core depends on.
+// Implemented by the RPC client and the replay player
+type Node interface {
+	GetInfo() (*info.Info, error)
+	GetBestBlock() (*client.ResponseGetBestBlock, error)
+	GetBlock(hash string) (*block.Block, error)
+	GetBlockHeader(hash string) (*block.Block, error)
+	GetBlockStats(hash string) (*blockstats.BlockStats, error)
+	TransactionGet(txid string) (*tx.Transaction, error)
+	RawMempool() ([]txpool.TxPool, error)
+}
+
+var _ Node = (*client.Client)(nil)
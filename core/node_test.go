@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/storage"
+	smap "github.com/1F47E/go-feesh/storage/map"
+)
+
+var errFakeNotFound = errors.New("not found")
+
+// node with a hand made chain, switched by the tests
+type fakeNode struct {
+	mu     sync.Mutex
+	blocks map[string]*block.Block
+	best   string
+	pool   []txpool.TxPool
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{blocks: make(map[string]*block.Block)}
+}
+
+// add the block on top of prev with the txs, returns its hash
+func (n *fakeNode) mine(name string, prev string, height int, txs ...string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	hash := testHash(name)
+	ids := make([]string, len(txs))
+	for i, t := range txs {
+		ids[i] = testHash(t)
+	}
+	n.blocks[hash] = &block.Block{
+		Hash:              hash,
+		Height:            height,
+		Previousblockhash: prev,
+		Transactions:      ids,
+		Bits:              "1d00ffff",
+	}
+	return hash
+}
+
+func (n *fakeNode) setBest(hash string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.best = hash
+}
+
+func (n *fakeNode) GetInfo() (*info.Info, error) {
+	best, err := n.GetBestBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &info.Info{Blocks: best.Height}, nil
+}
+
+func (n *fakeNode) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b, ok := n.blocks[n.best]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	return &client.ResponseGetBestBlock{Hash: b.Hash, Height: b.Height}, nil
+}
+
+func (n *fakeNode) GetBlock(hash string) (*block.Block, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	b, ok := n.blocks[hash]
+	if !ok {
+		return nil, errFakeNotFound
+	}
+	ret := *b
+	return &ret, nil
+}
+
+func (n *fakeNode) GetBlockHeader(hash string) (*block.Block, error) {
+	b, err := n.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Transactions = nil
+	return b, nil
+}
+
+func (n *fakeNode) GetBlockStats(hash string) (*blockstats.BlockStats, error) {
+	return nil, errFakeNotFound
+}
+
+func (n *fakeNode) TransactionGet(txid string) (*tx.Transaction, error) {
+	return nil, errFakeNotFound
+}
+
+func (n *fakeNode) RawMempool() ([]txpool.TxPool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]txpool.TxPool(nil), n.pool...), nil
+}
+
+func newTestCore(t *testing.T, node Node, depth int) (*Core, storage.PoolRepository) {
+	t.Helper()
+	cfg := &config.Config{
+		BlocksParsingDepth: depth,
+		ParserQueueSize:    10_000,
+		ParserQueuePolicy:  "block",
+	}
+	s := smap.New(storage.Retention{})
+	c := NewCore(context.Background(), cfg, node, s, make(chan notificator.Msg, 100), make(chan notificator.BlockMsg, 100))
+	return c, s
+}
+
+// 64 hex id from the name
+func testHash(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+)
+
+// txs with outputs kept for the prevouts lookups
+const outputsCacheLimit = 20_000
+
+// parent txs fetched from the node for the prevouts, per second for all requests
+const prevoutsFetchRate = 100
+
+// outputs of the parsed pool txs and the parents fetched for the prevouts.
+// Storage keeps no output scripts, so this is the only local source of them
+type outputsCache struct {
+	mu    sync.Mutex
+	limit int
+	items map[string]*list.Element
+	lru   *list.List // most recently used first
+}
+
+type outputsItem struct {
+	txid string
+	vout []tx.Vout
+}
+
+func newOutputsCache(limit int) *outputsCache {
+	return &outputsCache{
+		limit: limit,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+func (o *outputsCache) Get(txid string) ([]tx.Vout, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.items[txid]
+	if !ok {
+		return nil, false
+	}
+	o.lru.MoveToFront(e)
+	return e.Value.(*outputsItem).vout, true
+}
+
+func (o *outputsCache) Add(txid string, vout []tx.Vout) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if e, ok := o.items[txid]; ok {
+		e.Value.(*outputsItem).vout = vout
+		o.lru.MoveToFront(e)
+		return
+	}
+	o.items[txid] = o.lru.PushFront(&outputsItem{txid: txid, vout: vout})
+	for o.lru.Len() > o.limit {
+		e := o.lru.Back()
+		o.lru.Remove(e)
+		delete(o.items, e.Value.(*outputsItem).txid)
+	}
+}
+
+// fixed window budget of the node calls
+type budget struct {
+	mu     sync.Mutex
+	rate   int
+	window time.Time
+	used   int
+}
+
+func newBudget(rate int) *budget {
+	return &budget{rate: rate}
+}
+
+// take one call from the current second, false if it is spent
+func (b *budget) Take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Truncate(time.Second)
+	if !now.Equal(b.window) {
+		b.window = now
+		b.used = 0
+	}
+	if b.used >= b.rate {
+		return false
+	}
+	b.used++
+	return true
+}
@@ -0,0 +1,80 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
+	"github.com/1F47E/go-feesh/poolsnap"
+)
+
+// write the current pool as a snapshot
+func (c *Core) ExportPool(w io.Writer) error {
+	h, records := c.dumpPool()
+	return poolsnap.Write(w, h, records)
+}
+
+func (c *Core) savePool(path string) error {
+	h, records := c.dumpPool()
+	return poolsnap.Save(path, h, records)
+}
+
+func (c *Core) dumpPool() (poolsnap.Header, []mempool.Record) {
+	records := c.pool.Dump()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return poolsnap.NewHeader(c.network, c.height, len(records)), records
+}
+
+// warm the pool from the snapshot saved on the previous run.
+// The first node poll removes txs that left the pool meanwhile
+func (c *Core) bootstrapPool() {
+	log := logger.Log.WithField("context", "[bootstrap]")
+	path := c.Cfg.PoolSnapshotFile
+	if path == "" {
+		return
+	}
+	now := time.Now()
+	h, records, err := poolsnap.Load(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Errorf("error on loading pool snapshot %s: %v\n", path, err)
+		return
+	}
+	if err := c.importPool(h, records); err != nil {
+		log.Errorf("error on importing pool snapshot %s: %v\n", path, err)
+		return
+	}
+	log.Infof("restored %d pool txs from %s saved %s ago, took %v\n",
+		len(records), path, now.Sub(h.Time).Round(time.Second), time.Since(now))
+}
+
+func (c *Core) importPool(h poolsnap.Header, records []mempool.Record) error {
+	c.mu.Lock()
+	network := c.network
+	c.mu.Unlock()
+	if h.Network != "" && network != "" && h.Network != network {
+		return fmt.Errorf("snapshot is for %s, node is on %s", h.Network, network)
+	}
+	added := make([]txpool.TxPool, len(records))
+	parsed := make(map[string]mtx.Tx)
+	txs := make([]mtx.Tx, 0)
+	for i, r := range records {
+		added[i] = r.TxPool
+		if r.Parsed != nil {
+			parsed[r.Txid] = *r.Parsed
+			txs = append(txs, *r.Parsed)
+		}
+	}
+	c.pool.Apply(added, nil, parsed)
+	// parsed txs are not sent to the parser again
+	return c.storage.TxAddMany(txs)
+}
@@ -0,0 +1,214 @@
+package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/api"
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/sim"
+	"github.com/1F47E/go-feesh/storage"
+	smap "github.com/1F47E/go-feesh/storage/map"
+
+	"github.com/fasthttp/websocket"
+)
+
+// readers hit while the pool churns and blocks are mined
+var simReadPaths = []string{
+	"/v0/stats",
+	"/v0/pool",
+	"/v0/pool/txs",
+	"/v0/blocks",
+	"/v0/audits",
+	"/v0/mining/pools",
+	"/v0/history",
+	"/readyz",
+	"/api/v1/fees/recommended",
+	"/api/v1/fees/mempool-blocks",
+	"/api/v1/difficulty-adjustment",
+	"/api/mempool",
+	"/api/mempool/recent",
+	"/api/blocks/tip/height",
+	"/api/fee-estimates",
+}
+
+// whole service on the simulated node, meant to be run with -race
+func TestSimRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulated run takes a while")
+	}
+	sc, err := sim.Load("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// small blocks and pool, the run is about concurrency, not volume.
+	// Parsers have to keep up with the race detector on, the node forgets txs of old blocks
+	sc.Pool = 2000
+	sc.BlockWeight = 400_000
+	sc.TxRate = 0.2
+	// a block per second on average
+	const speed = 600
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := sim.New(sc, time.Now())
+	node.Start(ctx, speed)
+
+	cfg := &config.Config{
+		ApiHost:            freeAddr(t),
+		RpcLimit:           4,
+		BlocksParsingDepth: 3,
+		ParserQueueSize:    10_000,
+		ParserQueuePolicy:  "block",
+		MempoolApi:         true,
+		EsploraApi:         true,
+	}
+	broadcastCh := make(chan notificator.Msg)
+	blocksCh := make(chan notificator.BlockMsg)
+	c := core.NewCore(ctx, cfg, node, smap.New(storage.Retention{}), broadcastCh, blocksCh)
+	a := api.NewApi(c, notificator.New(broadcastCh, blocksCh))
+	c.Start(ctx)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- a.Listen()
+	}()
+	base := "http://" + cfg.ApiHost
+	// readiness waits for the first history sample too, it takes 10s
+	waitFor(t, 30*time.Second, func() bool {
+		resp, err := http.Get(base + "/readyz")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+
+	// ws client gets the pool and the blocks notifications
+	ws, _, err := websocket.DefaultDialer.Dial("ws://"+cfg.ApiHost+"/v0/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// message types seen, pool and block updates share the endpoint
+	var wsMu sync.Mutex
+	wsTypes := make(map[string]bool)
+	go func() {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Errorf("ws message %s: %v", data, err)
+			}
+			wsMu.Lock()
+			wsTypes[msg.Type] = true
+			wsMu.Unlock()
+		}
+	}()
+
+	start := c.Snapshot().Height
+	readCtx, stopReads := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := i; readCtx.Err() == nil; n++ {
+				path := simReadPaths[n%len(simReadPaths)]
+				resp, err := http.Get(base + path)
+				if err != nil {
+					t.Errorf("GET %s: %v", path, err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode >= http.StatusInternalServerError {
+					t.Errorf("GET %s: %d", path, resp.StatusCode)
+				}
+			}
+		}()
+	}
+	// core readers used by the handlers and electrum
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for readCtx.Err() == nil {
+			snap := c.Snapshot()
+			for _, tx := range snap.Txs {
+				c.GetTx(tx.Hash)
+			}
+			for _, b := range c.GetBlocks() {
+				c.GetBlockByHash(b.Hash)
+				c.GetBlockTxs(b.Hash, 0, 10)
+				c.GetBlockAudit(b.Hash)
+			}
+			c.RecommendedFees()
+			c.FeeEstimates()
+			c.GetDifficulty()
+			c.GetPoolStats()
+			c.GetMiningStats(24 * time.Hour)
+			c.GetQueueStats()
+			c.GetStorageStats()
+			c.Health()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// new blocks are mined, parsed and moved to the window
+	waitFor(t, time.Minute, func() bool {
+		blocks := c.GetBlocks()
+		return len(blocks) > 0 && blocks[0].Height >= start+2
+	})
+	waitFor(t, 5*time.Second, func() bool {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		return wsTypes["pool"] && wsTypes["block"]
+	})
+	stopReads()
+	wg.Wait()
+	ws.Close()
+
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelStop()
+	if err := c.Stop(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Shutdown(stopCtx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-listenErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("not done in %s", timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
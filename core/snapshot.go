@@ -0,0 +1,72 @@
+package core
+
+import (
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/mempool"
+)
+
+// how many newest pool txs to keep in the snapshot
+const snapshotTxsLimit = 1000
+
+// immutable view of the core state for API and websocket readers.
+// Replaced as a whole on every update, never modified after publishing
+type Snapshot struct {
+	Time            time.Time
+	Height          int
+	PoolSize        int
+	PoolSizeHistory []uint
+	Amount          uint64
+	FeeTotal        uint64 // in 1000 sat, approx precision
+	FeeAvg          uint64
+	Size            uint64  // projected next block size in bytes
+	NextBlockMinFee float64 // min fee rate in the projected next block, sat/vB
+	FeeBuckets      []uint
+	FeeBucketsMap   map[uint]uint
+	Txs             []mtx.Tx                 // newest first, up to snapshotTxsLimit
+	Blocks          []mblock.Block           // newest first
+	PoolBlocks      []mempool.ProjectedBlock // pool projected in blocks, next first
+	Histogram       []mempool.HistogramBin   // pool fee histogram, highest rate first
+}
+
+// projected next block size in Kb
+func (s *Snapshot) SizeKb() uint64 {
+	return s.Size / 1024
+}
+
+func (s *Snapshot) BlockByHash(hash string) (mblock.Block, bool) {
+	for _, b := range s.Blocks {
+		if b.Hash == hash {
+			return b, true
+		}
+	}
+	return mblock.Block{}, false
+}
+
+func (s *Snapshot) BlockByHeight(height int) (mblock.Block, bool) {
+	for _, b := range s.Blocks {
+		if b.Height == height {
+			return b, true
+		}
+	}
+	return mblock.Block{}, false
+}
+
+// latest published state, never nil
+func (c *Core) Snapshot() *Snapshot {
+	return c.snapshot.Load()
+}
+
+// copy the current snapshot, apply changes and publish it.
+// fn must not modify slices and maps of the old snapshot, only replace them
+func (c *Core) publish(fn func(s *Snapshot)) *Snapshot {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+	s := *c.snapshot.Load()
+	fn(&s)
+	s.Time = time.Now()
+	c.snapshot.Store(&s)
+	return &s
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
+)
+
+var (
+	ErrTxNotFound    = errors.New("tx not found")
+	ErrBlockNotFound = errors.New("block not found")
+	ErrNoNode        = errors.New("no node connected")
+)
+
+// block interval used for the eta when the window is too short to measure it
+const defaultBlockInterval = 10 * time.Minute
+
+// tx statuses
+const (
+	TxStatusMempool   = "mempool"
+	TxStatusConfirmed = "confirmed"
+	TxStatusReplaced  = "replaced"
+	TxStatusRemoved   = "removed" // left the pool without being seen in a block
+	TxStatusUnknown   = "unknown" // known to the node only
+)
+
+type TxInfo struct {
+	Tx      *mtx.Tx
+	Decoded *tx.Transaction // from the node, nil if it's not available
+	Status  string
+	Mempool *TxMempool
+	Block   *TxBlock
+	RBF     TxRBF
+}
+
+type TxMempool struct {
+	Position       mempool.Position
+	PoolSize       int
+	FeeRate        float64 // sat/vB
+	PackageFeeRate float64 // with the ancestors
+	ETA            time.Duration
+	Ancestors      mempool.Package
+	Descendants    mempool.Package
+}
+
+type TxBlock struct {
+	Hash          string
+	Height        int
+	Time          int64
+	Confirmations int
+}
+
+type TxRBF struct {
+	Signals    bool
+	ReplacedBy string
+	Replaces   []string
+}
+
+// everything known about the tx: pool position, block, replacements and the decoded tx
+func (c *Core) GetTx(txid string) (*TxInfo, error) {
+	log := logger.Log.WithField("context", "[GetTx]")
+	ret := &TxInfo{Status: TxStatusUnknown}
+	snap := c.Snapshot()
+
+	if ptx, ok := c.pool.Get(txid); ok {
+		ret.Tx = &ptx
+		ret.Status = TxStatusMempool
+	} else {
+		stored, err := c.storage.TxGet(txid)
+		if err != nil {
+			return nil, err
+		}
+		ret.Tx = stored
+	}
+
+	if c.cli != nil {
+		decoded, err := c.cli.TransactionGet(txid)
+		if err != nil {
+			log.Debugf("error on getrawtransaction %s: %v\n", txid, err)
+		} else {
+			ret.Decoded = decoded
+		}
+	}
+	if ret.Tx == nil && ret.Decoded == nil {
+		return nil, ErrTxNotFound
+	}
+	if ret.Decoded != nil {
+		ret.RBF.Signals = ret.Decoded.IsRBF()
+	} else {
+		ret.RBF.Signals = ret.Tx.RBF
+	}
+	ret.RBF.ReplacedBy, ret.RBF.Replaces = c.pool.Replacement(txid)
+
+	if ret.Status == TxStatusMempool {
+		pos, ok := c.pool.Position(txid, config.BLOCK_SIZE)
+		if ok {
+			ancestors, descendants := c.pool.Packages(txid)
+			ret.Mempool = &TxMempool{
+				Position:       pos,
+				PoolSize:       snap.PoolSize,
+				FeeRate:        ret.Tx.FeeRate(),
+				PackageFeeRate: c.pool.PackageFeeRate(*ret.Tx, ancestors),
+				ETA:            time.Duration(pos.Block) * blockInterval(snap.Blocks),
+				Ancestors:      ancestors,
+				Descendants:    descendants,
+			}
+		}
+		return ret, nil
+	}
+
+	block, err := c.txBlock(txid, ret.Decoded, snap)
+	if err != nil {
+		return nil, err
+	}
+	if block != nil {
+		ret.Block = block
+		ret.Status = TxStatusConfirmed
+	} else if ret.RBF.ReplacedBy != "" {
+		ret.Status = TxStatusReplaced
+	} else if c.pool.Seen(txid) {
+		ret.Status = TxStatusRemoved
+	}
+	return ret, nil
+}
+
+// block of the confirmed tx, from the node or the blocks window
+func (c *Core) txBlock(txid string, decoded *tx.Transaction, snap *Snapshot) (*TxBlock, error) {
+	if decoded != nil && decoded.Blockhash != "" {
+		ret := &TxBlock{
+			Hash:          decoded.Blockhash,
+			Time:          int64(decoded.Blocktime),
+			Confirmations: decoded.Confirmations,
+		}
+		if b, ok := snap.BlockByHash(decoded.Blockhash); ok {
+			ret.Height = b.Height
+		} else if decoded.Confirmations > 0 {
+			ret.Height = snap.Height - decoded.Confirmations + 1
+		}
+		return ret, nil
+	}
+	// txs are kept for the parsed blocks only
+	for _, b := range snap.Blocks[:min(len(snap.Blocks), c.blockDepth)] {
+		txs, err := c.storage.BlockGet(b.Hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range txs {
+			if id == txid {
+				return &TxBlock{
+					Hash:          b.Hash,
+					Height:        b.Height,
+					Time:          b.Time,
+					Confirmations: snap.Height - b.Height + 1,
+				}, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// outputs spent by the tx inputs. Parents are taken from the parsed pool txs
+// and the previous lookups, up to fetchLimit others are fetched from the node within the rate budget.
+// Nil for the coinbase input, parents the node does not know and the ones over the limits
+func (c *Core) GetPrevouts(t *tx.Transaction, fetchLimit int) []*tx.Vout {
+	log := logger.Log.WithField("context", "[GetPrevouts]")
+	ret := make([]*tx.Vout, len(t.Vin))
+	parents := make(map[string][]tx.Vout)
+	fetched := 0
+	for i, vin := range t.Vin {
+		if vin.Coinbase != "" {
+			continue
+		}
+		vout, ok := parents[vin.Txid]
+		if !ok {
+			vout, ok = c.outputs.Get(vin.Txid)
+		}
+		if !ok && c.cli != nil && fetched < fetchLimit && c.prevoutFetches.Take() {
+			fetched++
+			parent, err := c.cli.TransactionGet(vin.Txid)
+			if err != nil {
+				log.Debugf("error on getrawtransaction %s: %v\n", vin.Txid, err)
+			} else {
+				vout = parent.Vout
+				c.outputs.Add(vin.Txid, vout)
+			}
+		}
+		parents[vin.Txid] = vout
+		if vin.Vout < len(vout) {
+			ret[i] = &vout[vin.Vout]
+		}
+	}
+	return ret
+}
+
+// average interval between the window blocks
+func blockInterval(blocks []mblock.Block) time.Duration {
+	if len(blocks) < 2 {
+		return defaultBlockInterval
+	}
+	newest, oldest := blocks[0], blocks[len(blocks)-1]
+	if newest.Time <= oldest.Time {
+		return defaultBlockInterval
+	}
+	return time.Duration(newest.Time-oldest.Time) * time.Second / time.Duration(len(blocks)-1)
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+)
+
+// node knowing every parent, counts the lookups
+type parentsNode struct {
+	*fakeNode
+	calls atomic.Int32
+}
+
+func (n *parentsNode) TransactionGet(txid string) (*tx.Transaction, error) {
+	n.calls.Add(1)
+	return &tx.Transaction{Txid: txid, Vout: []tx.Vout{{Value: 0.1, N: 0}, {Value: 0.2, N: 1}}}, nil
+}
+
+func TestGetPrevoutsCached(t *testing.T) {
+	node := &parentsNode{fakeNode: newFakeNode()}
+	c, _ := newTestCore(t, node, 3)
+
+	// parent parsed from the pool
+	pooled := testHash("pooled")
+	c.outputs.Add(pooled, []tx.Vout{{Value: 0.5, N: 0}})
+	child := &tx.Transaction{Vin: []tx.Vin{
+		{Txid: pooled, Vout: 0},
+		{Txid: testHash("p1"), Vout: 1},
+		{Txid: testHash("p1"), Vout: 0},
+		{Txid: testHash("p2"), Vout: 0},
+		{Txid: testHash("p3"), Vout: 0},
+	}}
+	got := c.GetPrevouts(child, 2)
+	if node.calls.Load() != 2 {
+		t.Fatalf("node calls: got %d, want 2", node.calls.Load())
+	}
+	for i, want := range []float64{0.5, 0.2, 0.1, 0.1} {
+		if got[i] == nil || got[i].Value != want {
+			t.Fatalf("prevout %d: got %+v, want value %v", i, got[i], want)
+		}
+	}
+	if got[4] != nil {
+		t.Fatal("parent over the fetch limit is looked up")
+	}
+
+	// fetched parents are served from the cache
+	got = c.GetPrevouts(child, 0)
+	if node.calls.Load() != 2 || got[3] == nil {
+		t.Fatalf("cached parents are fetched again: %d calls", node.calls.Load())
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(3)
+	n := 0
+	for i := 0; i < 10; i++ {
+		if b.Take() {
+			n++
+		}
+	}
+	// the window can roll over once in between
+	if n < 3 || n > 6 {
+		t.Fatalf("taken %d of budget 3", n)
+	}
+}
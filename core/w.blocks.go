@@ -2,12 +2,26 @@ package core
 
 import (
 	"context"
+	"sort"
 	"time"
 
+	"github.com/1F47E/go-feesh/entity/btc/block"
 	mblock "github.com/1F47E/go-feesh/entity/models/block"
 	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mining"
+	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/queue"
 )
 
+// if some block txs failed to parse - stats are calculated from what we have after this time
+const blockParseTimeout = 10 * time.Minute
+
+type pendingBlock struct {
+	block *block.Block
+	added time.Time
+}
+
+// follow the node tip and fetch blocks missing in the window
 func (c *Core) workerParserBlocks(ctx context.Context, period time.Duration) {
 	log := logger.Log.WithField("context", "[workerParserBlocks]")
 	log.Info("started")
@@ -17,89 +31,111 @@ func (c *Core) workerParserBlocks(ctx context.Context, period time.Duration) {
 		ticker.Stop()
 	}()
 
-	// WARN: debug reset
-	c.height = 0
-
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// get the block height
-			info, err := c.cli.GetInfo()
+			now := time.Now()
+			cnt, err := c.syncBlocks(ctx)
 			if err != nil {
-				log.Errorf("error on getinfo: %v\n", err)
+				log.Errorf("error on blocks sync: %v\n", err)
 				continue
 			}
-			// skip if initial blocks already parsed and no new blocks
-			if c.height == info.Blocks && len(c.blocks) > 0 {
-				continue
+			c.sup.Beat(workerParserBlocks)
+			if cnt > 0 {
+				log.Debugf("blocks %d fetched in %s\n", cnt, time.Since(now))
 			}
-			c.height = info.Blocks
-			log.Debugf("new block height: %d\n", info.Blocks)
+		}
+	}
+}
 
-			// get best block
-			best, err := c.cli.GetBestBlock()
-			if err != nil {
-				log.Errorf("error on getbestblock: %v\n", err)
-				continue
-			}
+// walk back from the best block until the known one or window depth is reached.
+// Every unknown block is fetched and its txs are sent to the parser.
+// Returns number of fetched blocks
+func (c *Core) syncBlocks(ctx context.Context) (int, error) {
+	log := logger.Log.WithField("context", "[syncBlocks]")
 
-			if len(c.blocks) > c.blockDepth {
-				log.Warnf("blocks buffer is full. dropping oldest block. have %d blocks", len(c.blocks))
-				c.blocks = c.blocks[:len(c.blocks)-1]
-				continue
-			}
+	best, err := c.cli.GetBestBlock()
+	if err != nil {
+		return 0, err
+	}
+	// node tip went backwards, drop stale blocks
+	c.dropBlocks(c.blocks.RemoveAbove(best.Height))
+	c.dropPending(func(b *block.Block) bool {
+		return b.Height > best.Height
+	})
+	if tip, ok := c.blocks.Tip(); ok && tip.Hash == best.Hash {
+		return 0, nil
+	}
 
-			// collect N block hashes
-			// around 3k txs in a block and around 1.5Meg for txs data
-			blocks := make([]string, 0)
-			currentHash := best.Hash
-			for i := 0; i < c.blockDepth; i++ {
-				blocks = append(blocks, currentHash)
-				header, err := c.cli.GetBlockHeader(currentHash)
-				if err != nil {
-					log.Errorf("error on getblockheader: %v\n", err)
-					continue
-				}
-				// l.Debugf("best block hash: %s\n", best.Hash)
-				// l.Debugf("prev block hash: %s\n", header.Previousblockhash)
-				currentHash = header.Previousblockhash
-			}
-			log.Debugf("got %d last blocks\n", len(blocks))
-			for _, hash := range blocks {
-				log.Debugf("block hash: %s\n", hash)
+	cnt := 0
+	hash := best.Hash
+	for i := 0; i < c.blockDepth && hash != ""; i++ {
+		if ctx.Err() != nil {
+			return cnt, nil
+		}
+		height := best.Height - i
+		if b, ok := c.blocks.Get(height); ok {
+			if b.Hash == hash {
+				// rest of the chain is already in the window
+				break
 			}
+			log.Warnf("reorg at height %d: %s replaced by %s\n", height, b.Hash, hash)
+			c.blocks.Remove(height)
+			c.dropBlocks([]mblock.Block{b})
+		}
+		// orphaned blocks still waiting for txs would replace the new chain ones once parsed
+		c.dropPending(func(b *block.Block) bool {
+			return b.Height == height && b.Hash != hash
+		})
 
-			// parse N blocks
-			now := time.Now()
-			for i, hash := range blocks {
-				// get full block data (tx list)
-				exists, _ := c.storage.BlockExists(hash)
-				if !exists {
-					log.Debugf("%d/%d block parsing: %s\n", i+1, len(blocks), hash)
-					b, err := c.cli.GetBlock(hash)
-					if err != nil {
-						log.Errorf("error on getblock: %v\n", err)
-					}
-					// TODO: store raw block info also
-					_ = c.storage.BlockAdd(b.Hash, b.Transactions)
-					// add to in mem blocks index
-					c.mu.Lock()
-					c.blocksIndex = append(c.blocksIndex, b.Hash)
-					c.mu.Unlock()
-					// send block txs parser
-					txs, _ := c.storage.BlockGet(b.Hash)
-					for _, txid := range txs {
-						c.parserJobCh <- txid
-					}
+		// already fetched, waiting for txs
+		c.mu.Lock()
+		p, isPending := c.blocksPending[hash]
+		c.mu.Unlock()
+		if isPending {
+			hash = p.block.Previousblockhash
+			continue
+		}
+
+		log.Debugf("%d/%d block fetching: %d %s\n", i+1, c.blockDepth, height, hash)
+		b, err := c.cli.GetBlock(hash)
+		if err != nil {
+			return cnt, err
+		}
+		if err := c.storage.BlockAdd(b.Hash, b.Transactions); err != nil {
+			return cnt, err
+		}
+		c.mu.Lock()
+		c.blocksPending[b.Hash] = pendingBlock{block: b, added: time.Now()}
+		c.mu.Unlock()
+		cnt++
+		// long backfill is progress, not a stall
+		c.sup.Beat(workerParserBlocks)
+
+		// send not yet parsed block txs to parser
+		parsed, err := c.storage.TxGetMany(b.Transactions)
+		if err != nil {
+			return cnt, err
+		}
+		for _, txid := range b.Transactions {
+			if _, ok := parsed[txid]; ok {
+				continue
+			}
+			if err := c.queueTx(ctx, workerParserBlocks, txid, queue.PriorityBlock); err != nil {
+				if ctx.Err() != nil {
+					return cnt, nil
 				}
+				log.Warnf("block tx %s is not queued: %v\n", txid, err)
 			}
-			log.Debugf("blocks %d processed in %s\n", len(blocks), time.Since(now))
 		}
+		hash = b.Previousblockhash
 	}
+	return cnt, nil
 }
 
+// calc stats for fetched blocks once all their txs are parsed and move them to the window
 func (c *Core) workerBlocksProcessor(ctx context.Context, period time.Duration) {
 	log := logger.Log.WithField("context", "[workerBlocksProcessor]")
 	log.Info("started")
@@ -113,53 +149,275 @@ func (c *Core) workerBlocksProcessor(ctx context.Context, period time.Duration)
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// check blocks and what tx are parsed
-			txCnt := 0
-			if len(c.blocksIndex) == len(c.blocks) {
-				continue
-			}
-			log.Info("processing blocks")
-			for _, hash := range c.blocksIndex {
-				var bWeight, bSize, bFee, bAmount uint64
-				// log.Log.Debugf("checking block %s\n", hash)
-				txs, _ := c.storage.BlockGet(hash)
-				// log.Log.Debugf("block has %s txs: %d\n", hash, len(txs))
-				cnt := 0
-				for _, txid := range txs {
-					// check if tx is parsed
-					tx, _ := c.storage.TxGet(txid)
-					if tx != nil {
-						// skip first one. TODO: detect coinbase by param
-						cnt++
-						if cnt == 1 {
-							continue
-						}
-						bWeight += uint64(tx.Weight)
-						bSize += uint64(tx.Size)
-						bFee += tx.Fee
-						bAmount += tx.AmountOut
-						log.Debugf("block %s tx %s fee %d amount %d\n", hash, txid, tx.Fee, tx.AmountOut)
-					}
-				}
-				log.Debugf("block %s has tx %d parsed. total fee: %d amount: %d\n", hash, cnt, bFee, bAmount)
-				txCnt += cnt
-				// save block stats
-				b := mblock.Block{
-					Hash:   hash,
-					Txs:    uint64(len(txs)),
-					Weight: bWeight,
-					Size:   bSize,
-					Fee:    bFee,
-					Value:  bAmount,
-				}
-				c.blocks = append(c.blocks, b)
-				log.Infof("block %s added to blocks list. cnt: %d\n", hash, cnt)
-				// TODO: add to storage
-				// l.Debugf("block %s has %d/%d txs parsed. Weight: %d, Amount: %d", hash, cnt, len(txs), bWeight, bAmount)
-			}
-			if txCnt > 0 {
-				log.Debugf("total parsed txs: %d\n", txCnt)
+			c.sup.Beat(workerBlocksProcessor)
+			c.processPending()
+		}
+	}
+}
+
+// calc stats for the pending blocks with all txs parsed, or waiting too long,
+// and move them to the window
+func (c *Core) processPending() {
+	log := logger.Log.WithField("context", "[workerBlocksProcessor]")
+	c.mu.Lock()
+	pending := make([]pendingBlock, 0, len(c.blocksPending))
+	for _, p := range c.blocksPending {
+		pending = append(pending, p)
+	}
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	updated := false
+	for _, p := range pending {
+		b, complete := c.processBlock(p.block)
+		if !complete && time.Since(p.added) < blockParseTimeout {
+			continue
+		}
+		// claim the block, it can be orphaned by the sync meanwhile
+		c.mu.Lock()
+		_, ok := c.blocksPending[p.block.Hash]
+		delete(c.blocksPending, p.block.Hash)
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if complete {
+			c.applyNodeStats(&b)
+		} else {
+			log.Warnf("block %d %s processed with missing txs\n", b.Height, b.Hash)
+		}
+		c.attributeBlock(&b, p.block)
+		if audit, ok := c.auditBlock(b, p.block); ok {
+			if err := c.storage.AuditAdd(audit); err != nil {
+				log.Errorf("error on saving block audit: %v\n", err)
 			}
+			log.Infof("block %d audit: health %.1f%%, missing %d, added %d, unseen %d, fee delta %d\n",
+				b.Height, audit.Health, len(audit.Missing), len(audit.Added), len(audit.Unseen), audit.FeeDelta)
+		}
+		evicted := c.blocks.Add(b)
+		if err := c.storage.BlockStatsAdd(b); err != nil {
+			log.Errorf("error on saving block stats: %v\n", err)
+		}
+		for _, e := range evicted {
+			log.Debugf("block %d %s evicted from the window\n", e.Height, e.Hash)
+		}
+		c.dropBlocks(evicted)
+		updated = true
+		log.Infof("block %d %s added. txs: %d, fee: %d, value: %d, pool: %s\n", b.Height, b.Hash, b.Txs, b.Fee, b.Value, b.Pool)
+
+		// notify only about the new tip, not the backfilled ones
+		if tip, ok := c.blocks.Tip(); ok && tip.Hash == b.Hash {
+			go c.notifyBlock(b)
+		}
+	}
+
+	if updated {
+		c.trimBlockTxs()
+		blocks := c.blocks.List()
+		c.publish(func(s *Snapshot) {
+			s.Blocks = blocks
+		})
+	}
+	if updated && c.Cfg.BlocksWindowFile != "" {
+		if err := c.blocks.Save(c.Cfg.BlocksWindowFile); err != nil {
+			log.Errorf("error on saving blocks window: %v\n", err)
+		}
+	}
+}
+
+// calc block stats from parsed txs
+// returns false if some txs are not parsed yet
+func (c *Core) processBlock(b *block.Block) (mblock.Block, bool) {
+	ret := mblock.Block{
+		Hash:    b.Hash,
+		Height:  b.Height,
+		Time:    int64(b.Time),
+		Txs:     uint64(len(b.Transactions)),
+		Weight:  uint64(b.Weight),
+		Size:    uint64(b.Size),
+		Subsidy: mblock.Subsidy(b.Height),
+
+		Difficulty: b.Difficulty,
+	}
+	var weight, size uint64
+	rates := make([]float64, 0, len(b.Transactions))
+	cnt := 0
+	parsed, _ := c.storage.TxGetMany(b.Transactions)
+	for _, txid := range b.Transactions {
+		tx, ok := parsed[txid]
+		if !ok {
+			continue
+		}
+		cnt++
+		weight += uint64(tx.Weight)
+		size += uint64(tx.Size)
+		// same as getblockstats, coinbase outputs are counted, input is not
+		ret.Outputs += uint64(tx.Outputs)
+		if tx.Segwit {
+			ret.SegwitTxs++
+		}
+		if tx.Taproot {
+			ret.TaprootTxs++
+		}
+		if tx.Coinbase {
+			ret.Reward = tx.AmountOut
+			continue
+		}
+		ret.Inputs += uint64(tx.Inputs)
+		ret.Fee += tx.Fee
+		ret.Value += tx.AmountOut
+		// fee is known only for txs seen in the pool
+		if tx.Fee > 0 {
+			rates = append(rates, tx.FeeRate())
+		}
+	}
+	// headers from some nodes have no size info
+	if ret.Weight == 0 {
+		ret.Weight = weight
+	}
+	if ret.Size == 0 {
+		ret.Size = size
+	}
+	setFeeRates(&ret, rates)
+	return ret, cnt == len(b.Transactions)
+}
+
+// node knows fees of all block txs, prefer its stats if getblockstats is supported
+func (c *Core) applyNodeStats(b *mblock.Block) {
+	stats, err := c.cli.GetBlockStats(b.Hash)
+	if err != nil {
+		logger.Log.Debugf("getblockstats is not available, using parsed txs stats: %v\n", err)
+		return
+	}
+	b.Fee = stats.TotalFee
+	b.Value = stats.TotalOut
+	b.Subsidy = stats.Subsidy
+	b.Inputs = stats.Ins
+	b.Outputs = stats.Outs
+	b.SegwitTxs = stats.SwTxs
+	b.FeeRateMin = stats.MinFeeRate
+	b.FeeRateMax = stats.MaxFeeRate
+	b.FeeRatePercentiles = stats.FeeRatePercentiles
+	b.FeeRateMedian = stats.FeeRatePercentiles[2]
+	if b.Reward == 0 {
+		b.Reward = stats.Subsidy + stats.TotalFee
+	}
+	b.NodeStats = true
+}
+
+// find out the mining pool by the coinbase tx
+func (c *Core) attributeBlock(b *mblock.Block, raw *block.Block) {
+	b.Pool = mining.Unknown
+	if len(raw.Transactions) == 0 {
+		return
+	}
+	coinbase, err := c.cli.TransactionGet(raw.Transactions[0])
+	if err != nil {
+		logger.Log.Errorf("error on getting coinbase tx of block %s: %v\n", raw.Hash, err)
+		return
+	}
+	if !coinbase.IsCoinbase() {
+		return
+	}
+	p := c.miners.Match(coinbase.Vin[0].Coinbase, coinbase.GetAddresses())
+	b.Pool = p.Name
+	b.PoolLink = p.Link
+}
+
+// send new block websocket update with the pool stats attached
+func (c *Core) notifyBlock(b mblock.Block) {
+	msg := notificator.BlockMsg{
+		Height: b.Height,
+		Hash:   b.Hash,
+		Time:   b.Time,
+		Txs:    b.Txs,
+		Fee:    b.Fee,
+		Weight: b.Weight,
+		Pool:   b.Pool,
+	}
+	for _, s := range c.GetMiningStats(24 * time.Hour) {
+		if s.Name == b.Pool {
+			msg.PoolStats = s
+			break
+		}
+	}
+	select {
+	case c.blocksCh <- msg:
+	case <-time.After(time.Second * 5):
+		logger.Log.Error("timeout on sending block websocket message\n")
+	}
+}
+
+// min, max and percentiles of tx fee rates
+func setFeeRates(b *mblock.Block, rates []float64) {
+	if len(rates) == 0 {
+		return
+	}
+	sort.Float64s(rates)
+	b.FeeRateMin = uint64(rates[0])
+	b.FeeRateMax = uint64(rates[len(rates)-1])
+	for i, p := range mblock.FeeRatePercentiles {
+		idx := (len(rates) - 1) * int(p) / 100
+		b.FeeRatePercentiles[i] = uint64(rates[idx])
+	}
+	b.FeeRateMedian = b.FeeRatePercentiles[2]
+}
+
+// drop pending blocks matching fn with their stored txids
+func (c *Core) dropPending(fn func(b *block.Block) bool) {
+	log := logger.Log.WithField("context", "[dropPending]")
+	c.mu.Lock()
+	orphaned := make([]mblock.Block, 0)
+	for hash, p := range c.blocksPending {
+		if fn(p.block) {
+			delete(c.blocksPending, hash)
+			orphaned = append(orphaned, mblock.Block{Hash: hash, Height: p.block.Height})
+		}
+	}
+	c.mu.Unlock()
+	for _, b := range orphaned {
+		log.Warnf("pending block %d %s is orphaned\n", b.Height, b.Hash)
+	}
+	c.dropBlocks(orphaned)
+}
+
+// drop blocks left the window or orphaned from the storage with their txs, stats and audits
+func (c *Core) dropBlocks(blocks []mblock.Block) {
+	log := logger.Log.WithField("context", "[dropBlocks]")
+	for _, b := range blocks {
+		if err := c.storage.BlockRemove(b.Hash); err != nil {
+			log.Errorf("error on removing block %s: %v\n", b.Hash, err)
+		}
+		if err := c.storage.BlockStatsRemove(b.Hash); err != nil {
+			log.Errorf("error on removing block stats %s: %v\n", b.Hash, err)
+		}
+	}
+}
+
+// drop txs of the window blocks deeper than the parsing depth, their stats and audits are kept
+func (c *Core) trimBlockTxs() {
+	log := logger.Log.WithField("context", "[trimBlockTxs]")
+	tip, ok := c.blocks.Tip()
+	if !ok {
+		return
+	}
+	hashes, err := c.storage.BlockList()
+	if err != nil {
+		log.Errorf("error on listing blocks: %v\n", err)
+		return
+	}
+	for _, hash := range hashes {
+		b, ok := c.blocks.GetByHash(hash)
+		// pending ones are not in the window yet
+		if !ok || b.Height > tip.Height-c.blockDepth {
+			continue
+		}
+		if err := c.storage.BlockRemove(hash); err != nil {
+			log.Errorf("error on removing block txs %s: %v\n", hash, err)
+			continue
 		}
+		log.Debugf("block %d %s txs dropped\n", b.Height, b.Hash)
 	}
 }
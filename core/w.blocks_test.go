@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+)
+
+// mark block txs as parsed so the block can be processed
+func parseTxs(t *testing.T, s storage.PoolRepository, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := s.TxAdd(mtx.Tx{Hash: testHash(name), Size: 200, Weight: 800}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *Core) isPending(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.blocksPending[hash]
+	return ok
+}
+
+func TestSyncBlocksReorgDropsPending(t *testing.T) {
+	node := newFakeNode()
+	c, s := newTestCore(t, node, 3)
+
+	a100 := node.mine("a100", "", 100, "cb100")
+	a101 := node.mine("a101", a100, 101, "cb101a", "tx1")
+	node.setBest(a101)
+	parseTxs(t, s, "cb100")
+	if _, err := c.syncBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.processPending()
+	if b, ok := c.blocks.Get(100); !ok || b.Hash != a100 {
+		t.Fatalf("block 100 is not in the window: %+v", b)
+	}
+	if !c.isPending(a101) {
+		t.Fatal("block 101a should wait for its txs")
+	}
+
+	// 101a is orphaned while its txs are still parsing
+	b101 := node.mine("b101", a100, 101, "cb101b", "tx1")
+	b102 := node.mine("b102", b101, 102, "cb102b")
+	node.setBest(b102)
+	if _, err := c.syncBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.isPending(a101) {
+		t.Fatal("orphaned block 101a is still pending")
+	}
+
+	parseTxs(t, s, "cb101a", "tx1", "cb101b", "cb102b")
+	c.processPending()
+	for height, want := range map[int]string{100: a100, 101: b101, 102: b102} {
+		if b, ok := c.blocks.Get(height); !ok || b.Hash != want {
+			t.Fatalf("block %d: got %s, want %s", height, b.Hash, want)
+		}
+	}
+}
+
+func TestSyncBlocksTipBackwardsDropsPending(t *testing.T) {
+	node := newFakeNode()
+	c, s := newTestCore(t, node, 3)
+
+	a100 := node.mine("a100", "", 100, "cb100")
+	a101 := node.mine("a101", a100, 101, "cb101")
+	node.setBest(a101)
+	parseTxs(t, s, "cb100")
+	if _, err := c.syncBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.processPending()
+
+	node.setBest(a100)
+	if _, err := c.syncBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if c.isPending(a101) {
+		t.Fatal("block above the node tip is still pending")
+	}
+	parseTxs(t, s, "cb101")
+	c.processPending()
+	if b, ok := c.blocks.Get(101); ok {
+		t.Fatalf("block above the node tip is in the window: %s", b.Hash)
+	}
+}
+
+func TestBlockTxsKeptForDepthStatsForHistory(t *testing.T) {
+	node := newFakeNode()
+	const depth, history = 2, 4
+	c, s := newTestCore(t, node, depth)
+	c.blocks = newBlockWindow(history)
+
+	hashes := make(map[int]string)
+	prev := ""
+	for height := 100; height <= 106; height++ {
+		cb := fmt.Sprintf("cb%d", height)
+		prev = node.mine(fmt.Sprintf("a%d", height), prev, height, cb)
+		hashes[height] = prev
+		node.setBest(prev)
+		parseTxs(t, s, cb)
+		if _, err := c.syncBlocks(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		c.processPending()
+	}
+
+	stats, err := s.BlockStatsList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := make(map[string]bool)
+	for _, b := range stats {
+		kept[b.Hash] = true
+	}
+	for height := 100; height <= 106; height++ {
+		hash := hashes[height]
+		txs, err := s.BlockGet(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantTxs := height > 106-depth
+		wantStats := height > 106-history
+		if (txs != nil) != wantTxs {
+			t.Errorf("block %d: txs kept %v, want %v", height, txs != nil, wantTxs)
+		}
+		if kept[hash] != wantStats {
+			t.Errorf("block %d: stats kept %v, want %v", height, kept[hash], wantStats)
+		}
+		if _, ok := c.blocks.Get(height); ok != wantStats {
+			t.Errorf("block %d: in the window %v, want %v", height, ok, wantStats)
+		}
+	}
+	if got := len(c.GetBlocks()); got != history {
+		t.Fatalf("blocks: got %d, want %d", got, history)
+	}
+}
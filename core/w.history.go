@@ -0,0 +1,99 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/timeseries"
+)
+
+// pool metrics history
+const (
+	metricPoolSize        = "pool_size"
+	metricPoolVsize       = "pool_vsize"
+	metricPoolFees        = "pool_fees"
+	metricFeeBuckets      = "fee_buckets"
+	metricNextBlockMinFee = "next_block_min_fee"
+)
+
+// how often expired history points are dropped
+const historyTrimPeriod = 10 * time.Minute
+
+func historyMetrics() []timeseries.Metric {
+	labels := make([]string, len(mempool.Buckets))
+	for i, b := range mempool.Buckets {
+		labels[i] = fmt.Sprintf("%d", b)
+	}
+	return []timeseries.Metric{
+		{Name: metricPoolSize, Description: "Txs in the pool", Unit: "txs"},
+		{Name: metricPoolVsize, Description: "Total virtual size of the pool txs", Unit: "vB"},
+		{Name: metricPoolFees, Description: "Total fees of the pool txs", Unit: "sat"},
+		{Name: metricFeeBuckets, Description: "Txs per fee rate bucket, labels are the bucket upper bounds", Unit: "txs", Labels: labels},
+		{Name: metricNextBlockMinFee, Description: "Min fee rate to enter the projected next block", Unit: "sat/vB"},
+	}
+}
+
+func newHistory(s storage.PoolRepository) *timeseries.Store {
+	repo, _ := s.(storage.HistoryRepository)
+	return timeseries.New(historyMetrics(), repo)
+}
+
+func (c *Core) workerHistory(ctx context.Context, period time.Duration) {
+	log := logger.Log.WithField("context", "[workerHistory]")
+	log.Info("started")
+	ticker := time.NewTicker(period)
+	defer func() {
+		log.Infof(" stopped\n")
+		ticker.Stop()
+	}()
+
+	var trimmed time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			stats := c.pool.Stats()
+			snap := c.Snapshot()
+			vsize := stats.Size
+			if stats.Weight > 0 {
+				vsize = stats.Weight / 4
+			}
+			buckets := make([]float64, len(stats.Buckets))
+			for i, n := range stats.Buckets {
+				buckets[i] = float64(n)
+			}
+			err := c.history.Add(now, map[string][]float64{
+				metricPoolSize:        {float64(stats.Count)},
+				metricPoolVsize:       {float64(vsize)},
+				metricPoolFees:        {float64(stats.Fee)},
+				metricFeeBuckets:      buckets,
+				metricNextBlockMinFee: {snap.NextBlockMinFee},
+			})
+			if err != nil {
+				log.Errorf("error on saving history: %v\n", err)
+			}
+			if now.Sub(trimmed) >= historyTrimPeriod {
+				trimmed = now
+				if err := c.history.Trim(now); err != nil {
+					log.Errorf("error on trimming history: %v\n", err)
+				}
+			}
+			c.sup.Beat(workerHistory)
+		}
+	}
+}
+
+func (c *Core) HistoryMetrics() []timeseries.Metric {
+	return c.history.Metrics()
+}
+
+// metric points within [from, to], resolution is picked if empty
+func (c *Core) GetHistory(metric, resolution string, from, to time.Time) ([]storage.Point, timeseries.Resolution, error) {
+	return c.history.Range(metric, resolution, from, to)
+}
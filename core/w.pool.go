@@ -2,22 +2,29 @@ package core
 
 import (
 	"context"
-	"math/rand"
-	"sort"
 	"time"
 
 	"github.com/1F47E/go-feesh/config"
-	"github.com/1F47E/go-feesh/entity/btc/txpool"
 	mtx "github.com/1F47E/go-feesh/entity/models/tx"
 	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mempool"
 	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/queue"
+	"github.com/1F47E/go-feesh/storage"
 )
 
-// last bucket is 500+
-var buckets = []uint{2, 3, 4, 5, 6, 8, 10, 15, 25, 35, 50, 70, 85, 100, 125, 150, 200, 250, 300, 350, 400, 450, 499, 500}
-
 // var poolSizeHistoryTimeFrame = 1 * time.Minute
 var poolSizeHistoryLimit = 40
+var poolSizeHistoryPeriod = 5 * time.Minute
+
+// persisted pool size history series
+const seriesPoolSize = "pool_size"
+
+// how often pool aggregates are saved for the fee history
+var poolStatsPeriod = time.Minute
+
+// projected pool blocks in the snapshot, the last one takes the rest
+const poolBlocksLimit = 8
 
 func (c *Core) workerPoolPuller(ctx context.Context, period time.Duration) {
 	log := logger.Log.WithField("context", "[workerPoolPuller]")
@@ -33,16 +40,15 @@ func (c *Core) workerPoolPuller(ctx context.Context, period time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// get the block height
-			info, err := c.cli.GetInfo()
+			// get the best block, templates are projected on it
+			best, err := c.cli.GetBestBlock()
 			if err != nil {
-				log.Errorf("error on getinfo: %v\n", err)
+				log.Errorf("error on getbestblock: %v\n", err)
 				continue
 			}
 
-			if c.height != info.Blocks {
-				c.height = info.Blocks
-				log.Debugf("new block height: %d\n", info.Blocks)
+			if c.setTip(best.Height, best.Hash) {
+				log.Debugf("new best block: %d %s\n", best.Height, best.Hash)
 			}
 
 			// get ordered list of pool tsx. new first
@@ -51,47 +57,45 @@ func (c *Core) workerPoolPuller(ctx context.Context, period time.Duration) {
 				log.Errorf("error on rawmempool: %v\n", err)
 				continue
 			}
-			if len(poolTxs) == 0 {
+			c.sup.Beat(workerPoolPuller)
+
+			// apply only the changes to the pool model
+			now := time.Now()
+			added, removed := c.pool.Diff(poolTxs)
+			if len(added) == 0 && len(removed) == 0 {
 				continue
 			}
 
-			// check if we have new txs
-			hasNew := false
-			for _, tx := range poolTxs {
-				if _, ok := c.poolCopyMap[tx.Txid]; !ok {
-					hasNew = true
-					break
-				}
+			// txs can be already parsed, from the block or the previous pool appearance
+			ids := make([]string, len(added))
+			for i, tx := range added {
+				ids[i] = tx.Txid
 			}
-			if !hasNew {
-				continue
+			parsed, err := c.storage.TxGetMany(ids)
+			if err != nil {
+				log.Errorf("error on txget: %v\n", err)
+				parsed = make(map[string]mtx.Tx)
 			}
-			log.Debugf("got some new txs\n")
-			log.Warnf("new pool size: %d\n", len(poolTxs))
-
-			// copy pool txs mem for later reference what pool have
-			c.mu.Lock()
-			c.poolCopy = make([]txpool.TxPool, len(poolTxs))
-			c.poolCopyMap = make(map[string]txpool.TxPool)
-			for i, tx := range poolTxs {
-				c.poolCopy[i] = tx
-				c.poolCopyMap[tx.Txid] = tx
+			newTxs := make([]string, 0, len(added))
+			for _, txid := range ids {
+				if _, ok := parsed[txid]; !ok {
+					newTxs = append(newTxs, txid)
+				}
 			}
-			c.mu.Unlock()
+			c.pool.Apply(added, removed, parsed)
+			if err := c.storage.TxEvict(removed); err != nil {
+				log.Errorf("error on evicting txs: %v\n", err)
+			}
+			log.Debugf("pool updated: +%d -%d, size %d, took %v\n", len(added), len(removed), c.pool.Len(), time.Since(now))
 
 			// send new txs to parser
-			for _, tx := range poolTxs {
-				// skip if already parsed
-				exists, err := c.storage.TxGet(tx.Txid)
-				if err != nil {
-					log.Errorf("error on txget: %v\n", err)
-					continue
-				}
-				if exists != nil {
-					continue
+			for _, txid := range newTxs {
+				if err := c.queueTx(ctx, workerPoolPuller, txid, queue.PriorityPool); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Warnf("pool tx %s is not queued: %v\n", txid, err)
 				}
-				log.Debugf("new pool tx, sending to parser: %+v\n", tx)
-				c.parserJobCh <- tx.Txid
 			}
 		}
 	}
@@ -112,15 +116,29 @@ func (c *Core) workerPoolSizeHistory(ctx context.Context, period time.Duration)
 			return
 		case <-ticker.C:
 			// add history if time passed
-			size := uint(len(c.poolSorted))
-			c.poolSizeHistory = append(c.poolSizeHistory, size)
-
-			// cleanup old records
-			if len(c.poolSizeHistory) > poolSizeHistoryLimit {
-				// log.Warnf("pool history is too big, cleaning up. old len: %d\n", len(c.poolSizeHistory))
-				c.poolSizeHistory = c.poolSizeHistory[len(c.poolSizeHistory)-poolSizeHistoryLimit:]
-				// log.Warnf("new len: %d\n", len(c.poolSizeHistory))
+			now := time.Now()
+			size := uint(c.pool.Len())
+			c.publish(func(s *Snapshot) {
+				// copy, old snapshot can be still in use
+				history := make([]uint, 0, len(s.PoolSizeHistory)+1)
+				history = append(history, s.PoolSizeHistory...)
+				history = append(history, size)
+				// cleanup old records
+				if len(history) > poolSizeHistoryLimit {
+					history = history[len(history)-poolSizeHistoryLimit:]
+				}
+				s.PoolSizeHistory = history
+			})
+			if hs, ok := c.storage.(storage.HistoryRepository); ok {
+				err := hs.HistoryAdd(seriesPoolSize, []storage.Point{{Time: now, Values: []float64{float64(size)}}})
+				if err == nil {
+					err = hs.HistoryTrim(seriesPoolSize, now.Add(-time.Duration(poolSizeHistoryLimit)*period))
+				}
+				if err != nil {
+					log.Errorf("error on saving pool size history: %v\n", err)
+				}
 			}
+			c.sup.Beat(workerPoolSizeHistory)
 		}
 	}
 }
@@ -134,138 +152,111 @@ func (c *Core) workerPoolSorter(ctx context.Context, period time.Duration) {
 		ticker.Stop()
 	}()
 
+	var statsSaved time.Time
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			// construct pool slice for API access
-			// get pool copy, merge it with parsed tx
-			// order by time
-
-			// TODO: create another copy sorted by fee, calc fee buckets
+			// pool model keeps txs ordered and totals updated,
+			// only need to check what txs will fit in the next block
 			now := time.Now()
+			projection := c.pool.Project(config.BLOCK_SIZE)
+			stats := c.pool.Stats()
 
-			res := make([]mtx.Tx, 0)
 			c.mu.Lock()
-			// collect parsed txs based on pool copy
-			// also count totals
-			var amount, weight uint64
-			var totalFee1000 float64
-			feeBuckets := make([]uint, len(buckets))
-
-			for _, tx := range c.poolCopy {
-				// get parsed tx
-				parsedTx, err := c.storage.TxGet(tx.Txid)
-				if err != nil {
-					log.Errorf("error on txget: %v\n", err)
-					continue
-				}
-				if parsedTx == nil {
-					continue
-				}
-				// fix time
-				parsedTx.Time = time.Unix(int64(tx.Time), 0)
-
-				res = append(res, *parsedTx)
-
-				// totals
-				amount += parsedTx.AmountOut
-
-				// because total fee in sat will overflow uint64, storing in 1000 sat with approx precision
-				txFee1000 := float64(parsedTx.Fee) / 1000
-				if txFee1000 > 10 {
-					log.Warnf("tx fee is too big: %f, %+v\n", txFee1000, parsedTx)
-				}
-				totalFee1000 += txFee1000
-				weight += uint64(parsedTx.Weight)
-
-				// count fee buckets
-				feeB := parsedTx.FeePerByte()
-				// fix max fee
-				if feeB > 500 {
-					feeB = 500
-				}
-				bucket := 0
-				for i, b := range buckets {
-					if feeB <= b {
-						bucket = i
-						break
-					}
-				}
-
-				feeBuckets[bucket]++
+			height := c.height
+			// best block is unknown until the first pull
+			if c.best != "" {
+				c.setTemplate(blockTemplate{
+					best:   c.best,
+					height: height,
+					txs:    projection.Txs,
+					fee:    projection.Fee,
+					time:   now,
+				})
 			}
-			// log.Warnf("fee buckets: (%d) %v\n", len(feeBuckets), feeBuckets)
-
-			// sort by fee - check if tx will fit in the next block
-			sort.Slice(res, func(i, j int) bool {
-				return res[i].Fee > res[j].Fee
-			})
-			var totalSize uint32
-			for i := range res {
-				if res[i].Fee == 0 {
-					continue
-				}
-				if totalSize+res[i].Size > config.BLOCK_SIZE {
-					break
-				}
-				totalSize += res[i].Size
-				res[i].Fits = true
-			}
-
-			// sort by time
-			sort.Slice(res, func(i, j int) bool {
-				if !res[i].Time.Equal(res[j].Time) {
-					return res[i].Time.After(res[j].Time)
-				}
-				// sometimes time can be equal, sort by Hash
-				return res[i].Hash < res[j].Hash
-			})
-			prevPoolCnt := len(c.poolSorted)
-			c.poolSorted = res
-			c.totalAmount = amount
-			c.poolFeeTotal = uint64(totalFee1000)
-			c.totalSize = uint64(totalSize)
+			c.mu.Unlock()
 
 			// TODO: fee estimator
 
 			// Calc fee buckets
 			bucketsMap := make(map[uint]uint)
-			for i, b := range buckets {
-				bucketsMap[b] = feeBuckets[i]
-			}
-			c.feeBucketsMap = bucketsMap
-			c.feeBuckets = feeBuckets
-
-			c.mu.Unlock()
-			if prevPoolCnt != len(res) {
-				log.Debugf("pool sorted, took: %v\n", time.Since(now))
-				log.Debugf("total txs: %d\n", len(res))
+			for i, b := range mempool.Buckets {
+				bucketsMap[b] = stats.Buckets[i]
 			}
 
 			var feeAvg float64
-			if len(res) > 0 {
-				feeAvg = float64(totalFee1000) / float64(totalSize)
+			if projection.Size > 0 {
+				feeAvg = float64(stats.Fee) / 1000 / float64(projection.Size)
+			}
+			txs := c.pool.Newest(snapshotTxsLimit)
+			poolBlocks := c.pool.ProjectBlocks(config.BLOCK_SIZE, poolBlocksLimit)
+			histogram := c.pool.Histogram()
+
+			prev := c.Snapshot()
+			snap := c.publish(func(s *Snapshot) {
+				s.Height = height
+				s.PoolSize = stats.Count
+				s.Amount = stats.Amount
+				// in 1000 sat with approx precision
+				s.FeeTotal = stats.Fee / 1000
+				s.FeeAvg = uint64(feeAvg * 1000)
+				s.Size = projection.Size
+				s.NextBlockMinFee = projection.MinFeeRate
+				s.FeeBuckets = stats.Buckets
+				s.FeeBucketsMap = bucketsMap
+				s.Txs = txs
+				s.PoolBlocks = poolBlocks
+				s.Histogram = histogram
+			})
+			c.sup.Beat(workerPoolSorter)
+			if ps, ok := c.storage.(storage.PoolStatsRepository); ok && now.Sub(statsSaved) >= poolStatsPeriod {
+				statsSaved = now
+				err := ps.PoolStatsAdd(storage.PoolStats{
+					Time:       now,
+					Height:     height,
+					Count:      stats.Count,
+					Size:       stats.Size,
+					Weight:     stats.Weight,
+					Fee:        stats.Fee,
+					Amount:     stats.Amount,
+					FeeBuckets: bucketsMap,
+					Projected: storage.ProjectedBlock{
+						Txs:           len(projection.Txs),
+						Fee:           projection.Fee,
+						Size:          projection.Size,
+						Weight:        projection.Weight,
+						MinFeeRate:    projection.MinFeeRate,
+						MedianFeeRate: projection.MedianFeeRate,
+					},
+				})
+				if err != nil {
+					log.Errorf("error on saving pool stats: %v\n", err)
+				}
 			}
-			c.poolFeeAvg = uint64(feeAvg * 1000)
+			if prev.PoolSize != stats.Count {
+				log.Debugf("pool projected, took: %v\n", time.Since(now))
+				log.Debugf("total txs: %d\n", stats.Count)
+			}
+
 			// fee butkets
 			// TODO: move size to const
 			var feeBucketsArr [24]uint
-			copy(feeBucketsArr[:], feeBuckets)
+			copy(feeBucketsArr[:], snap.FeeBuckets)
 
 			var poolSizeHistory [20]uint
-			copy(poolSizeHistory[:], c.poolSizeHistory)
+			copy(poolSizeHistory[:], snap.PoolSizeHistory)
 
 			// send websocket update
 			msg := notificator.Msg{
-				Height:          c.height,
-				PoolSize:        len(res),
+				Height:          snap.Height,
+				PoolSize:        snap.PoolSize,
 				PoolSizeHistory: poolSizeHistory,
-				TotalFee:        int(c.poolFeeTotal),
-				AvgFee:          int(c.poolFeeAvg),
-				Amount:          int(amount),
-				Size:            int(totalSize),
+				TotalFee:        int(snap.FeeTotal),
+				AvgFee:          int(snap.FeeAvg),
+				Amount:          int(snap.Amount),
+				Size:            int(snap.Size),
 				FeeBuckets:      feeBucketsArr,
 			}
 			go c.nofity(msg)
@@ -273,45 +264,6 @@ func (c *Core) workerPoolSorter(ctx context.Context, period time.Duration) {
 	}
 }
 
-func (c *Core) workerPoolDebug(ctx context.Context, period time.Duration) {
-	log := logger.Log.WithField("context", "[workerPoolDebug]")
-	log.Info("started")
-	ticker := time.NewTicker(period)
-	defer func() {
-		log.Infof(" stopped\n")
-		ticker.Stop()
-	}()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// send random msg
-			history := [20]uint{}
-			for i := range history {
-				history[i] = uint(rand.Intn(1000))
-			}
-			buckets := [24]uint{}
-			for i := range buckets {
-				buckets[i] = uint(rand.Intn(1000))
-			}
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			msg := notificator.Msg{
-				Height:          r.Intn(1000),
-				PoolSize:        r.Intn(1000),
-				PoolSizeHistory: history,
-				TotalFee:        r.Intn(1000),
-				AvgFee:          r.Intn(1000),
-				Amount:          r.Intn(1000),
-				Size:            r.Intn(1000),
-				FeeBuckets:      buckets,
-			}
-			go c.nofity(msg)
-		}
-	}
-}
-
 // send websocket update
 // with timeout, protection from blocking
 func (c *Core) nofity(msg notificator.Msg) {
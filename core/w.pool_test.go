@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	"github.com/1F47E/go-feesh/queue"
+	"github.com/1F47E/go-feesh/supervisor"
+)
+
+// cold start with the parsers far behind, the puller waits on the full queue longer than the dead period
+func TestPoolPullerBlockedOnQueueIsHealthy(t *testing.T) {
+	node := newFakeNode()
+	node.setBest(node.mine("a100", "", 100))
+	for i := 0; i < 10; i++ {
+		node.pool = append(node.pool, txpool.TxPool{Txid: testHash(fmt.Sprintf("tx%d", i)), Time: time.Now().Unix(), Size: 200, Vsize: 200, Fee: 1000})
+	}
+	c, _ := newTestCore(t, node, 3)
+	// no parsers, the queue never drains
+	c.parserQueue = queue.New(4, queue.PolicyBlock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const staleAfter = 20 * time.Millisecond
+	c.sup.Go(ctx, workerPoolPuller, staleAfter, func(ctx context.Context) {
+		c.workerPoolPuller(ctx, time.Millisecond)
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.parserQueue.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool txs are not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(staleAfter * 5 * 3)
+	h := c.sup.Health()
+	if h.Status != supervisor.StatusOK {
+		t.Fatalf("health: %+v", h)
+	}
+	if len(h.Workers) != 1 || !h.Workers[0].Blocked {
+		t.Fatalf("puller is not blocked on the queue: %+v", h.Workers)
+	}
+}
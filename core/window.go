@@ -0,0 +1,173 @@
+package core
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+// rolling window of the last N parsed blocks, keyed by height
+type blockWindow struct {
+	mu     sync.RWMutex
+	size   int
+	blocks map[int]mblock.Block
+}
+
+func newBlockWindow(size int) *blockWindow {
+	return &blockWindow{
+		size:   size,
+		blocks: make(map[int]mblock.Block),
+	}
+}
+
+// add or replace block at its height
+// blocks that fall out of the window or replaced by another one are returned
+func (w *blockWindow) Add(b mblock.Block) []mblock.Block {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	replaced, ok := w.blocks[b.Height]
+	w.blocks[b.Height] = b
+	evicted := w.evict()
+	if ok && replaced.Hash != b.Hash {
+		evicted = append(evicted, replaced)
+	}
+	return evicted
+}
+
+// drop the block at height, used on reorgs
+func (w *blockWindow) Remove(height int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.blocks, height)
+}
+
+// drop all blocks above height, used when the node tip went backwards.
+// Returns removed blocks
+func (w *blockWindow) RemoveAbove(height int) []mblock.Block {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	removed := make([]mblock.Block, 0)
+	for h, b := range w.blocks {
+		if h > height {
+			removed = append(removed, b)
+			delete(w.blocks, h)
+		}
+	}
+	return removed
+}
+
+func (w *blockWindow) Get(height int) (mblock.Block, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	b, ok := w.blocks[height]
+	return b, ok
+}
+
+func (w *blockWindow) GetByHash(hash string) (mblock.Block, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, b := range w.blocks {
+		if b.Hash == hash {
+			return b, true
+		}
+	}
+	return mblock.Block{}, false
+}
+
+// highest block in the window
+func (w *blockWindow) Tip() (mblock.Block, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var tip mblock.Block
+	found := false
+	for h, b := range w.blocks {
+		if !found || h > tip.Height {
+			tip = b
+			found = true
+		}
+	}
+	return tip, found
+}
+
+func (w *blockWindow) Len() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return len(w.blocks)
+}
+
+// blocks ordered by height, newest first
+func (w *blockWindow) List() []mblock.Block {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.list()
+}
+
+func (w *blockWindow) list() []mblock.Block {
+	ret := make([]mblock.Block, 0, len(w.blocks))
+	for _, b := range w.blocks {
+		ret = append(ret, b)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Height > ret[j].Height
+	})
+	return ret
+}
+
+// keep only blocks within size from the highest one
+func (w *blockWindow) evict() []mblock.Block {
+	maxHeight := 0
+	for h := range w.blocks {
+		if h > maxHeight {
+			maxHeight = h
+		}
+	}
+	evicted := make([]mblock.Block, 0)
+	for h, b := range w.blocks {
+		if h <= maxHeight-w.size {
+			evicted = append(evicted, b)
+			delete(w.blocks, h)
+		}
+	}
+	return evicted
+}
+
+// write window to a json file. Written to tmp file first and renamed
+// so the crash in the middle will not corrupt the previous state
+func (w *blockWindow) Save(path string) error {
+	w.mu.RLock()
+	data, err := json.Marshal(w.list())
+	w.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load window from a json file. Missing file is not an error
+func (w *blockWindow) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var blocks []mblock.Block
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range blocks {
+		w.blocks[b.Height] = b
+	}
+	w.evict()
+	return nil
+}
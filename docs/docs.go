@@ -1,4 +1,4 @@
-// Code generated by swaggo/swag. DO NOT EDIT
+// Package docs Code generated by swaggo/swag. DO NOT EDIT
 package docs
 
 import "github.com/swaggo/swag"
@@ -15,9 +15,40 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/pool": {
+        "/admin/pool/snapshot": {
             "get": {
-                "description": "Get information about the current state of the pool",
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Current pool as gzip compressed json lines: a header with the format version, network and height,\nthen a tx per line, newest first. Same format as POOL_SNAPSHOT_FILE",
+                "produces": [
+                    "application/gzip"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Download mempool snapshot",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/audits": {
+            "get": {
+                "description": "Audits of the last blocks, newest first. Kept for the last BLOCKS_HISTORY blocks",
                 "consumes": [
                     "application/json"
                 ],
@@ -25,13 +56,13 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "pool"
+                    "blocks"
                 ],
-                "summary": "Get pool information",
+                "summary": "Get blocks audits",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "Limit the number of transactions returned",
+                        "description": "Blocks to look at, 100 by default, up to 1000",
                         "name": "limit",
                         "in": "query"
                     }
@@ -40,7 +71,16 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.PoolResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/block.Audit"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
                         }
                     },
                     "500": {
@@ -52,9 +92,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/stats": {
+        "/blocks": {
             "get": {
-                "description": "Get information about the current state of the system memory",
+                "description": "Get stats of the parsed blocks, newest first. Kept for the last BLOCKS_HISTORY blocks.\nNext page is the blocks before the height of the last one",
                 "consumes": [
                     "application/json"
                 ],
@@ -62,14 +102,175 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "etc"
+                    "blocks"
+                ],
+                "summary": "Get parsed blocks stats",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Blocks below the height",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 100 by default, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Some status about the system. G count and memory",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/api.StatsResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/api.BlockStatsResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/blocks/{hash}": {
+            "get": {
+                "description": "Get stats of the parsed block by hash or height",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocks"
+                ],
+                "summary": "Get block stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Block hash or height",
+                        "name": "hash",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.BlockStatsResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/blocks/{hash}/audit": {
+            "get": {
+                "description": "Compare the mined block with the txs projected to fit in it just before it arrived",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocks"
+                ],
+                "summary": "Get block audit",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Block hash",
+                        "name": "hash",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/block.Audit"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/blocks/{hash}/txs": {
+            "get": {
+                "description": "Parsed txs of the block in the block order, coinbase first.\nKept for the last BLOCKS_PARSING_DEPTH blocks",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocks"
+                ],
+                "summary": "Get block txs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Block hash",
+                        "name": "hash",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Position in the block to start from, up to 100000",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 100 by default, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.BlockTxsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
                         }
                     },
                     "500": {
@@ -80,68 +281,1236 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "api.APIError": {
+        },
+        "/healthz": {
+            "get": {
+                "description": "Fails if some worker is stuck for too long and the service should be restarted",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "etc"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/supervisor.Health"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/supervisor.Health"
+                        }
+                    }
+                }
+            }
+        },
+        "/history": {
+            "get": {
+                "description": "Recorded pool metrics and available resolutions with their retention",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "history"
+                ],
+                "summary": "List history metrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HistoryMetricsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/history/{metric}": {
+            "get": {
+                "description": "Metric points averaged per resolution step, oldest first. The last point can be a step still being filled.\nResolution is picked by the range if not set",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "history"
+                ],
+                "summary": "Get metric history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Metric: pool_size, pool_vsize, pool_fees, fee_buckets, next_block_min_fee",
+                        "name": "metric",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Unix time, default is an hour before to",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Unix time, default is now",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "10s, 1m, 1h or 1d",
+                        "name": "resolution",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.HistoryResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/mining/pools": {
+            "get": {
+                "description": "Share of blocks, fees earned and empty blocks per mining pool.\nLimited by the blocks history, BLOCKS_HISTORY blocks (a week by default) built up while running",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "mining"
+                ],
+                "summary": "Get mining pools stats",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "default": "24h",
+                        "description": "Period, 24h or 1w",
+                        "name": "period",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.MiningPoolsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/pool": {
+            "get": {
+                "description": "Get information about the current state of the pool",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "pool"
+                ],
+                "summary": "Get pool information",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Limit the number of transactions returned, 100 by default, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.PoolResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/pool/txs": {
+            "get": {
+                "description": "Pool txs filtered and sorted, paginated with the cursor from the previous page.\nValue, type and rbf filters match parsed txs only",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "pool"
+                ],
+                "summary": "Browse pool transactions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "time (default), fee, feerate, value or size",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "desc (default) or asc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Next cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 100 by default, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "sat/vB",
+                        "name": "min_fee_rate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "sat/vB",
+                        "name": "max_fee_rate",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Output amount, sat",
+                        "name": "min_value",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Output amount, sat",
+                        "name": "max_value",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Projected to fit in the next block",
+                        "name": "fits",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "legacy, segwit or taproot",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Signals replace by fee",
+                        "name": "rbf",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.PoolTxsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Fails until all workers produced fresh data or if the data is stale",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "etc"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/supervisor.Health"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/supervisor.Health"
+                        }
+                    }
+                }
+            }
+        },
+        "/search": {
+            "get": {
+                "description": "Resolve a block height, block hash or txid.\nBlocks are searched in the parsed window, txs in the pool, storage and the node",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocks"
+                ],
+                "summary": "Search",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Height, block hash or txid",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.SearchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/stats": {
+            "get": {
+                "description": "Get information about the current state of the system memory",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "etc"
+                ],
+                "summary": "Some status about the system. G count, memory, parser queue and storage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.StatsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        },
+        "/tx/{txid}": {
+            "get": {
+                "description": "Stored and decoded tx. Pool txs come with the position by fee rate, projected block,\neta and unconfirmed ancestors and descendants, confirmed ones with the block",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tx"
+                ],
+                "summary": "Get transaction",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Transaction id",
+                        "name": "txid",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/api.TxResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/api.APIError"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "api.APIError": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.BlockStatsResponse": {
+            "type": "object",
+            "properties": {
+                "difficulty": {
+                    "type": "number"
+                },
+                "fee": {
+                    "type": "integer"
+                },
+                "fee_rate_max": {
+                    "type": "integer"
+                },
+                "fee_rate_median": {
+                    "type": "integer"
+                },
+                "fee_rate_min": {
+                    "description": "fee rates in sat/vB",
+                    "type": "integer"
+                },
+                "fee_rate_percentiles": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "fullness": {
+                    "type": "number"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "inputs": {
+                    "type": "integer"
+                },
+                "node_stats": {
+                    "description": "true if fee stats are from getblockstats, otherwise calculated from parsed txs",
+                    "type": "boolean"
+                },
+                "outputs": {
+                    "type": "integer"
+                },
+                "pool": {
+                    "description": "mining pool attribution",
+                    "type": "string"
+                },
+                "pool_link": {
+                    "type": "string"
+                },
+                "reward": {
+                    "description": "coinbase",
+                    "type": "integer"
+                },
+                "segwit_share": {
+                    "type": "number"
+                },
+                "segwit_txs": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "subsidy": {
+                    "description": "newly mined coins",
+                    "type": "integer"
+                },
+                "taproot_share": {
+                    "type": "number"
+                },
+                "taproot_txs": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "integer"
+                },
+                "txs": {
+                    "type": "integer"
+                },
+                "value": {
+                    "type": "integer"
+                },
+                "weight": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.BlockTxsResponse": {
+            "type": "object",
+            "properties": {
+                "hash": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "total": {
+                    "description": "txs in the block",
+                    "type": "integer"
+                },
+                "txs": {
+                    "description": "txs not parsed yet have the hash only",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/tx.Tx"
+                    }
+                }
+            }
+        },
+        "api.BlockWrapper": {
+            "type": "object",
+            "properties": {
+                "fee": {
+                    "type": "integer"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "tx_count": {
+                    "type": "integer"
+                },
+                "weight": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.HistoryMetricsResponse": {
+            "type": "object",
+            "properties": {
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/timeseries.Metric"
+                    }
+                },
+                "resolutions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.HistoryResolution"
+                    }
+                }
+            }
+        },
+        "api.HistoryPoint": {
+            "type": "object",
+            "properties": {
+                "time": {
+                    "type": "integer"
+                },
+                "values": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                }
+            }
+        },
+        "api.HistoryResolution": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "retention": {
+                    "description": "seconds",
+                    "type": "integer"
+                },
+                "step": {
+                    "description": "seconds",
+                    "type": "integer"
+                }
+            }
+        },
+        "api.HistoryResponse": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "integer"
+                },
+                "labels": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "metric": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.HistoryPoint"
+                    }
+                },
+                "resolution": {
+                    "type": "string"
+                },
+                "to": {
+                    "type": "integer"
+                },
+                "unit": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.MiningPoolsResponse": {
+            "type": "object",
+            "properties": {
+                "blocks": {
+                    "type": "integer"
+                },
+                "period": {
+                    "type": "string"
+                },
+                "pools": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mining.Stats"
+                    }
+                }
+            }
+        },
+        "api.PoolResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer"
+                },
+                "blocks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/api.BlockWrapper"
+                    }
+                },
+                "fee": {
+                    "type": "integer"
+                },
+                "fee_avg": {
+                    "type": "integer"
+                },
+                "fee_buckets": {
+                    "description": "FeeBuckets []FeeBucket    ` + "`" + `json:\"fee_buckets\"` + "`" + `",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "size_history": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "txs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/tx.Tx"
+                    }
+                },
+                "weight": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.PoolTxsResponse": {
+            "type": "object",
+            "properties": {
+                "next": {
+                    "description": "cursor of the next page, empty on the last one",
+                    "type": "string"
+                },
+                "total": {
+                    "description": "txs matching the filters",
+                    "type": "integer"
+                },
+                "txs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/tx.Tx"
+                    }
+                }
+            }
+        },
+        "api.SearchResponse": {
+            "type": "object",
+            "properties": {
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "description": "blocks only",
+                    "type": "integer"
+                },
+                "type": {
+                    "description": "block or tx",
+                    "type": "string"
+                }
+            }
+        },
+        "api.StatsResponse": {
+            "type": "object",
+            "properties": {
+                "goroutines": {
+                    "type": "integer"
+                },
+                "mem_alloc_mb": {
+                    "type": "integer"
+                },
+                "parser_queue": {
+                    "$ref": "#/definitions/queue.Stats"
+                },
+                "storage": {
+                    "$ref": "#/definitions/storage.Stats"
+                }
+            }
+        },
+        "api.TxBlockResponse": {
+            "type": "object",
+            "properties": {
+                "confirmations": {
+                    "type": "integer"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.TxMempoolResponse": {
+            "type": "object",
+            "properties": {
+                "ancestors": {
+                    "$ref": "#/definitions/api.TxPackage"
+                },
+                "block": {
+                    "description": "projected block, 1 - the next one",
+                    "type": "integer"
+                },
+                "descendants": {
+                    "$ref": "#/definitions/api.TxPackage"
+                },
+                "eta": {
+                    "description": "seconds",
+                    "type": "integer"
+                },
+                "fee_rate": {
+                    "type": "number"
+                },
+                "package_fee_rate": {
+                    "description": "with the unconfirmed ancestors",
+                    "type": "number"
+                },
+                "pool_size": {
+                    "type": "integer"
+                },
+                "position": {
+                    "description": "by fee rate, 1 - the highest",
+                    "type": "integer"
+                },
+                "weight_ahead": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.TxPackage": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "fee": {
+                    "type": "integer"
+                },
+                "txids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "weight": {
+                    "type": "integer"
+                }
+            }
+        },
+        "api.TxRBFResponse": {
+            "type": "object",
+            "properties": {
+                "replaced_by": {
+                    "type": "string"
+                },
+                "replaces": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "signals": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "api.TxResponse": {
             "type": "object",
             "properties": {
-                "description": {
-                    "type": "string"
+                "block": {
+                    "$ref": "#/definitions/api.TxBlockResponse"
                 },
-                "error": {
-                    "type": "string"
+                "decoded": {
+                    "$ref": "#/definitions/tx.Transaction"
                 },
-                "request_id": {
+                "mempool": {
+                    "$ref": "#/definitions/api.TxMempoolResponse"
+                },
+                "rbf": {
+                    "$ref": "#/definitions/api.TxRBFResponse"
+                },
+                "status": {
+                    "description": "mempool, confirmed, replaced, removed or unknown",
                     "type": "string"
+                },
+                "tx": {
+                    "$ref": "#/definitions/tx.Tx"
                 }
             }
         },
-        "api.PoolResponse": {
+        "block.Audit": {
             "type": "object",
             "properties": {
-                "amount": {
+                "actual_fee": {
                     "type": "integer"
                 },
-                "blocks": {
+                "added": {
+                    "description": "mined, known in the pool but not projected. Accelerated or prioritized txs",
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
-                "fee": {
+                "expected_fee": {
                     "type": "integer"
                 },
-                "fee_buckets": {
-                    "type": "object",
-                    "additionalProperties": {
+                "expected_txs": {
+                    "type": "integer"
+                },
+                "fee_delta": {
+                    "description": "actual - expected",
+                    "type": "integer"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "health": {
+                    "description": "share of projected txs that made it into the block, 0..100",
+                    "type": "number"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "matched_txs": {
+                    "type": "integer"
+                },
+                "missing": {
+                    "description": "projected but not mined",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "time": {
+                    "description": "audit time",
+                    "type": "integer"
+                },
+                "unseen": {
+                    "description": "mined and never seen in the pool. Out-of-band txs",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "mining.Stats": {
+            "type": "object",
+            "properties": {
+                "blocks": {
+                    "type": "integer"
+                },
+                "empty_blocks": {
+                    "description": "coinbase only",
+                    "type": "integer"
+                },
+                "fees": {
+                    "type": "integer"
+                },
+                "link": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "share": {
+                    "description": "share of blocks, 0..1",
+                    "type": "number"
+                }
+            }
+        },
+        "queue.Stats": {
+            "type": "object",
+            "properties": {
+                "avg_wait_ms": {
+                    "description": "moving average of the time in queue",
+                    "type": "number"
+                },
+                "deduped": {
+                    "type": "integer"
+                },
+                "depth": {
+                    "description": "queued jobs by priority",
+                    "type": "array",
+                    "items": {
                         "type": "integer"
                     }
                 },
-                "height": {
+                "dropped": {
                     "type": "integer"
                 },
-                "size": {
+                "in_flight": {
+                    "type": "integer"
+                },
+                "last_wait_ms": {
+                    "type": "integer"
+                },
+                "limit": {
+                    "type": "integer"
+                },
+                "max_wait_ms": {
+                    "type": "integer"
+                },
+                "pushed": {
+                    "type": "integer"
+                }
+            }
+        },
+        "storage.Stats": {
+            "type": "object",
+            "properties": {
+                "audits": {
+                    "type": "integer"
+                },
+                "blocks": {
+                    "type": "integer"
+                },
+                "confirmed": {
+                    "description": "txs of the stored blocks",
+                    "type": "integer"
+                },
+                "disk_bytes": {
+                    "description": "embedded storages file size",
+                    "type": "integer"
+                },
+                "evicted": {
+                    "description": "txs left the pool, waiting for the grace period",
+                    "type": "integer"
+                },
+                "mem_bytes": {
+                    "description": "estimated memory usage, whole server memory for redis",
+                    "type": "integer"
+                },
+                "mem_limit": {
+                    "type": "integer"
+                },
+                "pruned_confirmed": {
+                    "description": "dropped txs by the reason",
+                    "type": "integer"
+                },
+                "pruned_evicted": {
+                    "type": "integer"
+                },
+                "pruned_lru": {
+                    "type": "integer"
+                },
+                "pruned_ttl": {
                     "type": "integer"
                 },
                 "txs": {
+                    "description": "0 if unknown",
+                    "type": "integer"
+                }
+            }
+        },
+        "supervisor.Health": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "$ref": "#/definitions/supervisor.Status"
+                },
+                "workers": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/tx.Tx"
+                        "$ref": "#/definitions/supervisor.WorkerHealth"
+                    }
+                }
+            }
+        },
+        "supervisor.Status": {
+            "type": "string",
+            "enum": [
+                "ok",
+                "degraded",
+                "down"
+            ],
+            "x-enum-varnames": [
+                "StatusOK",
+                "StatusDegraded",
+                "StatusDown"
+            ]
+        },
+        "supervisor.WorkerHealth": {
+            "type": "object",
+            "properties": {
+                "blocked": {
+                    "description": "waiting on backpressure, staleness is not counted",
+                    "type": "boolean"
+                },
+                "last_beat": {
+                    "type": "string"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "restarts": {
+                    "type": "integer"
+                },
+                "running": {
+                    "type": "boolean"
+                },
+                "stale": {
+                    "type": "boolean"
+                },
+                "stale_after": {
+                    "type": "string"
+                }
+            }
+        },
+        "timeseries.Metric": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "labels": {
+                    "description": "value names of vector metrics",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
                 },
-                "weight": {
+                "name": {
+                    "type": "string"
+                },
+                "unit": {
+                    "type": "string"
+                }
+            }
+        },
+        "tx.ScriptPubKey": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "description": "bitcoin core 22+",
+                    "type": "string"
+                },
+                "addresses": {
+                    "description": "btcd and older bitcoin core",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "asm": {
+                    "type": "string"
+                },
+                "hex": {
+                    "type": "string"
+                },
+                "reqSigs": {
                     "type": "integer"
+                },
+                "type": {
+                    "type": "string"
                 }
             }
         },
-        "api.StatsResponse": {
+        "tx.ScriptSig": {
             "type": "object",
             "properties": {
-                "goroutines": {
+                "asm": {
+                    "type": "string"
+                },
+                "hex": {
+                    "type": "string"
+                }
+            }
+        },
+        "tx.Transaction": {
+            "type": "object",
+            "properties": {
+                "blockhash": {
+                    "type": "string"
+                },
+                "blocktime": {
                     "type": "integer"
                 },
-                "mem_alloc_mb": {
+                "confirmations": {
+                    "type": "integer"
+                },
+                "hex": {
+                    "type": "string"
+                },
+                "locktime": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "integer"
+                },
+                "txid": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Hash          string ` + "`" + `json:\"hash\"` + "`" + `",
+                    "type": "integer"
+                },
+                "vin": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/tx.Vin"
+                    }
+                },
+                "vout": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/tx.Vout"
+                    }
+                },
+                "weight": {
                     "type": "integer"
                 }
             }
@@ -156,6 +1525,9 @@ const docTemplate = `{
                     "description": "FeeKb     uint64    ` + "`" + `json:\"fee_kb\"` + "`" + `\nFeeByte   uint64    ` + "`" + `json:\"fee_b\"` + "`" + `",
                     "type": "integer"
                 },
+                "coinbase": {
+                    "type": "boolean"
+                },
                 "fee": {
                     "type": "integer"
                 },
@@ -165,9 +1537,25 @@ const docTemplate = `{
                 "hash": {
                     "type": "string"
                 },
+                "inputs": {
+                    "type": "integer"
+                },
+                "outputs": {
+                    "type": "integer"
+                },
+                "rbf": {
+                    "description": "signals replaceability, bip125",
+                    "type": "boolean"
+                },
+                "segwit": {
+                    "type": "boolean"
+                },
                 "size": {
                     "type": "integer"
                 },
+                "taproot": {
+                    "type": "boolean"
+                },
                 "time": {
                     "type": "string"
                 },
@@ -175,6 +1563,54 @@ const docTemplate = `{
                     "type": "integer"
                 }
             }
+        },
+        "tx.Vin": {
+            "type": "object",
+            "properties": {
+                "coinbase": {
+                    "type": "string"
+                },
+                "scriptSig": {
+                    "$ref": "#/definitions/tx.ScriptSig"
+                },
+                "sequence": {
+                    "type": "integer"
+                },
+                "txid": {
+                    "type": "string"
+                },
+                "txinwitness": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "vout": {
+                    "type": "integer"
+                }
+            }
+        },
+        "tx.Vout": {
+            "type": "object",
+            "properties": {
+                "n": {
+                    "type": "integer"
+                },
+                "scriptPubKey": {
+                    "$ref": "#/definitions/tx.ScriptPubKey"
+                },
+                "value": {
+                    "type": "number"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "description": "Bearer token, set with ADMIN_TOKEN",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -189,6 +1625,8 @@ var SwaggerInfo = &swag.Spec{
 	Description:      "API for feeding the feesh some data",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
+	LeftDelim:        "{{",
+	RightDelim:       "}}",
 }
 
 func init() {
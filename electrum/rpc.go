@@ -0,0 +1,171 @@
+package electrum
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/1F47E/go-feesh/core"
+)
+
+// JSON-RPC error codes
+const (
+	codeParse          = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternal       = -32603
+)
+
+type request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"` // no id - notification, no response
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"` // result or error, never both
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type notification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// request or a batch of them, nil if nothing to respond
+func (s *Server) handleLine(sess *session, line []byte) []byte {
+	line = bytes.TrimSpace(line)
+	var ret any
+	if len(line) > 0 && line[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(line, &batch); err != nil || len(batch) == 0 {
+			ret = errorResponse(nil, &rpcError{codeParse, "invalid batch"})
+		} else {
+			resps := make([]response, 0, len(batch))
+			for _, raw := range batch {
+				if resp := s.handleRequest(sess, raw); resp != nil {
+					resps = append(resps, *resp)
+				}
+			}
+			if len(resps) == 0 {
+				return nil
+			}
+			ret = resps
+		}
+	} else {
+		resp := s.handleRequest(sess, line)
+		if resp == nil {
+			return nil
+		}
+		ret = resp
+	}
+	data, err := json.Marshal(ret)
+	if err != nil {
+		data, _ = json.Marshal(errorResponse(nil, &rpcError{codeInternal, err.Error()}))
+	}
+	return data
+}
+
+func (s *Server) handleRequest(sess *session, raw []byte) *response {
+	var req request
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return errorResponse(nil, &rpcError{codeParse, "invalid JSON"})
+	}
+	if req.Method == "" {
+		return errorResponse(req.ID, &rpcError{codeInvalidRequest, "no method"})
+	}
+	result, err := s.call(sess, req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return errorResponse(req.ID, err)
+	}
+	data, jerr := json.Marshal(result)
+	if jerr != nil {
+		return errorResponse(req.ID, &rpcError{codeInternal, jerr.Error()})
+	}
+	return &response{JSONRPC: "2.0", ID: req.ID, Result: data}
+}
+
+func (s *Server) call(sess *session, method string, params []json.RawMessage) (any, *rpcError) {
+	switch method {
+	case "server.version":
+		return []string{strings.TrimSpace("feesh " + os.Getenv("BUILD_VERSION")), protocolVersion}, nil
+	case "server.ping":
+		return nil, nil
+	case "blockchain.relayfee":
+		return btcPerKb(core.MinRelayFeeRate), nil
+	case "blockchain.estimatefee":
+		var blocks int
+		if len(params) < 1 || json.Unmarshal(params[0], &blocks) != nil || blocks < 1 {
+			return nil, &rpcError{codeInvalidParams, "number of blocks is required"}
+		}
+		return s.estimateFee(blocks), nil
+	case "mempool.get_fee_histogram":
+		bins := s.core.Snapshot().Histogram
+		ret := make([][2]float64, len(bins))
+		for i, b := range bins {
+			ret[i] = [2]float64{b.FeeRate, float64(b.VSize)}
+		}
+		return ret, nil
+	case "blockchain.headers.subscribe":
+		h := s.header.Load()
+		if h == nil {
+			var err error
+			if h, err = s.tipHeader(); err != nil {
+				return nil, &rpcError{codeInternal, fmt.Sprintf("tip header is not available: %v", err)}
+			}
+		}
+		sess.headers.Store(true)
+		return h, nil
+	}
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("unknown method %q", method)}
+}
+
+// fee rate in BTC/kB to get confirmed within the blocks,
+// estimate of the longest target not exceeding them.
+// Unknown while the core data is not fresh
+func (s *Server) estimateFee(blocks int) float64 {
+	if !s.core.Ready() {
+		// electrum convention for unknown
+		return -1
+	}
+	estimates := s.core.FeeEstimates()
+	target := 0
+	for _, t := range core.FeeEstimateTargets {
+		if t <= blocks && t > target {
+			target = t
+		}
+	}
+	rate, ok := estimates[target]
+	if !ok {
+		return -1
+	}
+	return btcPerKb(rate)
+}
+
+// sat/vB to BTC/kB rounded to sats
+func btcPerKb(rate float64) float64 {
+	return math.Round(rate*1000) / 1e8
+}
+
+func errorResponse(id json.RawMessage, err *rpcError) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{JSONRPC: "2.0", ID: id, Error: err}
+}
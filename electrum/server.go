@@ -0,0 +1,295 @@
+package electrum
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/logger"
+)
+
+// electrum protocol version served
+const protocolVersion = "1.4"
+
+const (
+	// idle sessions are dropped like electrumx does
+	sessionTimeout = 10 * time.Minute
+	writeTimeout   = 5 * time.Second
+	// max request line, fee methods have tiny params
+	maxLineSize = 64 * 1024
+	// how often the tip is checked for the headers notifications
+	tipPeriod = time.Second
+)
+
+type Options struct {
+	Addr     string
+	CertFile string // tls if both cert and key are set
+	KeyFile  string
+}
+
+// electrum JSON-RPC server for the fee and mempool methods.
+// Newline delimited JSON-RPC 2.0 over TCP or TLS, batches are supported
+type Server struct {
+	core     *core.Core
+	addr     string
+	tls      *tls.Config
+	ln       net.Listener
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	header   atomic.Pointer[Header] // tip header, nil until the node responds
+	nextID   atomic.Uint64
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// blockchain.headers.subscribe result
+type Header struct {
+	Hex    string `json:"hex"`
+	Height int    `json:"height"`
+}
+
+func New(c *core.Core, opts Options) (*Server, error) {
+	s := &Server{
+		core:     c,
+		addr:     opts.Addr,
+		sessions: make(map[*session]struct{}),
+		done:     make(chan struct{}),
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error on loading tls key pair: %w", err)
+		}
+		s.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	return s, nil
+}
+
+// listen and serve in the background until Stop
+func (s *Server) Start(ctx context.Context) error {
+	log := logger.Log.WithField("context", "[electrum]")
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	s.ln = ln
+	log.Infof("listening on %s, tls: %v\n", ln.Addr(), s.tls != nil)
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.acceptLoop()
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.workerTip(ctx)
+	}()
+	return nil
+}
+
+// close the listener and the sessions, wait for them until ctx is done
+func (s *Server) Stop(ctx context.Context) error {
+	close(s.done)
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	s.mu.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) acceptLoop() {
+	log := logger.Log.WithField("context", "[electrum]")
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			log.Errorf("error on accept: %v\n", err)
+			return
+		}
+		sess := &session{id: s.nextID.Add(1), conn: conn}
+		s.mu.Lock()
+		// Stop closes the sessions under the lock, do not add new ones after it
+		select {
+		case <-s.done:
+			s.mu.Unlock()
+			conn.Close()
+			return
+		default:
+		}
+		s.sessions[sess] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(sess)
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// read requests line by line until the client leaves or idles out
+func (s *Server) serve(sess *session) {
+	log := logger.Log.WithField("context", fmt.Sprintf("[electrum] #%d", sess.id))
+	log.Debugf("connected %s\n", sess.conn.RemoteAddr())
+	defer func() {
+		sess.conn.Close()
+		log.Debugf("disconnected\n")
+	}()
+	scanner := bufio.NewScanner(sess.conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(sessionTimeout))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				log.Debugf("read error: %v\n", err)
+			}
+			return
+		}
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		resp := s.handleLine(sess, line)
+		if resp == nil {
+			continue
+		}
+		if err := sess.write(resp); err != nil {
+			log.Debugf("write error: %v\n", err)
+			return
+		}
+	}
+}
+
+// watch the node tip and notify the headers subscribers
+func (s *Server) workerTip(ctx context.Context) {
+	log := logger.Log.WithField("context", "[electrum.workerTip]")
+	ticker := time.NewTicker(tipPeriod)
+	defer ticker.Stop()
+	var hash string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// hash is compared, a reorg to a block of the same height is a new tip too.
+			// Header is fetched once per tip
+			tip, err := s.core.GetTip()
+			if err != nil {
+				log.Errorf("error on tip: %v\n", err)
+				continue
+			}
+			if tip.Hash == hash {
+				continue
+			}
+			header, err := s.blockHeader(tip.Hash)
+			if err != nil {
+				log.Errorf("error on tip header: %v\n", err)
+				continue
+			}
+			hash = tip.Hash
+			prev := s.header.Swap(header)
+			if prev != nil && prev.Hex != header.Hex {
+				s.notify(header)
+			}
+		}
+	}
+}
+
+func (s *Server) tipHeader() (*Header, error) {
+	tip, err := s.core.GetTip()
+	if err != nil {
+		return nil, err
+	}
+	return s.blockHeader(tip.Hash)
+}
+
+func (s *Server) blockHeader(hash string) (*Header, error) {
+	b, err := s.core.GetNodeBlockHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := b.Header()
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Hex: fmt.Sprintf("%x", raw), Height: b.Height}, nil
+}
+
+// send the new tip to the subscribed sessions
+func (s *Server) notify(h *Header) {
+	msg, err := json.Marshal(notification{
+		JSONRPC: "2.0",
+		Method:  "blockchain.headers.subscribe",
+		Params:  []any{h},
+	})
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	subscribed := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		if sess.headers.Load() {
+			subscribed = append(subscribed, sess)
+		}
+	}
+	s.mu.Unlock()
+	for _, sess := range subscribed {
+		// slow client is dropped, the read loop cleans it up
+		if err := sess.write(msg); err != nil {
+			sess.conn.Close()
+		}
+	}
+}
+
+type session struct {
+	id      uint64
+	conn    net.Conn
+	wmu     sync.Mutex // responses and notifications are written concurrently
+	headers atomic.Bool
+}
+
+func (sess *session) write(msg []byte) error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+	sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := sess.conn.Write(append(msg, '\n'))
+	return err
+}
@@ -0,0 +1,287 @@
+package electrum
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/sim"
+	"github.com/1F47E/go-feesh/storage"
+	smap "github.com/1F47E/go-feesh/storage/map"
+)
+
+// simulated node with the tip replaceable by the test
+type reorgNode struct {
+	*sim.Sim
+	mu     sync.Mutex
+	tip    *client.ResponseGetBestBlock
+	header *block.Block
+	noPool bool // pool is not served, the core never gets ready
+}
+
+func (n *reorgNode) RawMempool() ([]txpool.TxPool, error) {
+	n.mu.Lock()
+	noPool := n.noPool
+	n.mu.Unlock()
+	if noPool {
+		return nil, errors.New("pool is not available")
+	}
+	return n.Sim.RawMempool()
+}
+
+func (n *reorgNode) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.tip != nil {
+		tip := *n.tip
+		return &tip, nil
+	}
+	return n.Sim.GetBestBlock()
+}
+
+func (n *reorgNode) GetBlockHeader(hash string) (*block.Block, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.header != nil && n.header.Hash == hash {
+		h := *n.header
+		return &h, nil
+	}
+	return n.Sim.GetBlockHeader(hash)
+}
+
+// replace the tip with another block of the same height
+func (n *reorgNode) reorg(t *testing.T) *block.Block {
+	t.Helper()
+	best, err := n.Sim.GetBestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := n.Sim.GetBlockHeader(best.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Hash = strings.Repeat("0", 8) + strings.Repeat("e", 56)
+	h.Nonce++
+	n.mu.Lock()
+	n.tip = &client.ResponseGetBestBlock{Hash: h.Hash, Height: h.Height}
+	n.header = h
+	n.mu.Unlock()
+	return h
+}
+
+// server over a ready core, not listening, sessions are connected with pipes
+func newTestServer(t *testing.T) (*Server, *reorgNode, context.Context) {
+	t.Helper()
+	sc, err := sim.Load("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Pool = 500
+	ctx, cancel := context.WithCancel(context.Background())
+	node := &reorgNode{Sim: sim.New(sc, time.Now())}
+	node.Start(ctx, 1)
+	cfg := &config.Config{
+		RpcLimit:           4,
+		BlocksParsingDepth: 3,
+		ParserQueueSize:    10_000,
+		ParserQueuePolicy:  "block",
+	}
+	c := core.NewCore(ctx, cfg, node, smap.New(storage.Retention{}), make(chan notificator.Msg, 100), make(chan notificator.BlockMsg, 100))
+	s, err := New(c, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stopCtx, cancelStop := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelStop()
+		if err := s.Stop(stopCtx); err != nil {
+			t.Error(err)
+		}
+		if err := c.Stop(stopCtx); err != nil {
+			t.Error(err)
+		}
+		cancel()
+	})
+	return s, node, ctx
+}
+
+// client end of a session served over a pipe
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *Server) connect(t *testing.T) *testClient {
+	t.Helper()
+	cli, srv := net.Pipe()
+	sess := &session{id: s.nextID.Add(1), conn: srv}
+	s.mu.Lock()
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.serve(sess)
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+	}()
+	t.Cleanup(func() { cli.Close() })
+	return &testClient{conn: cli, r: bufio.NewReader(cli)}
+}
+
+func (c *testClient) send(t *testing.T, line string) {
+	t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testClient) read(t *testing.T, timeout time.Duration) []byte {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return line
+}
+
+func (c *testClient) call(t *testing.T, line string) response {
+	t.Helper()
+	c.send(t, line)
+	var resp response
+	if err := json.Unmarshal(c.read(t, 5*time.Second), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestEstimateFeeUnknown(t *testing.T) {
+	s, node, ctx := newTestServer(t)
+	node.noPool = true
+	s.core.Start(ctx)
+	cli := s.connect(t)
+	resp := cli.call(t, `{"jsonrpc":"2.0","id":1,"method":"blockchain.estimatefee","params":[2]}`)
+	if resp.Error != nil || string(resp.Result) != "-1" {
+		t.Fatalf("got %s %v, want -1", resp.Result, resp.Error)
+	}
+}
+
+func TestSession(t *testing.T) {
+	s, node, ctx := newTestServer(t)
+	s.core.Start(ctx)
+	deadline := time.Now().Add(30 * time.Second)
+	for !s.core.Ready() || len(s.core.Snapshot().PoolBlocks) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("core is not ready")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cli := s.connect(t)
+
+	resp := cli.call(t, `{"jsonrpc":"2.0","id":"v","method":"server.version","params":["electrum",  "1.4"]}`)
+	var version []string
+	if err := json.Unmarshal(resp.Result, &version); err != nil || len(version) != 2 || version[1] != protocolVersion || !strings.HasPrefix(version[0], "feesh") {
+		t.Fatalf("server.version: got %s %v", resp.Result, resp.Error)
+	}
+	if string(resp.ID) != `"v"` {
+		t.Fatalf("id: got %s, want \"v\"", resp.ID)
+	}
+
+	// the longest target not exceeding the blocks
+	estimates := s.core.FeeEstimates()
+	for _, tt := range []struct {
+		blocks, target int
+	}{
+		{1, 1}, {2, 2}, {25, 25}, {26, 25}, {143, 25}, {144, 144}, {600, 504}, {5000, 1008},
+	} {
+		resp := cli.call(t, `{"jsonrpc":"2.0","id":1,"method":"blockchain.estimatefee","params":[`+itoa(tt.blocks)+`]}`)
+		var rate float64
+		if err := json.Unmarshal(resp.Result, &rate); err != nil {
+			t.Fatalf("estimatefee %d: %s %v", tt.blocks, resp.Result, resp.Error)
+		}
+		if want := btcPerKb(estimates[tt.target]); rate != want {
+			t.Fatalf("estimatefee %d: got %v, want %v of the target %d", tt.blocks, rate, want, tt.target)
+		}
+	}
+	for _, params := range []string{`[]`, `[0]`, `["two"]`} {
+		resp := cli.call(t, `{"jsonrpc":"2.0","id":1,"method":"blockchain.estimatefee","params":`+params+`}`)
+		if resp.Error == nil || resp.Error.Code != codeInvalidParams {
+			t.Fatalf("estimatefee %s: got %s %v", params, resp.Result, resp.Error)
+		}
+	}
+
+	// notifications get no response, the next line answers the next request
+	cli.send(t, `{"jsonrpc":"2.0","method":"server.ping"}`)
+	cli.send(t, `{"jsonrpc":"2.0","method":"unknown.method"}`)
+	if resp := cli.call(t, `{"jsonrpc":"2.0","id":7,"method":"server.ping"}`); string(resp.ID) != "7" || resp.Error != nil {
+		t.Fatalf("ping: got id %s, %v", resp.ID, resp.Error)
+	}
+
+	// batch, the notification in it is skipped
+	cli.send(t, `[{"jsonrpc":"2.0","id":1,"method":"blockchain.relayfee"},{"jsonrpc":"2.0","method":"server.ping"},{"jsonrpc":"2.0","id":2,"method":"nope"}]`)
+	var batch []response
+	if err := json.Unmarshal(cli.read(t, 5*time.Second), &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || string(batch[0].ID) != "1" || string(batch[0].Result) != "0.00001" || batch[1].Error == nil || batch[1].Error.Code != codeMethodNotFound {
+		t.Fatalf("batch: got %+v", batch)
+	}
+	if resp := cli.call(t, `[]`); resp.Error == nil || resp.Error.Code != codeParse {
+		t.Fatalf("empty batch: got %+v", resp)
+	}
+	if resp := cli.call(t, `{"jsonrpc":`); resp.Error == nil || resp.Error.Code != codeParse {
+		t.Fatalf("broken json: got %+v", resp)
+	}
+
+	// subscribe, then a same height reorg is pushed
+	resp = cli.call(t, `{"jsonrpc":"2.0","id":3,"method":"blockchain.headers.subscribe"}`)
+	var tip Header
+	if err := json.Unmarshal(resp.Result, &tip); err != nil || len(tip.Hex) != 160 {
+		t.Fatalf("subscribe: got %s %v", resp.Result, resp.Error)
+	}
+	go s.workerTip(ctx)
+	// the worker takes the current tip first, nothing to push
+	time.Sleep(2 * tipPeriod)
+	reorged := node.reorg(t)
+	var push notification
+	for {
+		line := cli.read(t, 10*time.Second)
+		if err := json.Unmarshal(line, &push); err != nil {
+			t.Fatal(err)
+		}
+		if push.Method != "" {
+			break
+		}
+	}
+	if push.Method != "blockchain.headers.subscribe" || len(push.Params) != 1 {
+		t.Fatalf("push: got %+v", push)
+	}
+	h, _ := json.Marshal(push.Params[0])
+	var pushed Header
+	if err := json.Unmarshal(h, &pushed); err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Height != reorged.Height || pushed.Hex == tip.Hex {
+		t.Fatalf("pushed header %d %s, subscribed to %d %s", pushed.Height, pushed.Hex, tip.Height, tip.Hex)
+	}
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
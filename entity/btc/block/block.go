@@ -1,5 +1,12 @@
 package block
 
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
 // GET BLOCK
 /*
 curl -X POST -H 'Content-Type: application/json' -u 'rpcuser:rpcpass' -d '{"jsonrpc":"1.0","method":"getblock","params":["00000000000000048e1b327dd79f72fab6395cc09a049e54fe2c0b90aa837914"],"id":1}' http://localhost:18334
@@ -38,8 +45,38 @@ type Block struct {
 	Merkleroot        string   `json:"merkleroot"`
 	Transactions      []string `json:"tx"`
 	Time              int      `json:"time"`
+	Mediantime        int      `json:"mediantime"` // bitcoin core only
 	Nonce             int      `json:"nonce"`
 	Bits              string   `json:"bits"`
 	Difficulty        float64  `json:"difficulty"`
 	Previousblockhash string   `json:"previousblockhash"`
 }
+
+// serialized 80 bytes block header, as hashed by the miners
+func (b *Block) Header() ([]byte, error) {
+	ret := make([]byte, 0, 80)
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(b.Version))
+	for _, h := range []string{b.Previousblockhash, b.Merkleroot} {
+		// genesis has no previous block
+		raw := make([]byte, 32)
+		if h != "" {
+			decoded, err := hex.DecodeString(h)
+			if err != nil || len(decoded) != 32 {
+				return nil, fmt.Errorf("invalid hash %q", h)
+			}
+			// rpc hashes are byte reversed
+			for i := range decoded {
+				raw[i] = decoded[31-i]
+			}
+		}
+		ret = append(ret, raw...)
+	}
+	bits, err := strconv.ParseUint(b.Bits, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bits %q", b.Bits)
+	}
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(b.Time))
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(bits))
+	ret = binary.LittleEndian.AppendUint32(ret, uint32(b.Nonce))
+	return ret, nil
+}
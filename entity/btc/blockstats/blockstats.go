@@ -0,0 +1,69 @@
+package blockstats
+
+// GET BLOCK STATS
+// NOTE: bitcoin core only, btcd does not have this method
+/*
+curl -X POST -H 'Content-Type: application/json' -u 'rpcuser:rpcpass' -d '{"jsonrpc":"1.0","method":"getblockstats","params":["00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054"],"id":1}' http://localhost:8332
+
+{
+  "avgfee": 4906,
+  "avgfeerate": 20,
+  "avgtxsize": 406,
+  "blockhash": "00000000000000000002a7c4c1e48d76c5a37902165a270156b7a8d72728a054",
+  "feerate_percentiles": [11, 13, 16, 21, 35],
+  "height": 801337,
+  "ins": 7612,
+  "maxfee": 480000,
+  "maxfeerate": 1004,
+  "maxtxsize": 65223,
+  "medianfee": 2910,
+  "mediantime": 1690810431,
+  "minfee": 1480,
+  "minfeerate": 10,
+  "mintxsize": 150,
+  "outs": 9001,
+  "subsidy": 625000000,
+  "swtotal_size": 1303018,
+  "swtotal_weight": 3533125,
+  "swtxs": 3018,
+  "time": 1690812143,
+  "total_out": 183823716213,
+  "total_size": 1438283,
+  "total_weight": 3992339,
+  "totalfee": 15697542,
+  "txs": 3200,
+  "utxo_increase": 1389,
+  "utxo_size_inc": 97866
+}
+*/
+
+type BlockStats struct {
+	AvgFee             uint64    `json:"avgfee"`
+	AvgFeeRate         uint64    `json:"avgfeerate"`
+	AvgTxSize          uint64    `json:"avgtxsize"`
+	BlockHash          string    `json:"blockhash"`
+	FeeRatePercentiles [5]uint64 `json:"feerate_percentiles"`
+	Height             int       `json:"height"`
+	Ins                uint64    `json:"ins"`
+	MaxFee             uint64    `json:"maxfee"`
+	MaxFeeRate         uint64    `json:"maxfeerate"`
+	MaxTxSize          uint64    `json:"maxtxsize"`
+	MedianFee          uint64    `json:"medianfee"`
+	MedianTime         int64     `json:"mediantime"`
+	MinFee             uint64    `json:"minfee"`
+	MinFeeRate         uint64    `json:"minfeerate"`
+	MinTxSize          uint64    `json:"mintxsize"`
+	Outs               uint64    `json:"outs"`
+	Subsidy            uint64    `json:"subsidy"`
+	SwTotalSize        uint64    `json:"swtotal_size"`
+	SwTotalWeight      uint64    `json:"swtotal_weight"`
+	SwTxs              uint64    `json:"swtxs"`
+	Time               int64     `json:"time"`
+	TotalOut           uint64    `json:"total_out"`
+	TotalSize          uint64    `json:"total_size"`
+	TotalWeight        uint64    `json:"total_weight"`
+	TotalFee           uint64    `json:"totalfee"`
+	Txs                uint64    `json:"txs"`
+	UtxoIncrease       int64     `json:"utxo_increase"`
+	UtxoSizeInc        int64     `json:"utxo_size_inc"`
+}
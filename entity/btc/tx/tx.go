@@ -86,6 +86,7 @@ curl -X POST -H 'Content-Type: application/json' -u 'rpcuser:rpcpass' -d '{"json
 
 type Transaction struct {
 	Txid string `json:"txid"`
+	Hex  string `json:"hex"`
 	// Hash          string `json:"hash"`
 	Version       int    `json:"version"`
 	Locktime      int    `json:"locktime"`
@@ -124,7 +125,8 @@ type ScriptPubKey struct {
 	Hex       string   `json:"hex"`
 	ReqSigs   int      `json:"reqSigs"`
 	Type      string   `json:"type"`
-	Addresses []string `json:"addresses"`
+	Address   string   `json:"address"`   // bitcoin core 22+
+	Addresses []string `json:"addresses"` // btcd and older bitcoin core
 }
 
 // get total out amount
@@ -135,3 +137,56 @@ func (t *Transaction) GetTotalOut() uint64 {
 	}
 	return uint64(total * 1_0000_0000)
 }
+
+// all output addresses
+func (t *Transaction) GetAddresses() []string {
+	ret := make([]string, 0, len(t.Vout))
+	for _, v := range t.Vout {
+		if v.ScriptPubKey.Address != "" {
+			ret = append(ret, v.ScriptPubKey.Address)
+		}
+		ret = append(ret, v.ScriptPubKey.Addresses...)
+	}
+	return ret
+}
+
+// first input of the coinbase tx has no prevout
+// any input sequence below 0xfffffffe opts in to replace by fee, bip125
+func (t *Transaction) IsRBF() bool {
+	for _, v := range t.Vin {
+		if v.Coinbase == "" && v.Sequence < 0xfffffffe {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Transaction) IsCoinbase() bool {
+	return len(t.Vin) > 0 && t.Vin[0].Coinbase != ""
+}
+
+// any input spends with witness data
+func (t *Transaction) IsSegwit() bool {
+	for _, v := range t.Vin {
+		if len(v.Txinwitness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tx creates taproot outputs or spends taproot with a key path.
+// Key path spend is a witness with a single schnorr signature (64 or 65 bytes)
+func (t *Transaction) IsTaproot() bool {
+	for _, v := range t.Vout {
+		if v.ScriptPubKey.Type == "witness_v1_taproot" {
+			return true
+		}
+	}
+	for _, v := range t.Vin {
+		if len(v.Txinwitness) == 1 && (len(v.Txinwitness[0]) == 128 || len(v.Txinwitness[0]) == 130) {
+			return true
+		}
+	}
+	return false
+}
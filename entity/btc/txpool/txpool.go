@@ -9,6 +9,8 @@ type TxPool struct {
 	Weight   uint32 `json:"weight"`
 	Fee      uint64 `json:"fee"`
 	FeePerKB uint64 `json:"fee_kb"`
+	// in-pool parents, if the node reports them
+	Depends []string `json:"depends,omitempty"`
 }
 
 // struct to parse response from rawmempool true (verbose)
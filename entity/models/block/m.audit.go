@@ -0,0 +1,25 @@
+package block
+
+// mined block compared to the txs we projected to fit in the next block
+type Audit struct {
+	Hash   string `json:"hash"`
+	Height int    `json:"height"`
+	Time   int64  `json:"time"` // audit time
+
+	ExpectedTxs int `json:"expected_txs"`
+	MatchedTxs  int `json:"matched_txs"`
+
+	// projected but not mined
+	Missing []string `json:"missing"`
+	// mined, known in the pool but not projected. Accelerated or prioritized txs
+	Added []string `json:"added"`
+	// mined and never seen in the pool. Out-of-band txs
+	Unseen []string `json:"unseen"`
+
+	ExpectedFee uint64 `json:"expected_fee"`
+	ActualFee   uint64 `json:"actual_fee"`
+	FeeDelta    int64  `json:"fee_delta"` // actual - expected
+
+	// share of projected txs that made it into the block, 0..100
+	Health float64 `json:"health"`
+}
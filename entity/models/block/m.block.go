@@ -1,15 +1,46 @@
 package block
 
-import "github.com/btcsuite/btcd/btcutil"
+import (
+	"github.com/1F47E/go-feesh/config"
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+// fee rate percentiles, same as in getblockstats
+var FeeRatePercentiles = [5]uint{10, 25, 50, 75, 90}
 
 type Block struct {
 	Hash   string `json:"hash"`
 	Height int    `json:"height"`
+	Time   int64  `json:"time"`
 	Value  uint64 `json:"value"`
 	Fee    uint64 `json:"fee"`
 	Weight uint64 `json:"weight"`
 	Size   uint64 `json:"size"`
 	Txs    uint64 `json:"txs"`
+
+	Difficulty float64 `json:"difficulty"`
+
+	// coinbase
+	Reward  uint64 `json:"reward"`  // coinbase outputs total, subsidy + fees
+	Subsidy uint64 `json:"subsidy"` // newly mined coins
+
+	// fee rates in sat/vB
+	FeeRateMin         uint64    `json:"fee_rate_min"`
+	FeeRateMedian      uint64    `json:"fee_rate_median"`
+	FeeRateMax         uint64    `json:"fee_rate_max"`
+	FeeRatePercentiles [5]uint64 `json:"fee_rate_percentiles"`
+
+	SegwitTxs  uint64 `json:"segwit_txs"`
+	TaprootTxs uint64 `json:"taproot_txs"`
+	Inputs     uint64 `json:"inputs"`
+	Outputs    uint64 `json:"outputs"`
+
+	// mining pool attribution
+	Pool     string `json:"pool"`
+	PoolLink string `json:"pool_link"`
+
+	// true if fee stats are from getblockstats, otherwise calculated from parsed txs
+	NodeStats bool `json:"node_stats"`
 }
 
 func (b *Block) ValueString() string {
@@ -23,3 +54,33 @@ func (b *Block) FeeString() string {
 func (b *Block) IsComplete() bool {
 	return b.Value != 0 && b.Fee != 0
 }
+
+// block weight relative to the max block weight, 0..1
+func (b *Block) Fullness() float64 {
+	return float64(b.Weight) / float64(config.BLOCK_SIZE)
+}
+
+// share of segwit txs, 0..1
+func (b *Block) SegwitShare() float64 {
+	if b.Txs == 0 {
+		return 0
+	}
+	return float64(b.SegwitTxs) / float64(b.Txs)
+}
+
+// share of taproot txs, 0..1
+func (b *Block) TaprootShare() float64 {
+	if b.Txs == 0 {
+		return 0
+	}
+	return float64(b.TaprootTxs) / float64(b.Txs)
+}
+
+// block subsidy at height, halving every 210k blocks
+func Subsidy(height int) uint64 {
+	halvings := height / 210_000
+	if halvings >= 64 {
+		return 0
+	}
+	return uint64(50*btcutil.SatoshiPerBitcoin) >> halvings
+}
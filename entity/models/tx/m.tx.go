@@ -17,16 +17,45 @@ type Tx struct {
 	AmountOut uint64 `json:"amount_out"`
 	AmountIn  uint64 `json:"amount_in"`
 	Fits      bool   `json:"fits"`
+	Inputs    uint32 `json:"inputs"`
+	Outputs   uint32 `json:"outputs"`
+	Coinbase  bool   `json:"coinbase"`
+	Segwit    bool   `json:"segwit"`
+	Taproot   bool   `json:"taproot"`
+	RBF       bool   `json:"rbf"` // signals replaceability, bip125
 }
 
 func (t *Tx) FeePerKb() uint {
+	if t.Size == 0 {
+		return 0
+	}
 	return uint(float64(t.Fee) / float64(t.Size) * 1000)
 }
 
 func (t *Tx) FeePerByte() uint {
+	if t.Size == 0 {
+		return 0
+	}
 	return uint(float64(t.Fee) / float64(t.Size))
 }
 
+// virtual size in vbytes, weight / 4 rounded up
+func (t *Tx) VSize() uint32 {
+	if t.Weight == 0 {
+		return t.Size
+	}
+	return (t.Weight + 3) / 4
+}
+
+// fee rate in sat/vB
+func (t *Tx) FeeRate() float64 {
+	vsize := t.VSize()
+	if vsize == 0 {
+		return 0
+	}
+	return float64(t.Fee) / float64(vsize)
+}
+
 func (t *Tx) FeeString() string {
 	return btcutil.Amount(t.Fee).String()
 }
@@ -0,0 +1,26 @@
+package tx
+
+import "testing"
+
+func TestFeeRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      Tx
+		perByte uint
+		perKb   uint
+	}{
+		{"regular", Tx{Size: 250, Fee: 5000}, 20, 20000},
+		{"fractional", Tx{Size: 3, Fee: 10}, 3, 3333},
+		{"zero size", Tx{Fee: 1000}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tx.FeePerByte(); got != tt.perByte {
+				t.Errorf("FeePerByte: got %d, want %d", got, tt.perByte)
+			}
+			if got := tt.tx.FeePerKb(); got != tt.perKb {
+				t.Errorf("FeePerKb: got %d, want %d", got, tt.perKb)
+			}
+		})
+	}
+}
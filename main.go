@@ -18,15 +18,24 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/1F47E/go-feesh/api"
 	"github.com/1F47E/go-feesh/client"
 	"github.com/1F47E/go-feesh/config"
 	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/electrum"
 	mblock "github.com/1F47E/go-feesh/entity/models/block"
 	"github.com/1F47E/go-feesh/logger"
 	"github.com/1F47E/go-feesh/notificator"
+	"github.com/1F47E/go-feesh/replay"
+	"github.com/1F47E/go-feesh/sim"
+	"github.com/1F47E/go-feesh/storage"
+	sbolt "github.com/1F47E/go-feesh/storage/bolt"
 	smap "github.com/1F47E/go-feesh/storage/map"
+	sredis "github.com/1F47E/go-feesh/storage/redis"
+	ssql "github.com/1F47E/go-feesh/storage/sql"
 
 	// docs are generated by Swag CLI
 	_ "github.com/1F47E/go-feesh/docs"
@@ -47,12 +56,19 @@ var buildTime string
 
 var cli *client.Client
 
+// kubernetes waits 30s by default before killing the pod
+const shutdownTimeout = 25 * time.Second
+
 // @title Feesh API
 // @version 0.0.1
 // @description API for feeding the feesh some data
 // @host localhost:8080
 // @BasePath /v1
 // @schemes https
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
+// @description Bearer token, set with ADMIN_TOKEN
 func main() {
 	fmt.Print(banner)
 	fmt.Println()
@@ -64,8 +80,41 @@ func main() {
 
 	var err error
 	cfg := config.NewConfig()
+	network := "mainnet"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	if os.Getenv("DRY") != "1" {
+	var node core.Node
+	if cfg.ReplayFile != "" {
+		// play the recorded events instead of the node
+		player, err := replay.NewPlayer(cfg.ReplayFile, cfg.ReplaySpeed)
+		if err != nil {
+			log.Fatalf("error on event log %s: %v", cfg.ReplayFile, err)
+		}
+		player.Start(ctx)
+		info, err := player.GetInfo()
+		if err != nil {
+			log.Fatalln("error on replay getinfo:", err)
+		}
+		if info.Testnet {
+			network = "testnet"
+		}
+		node = player
+	} else if cfg.SimScenario != "" {
+		// synthetic node
+		sc, err := sim.Load(cfg.SimScenario)
+		if err != nil {
+			log.Fatalln("error on simulation scenario:", err)
+		}
+		if sc.Testnet {
+			network = "testnet"
+		}
+		s := sim.New(sc, time.Now())
+		s.Start(ctx, cfg.SimSpeed)
+		logger.Log.Infof("simulating %s scenario at x%v", sc.Name, cfg.SimSpeed)
+		node = s
+	} else if os.Getenv("DRY") != "1" {
 
 		// create RPC client
 		cli, err = client.NewClient(cfg.RpcHost, cfg.RpcUser, cfg.RpcPass)
@@ -79,6 +128,9 @@ func main() {
 			log.Fatalln("error on getinfo:", err)
 		}
 		log.Printf("node info: %+v\n", info)
+		if info.Testnet {
+			network = "testnet"
+		}
 
 		// get last block hash
 		bestBlock, err := cli.GetBestBlock()
@@ -114,6 +166,17 @@ func main() {
 			Fee:    totalFee,
 		}
 		log.Printf("block %d, value: %d, fee: %d\n", wBlock.Height, wBlock.Value, wBlock.Fee)
+		node = cli
+	}
+
+	// record what the node returns, replayed later with REPLAY_FILE
+	var recorder *replay.Recorder
+	if cfg.RecordFile != "" && node != nil {
+		recorder, err = replay.NewRecorder(node, cfg.RecordFile)
+		if err != nil {
+			log.Fatalf("error on event log %s: %v", cfg.RecordFile, err)
+		}
+		node = recorder
 	}
 
 	// get block header
@@ -129,28 +192,51 @@ func main() {
 	// }
 	// log.Println("block tx cnt:", len(b.Transactions))
 
-	// TODO: graceful shutdown
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// create storage
+	retention := storage.Retention{
+		EvictedGrace: cfg.StorageEvictedGrace,
+		MemLimit:     uint64(cfg.StorageMemLimitMb) * 1024 * 1024,
+		TxTTL:        cfg.StorageTxTTL,
+	}
+	var strg storage.PoolRepository
+	switch cfg.Storage {
+	case "map":
+		strg = smap.New(retention)
+	case "redis":
+		strg, err = sredis.New(ctx, sredis.Options{
+			Addr:      cfg.RedisAddr,
+			Username:  cfg.RedisUser,
+			Password:  cfg.RedisPass,
+			DB:        cfg.RedisDB,
+			Prefix:    fmt.Sprintf("%s:%s:", cfg.RedisPrefix, network),
+			Retention: retention,
+		})
+		if err != nil {
+			log.Fatalln("error on redis storage:", err)
+		}
+	case "bolt":
+		strg, err = sbolt.New(cfg.BoltFile, retention)
+		if err != nil {
+			log.Fatalln("error on bolt storage:", err)
+		}
+	case "sql":
+		strg, err = ssql.New(cfg.SqlDriver, cfg.SqlDsn, retention)
+		if err != nil {
+			log.Fatalln("error on sql storage:", err)
+		}
+	default:
+		log.Fatalf("unknown storage: %s", cfg.Storage)
+	}
 
-	// create in mem storage (debug only)
-	strg := smap.New()
-	// create redis storage
-	// s, err := sredis.New(ctx)
-	// if err != nil {
-	// 	log.Fatalln("error on redis storage:", err)
-	// }
-
-	// common channel for WS notifications
+	// common channels for WS notifications
 	broadcastCh := make(chan notificator.Msg)
+	blocksCh := make(chan notificator.BlockMsg)
 
 	// WS notificator
-	noficator := notificator.New(broadcastCh)
+	noficator := notificator.New(broadcastCh, blocksCh)
 
-	// create core with RPC client and storage
-	c := core.NewCore(ctx, cfg, cli, strg, broadcastCh)
+	// create core with the node and storage
+	c := core.NewCore(ctx, cfg, node, strg, broadcastCh, blocksCh)
 
 	// create API with WS
 	a := api.NewApi(c, noficator)
@@ -158,20 +244,86 @@ func main() {
 	// start main workers
 	c.Start(ctx)
 
-	// graceful shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	// electrum protocol for the fee methods
+	var es *electrum.Server
+	if cfg.ElectrumHost != "" {
+		es, err = electrum.New(c, electrum.Options{
+			Addr:     cfg.ElectrumHost,
+			CertFile: cfg.ElectrumTLSCert,
+			KeyFile:  cfg.ElectrumTLSKey,
+		})
+		if err != nil {
+			log.Fatalln("error on electrum server:", err)
+		}
+		if err := es.Start(ctx); err != nil {
+			log.Fatalln("error on electrum listen:", err)
+		}
+	}
+
+	// start server
+	listenErr := make(chan error, 1)
 	go func() {
-		<-quit
-		cancel()
-		if err := a.Shutdown(); err != nil {
-			log.Fatalf("error on shutdown: %v", err)
+		listenErr <- a.Listen()
+	}()
+
+	// reload the mining pools definitions without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := c.ReloadMiningPools(); err != nil {
+				logger.Log.Errorf("error on reloading mining pools from %s: %v", cfg.PoolsFile, err)
+				continue
+			}
+			logger.Log.Infof("mining pools reloaded")
 		}
 	}()
 
-	// start server
-	err = a.Listen()
-	if err != nil {
-		log.Fatalf("error on listen: %v", err)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-quit:
+		logger.Log.Infof("received %s, shutting down", sig)
+	case err := <-listenErr:
+		logger.Log.Errorf("error on listen: %v", err)
+		exitCode = 1
+	}
+
+	// graceful shutdown.
+	// second signal or deadline abandons whatever is left
+	go func() {
+		<-quit
+		logger.Log.Warnf("forced shutdown")
+		os.Exit(1)
+	}()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := c.Stop(shutdownCtx); err != nil {
+		logger.Log.Errorf("error on stopping core: %v", err)
+		exitCode = 1
+	}
+	if es != nil {
+		if err := es.Stop(shutdownCtx); err != nil {
+			logger.Log.Errorf("error on stopping electrum server: %v", err)
+			exitCode = 1
+		}
+	}
+	if err := a.Shutdown(shutdownCtx); err != nil {
+		logger.Log.Errorf("error on shutdown: %v", err)
+		exitCode = 1
+	}
+	if err := c.Close(); err != nil {
+		logger.Log.Errorf("error on flushing state: %v", err)
+		exitCode = 1
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			logger.Log.Errorf("error on closing event log: %v", err)
+			exitCode = 1
+		}
 	}
+	cancelShutdown()
+	cancel()
+	logger.Log.Infof("===== Stopped")
+	os.Exit(exitCode)
 }
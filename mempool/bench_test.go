@@ -0,0 +1,143 @@
+package mempool
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/config"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+// mempool model memory, diff/apply time for a pool update and the next block projection (sorter) throughput.
+//
+//	go test -run '^$' -bench . -benchmem ./mempool
+
+var benchSizes = []int{100_000, 300_000, 1_000_000}
+
+// share of the pool replaced on every update
+const benchChurn = 0.01
+
+type benchTx struct {
+	id    [32]byte
+	time  int64
+	vsize uint32
+	fee   uint64
+}
+
+// sizes and fees roughly follow the real pool: mostly small txs paying a few sat/vB
+func randomBenchTx() benchTx {
+	var ret benchTx
+	_, _ = rand.Read(ret.id[:])
+	ret.vsize = uint32(110 + mrand.ExpFloat64()*250)
+	ret.fee = uint64(float64(ret.vsize) * (1 + mrand.ExpFloat64()*15))
+	ret.time = time.Now().Unix() - mrand.Int63n(3*24*3600)
+	return ret
+}
+
+func randomBenchTxs(n int) []benchTx {
+	ret := make([]benchTx, n)
+	for i := range ret {
+		ret[i] = randomBenchTx()
+	}
+	return ret
+}
+
+// getrawmempool as returned by the patched node.
+// Response is decoded from json on every pull, strings are not shared with the pool
+func benchResponse(txs []benchTx) []txpool.TxPool {
+	ret := make([]txpool.TxPool, len(txs))
+	for i, t := range txs {
+		ret[i] = txpool.TxPool{
+			Txid:   hex.EncodeToString(t.id[:]),
+			Time:   t.time,
+			Size:   t.vsize,
+			Vsize:  t.vsize,
+			Weight: t.vsize * 4,
+			Fee:    t.fee,
+		}
+	}
+	return ret
+}
+
+func benchPool(txs []benchTx) *Pool {
+	p := New()
+	added, removed := p.Diff(benchResponse(txs))
+	p.Apply(added, removed, nil)
+	return p
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// initial apply of all txs, reports the pool memory per tx
+func BenchmarkPoolFill(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("txs=%d", n), func(b *testing.B) {
+			txs := randomBenchTxs(n)
+			var bytesPerTx uint64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				before := heapAlloc()
+				resp := benchResponse(txs)
+				b.StartTimer()
+
+				p := New()
+				added, removed := p.Diff(resp)
+				p.Apply(added, removed, nil)
+
+				b.StopTimer()
+				resp, added = nil, nil
+				bytesPerTx = (heapAlloc() - before) / uint64(n)
+				runtime.KeepAlive(p)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(bytesPerTx), "bytes/tx")
+		})
+	}
+}
+
+// diff and apply of the node response with part of the pool replaced, as between pulls
+func BenchmarkPoolUpdate(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("txs=%d", n), func(b *testing.B) {
+			txs := randomBenchTxs(n)
+			p := benchPool(txs)
+			replace := int(float64(n) * benchChurn)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < replace; j++ {
+					txs[mrand.Intn(n)] = randomBenchTx()
+				}
+				resp := benchResponse(txs)
+				b.StartTimer()
+
+				added, removed := p.Diff(resp)
+				p.Apply(added, removed, nil)
+			}
+		})
+	}
+}
+
+// next block projection done by the pool sorter
+func BenchmarkPoolProject(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("txs=%d", n), func(b *testing.B) {
+			p := benchPool(randomBenchTxs(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.Project(config.BLOCK_SIZE)
+			}
+		})
+	}
+}
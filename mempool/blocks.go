@@ -0,0 +1,103 @@
+package mempool
+
+// fee rate percentiles of the projected block txs
+var feeRangePercentiles = []float64{0, 0.1, 0.25, 0.5, 0.75, 0.9, 1}
+
+// histogram bin vsize, grows by 10% with every bin
+const (
+	histogramBinSize   = 100_000
+	histogramBinGrowth = 1.1
+)
+
+// projected block of the pool txs
+type ProjectedBlock struct {
+	Txs    int
+	Size   uint64
+	Weight uint64
+	Fee    uint64
+	// fee rates in sat/vB
+	MedianFeeRate float64
+	FeeRange      []float64 // min, 10, 25, 50, 75, 90 percentiles and max
+}
+
+// fee rate and vsize of the pool txs paying at least that rate
+type HistogramBin struct {
+	FeeRate float64
+	VSize   uint64
+}
+
+// split the pool in up to n blocks by fee rate, the last one takes the rest.
+// Txs with unknown fee are skipped
+func (p *Pool) ProjectBlocks(maxWeight uint64, n int) []ProjectedBlock {
+	if n < 1 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := make([]ProjectedBlock, 0, n)
+	var cur ProjectedBlock
+	// highest first
+	rates := make([]float64, 0)
+	p.byRate.Ascend(func(i uint32) bool {
+		e := &p.arena[i]
+		if e.fee == 0 {
+			return true
+		}
+		w := uint64(e.vsize()) * 4
+		if cur.Txs > 0 && cur.Weight+w > maxWeight && len(ret) < n-1 {
+			ret = append(ret, cur.close(rates))
+			cur = ProjectedBlock{}
+			rates = rates[:0]
+		}
+		cur.Txs++
+		cur.Size += uint64(e.size)
+		cur.Weight += w
+		cur.Fee += e.fee
+		rates = append(rates, e.feeRate())
+		return true
+	})
+	if cur.Txs > 0 {
+		ret = append(ret, cur.close(rates))
+	}
+	return ret
+}
+
+// fill fee rate stats, rates are ordered highest first
+func (b ProjectedBlock) close(rates []float64) ProjectedBlock {
+	n := len(rates)
+	b.MedianFeeRate = rates[n/2]
+	b.FeeRange = make([]float64, len(feeRangePercentiles))
+	for i, pct := range feeRangePercentiles {
+		b.FeeRange[i] = rates[int(float64(n-1)*(1-pct))]
+	}
+	return b
+}
+
+// compact fee histogram, highest rates first.
+// Bins are closed when their vsize exceeds the bin size, which grows with every bin
+func (p *Pool) Histogram() []HistogramBin {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := make([]HistogramBin, 0)
+	binSize := float64(histogramBinSize)
+	var bin HistogramBin
+	p.byRate.Ascend(func(i uint32) bool {
+		e := &p.arena[i]
+		if e.fee == 0 {
+			return true
+		}
+		rate := e.feeRate()
+		if bin.VSize > uint64(binSize) && rate < bin.FeeRate {
+			ret = append(ret, bin)
+			bin = HistogramBin{}
+			binSize *= histogramBinGrowth
+		}
+		bin.FeeRate = rate
+		bin.VSize += uint64(e.vsize())
+		return true
+	})
+	if bin.VSize > 0 {
+		ret = append(ret, bin)
+	}
+	return ret
+}
@@ -0,0 +1,29 @@
+package mempool
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// txid in binary form, bytes in the same order as the hex string
+type ID [32]byte
+
+func ParseID(s string) (ID, bool) {
+	var id ID
+	if len(s) != hex.EncodedLen(len(id)) {
+		return id, false
+	}
+	if _, err := hex.Decode(id[:], []byte(s)); err != nil {
+		return id, false
+	}
+	return id, true
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// same order as the hex strings
+func (id ID) Less(other ID) bool {
+	return bytes.Compare(id[:], other[:]) < 0
+}
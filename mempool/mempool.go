@@ -0,0 +1,526 @@
+package mempool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+)
+
+// fee buckets in sat/b, last bucket is 500+
+var Buckets = []uint{2, 3, 4, 5, 6, 8, 10, 15, 25, 35, 50, 70, 85, 100, 125, 150, 200, 250, 300, 350, 400, 450, 499, 500}
+
+// how long to remember txs removed from the pool
+const removedTTL = time.Hour
+
+const (
+	flagParsed uint8 = 1 << iota
+	flagFits
+	flagCoinbase
+	flagSegwit
+	flagTaproot
+	flagRBF
+)
+
+// packed pool tx, stored by value in the arena
+type entry struct {
+	id        ID
+	fee       uint64
+	amountIn  uint64
+	amountOut uint64
+	rate      float64
+	unix      int64
+	size      uint32
+	weight    uint32
+	inputs    uint32
+	outputs   uint32
+	gen       uint32 // last diff generation the tx was seen in
+	bucket    uint8
+	flags     uint8
+}
+
+// running aggregates of the pool
+type Stats struct {
+	Count   int
+	Parsed  int
+	Amount  uint64 // parsed txs only
+	Size    uint64
+	Weight  uint64
+	Fee     uint64
+	Buckets []uint
+}
+
+// txs projected to fit in the next block
+type Projection struct {
+	Txs    map[string]struct{}
+	Fee    uint64
+	Size   uint64
+	Weight uint64
+	// fee rates of the included txs in sat/vB, min is the rate to enter the block
+	MinFeeRate    float64
+	MedianFeeRate float64
+}
+
+// incremental mempool model.
+// Txs are packed in a single arena, indexes keep arena positions
+// ordered by fee rate and by time. Updated with add/remove deltas
+type Pool struct {
+	mu      sync.RWMutex
+	arena   []entry
+	free    []uint32 // released arena slots
+	index   map[ID]uint32
+	byRate  *sortedSet[uint32]
+	byTime  *sortedSet[uint32]
+	fits    []uint32
+	removed map[ID]int64
+	parents map[ID][]ID // in-pool parents of txs that have them
+	// inputs of the parsed pool txs, kept while the tx is remembered as removed
+	spends   map[outpoint]ID
+	inputs   map[ID][]outpoint
+	replaced map[ID]ID   // replaced tx to the replacement
+	replaces map[ID][]ID // replacement to the replaced txs
+	stats    Stats
+	gen      uint32
+}
+
+// pool tx as reported by the node, with the parsed data if known
+type Record struct {
+	txpool.TxPool
+	Parsed *mtx.Tx `json:"parsed,omitempty"`
+}
+
+func New() *Pool {
+	p := &Pool{
+		arena:    make([]entry, 0),
+		free:     make([]uint32, 0),
+		index:    make(map[ID]uint32),
+		fits:     make([]uint32, 0),
+		removed:  make(map[ID]int64),
+		parents:  make(map[ID][]ID),
+		spends:   make(map[outpoint]ID),
+		inputs:   make(map[ID][]outpoint),
+		replaced: make(map[ID]ID),
+		replaces: make(map[ID][]ID),
+		stats:    Stats{Buckets: make([]uint, len(Buckets))},
+	}
+	p.byRate = newSortedSet(func(a, b uint32) bool {
+		ea, eb := &p.arena[a], &p.arena[b]
+		if ea.rate != eb.rate {
+			return ea.rate > eb.rate
+		}
+		return ea.id.Less(eb.id)
+	})
+	p.byTime = newSortedSet(func(a, b uint32) bool {
+		ea, eb := &p.arena[a], &p.arena[b]
+		if ea.unix != eb.unix {
+			return ea.unix > eb.unix
+		}
+		// sometimes time can be equal, sort by id
+		return ea.id.Less(eb.id)
+	})
+	return p
+}
+
+// compare the current node pool with the model, returns the deltas to apply.
+// Txs with malformed ids are skipped
+func (p *Pool) Diff(current []txpool.TxPool) ([]txpool.TxPool, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// mark txs still in the pool instead of building a set of the current ones
+	p.gen++
+	added := make([]txpool.TxPool, 0)
+	for _, tx := range current {
+		id, ok := ParseID(tx.Txid)
+		if !ok {
+			continue
+		}
+		i, ok := p.index[id]
+		if !ok {
+			added = append(added, tx)
+			continue
+		}
+		p.arena[i].gen = p.gen
+	}
+	removed := make([]string, 0)
+	for id, i := range p.index {
+		if p.arena[i].gen != p.gen {
+			removed = append(removed, id.String())
+		}
+	}
+	return added, removed
+}
+
+// apply deltas. Parsed txs can be passed to enrich added ones right away
+func (p *Pool) Apply(added []txpool.TxPool, removed []string, parsed map[string]mtx.Tx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now().Unix()
+	for _, txid := range removed {
+		id, ok := ParseID(txid)
+		if !ok {
+			continue
+		}
+		i, ok := p.index[id]
+		if !ok {
+			continue
+		}
+		p.byRate.Delete(i)
+		p.byTime.Delete(i)
+		p.account(&p.arena[i], -1)
+		delete(p.index, id)
+		p.arena[i] = entry{}
+		p.free = append(p.free, i)
+		p.removed[id] = now
+		delete(p.parents, id)
+	}
+	for _, ptx := range added {
+		id, ok := ParseID(ptx.Txid)
+		if !ok {
+			continue
+		}
+		if _, ok := p.index[id]; ok {
+			continue
+		}
+		e := entry{
+			id:     id,
+			unix:   ptx.Time,
+			size:   ptx.Size,
+			weight: ptx.Weight,
+			fee:    ptx.Fee,
+			gen:    p.gen,
+		}
+		if tx, ok := parsed[ptx.Txid]; ok {
+			e.merge(tx)
+		}
+		e.rate = e.feeRate()
+		e.bucket = uint8(bucket(e.feePerByte()))
+		i := p.alloc(e)
+		p.index[id] = i
+		p.byRate.Insert(i)
+		p.byTime.Insert(i)
+		p.account(&p.arena[i], 1)
+		delete(p.removed, id)
+		if len(ptx.Depends) > 0 {
+			parents := make([]ID, 0, len(ptx.Depends))
+			for _, txid := range ptx.Depends {
+				if pid, ok := ParseID(txid); ok {
+					parents = append(parents, pid)
+				}
+			}
+			p.parents[id] = parents
+		}
+	}
+	for id, t := range p.removed {
+		if now-t > int64(removedTTL.Seconds()) {
+			delete(p.removed, id)
+			p.forget(id)
+		}
+	}
+}
+
+// update pool tx with parsed data. Pool fields (time, fee, size) are kept
+func (p *Pool) Enrich(tx mtx.Tx) bool {
+	id, ok := ParseID(tx.Hash)
+	if !ok {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, ok := p.index[id]
+	if !ok {
+		return false
+	}
+	e := &p.arena[i]
+	p.account(e, -1)
+	e.merge(tx)
+	p.account(e, 1)
+	return true
+}
+
+func (p *Pool) Get(txid string) (mtx.Tx, bool) {
+	id, ok := ParseID(txid)
+	if !ok {
+		return mtx.Tx{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	i, ok := p.index[id]
+	if !ok {
+		return mtx.Tx{}, false
+	}
+	return p.arena[i].tx(), true
+}
+
+func (p *Pool) Has(txid string) bool {
+	id, ok := ParseID(txid)
+	if !ok {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok = p.index[id]
+	return ok
+}
+
+// tx is in the pool or was removed from it recently
+func (p *Pool) Seen(txid string) bool {
+	id, ok := ParseID(txid)
+	if !ok {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if _, ok := p.index[id]; ok {
+		return true
+	}
+	_, ok = p.removed[id]
+	return ok
+}
+
+// in-pool parents of the tx, empty if the node doesn't report them
+func (p *Pool) Depends(txid string) []string {
+	id, ok := ParseID(txid)
+	if !ok {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.depends(id)
+}
+
+// whole pool, newest first
+func (p *Pool) Dump() []Record {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := make([]Record, 0, len(p.index))
+	p.byTime.Ascend(func(i uint32) bool {
+		e := &p.arena[i]
+		r := Record{
+			TxPool: txpool.TxPool{
+				Txid:    e.id.String(),
+				Time:    e.unix,
+				Size:    e.size,
+				Vsize:   e.vsize(),
+				Weight:  e.weight,
+				Fee:     e.fee,
+				Depends: p.depends(e.id),
+			},
+		}
+		if r.Vsize > 0 {
+			r.FeePerKB = e.fee * 1000 / uint64(r.Vsize)
+		}
+		if e.flags&flagParsed != 0 {
+			tx := e.tx()
+			tx.Fits = false
+			r.Parsed = &tx
+		}
+		ret = append(ret, r)
+		return true
+	})
+	return ret
+}
+
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.index)
+}
+
+func (p *Pool) Stats() Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := p.stats
+	ret.Buckets = make([]uint, len(p.stats.Buckets))
+	copy(ret.Buckets, p.stats.Buckets)
+	return ret
+}
+
+// newest txs first
+func (p *Pool) Newest(limit int) []mtx.Tx {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := make([]mtx.Tx, 0, min(limit, len(p.index)))
+	p.byTime.Ascend(func(i uint32) bool {
+		if len(ret) >= limit {
+			return false
+		}
+		ret = append(ret, p.arena[i].tx())
+		return true
+	})
+	return ret
+}
+
+// take the highest fee rate txs until the block weight is reached and mark them as fitting.
+// Txs with unknown fee are skipped
+func (p *Pool) Project(maxWeight uint64) Projection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, i := range p.fits {
+		p.arena[i].flags &^= flagFits
+	}
+	p.fits = p.fits[:0]
+	ret := Projection{Txs: make(map[string]struct{})}
+	// highest first
+	rates := make([]float64, 0)
+	p.byRate.Ascend(func(i uint32) bool {
+		e := &p.arena[i]
+		if e.fee == 0 {
+			return true
+		}
+		w := uint64(e.weight)
+		if w == 0 {
+			w = uint64(e.size) * 4
+		}
+		if ret.Weight+w > maxWeight {
+			return false
+		}
+		e.flags |= flagFits
+		p.fits = append(p.fits, i)
+		ret.Txs[e.id.String()] = struct{}{}
+		ret.Fee += e.fee
+		ret.Size += uint64(e.size)
+		ret.Weight += w
+		rates = append(rates, float64(e.fee)*4/float64(w))
+		return true
+	})
+	if n := len(rates); n > 0 {
+		ret.MinFeeRate = rates[n-1]
+		ret.MedianFeeRate = rates[n/2]
+	}
+	return ret
+}
+
+// parents still in the pool
+// must be called with mu locked
+func (p *Pool) depends(id ID) []string {
+	parents := p.parents[id]
+	if len(parents) == 0 {
+		return nil
+	}
+	ret := make([]string, 0, len(parents))
+	for _, pid := range parents {
+		if _, ok := p.index[pid]; ok {
+			ret = append(ret, pid.String())
+		}
+	}
+	return ret
+}
+
+// put the entry to a free arena slot
+// must be called with mu locked
+func (p *Pool) alloc(e entry) uint32 {
+	if n := len(p.free); n > 0 {
+		i := p.free[n-1]
+		p.free = p.free[:n-1]
+		p.arena[i] = e
+		return i
+	}
+	p.arena = append(p.arena, e)
+	return uint32(len(p.arena) - 1)
+}
+
+// add or subtract entry from the running totals
+// must be called with mu locked
+func (p *Pool) account(e *entry, sign int) {
+	if sign > 0 {
+		p.stats.Count++
+		p.stats.Size += uint64(e.size)
+		p.stats.Weight += uint64(e.weight)
+		p.stats.Fee += e.fee
+		p.stats.Amount += e.amountOut
+		p.stats.Buckets[e.bucket]++
+		if e.flags&flagParsed != 0 {
+			p.stats.Parsed++
+		}
+		return
+	}
+	p.stats.Count--
+	p.stats.Size -= uint64(e.size)
+	p.stats.Weight -= uint64(e.weight)
+	p.stats.Fee -= e.fee
+	p.stats.Amount -= e.amountOut
+	p.stats.Buckets[e.bucket]--
+	if e.flags&flagParsed != 0 {
+		p.stats.Parsed--
+	}
+}
+
+// copy parsed tx fields, keep the pool ones
+func (e *entry) merge(tx mtx.Tx) {
+	e.amountIn = tx.AmountIn
+	e.amountOut = tx.AmountOut
+	e.inputs = tx.Inputs
+	e.outputs = tx.Outputs
+	e.flags &= flagFits
+	e.flags |= flagParsed
+	if tx.Coinbase {
+		e.flags |= flagCoinbase
+	}
+	if tx.Segwit {
+		e.flags |= flagSegwit
+	}
+	if tx.Taproot {
+		e.flags |= flagTaproot
+	}
+	if tx.RBF {
+		e.flags |= flagRBF
+	}
+}
+
+// unpack to the model
+func (e *entry) tx() mtx.Tx {
+	return mtx.Tx{
+		Hash:      e.id.String(),
+		Time:      time.Unix(e.unix, 0),
+		Size:      e.size,
+		Weight:    e.weight,
+		Fee:       e.fee,
+		AmountOut: e.amountOut,
+		AmountIn:  e.amountIn,
+		Fits:      e.flags&flagFits != 0,
+		Inputs:    e.inputs,
+		Outputs:   e.outputs,
+		Coinbase:  e.flags&flagCoinbase != 0,
+		Segwit:    e.flags&flagSegwit != 0,
+		Taproot:   e.flags&flagTaproot != 0,
+		RBF:       e.flags&flagRBF != 0,
+	}
+}
+
+func (e *entry) feeRate() float64 {
+	vsize := e.vsize()
+	if vsize == 0 {
+		return 0
+	}
+	return float64(e.fee) / float64(vsize)
+}
+
+// weight / 4 rounded up, size if weight is unknown
+func (e *entry) vsize() uint32 {
+	if e.weight != 0 {
+		return (e.weight + 3) / 4
+	}
+	return e.size
+}
+
+func (e *entry) feePerByte() uint {
+	if e.size == 0 {
+		return 0
+	}
+	return uint(float64(e.fee) / float64(e.size))
+}
+
+// fee bucket index by sat/b
+func bucket(feeB uint) int {
+	// fix max fee
+	if feeB > 500 {
+		feeB = 500
+	}
+	for i, b := range Buckets {
+		if feeB <= b {
+			return i
+		}
+	}
+	return len(Buckets) - 1
+}
@@ -0,0 +1,401 @@
+package mempool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+)
+
+// pool tx paying rate sat/vB, weight is 4x size
+func testPoolTx(name string, vsize uint32, rate uint64, unix int64) txpool.TxPool {
+	return txpool.TxPool{
+		Txid:   testTxid(name),
+		Time:   unix,
+		Size:   vsize,
+		Vsize:  vsize,
+		Weight: vsize * 4,
+		Fee:    uint64(vsize) * rate,
+	}
+}
+
+func testTxid(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(h[:])
+}
+
+func txids(txs []txpool.TxPool) []string {
+	ret := make([]string, len(txs))
+	for i, t := range txs {
+		ret[i] = t.Txid
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sorted(ids ...string) []string {
+	ret := append([]string{}, ids...)
+	sort.Strings(ret)
+	return ret
+}
+
+func TestDiffApply(t *testing.T) {
+	a, b, c, d := testPoolTx("a", 200, 1, 1), testPoolTx("b", 200, 2, 2), testPoolTx("c", 200, 3, 3), testPoolTx("d", 200, 4, 4)
+	tests := []struct {
+		name        string
+		current     []txpool.TxPool
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{"fill", []txpool.TxPool{a, b, c}, sorted(a.Txid, b.Txid, c.Txid), []string{}},
+		{"same pool", []txpool.TxPool{c, b, a}, []string{}, []string{}},
+		{"replace one", []txpool.TxPool{b, c, d}, sorted(d.Txid), sorted(a.Txid)},
+		{"malformed id is skipped", []txpool.TxPool{b, c, d, {Txid: "xyz"}}, []string{}, []string{}},
+		// seen in the previous pull only, the mark is per generation
+		{"back again", []txpool.TxPool{a, b}, sorted(a.Txid), sorted(c.Txid, d.Txid)},
+		{"empty pool", nil, []string{}, sorted(a.Txid, b.Txid)},
+	}
+	p := New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := p.Diff(tt.current)
+			sort.Strings(removed)
+			if got := txids(added); !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("added: got %v, want %v", got, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Fatalf("removed: got %v, want %v", removed, tt.wantRemoved)
+			}
+			p.Apply(added, removed, nil)
+			valid := 0
+			for _, tx := range tt.current {
+				if _, ok := ParseID(tx.Txid); ok {
+					valid++
+				}
+			}
+			if p.Len() != valid {
+				t.Fatalf("len: got %d, want %d", p.Len(), valid)
+			}
+			for _, txid := range removed {
+				if p.Has(txid) || !p.Seen(txid) {
+					t.Fatalf("removed tx %s: has %v, seen %v", txid, p.Has(txid), p.Seen(txid))
+				}
+			}
+		})
+	}
+}
+
+func TestSortedSet(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+	}{
+		{"single chunk", chunkSize},
+		{"split", chunkSize + 1},
+		{"many chunks", chunkSize*5 + 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(int64(tt.n)))
+			s := newSortedSet(func(a, b int) bool { return a < b })
+			for _, v := range rnd.Perm(tt.n) {
+				s.Insert(v)
+			}
+			checkSortedSet(t, s, tt.n)
+			if s.Delete(tt.n) {
+				t.Fatal("missing value is deleted")
+			}
+
+			// drop the odd ones, chunks shrink and the empty ones are removed
+			for _, v := range rnd.Perm(tt.n) {
+				if v%2 == 1 && !s.Delete(v) {
+					t.Fatalf("value %d is not deleted", v)
+				}
+			}
+			want := make([]int, 0)
+			for v := 0; v < tt.n; v += 2 {
+				want = append(want, v)
+			}
+			if got := ascend(s); !reflect.DeepEqual(got, want) || s.Len() != len(want) {
+				t.Fatalf("after delete: len %d, got %d values, want %d", s.Len(), len(got), len(want))
+			}
+			for _, v := range want {
+				s.Delete(v)
+			}
+			if s.Len() != 0 || len(s.chunks) != 0 {
+				t.Fatalf("emptied set: len %d, chunks %d", s.Len(), len(s.chunks))
+			}
+			// reusable after emptied
+			s.Insert(1)
+			if got := ascend(s); !reflect.DeepEqual(got, []int{1}) {
+				t.Fatalf("reused set: %v", got)
+			}
+		})
+	}
+}
+
+func checkSortedSet(t *testing.T, s *sortedSet[int], n int) {
+	t.Helper()
+	if s.Len() != n {
+		t.Fatalf("len: got %d, want %d", s.Len(), n)
+	}
+	for i, c := range s.chunks {
+		if len(c) == 0 || len(c) > chunkSize {
+			t.Fatalf("chunk %d size %d", i, len(c))
+		}
+	}
+	if wantChunks := (n + chunkSize - 1) / chunkSize; len(s.chunks) < wantChunks {
+		t.Fatalf("chunks: got %d, want at least %d", len(s.chunks), wantChunks)
+	}
+	got := ascend(s)
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("value %d at %d", v, i)
+		}
+	}
+}
+
+func ascend(s *sortedSet[int]) []int {
+	ret := make([]int, 0, s.Len())
+	s.Ascend(func(v int) bool {
+		ret = append(ret, v)
+		return true
+	})
+	return ret
+}
+
+// aggregates from scratch to check the running ones
+func recount(p *Pool) Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ret := Stats{Buckets: make([]uint, len(Buckets))}
+	for _, i := range p.index {
+		e := &p.arena[i]
+		ret.Count++
+		ret.Size += uint64(e.size)
+		ret.Weight += uint64(e.weight)
+		ret.Fee += e.fee
+		ret.Amount += e.amountOut
+		ret.Buckets[e.bucket]++
+		if e.flags&flagParsed != 0 {
+			ret.Parsed++
+		}
+	}
+	return ret
+}
+
+func TestStatsRunning(t *testing.T) {
+	txs := make([]txpool.TxPool, 0)
+	for i := 0; i < 50; i++ {
+		txs = append(txs, testPoolTx(fmt.Sprintf("tx%d", i), uint32(100+i*10), uint64(1+i*7), int64(i)))
+	}
+	parsed := map[string]mtx.Tx{
+		txs[0].Txid: {Hash: txs[0].Txid, AmountOut: 5000, Segwit: true},
+		txs[1].Txid: {Hash: txs[1].Txid, AmountOut: 7000},
+	}
+	p := New()
+	p.Apply(txs, nil, parsed)
+	full := p.Stats()
+
+	steps := []struct {
+		name string
+		fn   func()
+	}{
+		{"remove parsed and unparsed", func() { p.Apply(nil, []string{txs[0].Txid, txs[10].Txid}, nil) }},
+		{"remove missing", func() { p.Apply(nil, []string{testTxid("missing")}, nil) }},
+		{"re-add unparsed", func() { p.Apply([]txpool.TxPool{txs[0], txs[10]}, nil, nil) }},
+		{"enrich", func() { p.Enrich(parsed[txs[0].Txid]) }},
+		{"add twice", func() { p.Apply([]txpool.TxPool{txs[5]}, nil, nil) }},
+	}
+	for _, s := range steps {
+		s.fn()
+		if got, want := p.Stats(), recount(p); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: running %+v, recounted %+v", s.name, got, want)
+		}
+	}
+	if got := p.Stats(); !reflect.DeepEqual(got, full) {
+		t.Fatalf("back to the full pool: got %+v, want %+v", got, full)
+	}
+	if full.Count != 50 || full.Parsed != 2 || full.Amount != 12000 {
+		t.Fatalf("full pool stats: %+v", full)
+	}
+}
+
+func TestProject(t *testing.T) {
+	p := New()
+	// 1000 vB each, rates 1..10 and a tx with unknown fee
+	txs := []txpool.TxPool{{Txid: testTxid("nofee"), Size: 1000, Weight: 4000}}
+	for rate := 1; rate <= 10; rate++ {
+		txs = append(txs, testPoolTx(fmt.Sprintf("r%d", rate), 1000, uint64(rate), int64(rate)))
+	}
+	p.Apply(txs, nil, nil)
+
+	tests := []struct {
+		maxWeight uint64
+		want      []int // rates of the included txs
+		min, med  float64
+	}{
+		{4000 * 3, []int{10, 9, 8}, 8, 9},
+		{4000*3 + 3999, []int{10, 9, 8}, 8, 9},
+		{4000 * 20, []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, 1, 5},
+		{1000, nil, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.maxWeight), func(t *testing.T) {
+			got := p.Project(tt.maxWeight)
+			want := make(map[string]struct{})
+			var fee uint64
+			for _, rate := range tt.want {
+				want[testTxid(fmt.Sprintf("r%d", rate))] = struct{}{}
+				fee += uint64(rate) * 1000
+			}
+			if !reflect.DeepEqual(got.Txs, want) || got.Fee != fee || got.Weight != uint64(len(tt.want))*4000 {
+				t.Fatalf("got %d txs, fee %d, weight %d, want %d txs, fee %d", len(got.Txs), got.Fee, got.Weight, len(want), fee)
+			}
+			if got.MinFeeRate != tt.min || got.MedianFeeRate != tt.med {
+				t.Fatalf("rates: min %v, median %v, want %v, %v", got.MinFeeRate, got.MedianFeeRate, tt.min, tt.med)
+			}
+			// fits flags follow the last projection
+			fits := 0
+			for _, tx := range p.Newest(100) {
+				_, included := want[tx.Hash]
+				if tx.Fits != included {
+					t.Fatalf("tx %s fits %v, included %v", tx.Hash, tx.Fits, included)
+				}
+				if tx.Fits {
+					fits++
+				}
+			}
+			if fits != len(want) {
+				t.Fatalf("fits: got %d, want %d", fits, len(want))
+			}
+		})
+	}
+}
+
+func TestProjectBlocks(t *testing.T) {
+	p := New()
+	txs := []txpool.TxPool{{Txid: testTxid("nofee"), Size: 1000, Weight: 4000}}
+	for rate := 1; rate <= 10; rate++ {
+		txs = append(txs, testPoolTx(fmt.Sprintf("r%d", rate), 1000, uint64(rate), int64(rate)))
+	}
+	p.Apply(txs, nil, nil)
+
+	tests := []struct {
+		name      string
+		maxWeight uint64
+		n         int
+		want      [][]float64 // fee ranges
+	}{
+		{"no blocks", 4000 * 4, 0, nil},
+		{"one takes all", 4000 * 4, 1, [][]float64{{1, 2, 4, 6, 8, 10, 10}}},
+		{"full blocks and the rest", 4000 * 4, 2, [][]float64{
+			{7, 8, 8, 9, 10, 10, 10},
+			{1, 2, 3, 4, 5, 6, 6},
+		}},
+		{"fewer txs than blocks", 4000 * 4, 5, [][]float64{
+			{7, 8, 8, 9, 10, 10, 10},
+			{3, 4, 4, 5, 6, 6, 6},
+			{1, 2, 2, 2, 2, 2, 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := p.ProjectBlocks(tt.maxWeight, tt.n)
+			if len(blocks) != len(tt.want) {
+				t.Fatalf("blocks: got %d, want %d", len(blocks), len(tt.want))
+			}
+			txs := 0
+			for i, b := range blocks {
+				if !reflect.DeepEqual(b.FeeRange, tt.want[i]) {
+					t.Fatalf("block %d fee range: got %v, want %v", i, b.FeeRange, tt.want[i])
+				}
+				if b.Weight != uint64(b.Txs)*4000 || b.Size != uint64(b.Txs)*1000 {
+					t.Fatalf("block %d: %d txs, weight %d, size %d", i, b.Txs, b.Weight, b.Size)
+				}
+				// all but the last one are full
+				if i < len(blocks)-1 && b.Weight > tt.maxWeight {
+					t.Fatalf("block %d is overfilled: %d", i, b.Weight)
+				}
+				txs += b.Txs
+			}
+			if len(blocks) > 0 && txs != 10 {
+				t.Fatalf("txs in blocks: got %d, want 10", txs)
+			}
+		})
+	}
+}
+
+func TestPositionPackages(t *testing.T) {
+	p := New()
+	// parent <- child <- grandchild, and an unrelated tx
+	parent := testPoolTx("parent", 1000, 1, 1)
+	child := testPoolTx("child", 1000, 20, 2)
+	child.Depends = []string{parent.Txid}
+	grandchild := testPoolTx("grandchild", 500, 30, 3)
+	grandchild.Depends = []string{child.Txid}
+	other := testPoolTx("other", 1000, 10, 4)
+	p.Apply([]txpool.TxPool{parent, child, grandchild, other}, nil, nil)
+
+	posTests := []struct {
+		tx        txpool.TxPool
+		maxWeight uint64
+		want      Position
+	}{
+		{grandchild, 4000, Position{Rank: 1, WeightAhead: 0, Block: 1}},
+		{child, 4000, Position{Rank: 2, WeightAhead: 2000, Block: 1}},
+		{other, 4000, Position{Rank: 3, WeightAhead: 6000, Block: 2}},
+		{parent, 4000, Position{Rank: 4, WeightAhead: 10000, Block: 3}},
+	}
+	for _, tt := range posTests {
+		got, ok := p.Position(tt.tx.Txid, tt.maxWeight)
+		if !ok || got != tt.want {
+			t.Fatalf("position of %s: got %+v, want %+v", tt.tx.Txid, got, tt.want)
+		}
+	}
+	if _, ok := p.Position(testTxid("missing"), 4000); ok {
+		t.Fatal("position of a missing tx")
+	}
+
+	pkgTests := []struct {
+		name                   string
+		tx                     txpool.TxPool
+		ancestors, descendants []string
+		ancestorsFee           uint64
+	}{
+		{"parent", parent, nil, []string{child.Txid, grandchild.Txid}, 0},
+		{"child", child, []string{parent.Txid}, []string{grandchild.Txid}, parent.Fee},
+		{"grandchild", grandchild, []string{child.Txid, parent.Txid}, nil, child.Fee + parent.Fee},
+		{"unrelated", other, nil, nil, 0},
+	}
+	for _, tt := range pkgTests {
+		t.Run(tt.name, func(t *testing.T) {
+			anc, desc := p.Packages(tt.tx.Txid)
+			if !reflect.DeepEqual(anc.Txs, tt.ancestors) || !reflect.DeepEqual(desc.Txs, tt.descendants) {
+				t.Fatalf("ancestors %v, descendants %v", anc.Txs, desc.Txs)
+			}
+			if anc.Fee != tt.ancestorsFee {
+				t.Fatalf("ancestors fee: got %d, want %d", anc.Fee, tt.ancestorsFee)
+			}
+		})
+	}
+
+	// child pays for the parent: (1000 + 20000) / 2000 vB
+	tx, _ := p.Get(child.Txid)
+	anc, _ := p.Packages(child.Txid)
+	if got := p.PackageFeeRate(tx, anc); got != 10.5 {
+		t.Fatalf("package fee rate: got %v, want 10.5", got)
+	}
+
+	// confirmed parent leaves the package
+	p.Apply(nil, []string{parent.Txid}, nil)
+	if anc, _ := p.Packages(child.Txid); len(anc.Txs) != 0 {
+		t.Fatalf("ancestors after the parent is mined: %v", anc.Txs)
+	}
+}
@@ -0,0 +1,109 @@
+package mempool
+
+import (
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+)
+
+// place of the tx in the pool ordered by fee rate
+type Position struct {
+	Rank        int    // 1 - the highest fee rate
+	WeightAhead uint64 // weight of the higher fee rate txs
+	Block       int    // projected block, 1 - the next one
+}
+
+// related in-pool txs
+type Package struct {
+	Txs    []string
+	Fee    uint64
+	Weight uint64
+}
+
+// position by fee rate, blocks are filled up to maxWeight
+func (p *Pool) Position(txid string, maxWeight uint64) (Position, bool) {
+	id, ok := ParseID(txid)
+	if !ok {
+		return Position{}, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if _, ok := p.index[id]; !ok {
+		return Position{}, false
+	}
+	var ret Position
+	p.byRate.Ascend(func(i uint32) bool {
+		e := &p.arena[i]
+		ret.Rank++
+		if e.id == id {
+			return false
+		}
+		// unknown fee txs are not projected
+		if e.fee != 0 {
+			ret.WeightAhead += uint64(e.vsize()) * 4
+		}
+		return true
+	})
+	ret.Block = int(ret.WeightAhead/maxWeight) + 1
+	return ret, true
+}
+
+// unconfirmed ancestors and descendants of the tx.
+// Known only if the node reports pool txs dependencies
+func (p *Pool) Packages(txid string) (ancestors Package, descendants Package) {
+	id, ok := ParseID(txid)
+	if !ok {
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	add := func(pkg *Package, id ID) {
+		i := p.index[id]
+		pkg.Txs = append(pkg.Txs, id.String())
+		pkg.Fee += p.arena[i].fee
+		pkg.Weight += uint64(p.arena[i].vsize()) * 4
+	}
+
+	seen := map[ID]bool{id: true}
+	queue := []ID{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, pid := range p.parents[cur] {
+			if _, ok := p.index[pid]; ok && !seen[pid] {
+				seen[pid] = true
+				add(&ancestors, pid)
+				queue = append(queue, pid)
+			}
+		}
+	}
+
+	// children are not indexed, only txs with parents are scanned
+	children := make(map[ID][]ID)
+	for cid, parents := range p.parents {
+		for _, pid := range parents {
+			children[pid] = append(children[pid], cid)
+		}
+	}
+	seen = map[ID]bool{id: true}
+	queue = []ID{id}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, cid := range children[cur] {
+			if _, ok := p.index[cid]; ok && !seen[cid] {
+				seen[cid] = true
+				add(&descendants, cid)
+				queue = append(queue, cid)
+			}
+		}
+	}
+	return ancestors, descendants
+}
+
+// fee rate of the tx with its ancestors, sat/vB. Miners pick packages by it
+func (p *Pool) PackageFeeRate(tx mtx.Tx, ancestors Package) float64 {
+	weight := uint64(tx.VSize())*4 + ancestors.Weight
+	if weight == 0 {
+		return 0
+	}
+	return float64(tx.Fee+ancestors.Fee) * 4 / float64(weight)
+}
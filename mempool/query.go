@@ -0,0 +1,158 @@
+package mempool
+
+import (
+	"errors"
+	"sort"
+
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+)
+
+var ErrSort = errors.New("unknown sort")
+
+// pool txs sort keys
+const (
+	SortTime    = "time"
+	SortFee     = "fee"
+	SortFeeRate = "feerate"
+	SortValue   = "value"
+	SortSize    = "size" // vsize
+)
+
+// tx types, known for parsed txs only
+const (
+	TypeLegacy  = "legacy"
+	TypeSegwit  = "segwit"
+	TypeTaproot = "taproot"
+)
+
+// position in the sorted pool, the last returned tx
+type Cursor struct {
+	Key  float64
+	Txid string
+}
+
+// pool txs filter and page.
+// Zero bounds are not applied, nil flags match any tx
+type Query struct {
+	Sort       string
+	Asc        bool
+	MinFeeRate float64 // sat/vB
+	MaxFeeRate float64
+	MinValue   uint64 // sat, parsed txs only
+	MaxValue   uint64
+	Fits       *bool // projected to fit in the next block
+	Type       string
+	RBF        *bool // parsed txs only
+	After      *Cursor
+	Limit      int
+}
+
+type Page struct {
+	Txs   []mtx.Tx
+	Total int     // txs matching the filters
+	Next  *Cursor // nil on the last page
+}
+
+type match struct {
+	key float64
+	id  ID
+	i   uint32
+}
+
+// filter and sort the pool, returns the page after the cursor
+func (p *Pool) Query(q Query) (Page, error) {
+	key, ok := sortKeys[q.Sort]
+	if !ok {
+		return Page{}, ErrSort
+	}
+	var after *match
+	if q.After != nil {
+		id, ok := ParseID(q.After.Txid)
+		if !ok {
+			return Page{}, errors.New("invalid cursor")
+		}
+		after = &match{key: q.After.Key, id: id}
+	}
+	less := func(a, b *match) bool {
+		if a.key != b.key {
+			return (a.key < b.key) == q.Asc
+		}
+		return a.id.Less(b.id)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	matches := make([]match, 0)
+	for id, i := range p.index {
+		e := &p.arena[i]
+		if !q.match(e) {
+			continue
+		}
+		matches = append(matches, match{key: key(e), id: id, i: i})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return less(&matches[i], &matches[j])
+	})
+	ret := Page{Total: len(matches), Txs: make([]mtx.Tx, 0)}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matches), func(i int) bool {
+			return less(after, &matches[i])
+		})
+	}
+	end := min(start+q.Limit, len(matches))
+	for _, m := range matches[start:end] {
+		ret.Txs = append(ret.Txs, p.arena[m.i].tx())
+	}
+	if end < len(matches) && end > start {
+		last := matches[end-1]
+		ret.Next = &Cursor{Key: last.key, Txid: last.id.String()}
+	}
+	return ret, nil
+}
+
+var sortKeys = map[string]func(e *entry) float64{
+	SortTime:    func(e *entry) float64 { return float64(e.unix) },
+	SortFee:     func(e *entry) float64 { return float64(e.fee) },
+	SortFeeRate: func(e *entry) float64 { return e.feeRate() },
+	SortValue:   func(e *entry) float64 { return float64(e.amountOut) },
+	SortSize:    func(e *entry) float64 { return float64(e.vsize()) },
+}
+
+func (q *Query) match(e *entry) bool {
+	rate := e.feeRate()
+	if q.MinFeeRate > 0 && rate < q.MinFeeRate {
+		return false
+	}
+	if q.MaxFeeRate > 0 && rate > q.MaxFeeRate {
+		return false
+	}
+	parsed := e.flags&flagParsed != 0
+	if (q.MinValue > 0 || q.MaxValue > 0) && !parsed {
+		return false
+	}
+	if q.MinValue > 0 && e.amountOut < q.MinValue {
+		return false
+	}
+	if q.MaxValue > 0 && e.amountOut > q.MaxValue {
+		return false
+	}
+	if q.Fits != nil && (e.flags&flagFits != 0) != *q.Fits {
+		return false
+	}
+	if q.RBF != nil && (!parsed || (e.flags&flagRBF != 0) != *q.RBF) {
+		return false
+	}
+	switch q.Type {
+	case "":
+	case TypeLegacy:
+		return parsed && e.flags&flagSegwit == 0
+	case TypeSegwit:
+		return parsed && e.flags&flagSegwit != 0 && e.flags&flagTaproot == 0
+	case TypeTaproot:
+		return parsed && e.flags&flagTaproot != 0
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package mempool
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+)
+
+// pool of txs with repeating keys, so pages break inside the runs of equal keys
+func queryPool(t *testing.T) (*Pool, []txpool.TxPool, map[string]mtx.Tx) {
+	t.Helper()
+	txs := make([]txpool.TxPool, 0)
+	parsed := make(map[string]mtx.Tx)
+	for i := 0; i < 60; i++ {
+		tx := testPoolTx(fmt.Sprintf("q%d", i), uint32(100+(i%4)*50), uint64(1+i%7), int64(1000+i%5))
+		txs = append(txs, tx)
+		if i%3 != 0 {
+			parsed[tx.Txid] = mtx.Tx{
+				Hash:      tx.Txid,
+				AmountOut: uint64(10_000 * (i % 6)),
+				Segwit:    i%2 == 0,
+				Taproot:   i%4 == 0,
+				RBF:       i%5 == 0,
+			}
+		}
+	}
+	p := New()
+	p.Apply(txs, nil, parsed)
+	return p, txs, parsed
+}
+
+func TestQueryPagination(t *testing.T) {
+	p, _, _ := queryPool(t)
+	yes, no := true, false
+	tests := []struct {
+		name string
+		q    Query
+	}{
+		{"time desc", Query{Sort: SortTime}},
+		{"time asc", Query{Sort: SortTime, Asc: true}},
+		{"fee", Query{Sort: SortFee}},
+		{"feerate asc", Query{Sort: SortFeeRate, Asc: true}},
+		{"value", Query{Sort: SortValue}},
+		{"size", Query{Sort: SortSize}},
+		{"fee rate range", Query{Sort: SortFeeRate, MinFeeRate: 2, MaxFeeRate: 5}},
+		{"value range, parsed only", Query{Sort: SortValue, Asc: true, MinValue: 10_000, MaxValue: 40_000}},
+		{"rbf", Query{Sort: SortTime, RBF: &yes}},
+		{"not rbf", Query{Sort: SortTime, RBF: &no}},
+		{"segwit", Query{Sort: SortFee, Type: TypeSegwit}},
+		{"taproot", Query{Sort: SortFee, Type: TypeTaproot}},
+		{"legacy", Query{Sort: SortFee, Type: TypeLegacy}},
+		{"unknown type", Query{Sort: SortFee, Type: "p2pk"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// everything in one page is the reference
+			all := tt.q
+			all.Limit = 1000
+			full, err := p.Query(all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if full.Next != nil || full.Total != len(full.Txs) {
+				t.Fatalf("single page: total %d, txs %d, next %v", full.Total, len(full.Txs), full.Next)
+			}
+			checkOrder(t, full.Txs, tt.q)
+			for _, tx := range full.Txs {
+				e := p.arena[p.index[mustID(t, tx.Hash)]]
+				if !tt.q.match(&e) {
+					t.Fatalf("tx %s does not match the filters", tx.Hash)
+				}
+			}
+
+			for _, limit := range []int{1, 7, 13} {
+				q := tt.q
+				q.Limit = limit
+				got := make([]string, 0)
+				for pages := 0; ; pages++ {
+					if pages > len(full.Txs)+1 {
+						t.Fatal("pagination does not end")
+					}
+					page, err := p.Query(q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if page.Total != full.Total {
+						t.Fatalf("total: got %d, want %d", page.Total, full.Total)
+					}
+					for _, tx := range page.Txs {
+						got = append(got, tx.Hash)
+					}
+					if page.Next == nil {
+						break
+					}
+					if len(page.Txs) != limit {
+						t.Fatalf("page with the next cursor has %d txs, want %d", len(page.Txs), limit)
+					}
+					q.After = page.Next
+				}
+				if len(got) != len(full.Txs) {
+					t.Fatalf("limit %d: got %d txs, want %d", limit, len(got), len(full.Txs))
+				}
+				for i := range got {
+					if got[i] != full.Txs[i].Hash {
+						t.Fatalf("limit %d: tx %d is %s, want %s", limit, i, got[i], full.Txs[i].Hash)
+					}
+				}
+			}
+		})
+	}
+}
+
+// keys are in the query order, txids break the ties
+func checkOrder(t *testing.T, txs []mtx.Tx, q Query) {
+	t.Helper()
+	key := sortKeys[q.Sort]
+	ok := sort.SliceIsSorted(txs, func(i, j int) bool {
+		a, b := entryOf(txs[i]), entryOf(txs[j])
+		ka, kb := key(&a), key(&b)
+		if ka != kb {
+			return (ka < kb) == q.Asc
+		}
+		return txs[i].Hash < txs[j].Hash
+	})
+	if !ok {
+		t.Fatal("page is out of order")
+	}
+}
+
+func entryOf(tx mtx.Tx) entry {
+	return entry{
+		unix:      tx.Time.Unix(),
+		fee:       tx.Fee,
+		size:      tx.Size,
+		weight:    tx.Weight,
+		amountOut: tx.AmountOut,
+	}
+}
+
+func mustID(t *testing.T, txid string) ID {
+	t.Helper()
+	id, ok := ParseID(txid)
+	if !ok {
+		t.Fatalf("invalid txid %s", txid)
+	}
+	return id
+}
+
+func TestQueryErrors(t *testing.T) {
+	p, txs, _ := queryPool(t)
+	if _, err := p.Query(Query{Sort: "weight", Limit: 10}); err != ErrSort {
+		t.Fatalf("unknown sort: got %v, want %v", err, ErrSort)
+	}
+	if _, err := p.Query(Query{Sort: SortTime, Limit: 10, After: &Cursor{Key: 1, Txid: "zz"}}); err == nil {
+		t.Fatal("cursor with invalid txid is accepted")
+	}
+	// cursor of a tx left the pool still points to its place
+	cur := &Cursor{Key: float64(txs[0].Time), Txid: txs[0].Txid}
+	p.Apply(nil, []string{txs[0].Txid}, nil)
+	page, err := p.Query(Query{Sort: SortTime, Limit: 1000, After: cur})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range page.Txs {
+		if float64(tx.Time.Unix()) > cur.Key {
+			t.Fatalf("tx %s before the cursor", tx.Hash)
+		}
+	}
+}
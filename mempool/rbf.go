@@ -0,0 +1,94 @@
+package mempool
+
+// spent output
+type Outpoint struct {
+	Txid string
+	Vout uint32
+}
+
+type outpoint struct {
+	id ID
+	n  uint32
+}
+
+// remember the inputs of the pool tx. A tx spending the same outputs
+// as another pool tx replaces it, returns the replaced txs
+func (p *Pool) Spend(txid string, inputs []Outpoint) []string {
+	id, ok := ParseID(txid)
+	if !ok {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.index[id]; !ok {
+		return nil
+	}
+	var ret []string
+	ops := make([]outpoint, 0, len(inputs))
+	for _, in := range inputs {
+		pid, ok := ParseID(in.Txid)
+		if !ok {
+			continue
+		}
+		op := outpoint{id: pid, n: in.Vout}
+		ops = append(ops, op)
+		if prev, ok := p.spends[op]; ok && prev != id {
+			if _, seen := p.replaced[prev]; !seen {
+				p.replaced[prev] = id
+				p.replaces[id] = append(p.replaces[id], prev)
+				ret = append(ret, prev.String())
+			}
+		}
+		p.spends[op] = id
+	}
+	p.inputs[id] = ops
+	return ret
+}
+
+// replacement of the tx and the txs it replaced, known for the pool txs seen within the last hour
+func (p *Pool) Replacement(txid string) (by string, replaces []string) {
+	id, ok := ParseID(txid)
+	if !ok {
+		return "", nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if r, ok := p.replaced[id]; ok {
+		by = r.String()
+	}
+	for _, old := range p.replaces[id] {
+		replaces = append(replaces, old.String())
+	}
+	return by, replaces
+}
+
+// forget the inputs of the tx removed long ago
+// must be called with mu locked
+func (p *Pool) forget(id ID) {
+	for _, op := range p.inputs[id] {
+		if p.spends[op] == id {
+			delete(p.spends, op)
+		}
+	}
+	delete(p.inputs, id)
+	if r, ok := p.replaced[id]; ok {
+		p.replaces[r] = removeID(p.replaces[r], id)
+		if len(p.replaces[r]) == 0 {
+			delete(p.replaces, r)
+		}
+		delete(p.replaced, id)
+	}
+	for _, old := range p.replaces[id] {
+		delete(p.replaced, old)
+	}
+	delete(p.replaces, id)
+}
+
+func removeID(ids []ID, id ID) []ID {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,32 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+func TestForgetReplacement(t *testing.T) {
+	p := New()
+	orig, repl, parent := testTxid("orig"), testTxid("repl"), testTxid("parent")
+	p.Apply([]txpool.TxPool{{Txid: orig, Size: 200, Fee: 200}, {Txid: repl, Size: 200, Fee: 400}}, nil, nil)
+	p.Spend(orig, []Outpoint{{Txid: parent, Vout: 0}})
+	if got := p.Spend(repl, []Outpoint{{Txid: parent, Vout: 0}}); len(got) != 1 || got[0] != orig {
+		t.Fatalf("replaced: got %v, want %s", got, orig)
+	}
+	if by, replaces := p.Replacement(repl); by != "" || len(replaces) != 1 || replaces[0] != orig {
+		t.Fatalf("replacement of %s: by %q, replaces %v", repl, by, replaces)
+	}
+
+	// the replacement is forgotten before the replaced tx
+	id, _ := ParseID(repl)
+	p.mu.Lock()
+	p.forget(id)
+	p.mu.Unlock()
+	if len(p.replaced) != 0 || len(p.replaces) != 0 {
+		t.Fatalf("replacements are kept: %d replaced, %d replaces", len(p.replaced), len(p.replaces))
+	}
+	if by, _ := p.Replacement(orig); by != "" {
+		t.Fatalf("forgotten replacement %s is still reported", by)
+	}
+}
@@ -0,0 +1,109 @@
+package mempool
+
+import "sort"
+
+// max items in a chunk before it's split in two
+const chunkSize = 512
+
+// ordered set stored as a list of sorted chunks.
+// O(log n + chunkSize) insert and delete, ordered iteration,
+// no per item allocations.
+// less must define a strict order, values considered equal are the same item
+type sortedSet[T any] struct {
+	less   func(a, b T) bool
+	chunks [][]T
+	len    int
+}
+
+func newSortedSet[T any](less func(a, b T) bool) *sortedSet[T] {
+	return &sortedSet[T]{
+		less:   less,
+		chunks: make([][]T, 0),
+	}
+}
+
+// first chunk which last item is not less than v, last chunk if v is the greatest
+func (s *sortedSet[T]) chunk(v T) int {
+	i := sort.Search(len(s.chunks), func(i int) bool {
+		c := s.chunks[i]
+		return !s.less(c[len(c)-1], v)
+	})
+	if i == len(s.chunks) && i > 0 {
+		i--
+	}
+	return i
+}
+
+func (s *sortedSet[T]) Insert(v T) {
+	if len(s.chunks) == 0 {
+		c := make([]T, 0, chunkSize)
+		s.chunks = append(s.chunks, append(c, v))
+		s.len++
+		return
+	}
+	ci := s.chunk(v)
+	c := s.chunks[ci]
+	i := sort.Search(len(c), func(i int) bool {
+		return !s.less(c[i], v)
+	})
+	var zero T
+	c = append(c, zero)
+	copy(c[i+1:], c[i:])
+	c[i] = v
+	s.chunks[ci] = c
+	s.len++
+	if len(c) <= chunkSize {
+		return
+	}
+	// split in halves, both with room to grow
+	half := len(c) / 2
+	right := make([]T, len(c)-half, chunkSize)
+	copy(right, c[half:])
+	clear(c[half:])
+	s.chunks[ci] = c[:half]
+	s.chunks = append(s.chunks, nil)
+	copy(s.chunks[ci+2:], s.chunks[ci+1:])
+	s.chunks[ci+1] = right
+}
+
+func (s *sortedSet[T]) Delete(v T) bool {
+	if len(s.chunks) == 0 {
+		return false
+	}
+	ci := s.chunk(v)
+	c := s.chunks[ci]
+	i := sort.Search(len(c), func(i int) bool {
+		return !s.less(c[i], v)
+	})
+	if i == len(c) || s.less(v, c[i]) {
+		return false
+	}
+	copy(c[i:], c[i+1:])
+	var zero T
+	c[len(c)-1] = zero
+	c = c[:len(c)-1]
+	s.len--
+	if len(c) > 0 {
+		s.chunks[ci] = c
+		return true
+	}
+	copy(s.chunks[ci:], s.chunks[ci+1:])
+	s.chunks[len(s.chunks)-1] = nil
+	s.chunks = s.chunks[:len(s.chunks)-1]
+	return true
+}
+
+func (s *sortedSet[T]) Len() int {
+	return s.len
+}
+
+// iterate in order until fn returns false
+func (s *sortedSet[T]) Ascend(fn func(v T) bool) {
+	for _, c := range s.chunks {
+		for _, v := range c {
+			if !fn(v) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mining
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const Unknown = "Unknown"
+
+// known pools definitions, same format as the widely used pools.json
+// https://github.com/btccom/Blockchain-Known-Pools
+//
+//go:embed pools.json
+var embeddedPools []byte
+
+type Pool struct {
+	Name string `json:"name"`
+	Link string `json:"link"`
+}
+
+type definitions struct {
+	CoinbaseTags    map[string]Pool `json:"coinbase_tags"`
+	PayoutAddresses map[string]Pool `json:"payout_addresses"`
+}
+
+type tag struct {
+	tag  string
+	pool Pool
+}
+
+// attribute blocks to mining pools by coinbase tags and payout addresses
+type Matcher struct {
+	mu        sync.RWMutex
+	addresses map[string]Pool
+	tags      []tag // longest first, then by pool name, the first match wins
+}
+
+// create matcher with pools from the file
+// embedded definitions are used if path is empty
+func New(path string) (*Matcher, error) {
+	m := &Matcher{}
+	if err := m.Load(path); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// (re)load pools definitions, safe to call while matching
+func (m *Matcher) Load(path string) error {
+	data := embeddedPools
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+	}
+	var defs definitions
+	if err := json.Unmarshal(data, &defs); err != nil {
+		return err
+	}
+	tags := make([]tag, 0, len(defs.CoinbaseTags))
+	for t, p := range defs.CoinbaseTags {
+		tags = append(tags, tag{tag: t, pool: p})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if len(tags[i].tag) != len(tags[j].tag) {
+			return len(tags[i].tag) > len(tags[j].tag)
+		}
+		if tags[i].pool.Name != tags[j].pool.Name {
+			return tags[i].pool.Name < tags[j].pool.Name
+		}
+		return tags[i].tag < tags[j].tag
+	})
+	m.mu.Lock()
+	m.addresses = defs.PayoutAddresses
+	m.tags = tags
+	m.mu.Unlock()
+	return nil
+}
+
+// find pool by coinbase scriptSig hex and coinbase output addresses.
+// Payout address match has priority, in the outputs order. Tags are matched as substrings,
+// longest wins, pool name breaks the ties
+func (m *Matcher) Match(coinbaseHex string, addresses []string) Pool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, addr := range addresses {
+		if p, ok := m.addresses[addr]; ok {
+			return p
+		}
+	}
+	script, err := hex.DecodeString(coinbaseHex)
+	if err != nil {
+		return Pool{Name: Unknown}
+	}
+	for _, t := range m.tags {
+		if strings.Contains(string(script), t.tag) {
+			return t.pool
+		}
+	}
+	return Pool{Name: Unknown}
+}
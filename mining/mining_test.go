@@ -0,0 +1,111 @@
+package mining
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// coinbase scriptSig: bip34 height push, extranonce and the pool tag
+func scriptSig(height int, tag string) string {
+	push := []byte{0x03, byte(height), byte(height >> 8), byte(height >> 16)}
+	extranonce := []byte{0x08, 0xfa, 0xbe, 0x6d, 0x6d, 0x01, 0x02, 0x03, 0x04}
+	return hex.EncodeToString(append(append(push, extranonce...), tag...))
+}
+
+func TestMatchEmbedded(t *testing.T) {
+	m, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		coinbase string
+		want     string
+	}{
+		{"antpool", scriptSig(840001, "/AntPool/ Mined by pool1g"), "AntPool"},
+		{"foundry", scriptSig(840002, "Foundry USA Pool #dropgold/"), "Foundry USA"},
+		{"viabtc", scriptSig(840000, "/ViaBTC/Mined by 260786/"), "ViaBTC"},
+		{"f2pool chinese tag", scriptSig(840005, "七彩神仙鱼"), "F2Pool"},
+		{"no tag", scriptSig(840006, "/solo miner/"), Unknown},
+		{"invalid hex", "zz", Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Match(tt.coinbase, nil); got.Name != tt.want {
+				t.Fatalf("got %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+const testPools = `{
+	"coinbase_tags": {
+		"/Pool/": {"name": "Generic", "link": ""},
+		"/Pool/B/": {"name": "Pool B", "link": "https://b.example"},
+		"/Pool/A/": {"name": "Pool A", "link": "https://a.example"},
+		"Zeta": {"name": "Zeta", "link": ""},
+		"Beta": {"name": "Beta", "link": ""}
+	},
+	"payout_addresses": {
+		"bc1qxhmdufsvnuaaaer4ynz88fspdsxq2h9e9cetdj": {"name": "Foundry USA", "link": "https://foundrydigital.com"},
+		"1KFHE7w8BhaENAswwryaoccDb6qcT6DbYY": {"name": "F2Pool", "link": "https://www.f2pool.com"}
+	}
+}`
+
+func TestMatchOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pools.json")
+	if err := os.WriteFile(path, []byte(testPools), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		coinbase  string
+		addresses []string
+		want      string
+	}{
+		{"longest tag wins", scriptSig(1, "/Pool/A/"), nil, "Pool A"},
+		{"short tag only", scriptSig(1, "/Pool/C/"), nil, "Generic"},
+		// same length tags, the pool name decides
+		{"tie by name", scriptSig(1, "ZetaBeta"), nil, "Beta"},
+		{"tie by name reversed", scriptSig(1, "BetaZeta"), nil, "Beta"},
+		{"payout address over tag", scriptSig(1, "/Pool/A/"), []string{"bc1qxhmdufsvnuaaaer4ynz88fspdsxq2h9e9cetdj"}, "Foundry USA"},
+		{"first output address wins", "", []string{"1KFHE7w8BhaENAswwryaoccDb6qcT6DbYY", "bc1qxhmdufsvnuaaaer4ynz88fspdsxq2h9e9cetdj"}, "F2Pool"},
+		{"unknown address", scriptSig(1, "/Pool/B/"), []string{"bc1qunknown"}, "Pool B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// map iteration order must not matter
+			for i := 0; i < 20; i++ {
+				if got := m.Match(tt.coinbase, tt.addresses); got.Name != tt.want {
+					t.Fatalf("got %s, want %s", got.Name, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadKeepsDefinitionsOnError(t *testing.T) {
+	m, err := New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pools.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(path); err == nil {
+		t.Fatal("broken file is loaded")
+	}
+	if err := m.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("missing file is loaded")
+	}
+	if got := m.Match(scriptSig(1, "/AntPool/"), nil); got.Name != "AntPool" {
+		t.Fatalf("definitions are lost: got %s", got.Name)
+	}
+}
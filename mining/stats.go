@@ -0,0 +1,55 @@
+package mining
+
+import (
+	"sort"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+type Stats struct {
+	Name        string  `json:"name"`
+	Link        string  `json:"link"`
+	Blocks      int     `json:"blocks"`
+	Share       float64 `json:"share"` // share of blocks, 0..1
+	Fees        uint64  `json:"fees"`
+	EmptyBlocks int     `json:"empty_blocks"` // coinbase only
+}
+
+// aggregate blocks mined since the given time by pool, most blocks first
+func Aggregate(blocks []mblock.Block, since time.Time) []Stats {
+	byPool := make(map[string]*Stats)
+	total := 0
+	for _, b := range blocks {
+		if b.Time < since.Unix() {
+			continue
+		}
+		name := b.Pool
+		if name == "" {
+			name = Unknown
+		}
+		s, ok := byPool[name]
+		if !ok {
+			s = &Stats{Name: name, Link: b.PoolLink}
+			byPool[name] = s
+		}
+		s.Blocks++
+		s.Fees += b.Fee
+		if b.Txs <= 1 {
+			s.EmptyBlocks++
+		}
+		total++
+	}
+	ret := make([]Stats, 0, len(byPool))
+	for _, s := range byPool {
+		s.Share = float64(s.Blocks) / float64(total)
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Blocks != ret[j].Blocks {
+			return ret[i].Blocks > ret[j].Blocks
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
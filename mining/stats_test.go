@@ -0,0 +1,35 @@
+package mining
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+func TestAggregate(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration) int64 { return now.Add(-ago).Unix() }
+	blocks := []mblock.Block{
+		{Height: 6, Time: at(time.Hour), Pool: "AntPool", PoolLink: "https://www.antpool.com", Txs: 3000, Fee: 300},
+		{Height: 5, Time: at(2 * time.Hour), Pool: "Foundry USA", Txs: 2500, Fee: 200},
+		{Height: 4, Time: at(3 * time.Hour), Pool: "AntPool", PoolLink: "https://www.antpool.com", Txs: 1, Fee: 0},
+		{Height: 3, Time: at(4 * time.Hour), Txs: 100, Fee: 10},
+		{Height: 2, Time: at(5 * time.Hour), Pool: "Foundry USA", Txs: 2000, Fee: 100},
+		// out of the period
+		{Height: 1, Time: at(48 * time.Hour), Pool: "ViaBTC", Txs: 2000, Fee: 100},
+	}
+	got := Aggregate(blocks, now.Add(-24*time.Hour))
+	want := []Stats{
+		{Name: "AntPool", Link: "https://www.antpool.com", Blocks: 2, Share: 0.4, Fees: 300, EmptyBlocks: 1},
+		{Name: "Foundry USA", Blocks: 2, Share: 0.4, Fees: 300},
+		{Name: Unknown, Blocks: 1, Share: 0.2, Fees: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got := Aggregate(blocks, now); len(got) != 0 {
+		t.Fatalf("no blocks in the period: got %+v", got)
+	}
+}
@@ -1,16 +1,24 @@
 package notificator
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/mining"
 	"github.com/gofiber/websocket/v2"
 )
 
 var log = logger.Log.WithField("scope", "notificator")
 
+// how long to wait for the close frame to be written
+const closeTimeout = 1 * time.Second
+
+// pool update, sent on every pool change
 type Msg struct {
+	Type            string   `json:"type"` // always "pool"
 	Height          int      `json:"height"`
 	PoolSize        int      `json:"size"`
 	PoolSizeHistory [20]uint `json:"size_history"`
@@ -21,6 +29,19 @@ type Msg struct {
 	FeeBuckets      [24]uint `json:"fee_buckets"`
 }
 
+// new block event, sent once the block is parsed
+type BlockMsg struct {
+	Type      string       `json:"type"` // always "block"
+	Height    int          `json:"height"`
+	Hash      string       `json:"hash"`
+	Time      int64        `json:"time"`
+	Txs       uint64       `json:"txs"`
+	Fee       uint64       `json:"fee"`
+	Weight    uint64       `json:"weight"`
+	Pool      string       `json:"pool"`
+	PoolStats mining.Stats `json:"pool_stats"` // attributed pool stats for the last 24h
+}
+
 type client struct {
 	isClosing bool
 	mu        sync.Mutex
@@ -31,15 +52,22 @@ type Notificator struct {
 	UnregisterCh       chan *websocket.Conn
 	clients            map[*websocket.Conn]*client
 	broadcastCh        chan Msg
+	blocksCh           chan BlockMsg
 	lastBroadcastedMsg Msg
+	done               chan struct{} // closed on Stop
+	stopped            chan struct{} // closed when the hub is stopped
+	stopOnce           sync.Once
 }
 
-func New(notificationsCh chan Msg) *Notificator {
+func New(notificationsCh chan Msg, blocksCh chan BlockMsg) *Notificator {
 	return &Notificator{
 		RegisterCh:   make(chan *websocket.Conn),
 		UnregisterCh: make(chan *websocket.Conn),
 		clients:      make(map[*websocket.Conn]*client),
 		broadcastCh:  notificationsCh,
+		blocksCh:     blocksCh,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
 	}
 }
 
@@ -48,51 +76,68 @@ func (n *Notificator) Start() {
 	// go n.workerWsDemo()
 }
 
+// stop the hub and close all connections with the close frame.
+// Waits for the hub to stop until ctx is done
+func (n *Notificator) Stop(ctx context.Context) error {
+	n.stopOnce.Do(func() {
+		close(n.done)
+	})
+	select {
+	case <-n.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (n *Notificator) Send(msg Msg) {
 	n.broadcastCh <- msg
 }
 
+// add the client, returns false if the hub is stopped
+func (n *Notificator) Register(connection *websocket.Conn) bool {
+	select {
+	case n.RegisterCh <- connection:
+		return true
+	case <-n.done:
+		return false
+	}
+}
+
+func (n *Notificator) Unregister(connection *websocket.Conn) {
+	select {
+	case n.UnregisterCh <- connection:
+	case <-n.done:
+	}
+}
+
 func (n *Notificator) workerWsHub() {
+	defer close(n.stopped)
 	for {
 		select {
+		case <-n.done:
+			n.closeAll()
+			log.Debugf("hub stopped")
+			return
+
 		case connection := <-n.RegisterCh:
 			n.clients[connection] = &client{}
 			log.Debugf("connection registered")
 
 		case msg := <-n.broadcastCh:
+			msg.Type = "pool"
 			// avoid sending the same message
 			if msg == n.lastBroadcastedMsg {
 				continue
 			}
 			n.lastBroadcastedMsg = msg
 			log.Debugf("message received: %+v", msg)
-			// Send the message to all clients
-			for connection, c := range n.clients {
-				go func(connection *websocket.Conn, c *client) {
-					c.mu.Lock()
-					defer c.mu.Unlock()
-					if c.isClosing {
-						return
-					}
-					// serialize message
-					msgBytes, err := json.Marshal(msg)
-					if err != nil {
-						log.Errorf("error on marshal msg: %v", err)
-						return
-					}
-					if err := connection.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
-						c.isClosing = true
-						log.Debugf("write error: %v", err)
-
-						err = connection.WriteMessage(websocket.CloseMessage, []byte{})
-						if err != nil {
-							log.Errorf("close error: %v", err)
-						}
-						connection.Close()
-						n.UnregisterCh <- connection
-					}
-				}(connection, c)
-			}
+			n.broadcast(msg)
+
+		case msg := <-n.blocksCh:
+			log.Debugf("block message received: %+v", msg)
+			msg.Type = "block"
+			n.broadcast(msg)
 
 		case connection := <-n.UnregisterCh:
 			// Remove the client from the hub
@@ -103,6 +148,60 @@ func (n *Notificator) workerWsHub() {
 	}
 }
 
+// send the message to all clients
+func (n *Notificator) broadcast(msg interface{}) {
+	// serialize message
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("error on marshal msg: %v", err)
+		return
+	}
+	for connection, c := range n.clients {
+		go func(connection *websocket.Conn, c *client) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.isClosing {
+				return
+			}
+			if err := connection.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+				c.isClosing = true
+				log.Debugf("write error: %v", err)
+
+				err = connection.WriteMessage(websocket.CloseMessage, []byte{})
+				if err != nil {
+					log.Errorf("close error: %v", err)
+				}
+				connection.Close()
+				n.Unregister(connection)
+			}
+		}(connection, c)
+	}
+}
+
+// send the close frame to all clients and close the connections
+func (n *Notificator) closeAll() {
+	var wg sync.WaitGroup
+	for connection, c := range n.clients {
+		wg.Add(1)
+		go func(connection *websocket.Conn, c *client) {
+			defer wg.Done()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.isClosing {
+				return
+			}
+			c.isClosing = true
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+			if err := connection.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout)); err != nil {
+				log.Debugf("close error: %v", err)
+			}
+			connection.Close()
+		}(connection, c)
+	}
+	wg.Wait()
+	n.clients = make(map[*websocket.Conn]*client)
+}
+
 // demo ws msg
 // func (n *Notificator) workerWsDemo() {
 // 	cnt := 0
@@ -0,0 +1,122 @@
+// Package poolsnap reads and writes mempool snapshot files.
+//
+// A snapshot is gzip compressed json lines: the header first, then a mempool.Record per tx,
+// newest first. Readers accept any version up to Version, fields are only added.
+package poolsnap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/1F47E/go-feesh/mempool"
+)
+
+const (
+	Format  = "feesh-mempool"
+	Version = 1
+)
+
+var ErrFormat = errors.New("not a mempool snapshot")
+
+type Header struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Network string    `json:"network,omitempty"`
+	Height  int       `json:"height"`
+	Time    time.Time `json:"time"`
+	Txs     int       `json:"txs"`
+}
+
+func NewHeader(network string, height int, txs int) Header {
+	return Header{
+		Format:  Format,
+		Version: Version,
+		Network: network,
+		Height:  height,
+		Time:    time.Now().UTC(),
+		Txs:     txs,
+	}
+}
+
+func Write(w io.Writer, h Header, records []mempool.Record) error {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(h); err != nil {
+		return err
+	}
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func Read(r io.Reader) (Header, []mempool.Record, error) {
+	var h Header
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return h, nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	defer zr.Close()
+	dec := json.NewDecoder(bufio.NewReader(zr))
+	if err := dec.Decode(&h); err != nil {
+		return h, nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if h.Format != Format {
+		return h, nil, ErrFormat
+	}
+	if h.Version > Version {
+		return h, nil, fmt.Errorf("snapshot version %d is newer than supported %d", h.Version, Version)
+	}
+	records := make([]mempool.Record, 0, h.Txs)
+	for {
+		var rec mempool.Record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return h, nil, fmt.Errorf("error on tx %d: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+	return h, records, nil
+}
+
+// write to a temp file and replace
+func Save(path string, h Header, records []mempool.Record) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	err = Write(bw, h, records)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func Load(path string) (Header, []mempool.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,151 @@
+package poolsnap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/mempool"
+)
+
+func testRecords() []mempool.Record {
+	return []mempool.Record{
+		{
+			TxPool: txpool.TxPool{Txid: strings.Repeat("a", 64), Time: 1700000100, Size: 250, Vsize: 141, Weight: 561, Fee: 2820, FeePerKB: 20000},
+			Parsed: &mtx.Tx{Hash: strings.Repeat("a", 64), Fee: 2820, AmountOut: 50_000, Segwit: true},
+		},
+		{
+			TxPool: txpool.TxPool{Txid: strings.Repeat("b", 64), Time: 1700000000, Size: 300, Vsize: 300, Weight: 1200, Fee: 300, Depends: []string{strings.Repeat("c", 64)}},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []mempool.Record
+	}{
+		{"empty pool", []mempool.Record{}},
+		{"txs", testRecords()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHeader("mainnet", 800_000, len(tt.records))
+			var buf bytes.Buffer
+			if err := Write(&buf, h, tt.records); err != nil {
+				t.Fatal(err)
+			}
+			got, records, err := Read(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Time.Equal(h.Time) {
+				t.Fatalf("time: got %s, want %s", got.Time, h.Time)
+			}
+			got.Time = h.Time
+			if got != h {
+				t.Fatalf("header: got %+v, want %+v", got, h)
+			}
+			if !reflect.DeepEqual(records, tt.records) {
+				t.Fatalf("records: got %+v, want %+v", records, tt.records)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.gz")
+	h := NewHeader("testnet", 10, 2)
+	if err := Save(path, h, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	_, records, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records, testRecords()) {
+		t.Fatalf("records: got %+v", records)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp"); len(matches) != 0 {
+		t.Fatal("temp file is left")
+	}
+}
+
+// gzip with the given json lines
+func rawSnapshot(t *testing.T, lines ...any) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, l := range lines {
+		if err := enc.Encode(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadRejects(t *testing.T) {
+	newer := NewHeader("", 1, 0)
+	newer.Version = Version + 1
+	older := NewHeader("", 1, 1)
+	older.Version = Version - 1
+	rec := testRecords()[1]
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format bool // rejected with ErrFormat
+		ok     bool
+	}{
+		{"not gzip", []byte(`{"format":"feesh-mempool","version":1}`), true, false},
+		{"empty", nil, true, false},
+		{"wrong format", rawSnapshot(t, Header{Format: "feesh-blocks", Version: 1}), true, false},
+		{"no format", rawSnapshot(t, map[string]int{"version": 1}), true, false},
+		{"header is not json", rawSnapshot(t, "feesh-mempool"), true, false},
+		{"newer version", rawSnapshot(t, newer), false, false},
+		{"older version", rawSnapshot(t, older, rec), false, true},
+		{"broken record", rawSnapshot(t, NewHeader("", 1, 1), "tx"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Read(bytes.NewReader(tt.data))
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("snapshot is accepted")
+			}
+			if errors.Is(err, ErrFormat) != tt.format {
+				t.Fatalf("got %v, format error %v", err, tt.format)
+			}
+		})
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, NewHeader("", 1, 2), testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	// the gzip trailer is checked, a cut anywhere is an error
+	full := buf.Bytes()
+	for n := 0; n < len(full); n++ {
+		if _, _, err := Read(bytes.NewReader(full[:n])); err == nil {
+			t.Fatalf("snapshot cut at %d of %d bytes is accepted", n, len(full))
+		}
+	}
+}
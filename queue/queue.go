@@ -0,0 +1,245 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lower value is served first
+type Priority int
+
+const (
+	PriorityPool  Priority = iota // new mempool txs
+	PriorityBlock                 // block txs backfill
+	priorities
+)
+
+// what to do when the queue is full
+type Policy string
+
+const (
+	PolicyBlock  Policy = "block"  // wait for space
+	PolicyReject Policy = "reject" // drop the new job
+	PolicyEvict  Policy = "evict"  // drop the oldest job of the lowest priority
+)
+
+// share of the limit only pool jobs can take, so a block backfill never
+// makes new pool txs wait
+const poolReserve = 0.25
+
+var ErrFull = fmt.Errorf("queue is full")
+
+type Job struct {
+	Txid     string
+	Priority Priority
+	added    time.Time
+}
+
+type Stats struct {
+	Depth      [priorities]int `json:"depth"` // queued jobs by priority
+	InFlight   int             `json:"in_flight"`
+	Limit      int             `json:"limit"`
+	Pushed     uint64          `json:"pushed"`
+	Deduped    uint64          `json:"deduped"`
+	Dropped    uint64          `json:"dropped"`
+	AvgWaitMs  float64         `json:"avg_wait_ms"` // moving average of the time in queue
+	MaxWaitMs  int64           `json:"max_wait_ms"`
+	LastWaitMs int64           `json:"last_wait_ms"`
+}
+
+// bounded priority queue of txids to parse.
+// Txid is deduplicated from push until Done is called.
+// Lower priority jobs are limited to the part of the limit not reserved for the pool
+type Queue struct {
+	mu       sync.Mutex
+	limit    int
+	reserved int // part of the limit for the pool jobs only
+	policy   Policy
+	jobs     [priorities][]Job
+	size     int
+	inflight map[string]struct{}
+	ready    chan struct{}
+	space    chan struct{} // closed on pop, nil if nobody waits
+	stats    Stats
+}
+
+func New(limit int, policy Policy) *Queue {
+	return &Queue{
+		limit:    limit,
+		reserved: int(float64(limit) * poolReserve),
+		policy:   policy,
+		inflight: make(map[string]struct{}),
+		ready:    make(chan struct{}, 1),
+		stats:    Stats{Limit: limit},
+	}
+}
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyBlock, PolicyReject, PolicyEvict:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown queue policy: %s", s)
+}
+
+// add the job. Returns false if txid is already queued or being processed.
+// When the queue is full behaves according to the policy, ErrFull is returned for rejected jobs
+func (q *Queue) Push(ctx context.Context, txid string, p Priority) (bool, error) {
+	for {
+		q.mu.Lock()
+		if _, ok := q.inflight[txid]; ok {
+			q.stats.Deduped++
+			q.mu.Unlock()
+			return false, nil
+		}
+		if q.size >= q.capacity(p) {
+			switch q.policy {
+			case PolicyReject:
+				q.stats.Dropped++
+				q.mu.Unlock()
+				return false, ErrFull
+			case PolicyEvict:
+				if !q.evict(p) {
+					q.stats.Dropped++
+					q.mu.Unlock()
+					return false, ErrFull
+				}
+			default:
+				// every waiter is woken on pop, pool and block ones wait for different space
+				if q.space == nil {
+					q.space = make(chan struct{})
+				}
+				space := q.space
+				q.mu.Unlock()
+				select {
+				case <-ctx.Done():
+					return false, ctx.Err()
+				case <-space:
+				}
+				continue
+			}
+		}
+		q.jobs[p] = append(q.jobs[p], Job{Txid: txid, Priority: p, added: time.Now()})
+		q.inflight[txid] = struct{}{}
+		q.size++
+		q.stats.Pushed++
+		q.mu.Unlock()
+		signal(q.ready)
+		return true, nil
+	}
+}
+
+// take the oldest job of the highest priority, blocks until there is one
+func (q *Queue) Pop(ctx context.Context) (Job, error) {
+	for {
+		q.mu.Lock()
+		for p := range q.jobs {
+			if len(q.jobs[p]) == 0 {
+				continue
+			}
+			job := q.jobs[p][0]
+			q.jobs[p][0] = Job{}
+			q.jobs[p] = q.jobs[p][1:]
+			q.size--
+			q.observeWait(time.Since(job.added))
+			if q.space != nil {
+				close(q.space)
+				q.space = nil
+			}
+			left := q.size
+			q.mu.Unlock()
+			// wake up the next consumer
+			if left > 0 {
+				signal(q.ready)
+			}
+			return job, nil
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-q.ready:
+		}
+	}
+}
+
+// job is processed, txid can be queued again
+func (q *Queue) Done(txid string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.inflight, txid)
+}
+
+// queued and being processed jobs
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.inflight)
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ret := q.stats
+	for p := range q.jobs {
+		ret.Depth[p] = len(q.jobs[p])
+	}
+	ret.InFlight = len(q.inflight) - q.size
+	return ret
+}
+
+// max queue size the job of priority p can be pushed to
+func (q *Queue) capacity(p Priority) int {
+	if p == PriorityPool {
+		return q.limit
+	}
+	return q.limit - q.reserved
+}
+
+// drop the oldest job with the lowest priority not higher than p
+// must be called with mu locked
+func (q *Queue) evict(p Priority) bool {
+	for i := len(q.jobs) - 1; i >= int(p); i-- {
+		if len(q.jobs[i]) == 0 {
+			continue
+		}
+		delete(q.inflight, q.jobs[i][0].Txid)
+		q.jobs[i][0] = Job{}
+		q.jobs[i] = q.jobs[i][1:]
+		q.size--
+		q.stats.Dropped++
+		return true
+	}
+	return false
+}
+
+// must be called with mu locked
+func (q *Queue) observeWait(d time.Duration) {
+	ms := d.Milliseconds()
+	q.stats.LastWaitMs = ms
+	if ms > q.stats.MaxWaitMs {
+		q.stats.MaxWaitMs = ms
+	}
+	// exponential moving average
+	if q.stats.AvgWaitMs == 0 {
+		q.stats.AvgWaitMs = float64(ms)
+		return
+	}
+	q.stats.AvgWaitMs = q.stats.AvgWaitMs*0.99 + float64(ms)*0.01
+}
+
+// non-blocking notify
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPoolPushNotBlockedByBackfill(t *testing.T) {
+	ctx := context.Background()
+	q := New(8, PolicyBlock)
+	// backfill takes all the space it can
+	for i := 0; i < 6; i++ {
+		if _, err := q.Push(ctx, fmt.Sprintf("block%d", i), PriorityBlock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := q.Push(waitCtx, "block6", PriorityBlock); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("block push over the reserve: %v, want to wait", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		pushCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		ok, err := q.Push(pushCtx, fmt.Sprintf("pool%d", i), PriorityPool)
+		cancel()
+		if !ok || err != nil {
+			t.Fatalf("pool push is blocked by the backfill: %v", err)
+		}
+	}
+	if job, err := q.Pop(ctx); err != nil || job.Priority != PriorityPool {
+		t.Fatalf("pop: %+v %v, want pool job first", job, err)
+	}
+}
+
+func TestBlockedPushWokenOnPop(t *testing.T) {
+	ctx := context.Background()
+	q := New(4, PolicyBlock)
+	for i := 0; i < 3; i++ {
+		q.Push(ctx, fmt.Sprintf("block%d", i), PriorityBlock)
+	}
+	q.Push(ctx, "pool0", PriorityPool)
+
+	// both wait for space, a single pop wakes them both
+	done := make(chan string, 2)
+	for _, p := range []Priority{PriorityBlock, PriorityPool} {
+		go func() {
+			txid := fmt.Sprintf("waiting%d", p)
+			if _, err := q.Push(ctx, txid, p); err == nil {
+				done <- txid
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := q.Pop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case txid := <-done:
+		if txid != fmt.Sprintf("waiting%d", PriorityPool) {
+			t.Fatalf("%s is pushed, want the pool job", txid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pool push is not woken on pop")
+	}
+}
@@ -0,0 +1,164 @@
+// Package replay records node activity to an event log and plays it back in place of the node.
+//
+// The log is append-only json lines, gzip compressed if the file name ends with .gz.
+// Every recording session starts with a start event and a full pool,
+// the following pool events carry only the changes.
+package replay
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+const (
+	Format  = "feesh-events"
+	Version = 1
+)
+
+var ErrFormat = errors.New("not an event log")
+
+type EventType string
+
+const (
+	EventStart EventType = "start" // new recording session, carries node info
+	EventInfo  EventType = "info"  // node info changed
+	EventPool  EventType = "pool"  // pool changes, full pool if Reset
+	EventBlock EventType = "block"
+	EventStats EventType = "stats" // block stats
+	EventTx    EventType = "tx"    // decoded tx fetched from the node
+)
+
+type Event struct {
+	Time    time.Time              `json:"t"`
+	Type    EventType              `json:"type"`
+	Format  string                 `json:"format,omitempty"`
+	Version int                    `json:"version,omitempty"`
+	Info    *info.Info             `json:"info,omitempty"`
+	Reset   bool                   `json:"reset,omitempty"`
+	Added   []txpool.TxPool        `json:"added,omitempty"`
+	Removed []string               `json:"removed,omitempty"`
+	Block   *block.Block           `json:"block,omitempty"`
+	Stats   *blockstats.BlockStats `json:"stats,omitempty"`
+	Tx      *tx.Transaction        `json:"tx,omitempty"`
+}
+
+// appends events to the log file
+type Writer struct {
+	f   *os.File
+	bw  *bufio.Writer
+	zw  *gzip.Writer
+	enc *json.Encoder
+}
+
+// open the log for appending, gzip sessions are appended as separate members
+func Create(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{f: f, bw: bufio.NewWriter(f)}
+	if strings.HasSuffix(path, ".gz") {
+		w.zw = gzip.NewWriter(w.bw)
+		w.enc = json.NewEncoder(w.zw)
+	} else {
+		w.enc = json.NewEncoder(w.bw)
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(e *Event) error {
+	return w.enc.Encode(e)
+}
+
+// push buffered events to the file, a gzip log stays readable up to the last flush
+func (w *Writer) Flush() error {
+	if w.zw != nil {
+		if err := w.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.bw.Flush()
+}
+
+func (w *Writer) Close() error {
+	var err error
+	if w.zw != nil {
+		err = w.zw.Close()
+	}
+	if ferr := w.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reads events in order
+type Reader struct {
+	f   *os.File
+	zr  *gzip.Reader
+	dec *json.Decoder
+}
+
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{f: f}
+	br := bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		r.zr, err = gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		r.dec = json.NewDecoder(r.zr)
+	} else {
+		r.dec = json.NewDecoder(br)
+	}
+	return r, nil
+}
+
+// next event, io.EOF at the end of the log.
+// A log cut by a crash ends at the last complete event
+func (r *Reader) Next() (*Event, error) {
+	var e Event
+	err := r.dec.Decode(&e)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e.Type == EventStart {
+		if e.Format != Format {
+			return nil, ErrFormat
+		}
+		if e.Version > Version {
+			return nil, fmt.Errorf("event log version %d is newer than supported %d", e.Version, Version)
+		}
+	}
+	return &e, nil
+}
+
+func (r *Reader) Close() error {
+	if r.zr != nil {
+		r.zr.Close()
+	}
+	return r.f.Close()
+}
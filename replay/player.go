@@ -0,0 +1,347 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	"github.com/1F47E/go-feesh/logger"
+)
+
+var ErrNotFound = errors.New("not recorded")
+
+// gaps between recording sessions are cut to this
+const maxGap = time.Minute
+
+// how long a lookup waits for the data recorded a bit later than requested, replay time
+const lookahead = 30 * time.Second
+
+// node replaying the event log at the given speed.
+// Recorded timestamps are moved to the replay time so the data looks live,
+// block headers built from the shifted fields don't match the block hashes
+type Player struct {
+	path  string
+	speed float64
+
+	mu      sync.RWMutex
+	info    *info.Info
+	pool    map[string]txpool.TxPool
+	blocks  map[string]*block.Block
+	tip     *block.Block
+	stats   map[string]*blockstats.BlockStats
+	txs     map[string]*tx.Transaction
+	changed chan struct{} // closed and replaced on every applied event
+	done    bool
+
+	// replay clock, guarded by mu
+	started time.Time // wall time of the first event
+	first   time.Time // recorded time of the first event
+	skipped time.Duration
+}
+
+var _ core.Node = (*Player)(nil)
+
+// the log is checked here, played by Start
+func NewPlayer(path string, speed float64) (*Player, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed %v", speed)
+	}
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	e, err := r.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if e.Type != EventStart {
+		return nil, ErrFormat
+	}
+	return &Player{
+		path:    path,
+		speed:   speed,
+		pool:    make(map[string]txpool.TxPool),
+		blocks:  make(map[string]*block.Block),
+		stats:   make(map[string]*blockstats.BlockStats),
+		txs:     make(map[string]*tx.Transaction),
+		changed: make(chan struct{}),
+	}, nil
+}
+
+func (p *Player) Start(ctx context.Context) {
+	go p.play(ctx)
+}
+
+func (p *Player) play(ctx context.Context) {
+	log := logger.Log.WithField("context", "[replay]")
+	defer func() {
+		p.mu.Lock()
+		p.done = true
+		close(p.changed)
+		p.changed = make(chan struct{})
+		p.mu.Unlock()
+	}()
+	r, err := Open(p.path)
+	if err != nil {
+		log.Errorf("error on opening event log: %v\n", err)
+		return
+	}
+	defer r.Close()
+	log.Infof("replaying %s at x%v\n", p.path, p.speed)
+
+	var last time.Time
+	var n int
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			log.Infof("replay finished, %d events\n", n)
+			return
+		}
+		if err != nil {
+			log.Errorf("error on reading event %d: %v\n", n+1, err)
+			return
+		}
+		if n == 0 {
+			p.mu.Lock()
+			p.started = time.Now()
+			p.first = e.Time
+			p.mu.Unlock()
+		} else if gap := e.Time.Sub(last); gap > maxGap {
+			p.mu.Lock()
+			p.skipped += gap - maxGap
+			p.mu.Unlock()
+		}
+		last = e.Time
+		n++
+
+		// wait for the event time
+		if d := time.Until(p.wall(e.Time)); d > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		p.apply(e)
+	}
+}
+
+// replay wall time of the recorded time
+func (p *Player) wall(t time.Time) time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.wallLocked(t)
+}
+
+func (p *Player) wallLocked(t time.Time) time.Time {
+	if p.started.IsZero() {
+		return t
+	}
+	v := t.Sub(p.first) - p.skipped
+	return p.started.Add(time.Duration(float64(v) / p.speed))
+}
+
+// recorded unix time moved to the replay time
+func (p *Player) shift(unix int64) int64 {
+	if unix == 0 {
+		return 0
+	}
+	return p.wallLocked(time.Unix(unix, 0)).Unix()
+}
+
+func (p *Player) apply(e *Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch e.Type {
+	case EventStart, EventInfo:
+		p.info = e.Info
+	case EventPool:
+		if e.Reset {
+			// new session, drop the txs left the pool in between
+			pool := make(map[string]txpool.TxPool, len(e.Added))
+			for _, t := range e.Added {
+				pool[t.Txid] = t
+			}
+			for txid := range p.pool {
+				if _, ok := pool[txid]; !ok {
+					delete(p.txs, txid)
+				}
+			}
+			p.pool = make(map[string]txpool.TxPool, len(e.Added))
+		}
+		for _, t := range e.Added {
+			t.Time = p.shift(t.Time)
+			p.pool[t.Txid] = t
+		}
+		for _, txid := range e.Removed {
+			delete(p.pool, txid)
+			delete(p.txs, txid)
+		}
+	case EventBlock:
+		// replayed headers are synthetic, with the shifted time
+		// the serialized header no longer hashes to the block hash
+		b := *e.Block
+		b.Time = int(p.shift(int64(b.Time)))
+		b.Mediantime = int(p.shift(int64(b.Mediantime)))
+		p.blocks[b.Hash] = &b
+		if p.tip == nil || b.Height >= p.tip.Height {
+			p.tip = &b
+		}
+	case EventStats:
+		s := *e.Stats
+		s.Time = p.shift(s.Time)
+		s.MedianTime = p.shift(s.MedianTime)
+		p.stats[s.BlockHash] = &s
+	case EventTx:
+		t := *e.Tx
+		t.Time = int(p.shift(int64(t.Time)))
+		t.Blocktime = int(p.shift(int64(t.Blocktime)))
+		p.txs[t.Txid] = &t
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// wait until found returns true, the data can be recorded a bit later than the core asks for it
+func (p *Player) wait(found func() bool) bool {
+	timeout := time.After(max(time.Duration(float64(lookahead)/p.speed), 100*time.Millisecond))
+	for {
+		p.mu.RLock()
+		ok := found()
+		done := p.done
+		changed := p.changed
+		p.mu.RUnlock()
+		if ok {
+			return true
+		}
+		if done {
+			return false
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func (p *Player) GetInfo() (*info.Info, error) {
+	var ret info.Info
+	ok := p.wait(func() bool {
+		if p.info == nil {
+			return false
+		}
+		ret = *p.info
+		if p.tip != nil && p.tip.Height > ret.Blocks {
+			ret.Blocks = p.tip.Height
+		}
+		return true
+	})
+	if !ok {
+		return nil, fmt.Errorf("node info: %w", ErrNotFound)
+	}
+	return &ret, nil
+}
+
+func (p *Player) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	var ret client.ResponseGetBestBlock
+	ok := p.wait(func() bool {
+		if p.tip == nil {
+			return false
+		}
+		ret = client.ResponseGetBestBlock{Hash: p.tip.Hash, Height: p.tip.Height}
+		return true
+	})
+	if !ok {
+		return nil, fmt.Errorf("best block: %w", ErrNotFound)
+	}
+	return &ret, nil
+}
+
+func (p *Player) GetBlock(hash string) (*block.Block, error) {
+	var ret block.Block
+	ok := p.wait(func() bool {
+		b, ok := p.blocks[hash]
+		if ok {
+			ret = *b
+			ret.Confirmations = p.tip.Height - b.Height + 1
+		}
+		return ok
+	})
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+	}
+	return &ret, nil
+}
+
+// header fields of the recorded block
+func (p *Player) GetBlockHeader(hash string) (*block.Block, error) {
+	b, err := p.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Transactions = nil
+	return b, nil
+}
+
+func (p *Player) GetBlockStats(hash string) (*blockstats.BlockStats, error) {
+	var ret blockstats.BlockStats
+	ok := p.wait(func() bool {
+		s, ok := p.stats[hash]
+		if ok {
+			ret = *s
+		}
+		return ok
+	})
+	if !ok {
+		return nil, fmt.Errorf("block stats %s: %w", hash, ErrNotFound)
+	}
+	return &ret, nil
+}
+
+func (p *Player) TransactionGet(txid string) (*tx.Transaction, error) {
+	var ret *tx.Transaction
+	ok := p.wait(func() bool {
+		t, ok := p.txs[txid]
+		if ok {
+			c := *t
+			ret = &c
+		}
+		return ok
+	})
+	if !ok {
+		return nil, fmt.Errorf("tx %s: %w", txid, ErrNotFound)
+	}
+	return ret, nil
+}
+
+// current replayed pool, newest first as the patched node returns it
+func (p *Player) RawMempool() ([]txpool.TxPool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.info == nil {
+		return nil, fmt.Errorf("pool: %w", ErrNotFound)
+	}
+	ret := make([]txpool.TxPool, 0, len(p.pool))
+	for _, t := range p.pool {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Time > ret[j].Time
+	})
+	return ret, nil
+}
@@ -0,0 +1,171 @@
+package replay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+	"github.com/1F47E/go-feesh/logger"
+)
+
+// node wrapper writing everything the core gets from the node to the event log.
+// Recording errors are logged and never fail the node calls
+type Recorder struct {
+	node core.Node
+
+	mu      sync.Mutex
+	w       *Writer
+	started bool
+	height  int
+	pool    map[string]struct{}
+	blocks  map[string]struct{}
+	stats   map[string]struct{}
+	txs     map[string]struct{} // pool txs already recorded
+	failed  bool
+}
+
+var _ core.Node = (*Recorder)(nil)
+
+func NewRecorder(node core.Node, path string) (*Recorder, error) {
+	w, err := Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		node:   node,
+		w:      w,
+		pool:   make(map[string]struct{}),
+		blocks: make(map[string]struct{}),
+		stats:  make(map[string]struct{}),
+		txs:    make(map[string]struct{}),
+	}, nil
+}
+
+func (r *Recorder) GetInfo() (*info.Info, error) {
+	i, err := r.node.GetInfo()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case !r.started:
+		r.started = true
+		r.height = i.Blocks
+		r.write(&Event{Type: EventStart, Format: Format, Version: Version, Info: i}, true)
+	case i.Blocks != r.height:
+		r.height = i.Blocks
+		r.write(&Event{Type: EventInfo, Info: i}, true)
+	}
+	return i, nil
+}
+
+// derived from the blocks on replay
+func (r *Recorder) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	return r.node.GetBestBlock()
+}
+
+// served from the recorded blocks on replay
+func (r *Recorder) GetBlockHeader(hash string) (*block.Block, error) {
+	return r.node.GetBlockHeader(hash)
+}
+
+func (r *Recorder) GetBlock(hash string) (*block.Block, error) {
+	b, err := r.node.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.blocks[b.Hash]; !ok {
+		r.blocks[b.Hash] = struct{}{}
+		r.write(&Event{Type: EventBlock, Block: b}, true)
+	}
+	return b, nil
+}
+
+func (r *Recorder) GetBlockStats(hash string) (*blockstats.BlockStats, error) {
+	s, err := r.node.GetBlockStats(hash)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.stats[hash]; !ok {
+		r.stats[hash] = struct{}{}
+		r.write(&Event{Type: EventStats, Stats: s}, false)
+	}
+	return s, nil
+}
+
+func (r *Recorder) TransactionGet(txid string) (*tx.Transaction, error) {
+	t, err := r.node.TransactionGet(txid)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.txs[txid]; !ok {
+		// dedupe pool txs only, others are fetched once anyway
+		if _, ok := r.pool[txid]; ok {
+			r.txs[txid] = struct{}{}
+		}
+		r.write(&Event{Type: EventTx, Tx: t}, false)
+	}
+	return t, nil
+}
+
+// the first call of the session records the full pool, then only the changes
+func (r *Recorder) RawMempool() ([]txpool.TxPool, error) {
+	txs, err := r.node.RawMempool()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := &Event{Type: EventPool, Reset: len(r.pool) == 0}
+	seen := make(map[string]struct{}, len(txs))
+	for _, t := range txs {
+		seen[t.Txid] = struct{}{}
+		if _, ok := r.pool[t.Txid]; !ok {
+			e.Added = append(e.Added, t)
+		}
+	}
+	for txid := range r.pool {
+		if _, ok := seen[txid]; !ok {
+			e.Removed = append(e.Removed, txid)
+			delete(r.txs, txid)
+		}
+	}
+	r.pool = seen
+	if e.Reset || len(e.Added) > 0 || len(e.Removed) > 0 {
+		r.write(e, true)
+	}
+	return txs, nil
+}
+
+// caller holds mu
+func (r *Recorder) write(e *Event, flush bool) {
+	e.Time = time.Now().UTC()
+	err := r.w.Write(e)
+	if err == nil && flush {
+		err = r.w.Flush()
+	}
+	if err != nil && !r.failed {
+		// log once, the disk is full or gone
+		r.failed = true
+		logger.Log.WithField("context", "[recorder]").Errorf("error on writing event log: %v\n", err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Close()
+}
@@ -0,0 +1,315 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+// node serving whatever the test puts in it
+type fakeNode struct {
+	info   info.Info
+	pool   []txpool.TxPool
+	blocks map[string]*block.Block
+	stats  map[string]*blockstats.BlockStats
+	txs    map[string]*tx.Transaction
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{
+		blocks: make(map[string]*block.Block),
+		stats:  make(map[string]*blockstats.BlockStats),
+		txs:    make(map[string]*tx.Transaction),
+	}
+}
+
+func (n *fakeNode) GetInfo() (*info.Info, error) {
+	i := n.info
+	return &i, nil
+}
+
+func (n *fakeNode) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	return nil, errors.New("not used")
+}
+
+func (n *fakeNode) GetBlock(hash string) (*block.Block, error) {
+	b, ok := n.blocks[hash]
+	if !ok {
+		return nil, errors.New("no block")
+	}
+	c := *b
+	return &c, nil
+}
+
+func (n *fakeNode) GetBlockHeader(hash string) (*block.Block, error) {
+	return n.GetBlock(hash)
+}
+
+func (n *fakeNode) GetBlockStats(hash string) (*blockstats.BlockStats, error) {
+	s, ok := n.stats[hash]
+	if !ok {
+		return nil, errors.New("no stats")
+	}
+	c := *s
+	return &c, nil
+}
+
+func (n *fakeNode) TransactionGet(txid string) (*tx.Transaction, error) {
+	t, ok := n.txs[txid]
+	if !ok {
+		return nil, errors.New("no tx")
+	}
+	c := *t
+	return &c, nil
+}
+
+func (n *fakeNode) RawMempool() ([]txpool.TxPool, error) {
+	return append([]txpool.TxPool(nil), n.pool...), nil
+}
+
+func poolTx(txid string, unix int64) txpool.TxPool {
+	return txpool.TxPool{Txid: txid, Time: unix, Size: 200, Vsize: 200, Fee: 1000}
+}
+
+// play the whole log, the player is done when returned
+func playAll(t *testing.T, path string, speed float64) *Player {
+	t.Helper()
+	p, err := NewPlayer(path, speed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.Start(ctx)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		p.mu.RLock()
+		done := p.done
+		p.mu.RUnlock()
+		if done {
+			return p
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("replay is not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func pooled(t *testing.T, p *Player) []string {
+	t.Helper()
+	txs, err := p.RawMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		ret = append(ret, tx.Txid)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.gz")
+	now := time.Now().Unix()
+	node := newFakeNode()
+	node.info = info.Info{Blocks: 99, Relayfee: 0.00001}
+	node.pool = []txpool.TxPool{poolTx("a", now), poolTx("b", now)}
+	node.blocks["h100"] = &block.Block{Hash: "h100", Height: 100, Time: int(now), Mediantime: int(now - 3600), Transactions: []string{"c0", "x"}}
+	node.stats["h100"] = &blockstats.BlockStats{BlockHash: "h100", Height: 100, Time: now}
+	node.txs["a"] = &tx.Transaction{Txid: "a", Time: int(now)}
+	node.txs["b"] = &tx.Transaction{Txid: "b", Time: int(now)}
+
+	// the first session: full pool, a parsed tx, a block and the pool changes
+	r, err := NewRecorder(node, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		func() error { _, err := r.GetInfo(); return err },
+		func() error { _, err := r.RawMempool(); return err },
+		func() error { _, err := r.TransactionGet("a"); return err },
+		func() error { _, err := r.TransactionGet("b"); return err },
+		// fetched twice, recorded once
+		func() error { _, err := r.TransactionGet("a"); return err },
+		func() error { _, err := r.GetBlock("h100"); return err },
+		func() error { _, err := r.GetBlockStats("h100"); return err },
+		func() error {
+			node.pool = []txpool.TxPool{poolTx("b", now), poolTx("c", now)}
+			node.info.Blocks = 100
+			_, err := r.RawMempool()
+			return err
+		},
+		func() error { _, err := r.GetInfo(); return err },
+		// nothing changed, nothing recorded
+		func() error { _, err := r.RawMempool(); return err },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart appends a new session starting with the full pool
+	node.pool = []txpool.TxPool{poolTx("b", now), poolTx("d", now)}
+	r, err = NewRecorder(node, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.RawMempool(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make([]EventType, 0)
+	for {
+		e, err := log.Next()
+		if err != nil {
+			break
+		}
+		types = append(types, e.Type)
+	}
+	log.Close()
+	want := []EventType{EventStart, EventPool, EventTx, EventTx, EventBlock, EventStats, EventPool, EventInfo, EventStart, EventPool}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("events: got %v, want %v", types, want)
+	}
+
+	p := playAll(t, path, 1000)
+	if got := pooled(t, p); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Fatalf("pool: got %v, want [b d]", got)
+	}
+	i, err := p.GetInfo()
+	if err != nil || i.Blocks != 100 {
+		t.Fatalf("info: got %+v %v", i, err)
+	}
+	best, err := p.GetBestBlock()
+	if err != nil || best.Hash != "h100" || best.Height != 100 {
+		t.Fatalf("best block: got %+v %v", best, err)
+	}
+	b, err := p.GetBlock("h100")
+	if err != nil || !reflect.DeepEqual(b.Transactions, []string{"c0", "x"}) || b.Confirmations != 1 {
+		t.Fatalf("block: got %+v %v", b, err)
+	}
+	if h, err := p.GetBlockHeader("h100"); err != nil || h.Transactions != nil {
+		t.Fatalf("header: got %+v %v", h, err)
+	}
+	if _, err := p.GetBlockStats("h100"); err != nil {
+		t.Fatal(err)
+	}
+	// the parsed pool tx left in between the sessions is dropped on reset
+	if _, err := p.TransactionGet("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.TransactionGet("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("tx left the pool: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestReplayClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	block100 := &block.Block{Hash: "h100", Height: 100, Time: int(t0.Unix()), Mediantime: int(t0.Add(-time.Hour).Unix())}
+	events := []*Event{
+		{Time: t0, Type: EventStart, Format: Format, Version: Version, Info: &info.Info{Blocks: 99}},
+		{Time: t0, Type: EventPool, Reset: true, Added: []txpool.TxPool{poolTx("a", t0.Unix()), poolTx("b", t0.Unix())}},
+		{Time: t0.Add(30 * time.Second), Type: EventTx, Tx: &tx.Transaction{Txid: "a", Time: int(t0.Unix())}},
+		{Time: t0.Add(30 * time.Second), Type: EventTx, Tx: &tx.Transaction{Txid: "b", Time: int(t0.Unix())}},
+		{Time: t0.Add(60 * time.Second), Type: EventBlock, Block: block100},
+		// two hours later, the gap is cut to a minute
+		{Time: t0.Add(2*time.Hour + time.Minute), Type: EventStart, Format: Format, Version: Version, Info: &info.Info{Blocks: 100}},
+		{Time: t0.Add(2*time.Hour + time.Minute), Type: EventPool, Reset: true, Added: []txpool.TxPool{poolTx("b", t0.Add(2*time.Hour).Unix()), poolTx("c", t0.Add(2*time.Hour).Unix())}},
+	}
+	for _, e := range events {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a minute of the log is half a second
+	const speed = 120
+	started := time.Now()
+	p := playAll(t, path, speed)
+	took := time.Since(started)
+	// 1m of events + 1m of the cut gap
+	if want := 2 * time.Minute / speed; took < want || took > want+2*time.Second {
+		t.Fatalf("replay took %s, want about %s", took, want)
+	}
+	p.mu.RLock()
+	skipped, first, wall0 := p.skipped, p.first, p.started
+	p.mu.RUnlock()
+	if want := 2*time.Hour - time.Minute; skipped != want || !first.Equal(t0) {
+		t.Fatalf("skipped %s from %s, want %s from %s", skipped, first, want, t0)
+	}
+	if wall0.Sub(started) > time.Second {
+		t.Fatalf("first event at %s, replay started at %s", wall0, started)
+	}
+	// replay second of the recorded time, the cut gap counts after it only
+	at := func(d time.Duration) int64 {
+		if d > time.Hour {
+			d -= skipped
+		}
+		return wall0.Add(d / speed).Unix()
+	}
+
+	b, err := p.GetBlock("h100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Time != int(at(0)) || b.Mediantime != int(at(-time.Hour)) {
+		t.Fatalf("block times: got %d %d, want %d %d", b.Time, b.Mediantime, at(0), at(-time.Hour))
+	}
+	// the recorded block is not changed
+	if block100.Time != int(t0.Unix()) {
+		t.Fatal("recorded block is changed")
+	}
+	txs, err := p.RawMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range txs {
+		if want := at(2 * time.Hour); tx.Time != want {
+			t.Fatalf("tx %s time: got %d, want %d", tx.Txid, tx.Time, want)
+		}
+	}
+	if got := pooled(t, p); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("pool after reset: got %v, want [b c]", got)
+	}
+	if _, err := p.TransactionGet("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("tx left the pool: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := p.TransactionGet("b"); err != nil {
+		t.Fatal(err)
+	}
+}
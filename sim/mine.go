@@ -0,0 +1,130 @@
+package sim
+
+import (
+	"sort"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+// coinbase weight reserved in the block
+const coinbaseWeight = 4000
+
+// unconfirmed ancestors in the pool, parents first
+func (s *Sim) ancestors(t *simTx, seen map[string]bool, ret []*simTx) []*simTx {
+	if len(t.parents) == 0 {
+		return ret
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	for _, p := range t.parents {
+		parent, ok := s.pool[p]
+		if !ok || seen[p] {
+			continue
+		}
+		seen[p] = true
+		ret = s.ancestors(parent, seen, ret)
+		ret = append(ret, parent)
+	}
+	return ret
+}
+
+// build the block from the pool by the ancestor fee rate and confirm it
+func (s *Sim) mine(at time.Time, prev string, height int) *simBlock {
+	type candidate struct {
+		tx    *simTx
+		score float64
+	}
+	candidates := make([]candidate, 0, len(s.pool))
+	for _, t := range s.pool {
+		fee, vsize := t.fee, uint64(t.vsize)
+		for _, a := range s.ancestors(t, nil, nil) {
+			fee += a.fee
+			vsize += uint64(a.vsize)
+		}
+		candidates = append(candidates, candidate{tx: t, score: float64(fee) / float64(vsize)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score == candidates[j].score {
+			return candidates[i].tx.txid < candidates[j].tx.txid
+		}
+		return candidates[i].score > candidates[j].score
+	})
+
+	b := &simBlock{
+		hash:   s.hash(true),
+		prev:   prev,
+		height: height,
+		time:   at,
+		txs:    []*simTx{nil}, // coinbase
+		weight: coinbaseWeight,
+		size:   coinbaseWeight / 4,
+	}
+	included := make(map[string]bool)
+	var fees uint64
+	for _, c := range candidates {
+		if s.sc.BlockWeight-b.weight < 400 {
+			break
+		}
+		if included[c.tx.txid] {
+			continue
+		}
+		pkg := append(s.ancestors(c.tx, nil, nil), c.tx)
+		var weight uint64
+		for _, t := range pkg {
+			if !included[t.txid] {
+				weight += uint64(t.weight)
+			}
+		}
+		if b.weight+weight > s.sc.BlockWeight {
+			continue
+		}
+		for _, t := range pkg {
+			if included[t.txid] {
+				continue
+			}
+			included[t.txid] = true
+			b.txs = append(b.txs, t)
+			b.weight += uint64(t.weight)
+			b.size += uint64(t.size)
+			fees += t.fee
+		}
+	}
+
+	tag := "/sim/"
+	if len(s.sc.Miners) > 0 {
+		tag = s.sc.Miners[s.rng.Intn(len(s.sc.Miners))]
+	}
+	cb := &simTx{
+		txid:     s.hash(false),
+		time:     at,
+		vsize:    coinbaseWeight / 4,
+		weight:   coinbaseWeight,
+		size:     coinbaseWeight / 4,
+		segwit:   true,
+		outputs:  []uint64{mblock.Subsidy(height) + fees},
+		coinbase: tag,
+		block:    b,
+	}
+	b.txs[0] = cb
+	s.txs[cb.txid] = cb
+
+	// confirmed txs leave the pool, their children stay
+	for _, t := range b.txs[1:] {
+		t.block = b
+		delete(s.pool, t.txid)
+	}
+	s.compact()
+	s.blocks[b.hash] = b
+	s.chain = append(s.chain, b)
+	if len(s.chain) > max(keepBlocks, s.sc.Blocks) {
+		old := s.chain[0]
+		s.chain = s.chain[1:]
+		delete(s.blocks, old.hash)
+		for _, t := range old.txs {
+			delete(s.txs, t.txid)
+		}
+	}
+	return b
+}
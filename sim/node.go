@@ -0,0 +1,241 @@
+package sim
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/1F47E/go-feesh/client"
+	"github.com/1F47E/go-feesh/core"
+	"github.com/1F47E/go-feesh/entity/btc/block"
+	"github.com/1F47E/go-feesh/entity/btc/blockstats"
+	"github.com/1F47E/go-feesh/entity/btc/info"
+	"github.com/1F47E/go-feesh/entity/btc/tx"
+	"github.com/1F47E/go-feesh/entity/btc/txpool"
+)
+
+var ErrNotFound = errors.New("not found")
+
+var _ core.Node = (*Sim)(nil)
+
+func (s *Sim) GetInfo() (*info.Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &info.Info{
+		Version:         270000,
+		ProtocolVersion: 70016,
+		Blocks:          s.tip().height,
+		Connections:     10,
+		Difficulty:      s.sc.Difficulty,
+		Testnet:         s.sc.Testnet,
+		Relayfee:        0.00001,
+	}, nil
+}
+
+func (s *Sim) tip() *simBlock {
+	return s.chain[len(s.chain)-1]
+}
+
+func (s *Sim) GetBestBlock() (*client.ResponseGetBestBlock, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tip := s.tip()
+	return &client.ResponseGetBestBlock{Hash: tip.hash, Height: tip.height}, nil
+}
+
+// header fields of the block, like getblockheader
+func (s *Sim) GetBlockHeader(hash string) (*block.Block, error) {
+	b, err := s.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	b.Transactions = nil
+	return b, nil
+}
+
+func (s *Sim) GetBlock(hash string) (*block.Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+	}
+	txids := make([]string, len(b.txs))
+	var stripped uint64
+	for i, t := range b.txs {
+		txids[i] = t.txid
+		stripped += uint64(t.weight-t.size) / 3
+	}
+	return &block.Block{
+		Hash:              b.hash,
+		Confirmations:     s.tip().height - b.height + 1,
+		Strippedsize:      int(stripped),
+		Size:              int(b.size),
+		Weight:            int(b.weight),
+		Height:            b.height,
+		Version:           0x20000000,
+		VersionHex:        "20000000",
+		Merkleroot:        b.txs[0].txid,
+		Transactions:      txids,
+		Time:              int(s.wall(b.time).Unix()),
+		Bits:              "17034219",
+		Difficulty:        s.sc.Difficulty,
+		Previousblockhash: b.prev,
+	}, nil
+}
+
+// fee rate percentiles are weighted by tx weight as the node does
+func (s *Sim) GetBlockStats(hash string) (*blockstats.BlockStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+	}
+	cb := b.txs[0]
+	ret := &blockstats.BlockStats{
+		BlockHash:   b.hash,
+		Height:      b.height,
+		Time:        s.wall(b.time).Unix(),
+		MedianTime:  s.wall(b.time).Unix(),
+		Subsidy:     cb.outputs[0],
+		Txs:         uint64(len(b.txs)),
+		TotalSize:   b.size,
+		TotalWeight: b.weight,
+		Ins:         1,
+		Outs:        1,
+		TotalOut:    cb.outputs[0],
+	}
+	txs := b.txs[1:]
+	if len(txs) == 0 {
+		return ret, nil
+	}
+	ret.MinFee, ret.MinFeeRate, ret.MinTxSize = ^uint64(0), ^uint64(0), ^uint64(0)
+	fees := make([]uint64, 0, len(txs))
+	for _, t := range txs {
+		rate := t.fee / uint64(t.vsize)
+		ret.TotalFee += t.fee
+		ret.MinFee = min(ret.MinFee, t.fee)
+		ret.MaxFee = max(ret.MaxFee, t.fee)
+		ret.MinFeeRate = min(ret.MinFeeRate, rate)
+		ret.MaxFeeRate = max(ret.MaxFeeRate, rate)
+		ret.MinTxSize = min(ret.MinTxSize, uint64(t.size))
+		ret.MaxTxSize = max(ret.MaxTxSize, uint64(t.size))
+		ret.Ins += uint64(len(t.inputs))
+		ret.Outs += uint64(len(t.outputs))
+		for _, o := range t.outputs {
+			ret.TotalOut += o
+		}
+		if t.segwit {
+			ret.SwTxs++
+			ret.SwTotalSize += uint64(t.size)
+			ret.SwTotalWeight += uint64(t.weight)
+		}
+		fees = append(fees, t.fee)
+	}
+	ret.Subsidy -= ret.TotalFee
+	ret.TotalOut -= ret.TotalFee
+	n := uint64(len(txs))
+	ret.AvgFee = ret.TotalFee / n
+	ret.AvgTxSize = (b.size - uint64(cb.size)) / n
+	ret.AvgFeeRate = ret.TotalFee * 4 / (b.weight - uint64(cb.weight))
+	sort.Slice(fees, func(i, j int) bool { return fees[i] < fees[j] })
+	ret.MedianFee = fees[len(fees)/2]
+
+	byRate := make([]*simTx, len(txs))
+	copy(byRate, txs)
+	sort.Slice(byRate, func(i, j int) bool { return byRate[i].rate() < byRate[j].rate() })
+	var total, cum uint64
+	for _, t := range byRate {
+		total += uint64(t.weight)
+	}
+	marks := [5]float64{0.1, 0.25, 0.5, 0.75, 0.9}
+	i := 0
+	for _, t := range byRate {
+		cum += uint64(t.weight)
+		for i < len(marks) && float64(cum) >= marks[i]*float64(total) {
+			ret.FeeRatePercentiles[i] = t.fee / uint64(t.vsize)
+			i++
+		}
+	}
+	return ret, nil
+}
+
+func (s *Sim) TransactionGet(txid string) (*tx.Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.txs[txid]
+	if !ok {
+		return nil, fmt.Errorf("tx %s: %w", txid, ErrNotFound)
+	}
+	ret := &tx.Transaction{
+		Txid:    t.txid,
+		Version: 2,
+		Size:    int(t.size),
+		Weight:  int(t.weight),
+		Time:    int(s.wall(t.time).Unix()),
+	}
+	if t.block != nil {
+		ret.Blockhash = t.block.hash
+		ret.Confirmations = s.tip().height - t.block.height + 1
+		ret.Blocktime = int(s.wall(t.block.time).Unix())
+	}
+	if t.coinbase != "" {
+		ret.Vin = []tx.Vin{{Coinbase: hex.EncodeToString([]byte(t.coinbase)), Sequence: 0xffffffff}}
+	}
+	for _, in := range t.inputs {
+		vin := tx.Vin{Txid: in.txid, Vout: in.vout, Sequence: 0xffffffff}
+		if t.rbf {
+			vin.Sequence = 0xfffffffd
+		}
+		if t.segwit {
+			vin.Txinwitness = []string{"30440220", "02"}
+		}
+		ret.Vin = append(ret.Vin, vin)
+	}
+	kind := "pubkeyhash"
+	switch {
+	case t.taproot:
+		kind = "witness_v1_taproot"
+	case t.segwit:
+		kind = "witness_v0_keyhash"
+	}
+	for i, o := range t.outputs {
+		ret.Vout = append(ret.Vout, tx.Vout{
+			Value:        float64(o) / 1e8,
+			N:            i,
+			ScriptPubKey: tx.ScriptPubKey{Type: kind},
+		})
+	}
+	return ret, nil
+}
+
+// pool newest first with the unconfirmed parents, as the patched node returns it
+func (s *Sim) RawMempool() ([]txpool.TxPool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]txpool.TxPool, 0, len(s.pool))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		t := s.order[i]
+		if s.pool[t.txid] != t {
+			continue
+		}
+		p := txpool.TxPool{
+			Txid:     t.txid,
+			Time:     s.wall(t.time).Unix(),
+			Size:     t.size,
+			Vsize:    t.vsize,
+			Weight:   t.weight,
+			Fee:      t.fee,
+			FeePerKB: t.fee * 1000 / uint64(t.vsize),
+		}
+		for _, parent := range t.parents {
+			if _, ok := s.pool[parent]; ok {
+				p.Depends = append(p.Depends, parent)
+			}
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
@@ -0,0 +1,181 @@
+package sim
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// bundled scenarios, picked by name instead of a file path
+//
+//go:embed scenarios/*.json
+var embedded embed.FS
+
+// simulation parameters, loaded from json.
+// Durations are strings like "10m", fee rates are in sat/vB
+type Scenario struct {
+	Name          string      `json:"name"`
+	Seed          int64       `json:"seed"`
+	Testnet       bool        `json:"testnet"`
+	Height        int         `json:"height"`         // chain tip at the start
+	Blocks        int         `json:"blocks"`         // blocks mined before the start, fill the blocks window
+	BlockInterval Duration    `json:"block_interval"` // mean, blocks are found as a poisson process
+	BlockWeight   uint64      `json:"block_weight"`
+	Difficulty    float64     `json:"difficulty"`
+	TxRate        float64     `json:"tx_rate"` // mean tx arrivals per second
+	Pool          int         `json:"pool"`    // txs in the pool at the start
+	Expiry        Duration    `json:"expiry"`  // pool txs older than this are dropped
+	FeeRate       []Component `json:"fee_rate"`
+	Vsize         []Component `json:"vsize"`
+	Segwit        float64     `json:"segwit"`  // share of txs with witness
+	Taproot       float64     `json:"taproot"` // share of txs paying to taproot
+	Chain         float64     `json:"chain"`   // share of txs spending an unconfirmed output
+	RBF           RBF         `json:"rbf"`
+	CPFP          CPFP        `json:"cpfp"`
+	Miners        []string    `json:"miners"` // coinbase tags, blocks are attributed by them
+	Phases        []Phase     `json:"phases"`
+}
+
+// lognormal mixture component, values are clamped to [min, max]
+type Component struct {
+	Weight float64 `json:"weight"`
+	Median float64 `json:"median"`
+	Sigma  float64 `json:"sigma"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+// fee bumps replacing pool txs
+type RBF struct {
+	Share  float64  `json:"share"`  // share of txs replaced while in the pool
+	Signal float64  `json:"signal"` // share of txs signaling replaceability, replaced ones always do
+	Delay  Duration `json:"delay"`  // mean time to the replacement
+	Bump   float64  `json:"bump"`   // fee rate multiplier
+}
+
+// children paying for stuck parents
+type CPFP struct {
+	Share  float64  `json:"share"`  // share of the parents below the fee rate getting a child
+	Below  float64  `json:"below"`  // parents fee rate
+	Target float64  `json:"target"` // package fee rate
+	Delay  Duration `json:"delay"`  // mean time to the child
+}
+
+// time window with the changed traffic, a fee spike or an inscription wave.
+// Unset fields keep the scenario values
+type Phase struct {
+	Name     string      `json:"name"`
+	At       Duration    `json:"at"` // since the start
+	Duration Duration    `json:"duration"`
+	TxRate   float64     `json:"tx_rate"` // multiplier
+	FeeRate  []Component `json:"fee_rate"`
+	Vsize    []Component `json:"vsize"`
+	Segwit   float64     `json:"segwit"`
+	Taproot  float64     `json:"taproot"`
+}
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// load the scenario from the file, or the bundled one by name
+func Load(name string) (*Scenario, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		data, err = embedded.ReadFile(path.Join("scenarios", name+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("no scenario file or bundled scenario %s", name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error on parsing scenario %s: %w", name, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", name, err)
+	}
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	switch {
+	case s.BlockInterval <= 0:
+		return errors.New("block_interval should be positive")
+	case s.BlockWeight == 0:
+		return errors.New("block_weight should be positive")
+	case s.TxRate <= 0:
+		return errors.New("tx_rate should be positive")
+	case len(s.FeeRate) == 0 || len(s.Vsize) == 0:
+		return errors.New("fee_rate and vsize distributions are required")
+	case s.Height < s.Blocks:
+		return errors.New("height should not be less than blocks")
+	}
+	for _, p := range s.Phases {
+		if p.Duration <= 0 {
+			return fmt.Errorf("phase %s duration should be positive", p.Name)
+		}
+	}
+	return nil
+}
+
+// traffic parameters at the time since the start, phases override in order
+type params struct {
+	txRate  float64
+	feeRate []Component
+	vsize   []Component
+	segwit  float64
+	taproot float64
+}
+
+func (s *Scenario) params(since time.Duration) params {
+	p := params{
+		txRate:  s.TxRate,
+		feeRate: s.FeeRate,
+		vsize:   s.Vsize,
+		segwit:  s.Segwit,
+		taproot: s.Taproot,
+	}
+	for _, ph := range s.Phases {
+		if since < time.Duration(ph.At) || since >= time.Duration(ph.At+ph.Duration) {
+			continue
+		}
+		if ph.TxRate > 0 {
+			p.txRate *= ph.TxRate
+		}
+		if len(ph.FeeRate) > 0 {
+			p.feeRate = ph.FeeRate
+		}
+		if len(ph.Vsize) > 0 {
+			p.vsize = ph.Vsize
+		}
+		if ph.Segwit > 0 {
+			p.segwit = ph.Segwit
+		}
+		if ph.Taproot > 0 {
+			p.taproot = ph.Taproot
+		}
+	}
+	return p
+}
@@ -0,0 +1,405 @@
+// Package sim is a synthetic bitcoin node for load testing and end to end runs without a node.
+//
+// Txs arrive as a poisson process with fee rates and sizes drawn from the scenario distributions,
+// blocks are found as a poisson process and filled by the ancestor fee rate like the node does.
+// The simulation runs on its own clock, the same scenario and seed give the same txs and blocks
+// for the same simulated time.
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// mined blocks kept with their txs
+const keepBlocks = 144
+
+type outpoint struct {
+	txid string
+	vout int
+}
+
+type simTx struct {
+	txid     string
+	time     time.Time // simulated
+	size     uint32
+	weight   uint32
+	vsize    uint32
+	fee      uint64
+	inputs   []outpoint
+	outputs  []uint64 // sat
+	segwit   bool
+	taproot  bool
+	rbf      bool     // signals replaceability
+	parents  []string // unconfirmed parents at creation
+	children []string // spend the outputs in order
+	coinbase string   // miner tag, coinbase only
+	block    *simBlock
+}
+
+func (t *simTx) rate() float64 {
+	return float64(t.fee) / float64(t.vsize)
+}
+
+type simBlock struct {
+	hash   string
+	prev   string
+	height int
+	time   time.Time
+	txs    []*simTx // coinbase first
+	size   uint64
+	weight uint64
+}
+
+type eventKind int
+
+const (
+	evArrival eventKind = iota
+	evBlock
+	evRBF
+	evCPFP
+)
+
+type event struct {
+	at   time.Time
+	seq  uint64
+	kind eventKind
+	txid string
+}
+
+// earliest first, ties in the scheduling order
+type events []event
+
+func (e events) Len() int { return len(e) }
+func (e events) Less(i, j int) bool {
+	if e[i].at.Equal(e[j].at) {
+		return e[i].seq < e[j].seq
+	}
+	return e[i].at.Before(e[j].at)
+}
+func (e events) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e *events) Push(x any)   { *e = append(*e, x.(event)) }
+func (e *events) Pop() any {
+	old := *e
+	x := old[len(old)-1]
+	*e = old[:len(old)-1]
+	return x
+}
+
+type Sim struct {
+	sc *Scenario
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	epoch  time.Time // start of the simulation, simulated and wall
+	now    time.Time // simulated clock
+	speed  float64
+	seq    uint64
+	events events
+
+	pool   map[string]*simTx
+	order  []*simTx          // pool arrival order, compacted lazily
+	txs    map[string]*simTx // pool and kept blocks txs
+	blocks map[string]*simBlock
+	chain  []*simBlock // kept blocks, oldest first
+}
+
+// create the chain with the scenario blocks and the pool, the clock starts at now
+func New(sc *Scenario, now time.Time) *Sim {
+	s := &Sim{
+		sc:     sc,
+		rng:    rand.New(rand.NewSource(sc.Seed)),
+		epoch:  now,
+		now:    now,
+		speed:  1,
+		pool:   make(map[string]*simTx),
+		txs:    make(map[string]*simTx),
+		blocks: make(map[string]*simBlock),
+	}
+	interval := time.Duration(sc.BlockInterval)
+	p := sc.params(0)
+
+	// past blocks, filled with the txs arrived during the interval before each
+	prev := s.hash(true)
+	for i := 0; i < sc.Blocks; i++ {
+		at := now.Add(-time.Duration(sc.Blocks-1-i) * interval)
+		var weight uint64
+		for weight < sc.BlockWeight*95/100 {
+			t := s.newTx(p, at.Add(-time.Duration(s.rng.Int63n(int64(interval)))))
+			s.addTx(t)
+			weight += uint64(t.weight)
+		}
+		b := s.mine(at, prev, sc.Height-sc.Blocks+1+i)
+		prev = b.hash
+	}
+
+	// pool, arrived during the last hour
+	for i := 0; i < sc.Pool; i++ {
+		at := now.Add(-time.Hour + time.Duration(i)*time.Hour/time.Duration(max(sc.Pool, 1)))
+		s.arrive(p, at)
+	}
+
+	s.schedule(now.Add(s.exp(1/p.txRate)), evArrival, "")
+	s.schedule(now.Add(s.exp(interval.Seconds())), evBlock, "")
+	return s
+}
+
+// run the clock at the speed factor until ctx is done
+func (s *Sim) Start(ctx context.Context, speed float64) {
+	s.mu.Lock()
+	s.speed = speed
+	s.mu.Unlock()
+	go func() {
+		start := time.Now()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				elapsed := time.Duration(float64(time.Since(start)) * speed)
+				s.AdvanceTo(s.epoch.Add(elapsed))
+			}
+		}
+	}()
+}
+
+// move the simulated clock by d
+func (s *Sim) Advance(d time.Duration) {
+	s.mu.Lock()
+	to := s.now.Add(d)
+	s.mu.Unlock()
+	s.AdvanceTo(to)
+}
+
+// process everything scheduled until the simulated time
+func (s *Sim) AdvanceTo(to time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.events) > 0 && !s.events[0].at.After(to) {
+		e := heap.Pop(&s.events).(event)
+		s.now = e.at
+		switch e.kind {
+		case evArrival:
+			p := s.sc.params(e.at.Sub(s.epoch))
+			s.arrive(p, e.at)
+			s.schedule(e.at.Add(s.exp(1/p.txRate)), evArrival, "")
+		case evBlock:
+			s.expire(e.at)
+			tip := s.chain[len(s.chain)-1]
+			s.mine(e.at, tip.hash, tip.height+1)
+			s.schedule(e.at.Add(s.exp(time.Duration(s.sc.BlockInterval).Seconds())), evBlock, "")
+		case evRBF:
+			s.replace(e.txid, e.at)
+		case evCPFP:
+			s.child(e.txid, e.at)
+		}
+	}
+	if to.After(s.now) {
+		s.now = to
+	}
+}
+
+// wall time of the simulated time, the simulation looks live at any speed
+func (s *Sim) wall(t time.Time) time.Time {
+	return s.epoch.Add(time.Duration(float64(t.Sub(s.epoch)) / s.speed))
+}
+
+func (s *Sim) schedule(at time.Time, kind eventKind, txid string) {
+	s.seq++
+	heap.Push(&s.events, event{at: at, seq: s.seq, kind: kind, txid: txid})
+}
+
+// exponentially distributed duration with the mean in seconds
+func (s *Sim) exp(mean float64) time.Duration {
+	return time.Duration(s.rng.ExpFloat64() * mean * float64(time.Second))
+}
+
+func (s *Sim) draw(mix []Component) float64 {
+	var total float64
+	for _, c := range mix {
+		total += c.Weight
+	}
+	r := s.rng.Float64() * total
+	c := mix[len(mix)-1]
+	for _, m := range mix {
+		if r < m.Weight {
+			c = m
+			break
+		}
+		r -= m.Weight
+	}
+	v := c.Median * math.Exp(c.Sigma*s.rng.NormFloat64())
+	if v < c.Min {
+		v = c.Min
+	}
+	if c.Max > 0 && v > c.Max {
+		v = c.Max
+	}
+	return v
+}
+
+// random hash, block hashes start with zeros
+func (s *Sim) hash(block bool) string {
+	var b [32]byte
+	s.rng.Read(b[:])
+	if block {
+		b[0], b[1], b[2], b[3] = 0, 0, 0, 0
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// new tx from the distributions, not added to the pool
+func (s *Sim) newTx(p params, at time.Time) *simTx {
+	vsize := uint32(s.draw(p.vsize))
+	t := &simTx{
+		txid:    s.hash(false),
+		time:    at,
+		vsize:   vsize,
+		segwit:  s.rng.Float64() < p.segwit,
+		taproot: s.rng.Float64() < p.taproot,
+	}
+	t.taproot = t.taproot && t.segwit
+	s.shape(t)
+	t.fee = uint64(s.draw(p.feeRate) * float64(vsize))
+	ins := 1 + int(float64(vsize)/300*s.rng.Float64())
+	for i := 0; i < ins; i++ {
+		t.inputs = append(t.inputs, outpoint{txid: s.hash(false), vout: s.rng.Intn(3)})
+	}
+	outs := 1 + s.rng.Intn(2)
+	for i := 0; i < outs; i++ {
+		t.outputs = append(t.outputs, uint64(1e6*math.Exp(1.5*s.rng.NormFloat64()))+546)
+	}
+	return t
+}
+
+// size and weight from the vsize, witness data is discounted
+func (s *Sim) shape(t *simTx) {
+	t.weight = t.vsize * 4
+	t.size = t.vsize
+	if t.segwit {
+		witness := t.vsize / 2
+		t.size = (t.weight-witness)/4 + witness
+	}
+}
+
+// new tx arrived, maybe spending an unconfirmed output and scheduled for a bump
+func (s *Sim) arrive(p params, at time.Time) {
+	t := s.newTx(p, at)
+	if len(s.order) > 0 && s.rng.Float64() < s.sc.Chain {
+		parent := s.order[len(s.order)-1-s.rng.Intn(min(len(s.order), 100))]
+		if s.pool[parent.txid] == parent && len(parent.children) < len(parent.outputs) {
+			t.inputs[0] = outpoint{txid: parent.txid, vout: len(parent.children)}
+			t.parents = []string{parent.txid}
+		}
+	}
+	t.rbf = s.rng.Float64() < s.sc.RBF.Signal
+	s.addTx(t)
+	if s.rng.Float64() < s.sc.RBF.Share {
+		t.rbf = true
+		s.schedule(at.Add(s.exp(time.Duration(s.sc.RBF.Delay).Seconds())), evRBF, t.txid)
+	}
+	if t.rate() < s.sc.CPFP.Below && s.rng.Float64() < s.sc.CPFP.Share {
+		s.schedule(at.Add(s.exp(time.Duration(s.sc.CPFP.Delay).Seconds())), evCPFP, t.txid)
+	}
+}
+
+func (s *Sim) addTx(t *simTx) {
+	s.pool[t.txid] = t
+	s.txs[t.txid] = t
+	s.order = append(s.order, t)
+	for _, p := range t.parents {
+		if parent, ok := s.pool[p]; ok {
+			parent.children = append(parent.children, t.txid)
+		}
+	}
+}
+
+// drop the tx with its descendants from the pool
+func (s *Sim) removeTx(txid string) {
+	t, ok := s.pool[txid]
+	if !ok {
+		return
+	}
+	delete(s.pool, txid)
+	delete(s.txs, txid)
+	for _, c := range t.children {
+		s.removeTx(c)
+	}
+	s.compact()
+}
+
+// drop the txs left the pool from the arrival order
+func (s *Sim) compact() {
+	if len(s.order) < 2*len(s.pool)+1000 {
+		return
+	}
+	order := make([]*simTx, 0, len(s.pool))
+	for _, o := range s.order {
+		if s.pool[o.txid] == o {
+			order = append(order, o)
+		}
+	}
+	s.order = order
+}
+
+// replace by fee, same inputs with the higher fee
+func (s *Sim) replace(txid string, at time.Time) {
+	orig, ok := s.pool[txid]
+	if !ok {
+		return
+	}
+	t := &simTx{
+		txid:    s.hash(false),
+		time:    at,
+		vsize:   orig.vsize,
+		segwit:  orig.segwit,
+		taproot: orig.taproot,
+		rbf:     true,
+		inputs:  orig.inputs,
+		outputs: orig.outputs,
+		parents: orig.parents,
+	}
+	s.shape(t)
+	// incremental relay fee is 1 sat/vB
+	t.fee = max(uint64(float64(orig.fee)*s.sc.RBF.Bump), orig.fee+uint64(orig.vsize))
+	s.removeTx(txid)
+	s.addTx(t)
+}
+
+// child paying for the parent still in the pool
+func (s *Sim) child(txid string, at time.Time) {
+	parent, ok := s.pool[txid]
+	if !ok || len(parent.children) >= len(parent.outputs) {
+		return
+	}
+	p := s.sc.params(at.Sub(s.epoch))
+	t := s.newTx(p, at)
+	t.vsize = min(t.vsize, 300)
+	s.shape(t)
+	t.inputs = []outpoint{{txid: parent.txid, vout: len(parent.children)}}
+	t.parents = []string{parent.txid}
+	target := s.sc.CPFP.Target * float64(parent.vsize+t.vsize)
+	t.fee = max(uint64(target)-min(uint64(target), parent.fee), uint64(s.sc.CPFP.Target*float64(t.vsize)))
+	s.addTx(t)
+}
+
+// drop the txs stayed in the pool for too long
+func (s *Sim) expire(at time.Time) {
+	if s.sc.Expiry <= 0 {
+		return
+	}
+	cut := at.Add(-time.Duration(s.sc.Expiry))
+	for _, t := range s.order {
+		if !t.time.Before(cut) {
+			break
+		}
+		s.removeTx(t.txid)
+	}
+}
@@ -0,0 +1,245 @@
+package sim
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+)
+
+var testStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// bundled scenario with a small pool and slow traffic to keep the tests fast
+func testScenario(t *testing.T, name string) *Scenario {
+	t.Helper()
+	sc, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Pool = 300
+	sc.TxRate = 0.5
+	return sc
+}
+
+type simRun struct {
+	pool   []string
+	blocks []string
+	txs    [][]string // per block
+}
+
+func runSim(t *testing.T, sc *Scenario, steps int, d time.Duration) simRun {
+	t.Helper()
+	s := New(sc, testStart)
+	for i := 0; i < steps; i++ {
+		s.Advance(d / time.Duration(steps))
+	}
+	var ret simRun
+	pool, err := s.RawMempool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pool {
+		ret.pool = append(ret.pool, p.Txid)
+	}
+	for _, b := range s.chain {
+		block, err := s.GetBlock(b.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret.blocks = append(ret.blocks, block.Hash)
+		ret.txs = append(ret.txs, block.Transactions)
+	}
+	return ret
+}
+
+func TestDeterministic(t *testing.T) {
+	sc := testScenario(t, "default")
+	want := runSim(t, sc, 1, 2*time.Hour)
+	if len(want.blocks) <= sc.Blocks || len(want.pool) == 0 {
+		t.Fatalf("nothing happened: %d blocks, %d pool txs", len(want.blocks), len(want.pool))
+	}
+	tests := []struct {
+		name  string
+		seed  int64
+		steps int
+		same  bool
+	}{
+		{"same seed", sc.Seed, 1, true},
+		// the clock ticks of a running node give the same result
+		{"same seed in steps", sc.Seed, 720, true},
+		{"another seed", sc.Seed + 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := testScenario(t, "default")
+			sc.Seed = tt.seed
+			got := runSim(t, sc, tt.steps, 2*time.Hour)
+			if reflect.DeepEqual(got, want) != tt.same {
+				t.Fatalf("same txs and blocks: %v, want %v", !tt.same, tt.same)
+			}
+		})
+	}
+}
+
+func TestMineByFeeRate(t *testing.T) {
+	sc := testScenario(t, "default")
+	sc.Blocks, sc.Pool = 0, 0
+	// room for three 100 vB txs
+	sc.BlockWeight = coinbaseWeight + 3*400
+	s := New(sc, testStart)
+	tx := func(name string, vsize uint32, rate uint64, parent string) *simTx {
+		t := &simTx{txid: name, time: testStart, vsize: vsize, fee: rate * uint64(vsize), outputs: []uint64{1000}}
+		if parent != "" {
+			t.parents = []string{parent}
+		}
+		s.shape(t)
+		s.addTx(t)
+		return t
+	}
+	tx("a", 100, 10, "")
+	tx("b", 100, 5, "")
+	// child pays for the parent, the package rate is 15.5
+	tx("parent", 100, 1, "")
+	tx("child", 100, 30, "parent")
+	// the best rate does not fit
+	tx("big", 1000, 20, "")
+
+	b := s.mine(testStart, "prev", 1)
+	got := make([]string, 0)
+	for _, t := range b.txs[1:] {
+		got = append(got, t.txid)
+	}
+	if want := []string{"parent", "child", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("block txs: got %v, want %v", got, want)
+	}
+	left := make([]string, 0)
+	for txid := range s.pool {
+		left = append(left, txid)
+	}
+	sort.Strings(left)
+	if want := []string{"b", "big"}; !reflect.DeepEqual(left, want) {
+		t.Fatalf("pool: got %v, want %v", left, want)
+	}
+	// subsidy and the fees of the block txs
+	if cb := b.txs[0]; cb.coinbase == "" || cb.outputs[0] != mblock.Subsidy(1)+100+3000+1000 {
+		t.Fatalf("coinbase: tag %q, output %d", cb.coinbase, cb.outputs[0])
+	}
+}
+
+// txs arrived in [from, to) since the start, in the pool or mined
+func arrived(s *Sim, from, to time.Duration) []*simTx {
+	ret := make([]*simTx, 0)
+	for _, t := range s.txs {
+		since := t.time.Sub(testStart)
+		if t.coinbase == "" && since >= from && since < to {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+func inputsKey(t *simTx) string {
+	parts := make([]string, 0, len(t.inputs))
+	for _, in := range t.inputs {
+		parts = append(parts, fmt.Sprintf("%s:%d", in.txid, in.vout))
+	}
+	return strings.Join(parts, ",")
+}
+
+func TestRBF(t *testing.T) {
+	sc := testScenario(t, "default")
+	sc.RBF = RBF{Share: 0.5, Delay: Duration(time.Minute), Bump: 1.5}
+	sc.CPFP.Share = 0
+	s := New(sc, testStart)
+	before := make(map[string]*simTx)
+	for _, tx := range s.pool {
+		before[inputsKey(tx)] = tx
+	}
+	s.Advance(10 * time.Minute)
+
+	replaced := 0
+	for _, tx := range s.txs {
+		orig, ok := before[inputsKey(tx)]
+		if !ok || orig.txid == tx.txid {
+			continue
+		}
+		replaced++
+		if !tx.rbf || tx.fee < orig.fee+uint64(orig.vsize) {
+			t.Fatalf("replacement %s: rbf %v, fee %d of %d", tx.txid, tx.rbf, tx.fee, orig.fee)
+		}
+		if _, ok := s.txs[orig.txid]; ok {
+			t.Fatalf("replaced %s is kept", orig.txid)
+		}
+	}
+	if replaced == 0 {
+		t.Fatal("no replacements")
+	}
+}
+
+func TestCPFP(t *testing.T) {
+	sc := testScenario(t, "default")
+	sc.RBF.Share = 0
+	sc.CPFP = CPFP{Share: 1, Below: 3, Target: 15, Delay: Duration(time.Minute)}
+	s := New(sc, testStart)
+	s.Advance(30 * time.Minute)
+
+	packages := 0
+	for _, child := range s.txs {
+		if len(child.parents) == 0 {
+			continue
+		}
+		parent, ok := s.txs[child.parents[0]]
+		if !ok || parent.rate() >= sc.CPFP.Below {
+			continue
+		}
+		rate := float64(parent.fee+child.fee) / float64(parent.vsize+child.vsize)
+		if rate < sc.CPFP.Target*0.99 {
+			continue
+		}
+		packages++
+		// mined together or the parent first
+		if child.block != nil && (parent.block == nil || parent.block.height > child.block.height) {
+			t.Fatalf("child %s mined without the parent %s", child.txid, parent.txid)
+		}
+	}
+	if packages == 0 {
+		t.Fatal("no cpfp packages")
+	}
+}
+
+func TestSpike(t *testing.T) {
+	sc := testScenario(t, "inscriptions")
+	s := New(sc, testStart)
+	s.Advance(90 * time.Minute)
+
+	medianRate := func(txs []*simTx) float64 {
+		rates := make([]float64, len(txs))
+		for i, t := range txs {
+			rates[i] = t.rate()
+		}
+		sort.Float64s(rates)
+		return rates[len(rates)/2]
+	}
+	// before the wave and within it, both half an hour
+	calm := arrived(s, 0, 30*time.Minute)
+	wave := arrived(s, 45*time.Minute, 75*time.Minute)
+	if len(calm) == 0 || len(wave) < 3*len(calm) {
+		t.Fatalf("arrivals: %d calm, %d in the wave", len(calm), len(wave))
+	}
+	if c, w := medianRate(calm), medianRate(wave); w < 3*c {
+		t.Fatalf("median fee rate: %.1f calm, %.1f in the wave", c, w)
+	}
+	taproot := 0
+	for _, t := range wave {
+		if t.taproot {
+			taproot++
+		}
+	}
+	if taproot < len(wave)/2 {
+		t.Fatalf("taproot txs in the wave: %d of %d", taproot, len(wave))
+	}
+}
@@ -0,0 +1,618 @@
+package storage_bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// buckets layout
+//
+//	txs         txid -> added time + tx json
+//	txs_added   added time + txid, for the tx ttl
+//	confirmed   txid -> block hash
+//	evicted     txid -> eviction time
+//	evicted_at  eviction time + txid, for the grace period
+//	blocks      hash -> block txids json
+//	blockstats  hash -> processed block json
+//	audits      hash -> block audit json
+//	history     series sub buckets, time -> values json
+//
+// times are unix nanoseconds, big endian to keep the order
+var (
+	bucketTxs        = []byte("txs")
+	bucketTxsAdded   = []byte("txs_added")
+	bucketConfirmed  = []byte("confirmed")
+	bucketEvicted    = []byte("evicted")
+	bucketEvictedAt  = []byte("evicted_at")
+	bucketBlocks     = []byte("blocks")
+	bucketBlockStats = []byte("blockstats")
+	bucketAudits     = []byte("audits")
+	bucketHistory    = []byte("history")
+)
+
+const (
+	// compact on close if more than half of the file is free and the file is big enough
+	compactMinSize = 64 * 1024 * 1024
+	compactTxSize  = 64 * 1024 * 1024
+	openTimeout    = 5 * time.Second
+	// txs read per transaction on iteration
+	iterateChunk = 1000
+)
+
+type Bolt struct {
+	db        *bolt.DB
+	path      string
+	retention storage.Retention
+	// dropped txs counters
+	prunedConfirmed atomic.Uint64
+	prunedEvicted   atomic.Uint64
+	prunedTTL       atomic.Uint64
+}
+
+func New(path string, r storage.Retention) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error on opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketTxs, bucketTxsAdded, bucketConfirmed, bucketEvicted, bucketEvictedAt,
+			bucketBlocks, bucketBlockStats, bucketAudits, bucketHistory} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Bolt{db: db, path: path, retention: r}, nil
+}
+
+func (b *Bolt) TxGet(txid string) (*tx.Tx, error) {
+	var ret *tx.Tx
+	err := b.db.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(bucketTxs).Get([]byte(txid))
+		if v == nil {
+			return nil
+		}
+		var t tx.Tx
+		if err := json.Unmarshal(v[8:], &t); err != nil {
+			return err
+		}
+		ret = &t
+		return nil
+	})
+	return ret, err
+}
+
+func (b *Bolt) TxGetMany(txids []string) (map[string]tx.Tx, error) {
+	ret := make(map[string]tx.Tx, len(txids))
+	err := b.db.View(func(btx *bolt.Tx) error {
+		txs := btx.Bucket(bucketTxs)
+		for _, txid := range txids {
+			v := txs.Get([]byte(txid))
+			if v == nil {
+				continue
+			}
+			var t tx.Tx
+			if err := json.Unmarshal(v[8:], &t); err != nil {
+				return err
+			}
+			ret[txid] = t
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// concurrent adds from the parsers are committed together
+func (b *Bolt) TxAdd(t tx.Tx) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return b.db.Batch(func(btx *bolt.Tx) error {
+		return putTx(btx, t.Hash, data, now)
+	})
+}
+
+func (b *Bolt) TxAddMany(txs []tx.Tx) error {
+	data := make([][]byte, len(txs))
+	for i, t := range txs {
+		d, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		data[i] = d
+	}
+	now := time.Now()
+	return b.db.Update(func(btx *bolt.Tx) error {
+		for i, t := range txs {
+			if err := putTx(btx, t.Hash, data[i], now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) TxEvict(txids []string) error {
+	now := time.Now()
+	return b.db.Update(func(btx *bolt.Tx) error {
+		confirmed := btx.Bucket(bucketConfirmed)
+		evicted := btx.Bucket(bucketEvicted)
+		evictedAt := btx.Bucket(bucketEvictedAt)
+		at := timeKey(now)
+		for _, txid := range txids {
+			key := []byte(txid)
+			// mined
+			if confirmed.Get(key) != nil {
+				continue
+			}
+			if old := evicted.Get(key); old != nil {
+				if err := evictedAt.Delete(append(append([]byte(nil), old...), key...)); err != nil {
+					return err
+				}
+			}
+			if err := evicted.Put(key, at); err != nil {
+				return err
+			}
+			if err := evictedAt.Put(append(append([]byte(nil), at...), key...), nil); err != nil {
+				return err
+			}
+		}
+		return b.prune(btx, now)
+	})
+}
+
+func (b *Bolt) TxDelete(txids []string) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		evicted := btx.Bucket(bucketEvicted)
+		evictedAt := btx.Bucket(bucketEvictedAt)
+		for _, txid := range txids {
+			key := []byte(txid)
+			if at := evicted.Get(key); at != nil {
+				if err := evictedAt.Delete(append(append([]byte(nil), at...), key...)); err != nil {
+					return err
+				}
+				if err := evicted.Delete(key); err != nil {
+					return err
+				}
+			}
+			if _, err := deleteTx(btx, txid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// read in chunks, fn is called outside of the db transaction
+func (b *Bolt) TxIterate(fn func(t tx.Tx) bool) error {
+	var after []byte
+	for {
+		chunk := make([]tx.Tx, 0, iterateChunk)
+		err := b.db.View(func(btx *bolt.Tx) error {
+			c := btx.Bucket(bucketTxs).Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(chunk) < iterateChunk; k, v = c.Next() {
+				var t tx.Tx
+				if err := json.Unmarshal(v[8:], &t); err != nil {
+					return err
+				}
+				chunk = append(chunk, t)
+				after = append(after[:0], k...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range chunk {
+			if !fn(t) {
+				return nil
+			}
+		}
+		if len(chunk) < iterateChunk {
+			return nil
+		}
+	}
+}
+
+func (b *Bolt) BlockExists(hash string) (bool, error) {
+	var ok bool
+	err := b.db.View(func(btx *bolt.Tx) error {
+		ok = btx.Bucket(bucketBlocks).Get([]byte(hash)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+func (b *Bolt) BlockGet(hash string) ([]string, error) {
+	var txs []string
+	err := b.db.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(bucketBlocks).Get([]byte(hash))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &txs)
+	})
+	return txs, err
+}
+
+func (b *Bolt) BlockList() ([]string, error) {
+	ret := make([]string, 0)
+	err := b.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketBlocks).ForEach(func(k, _ []byte) error {
+			ret = append(ret, string(k))
+			return nil
+		})
+	})
+	return ret, err
+}
+
+func (b *Bolt) BlockAdd(hash string, txs []string) error {
+	data, err := json.Marshal(txs)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(btx *bolt.Tx) error {
+		if err := btx.Bucket(bucketBlocks).Put([]byte(hash), data); err != nil {
+			return err
+		}
+		confirmed := btx.Bucket(bucketConfirmed)
+		evicted := btx.Bucket(bucketEvicted)
+		evictedAt := btx.Bucket(bucketEvictedAt)
+		for _, txid := range txs {
+			key := []byte(txid)
+			if err := confirmed.Put(key, []byte(hash)); err != nil {
+				return err
+			}
+			// evicted from the pool because it was mined
+			if at := evicted.Get(key); at != nil {
+				if err := evictedAt.Delete(append(append([]byte(nil), at...), key...)); err != nil {
+					return err
+				}
+				if err := evicted.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) BlockRemove(hash string) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		key := []byte(hash)
+		var txs []string
+		if v := btx.Bucket(bucketBlocks).Get(key); v != nil {
+			if err := json.Unmarshal(v, &txs); err != nil {
+				return err
+			}
+		}
+		confirmed := btx.Bucket(bucketConfirmed)
+		for _, txid := range txs {
+			// tx can be in another block after reorg
+			if !bytes.Equal(confirmed.Get([]byte(txid)), key) {
+				continue
+			}
+			if err := confirmed.Delete([]byte(txid)); err != nil {
+				return err
+			}
+			ok, err := deleteTx(btx, txid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				b.prunedConfirmed.Add(1)
+			}
+		}
+		return btx.Bucket(bucketBlocks).Delete(key)
+	})
+}
+
+func (b *Bolt) BlockStatsRemove(hash string) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketBlockStats, bucketAudits} {
+			if err := btx.Bucket(name).Delete([]byte(hash)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) BlockStatsAdd(blk block.Block) error {
+	data, err := json.Marshal(blk)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketBlockStats).Put([]byte(blk.Hash), data)
+	})
+}
+
+func (b *Bolt) BlockStatsList() ([]block.Block, error) {
+	ret := make([]block.Block, 0)
+	err := b.db.View(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketBlockStats).ForEach(func(_, v []byte) error {
+			var blk block.Block
+			if err := json.Unmarshal(v, &blk); err != nil {
+				return err
+			}
+			ret = append(ret, blk)
+			return nil
+		})
+	})
+	return ret, err
+}
+
+func (b *Bolt) AuditGet(hash string) (*block.Audit, error) {
+	var ret *block.Audit
+	err := b.db.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(bucketAudits).Get([]byte(hash))
+		if v == nil {
+			return nil
+		}
+		var a block.Audit
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		ret = &a
+		return nil
+	})
+	return ret, err
+}
+
+func (b *Bolt) AuditAdd(a block.Audit) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketAudits).Put([]byte(a.Hash), data)
+	})
+}
+
+func (b *Bolt) HistoryAdd(series string, points []storage.Point) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		bucket, err := btx.Bucket(bucketHistory).CreateBucketIfNotExists([]byte(series))
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			data, err := json.Marshal(p.Values)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(timeKey(p.Time), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) HistoryRange(series string, from, to time.Time) ([]storage.Point, error) {
+	ret := make([]storage.Point, 0)
+	err := b.db.View(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(bucketHistory).Bucket([]byte(series))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		end := timeKey(to)
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			p := storage.Point{Time: keyTime(k)}
+			if err := json.Unmarshal(v, &p.Values); err != nil {
+				return err
+			}
+			ret = append(ret, p)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (b *Bolt) HistoryTrim(series string, before time.Time) error {
+	return b.db.Update(func(btx *bolt.Tx) error {
+		bucket := btx.Bucket(bucketHistory).Bucket([]byte(series))
+		if bucket == nil {
+			return nil
+		}
+		return deleteBefore(bucket, timeKey(before), nil)
+	})
+}
+
+func (b *Bolt) Stats() storage.Stats {
+	log := logger.Log.WithField("context", "[bolt]")
+	ret := storage.Stats{
+		PrunedConfirmed: b.prunedConfirmed.Load(),
+		PrunedEvicted:   b.prunedEvicted.Load(),
+		PrunedTTL:       b.prunedTTL.Load(),
+	}
+	err := b.db.View(func(btx *bolt.Tx) error {
+		ret.Txs = btx.Bucket(bucketTxs).Stats().KeyN
+		ret.Confirmed = btx.Bucket(bucketConfirmed).Stats().KeyN
+		ret.Evicted = btx.Bucket(bucketEvicted).Stats().KeyN
+		ret.Blocks = btx.Bucket(bucketBlocks).Stats().KeyN
+		ret.Audits = btx.Bucket(bucketAudits).Stats().KeyN
+		ret.DiskBytes = uint64(btx.Size())
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error on stats: %v\n", err)
+	}
+	return ret
+}
+
+// close the db and compact the file if it's mostly free pages
+func (b *Bolt) Close() error {
+	log := logger.Log.WithField("context", "[bolt]")
+	var size int64
+	_ = b.db.View(func(btx *bolt.Tx) error {
+		size = btx.Size()
+		return nil
+	})
+	st := b.db.Stats()
+	free := int64(st.FreePageN+st.PendingPageN) * int64(b.db.Info().PageSize)
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	if size < compactMinSize || free*2 < size {
+		return nil
+	}
+	now := time.Now()
+	if err := compact(b.path); err != nil {
+		return fmt.Errorf("error on compaction: %w", err)
+	}
+	log.Infof("compacted %s, %d MB free, took %s\n", b.path, free/1024/1024, time.Since(now))
+	return nil
+}
+
+// drop txs evicted longer than the grace period ago and txs older than the ttl
+// must be called within the update
+func (b *Bolt) prune(btx *bolt.Tx, now time.Time) error {
+	evicted := btx.Bucket(bucketEvicted)
+	err := deleteBefore(btx.Bucket(bucketEvictedAt), timeKey(now.Add(-b.retention.EvictedGrace)), func(k []byte) error {
+		txid := k[8:]
+		// evicted again later
+		if !bytes.Equal(evicted.Get(txid), k[:8]) {
+			return nil
+		}
+		if err := evicted.Delete(txid); err != nil {
+			return err
+		}
+		ok, err := deleteTx(btx, string(txid))
+		if ok {
+			b.prunedEvicted.Add(1)
+		}
+		return err
+	})
+	if err != nil || b.retention.TxTTL == 0 {
+		return err
+	}
+	txs := btx.Bucket(bucketTxs)
+	confirmed := btx.Bucket(bucketConfirmed)
+	return deleteBefore(btx.Bucket(bucketTxsAdded), timeKey(now.Add(-b.retention.TxTTL)), func(k []byte) error {
+		txid := k[8:]
+		v := txs.Get(txid)
+		// deleted and added again later
+		if v == nil || !bytes.Equal(v[:8], k[:8]) {
+			return nil
+		}
+		// dropped with the block
+		if confirmed.Get(txid) != nil {
+			return nil
+		}
+		if err := txs.Delete(txid); err != nil {
+			return err
+		}
+		b.prunedTTL.Add(1)
+		return nil
+	})
+}
+
+// keeps the first added time for the ttl
+// must be called within the update
+func putTx(btx *bolt.Tx, txid string, data []byte, now time.Time) error {
+	txs := btx.Bucket(bucketTxs)
+	key := []byte(txid)
+	added := timeKey(now)
+	if old := txs.Get(key); old != nil {
+		added = append([]byte(nil), old[:8]...)
+	} else if err := btx.Bucket(bucketTxsAdded).Put(append(added, key...), nil); err != nil {
+		return err
+	}
+	return txs.Put(key, append(added[:8:8], data...))
+}
+
+// must be called within the update
+func deleteTx(btx *bolt.Tx, txid string) (bool, error) {
+	txs := btx.Bucket(bucketTxs)
+	v := txs.Get([]byte(txid))
+	if v == nil {
+		return false, nil
+	}
+	if err := btx.Bucket(bucketTxsAdded).Delete(append(append([]byte(nil), v[:8]...), txid...)); err != nil {
+		return false, err
+	}
+	return true, txs.Delete([]byte(txid))
+}
+
+// delete keys less than end from the time ordered bucket, fn is called for every key before deletion
+func deleteBefore(bucket *bolt.Bucket, end []byte, fn func(k []byte) error) error {
+	keys := make([][]byte, 0)
+	c := bucket.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if fn != nil {
+			if err := fn(k); err != nil {
+				return err
+			}
+		}
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy the live data to a new file and replace the old one
+func compact(path string) error {
+	tmp := path + ".compact"
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, src, compactTxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	src.Close()
+	return os.Rename(tmp, path)
+}
+
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+}
@@ -0,0 +1,183 @@
+package storage_bolt
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/storage/storagetest"
+)
+
+var _ storage.HistoryRepository = (*Bolt)(nil)
+
+func newTestBolt(t *testing.T, path string, r storage.Retention) *Bolt {
+	t.Helper()
+	s, err := New(path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func stored(t *testing.T, s *Bolt, txid string) bool {
+	t.Helper()
+	got, err := s.TxGet(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got != nil
+}
+
+// data is kept between reopens, the block removal keeps its stats and audit
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feesh.db")
+	s := newTestBolt(t, path, storage.Retention{})
+	txids := []string{"tx1", "tx2"}
+	for _, txid := range txids {
+		if err := s.TxAdd(tx.Tx{Hash: txid, Fee: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BlockAdd("b1", txids); err != nil {
+		t.Fatal(err)
+	}
+	b := block.Block{Hash: "b1", Height: 1, Txs: 2}
+	if err := s.BlockStatsAdd(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AuditAdd(block.Audit{Hash: "b1", Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestBolt(t, path, storage.Retention{})
+	defer s.Close()
+	if got, err := s.BlockGet("b1"); err != nil || !reflect.DeepEqual(got, txids) {
+		t.Fatalf("block txs after reopen: %v, %v", got, err)
+	}
+	if got, err := s.TxGet("tx1"); err != nil || got == nil || got.Fee != 100 {
+		t.Fatalf("tx after reopen: %v, %v", got, err)
+	}
+
+	if err := s.BlockRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, txid := range txids {
+		if stored(t, s, txid) {
+			t.Errorf("tx %s of the removed block is stored", txid)
+		}
+	}
+	if got, err := s.BlockStatsList(); err != nil || !reflect.DeepEqual(got, []block.Block{b}) {
+		t.Fatalf("block stats: %v, %v", got, err)
+	}
+	if err := s.BlockStatsRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := s.AuditGet("b1"); err != nil || a != nil {
+		t.Fatalf("audit after the removal: %v, %v", a, err)
+	}
+	if st := s.Stats(); st.Txs != 0 || st.Blocks != 0 || st.PrunedConfirmed != 2 || st.DiskBytes == 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	s := newTestBolt(t, filepath.Join(t.TempDir(), "feesh.db"), storage.Retention{
+		EvictedGrace: 10 * time.Millisecond,
+		TxTTL:        50 * time.Millisecond,
+	})
+	defer s.Close()
+	for _, txid := range []string{"mined", "dropped", "stale"} {
+		if err := s.TxAdd(tx.Tx{Hash: txid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BlockAdd("b1", []string{"mined"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TxEvict([]string{"mined", "dropped"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// pruned on the next eviction
+	if err := s.TxEvict(nil); err != nil {
+		t.Fatal(err)
+	}
+	for txid, want := range map[string]bool{"mined": true, "dropped": false, "stale": true} {
+		if got := stored(t, s, txid); got != want {
+			t.Errorf("after the grace period, tx %s: stored %v, want %v", txid, got, want)
+		}
+	}
+
+	// never evicted, dropped by the ttl unless confirmed
+	time.Sleep(50 * time.Millisecond)
+	if err := s.TxEvict(nil); err != nil {
+		t.Fatal(err)
+	}
+	for txid, want := range map[string]bool{"mined": true, "stale": false} {
+		if got := stored(t, s, txid); got != want {
+			t.Errorf("after the ttl, tx %s: stored %v, want %v", txid, got, want)
+		}
+	}
+	if st := s.Stats(); st.PrunedEvicted != 1 || st.PrunedTTL != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newTestBolt(t, filepath.Join(t.TempDir(), "feesh.db"), storage.Retention{})
+	defer s.Close()
+	start := time.Unix(1700000000, 0)
+	points := make([]storage.Point, 0)
+	for i := 0; i < 5; i++ {
+		points = append(points, storage.Point{Time: start.Add(time.Duration(i) * time.Minute), Values: []float64{float64(i), 1}})
+	}
+	if err := s.HistoryAdd("pool", points); err != nil {
+		t.Fatal(err)
+	}
+	// other series are separate
+	if err := s.HistoryAdd("fees", points[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.HistoryRange("pool", points[1].Time, points[3].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !got[0].Time.Equal(points[1].Time) || !reflect.DeepEqual(got[2].Values, points[3].Values) {
+		t.Fatalf("range: %+v", got)
+	}
+
+	if err := s.HistoryTrim("pool", points[2].Time); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.HistoryRange("pool", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !got[0].Time.Equal(points[2].Time) {
+		t.Fatalf("after trim: %+v", got)
+	}
+	if got, err := s.HistoryRange("fees", start, start); err != nil || len(got) != 1 {
+		t.Fatalf("other series: %+v, %v", got, err)
+	}
+	if got, err := s.HistoryRange("missing", start, start.Add(time.Hour)); err != nil || len(got) != 0 {
+		t.Fatalf("missing series: %+v, %v", got, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Test(t, func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		s, err := New(filepath.Join(t.TempDir(), "feesh.db"), r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() { s.Close() }, nil
+	})
+}
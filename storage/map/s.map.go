@@ -1,35 +1,179 @@
 package storage_map
 
 import (
+	"container/list"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
+	"github.com/1F47E/go-feesh/entity/models/block"
 	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
 )
 
+// rough memory overhead of the maps and the lru list per stored item
+const (
+	txOverhead      = 160
+	blockTxOverhead = 128 // txid in the block list and in the confirmed index
+	auditTxOverhead = 80  // txid in the audit lists
+	blockStatsSize  = uint64(unsafe.Sizeof(block.Block{})) + 200
+)
+
+// txs are split by txid hash, parsers and the pool puller don't wait for each other.
+// Every shard has its own lru and an equal part of the memory limit
+const shardsCount = 64
+
+type item struct {
+	tx   tx.Tx
+	elem *list.Element // position in the shard lru list
+	size uint64
+}
+
+type shard struct {
+	mu        sync.Mutex
+	txs       map[string]*item
+	lru       *list.List // txids, most recently used first
+	mem       uint64
+	prunedLRU uint64
+}
+
+type evictedTx struct {
+	txid string
+	at   time.Time
+}
+
+// lock order is mu, then shards
 type MapStorage struct {
-	mu     *sync.Mutex
-	txs    map[string]*tx.Tx
-	blocks map[string][]string
+	shards    [shardsCount]*shard
+	mu        *sync.Mutex // blocks, confirmed and evicted indexes
+	retention storage.Retention
+	confirmed map[string]string    // txid -> stored block hash
+	evicted   map[string]time.Time // txids left the pool
+	evictedQ  []evictedTx          // in eviction order, grace period is the same for all
+	blocks    map[string][]string
+	processed map[string]block.Block // block stats
+	audits    map[string]*block.Audit
+	mem       atomic.Uint64 // blocks, stats and audits, txs are counted in shards
+	stats     storage.Stats
 }
 
-func New() *MapStorage {
-	return &MapStorage{
-		mu:     &sync.Mutex{},
-		txs:    make(map[string]*tx.Tx),
-		blocks: make(map[string][]string),
+func New(r storage.Retention) *MapStorage {
+	m := &MapStorage{
+		mu:        &sync.Mutex{},
+		retention: r,
+		confirmed: make(map[string]string),
+		evicted:   make(map[string]time.Time),
+		evictedQ:  make([]evictedTx, 0),
+		blocks:    make(map[string][]string),
+		processed: make(map[string]block.Block),
+		audits:    make(map[string]*block.Audit),
 	}
+	for i := range m.shards {
+		m.shards[i] = &shard{
+			txs: make(map[string]*item),
+			lru: list.New(),
+		}
+	}
+	return m
 }
 
 func (m *MapStorage) TxGet(txid string) (*tx.Tx, error) {
+	s := m.shard(txid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.get(txid)
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (m *MapStorage) TxGetMany(txids []string) (map[string]tx.Tx, error) {
+	ret := make(map[string]tx.Tx, len(txids))
+	m.byShard(txids, func(s *shard, txids []string) {
+		for _, txid := range txids {
+			if t, ok := s.get(txid); ok {
+				ret[txid] = t
+			}
+		}
+	})
+	return ret, nil
+}
+
+func (m *MapStorage) TxAdd(t tx.Tx) error {
+	s := m.shard(t.Hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(t)
+	s.prune(m.shardLimit())
+	return nil
+}
+
+func (m *MapStorage) TxAddMany(txs []tx.Tx) error {
+	groups := make(map[*shard][]tx.Tx)
+	for _, t := range txs {
+		s := m.shard(t.Hash)
+		groups[s] = append(groups[s], t)
+	}
+	limit := m.shardLimit()
+	for s, txs := range groups {
+		s.mu.Lock()
+		for _, t := range txs {
+			s.add(t)
+		}
+		s.prune(limit)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (m *MapStorage) TxEvict(txids []string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.txs[txid], nil
+	now := time.Now()
+	for _, txid := range txids {
+		// mined
+		if _, ok := m.confirmed[txid]; ok {
+			continue
+		}
+		m.evicted[txid] = now
+		m.evictedQ = append(m.evictedQ, evictedTx{txid: txid, at: now})
+	}
+	m.pruneEvicted(now)
+	return nil
 }
 
-func (m *MapStorage) TxAdd(tx tx.Tx) error {
+func (m *MapStorage) TxDelete(txids []string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.txs[tx.Hash] = &tx
+	for _, txid := range txids {
+		// stale entries in the queue are skipped on prune
+		delete(m.evicted, txid)
+	}
+	m.mu.Unlock()
+	m.byShard(txids, func(s *shard, txids []string) {
+		for _, txid := range txids {
+			s.delete(txid)
+		}
+	})
+	return nil
+}
+
+// txs are copied shard by shard, fn is called without locks
+func (m *MapStorage) TxIterate(fn func(t tx.Tx) bool) error {
+	for _, s := range m.shards {
+		s.mu.Lock()
+		txs := make([]tx.Tx, 0, len(s.txs))
+		for _, it := range s.txs {
+			txs = append(txs, it.tx)
+		}
+		s.mu.Unlock()
+		for _, t := range txs {
+			if !fn(t) {
+				return nil
+			}
+		}
+	}
 	return nil
 }
 
@@ -46,9 +190,257 @@ func (m *MapStorage) BlockGet(hash string) ([]string, error) {
 	return m.blocks[hash], nil
 }
 
+func (m *MapStorage) BlockList() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]string, 0, len(m.blocks))
+	for hash := range m.blocks {
+		ret = append(ret, hash)
+	}
+	return ret, nil
+}
+
 func (m *MapStorage) BlockAdd(hash string, txs []string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if _, ok := m.blocks[hash]; ok {
+		m.removeBlock(hash, false)
+	}
 	m.blocks[hash] = txs
+	m.mem.Add(uint64(len(txs)) * blockTxOverhead)
+	for _, txid := range txs {
+		m.confirmed[txid] = hash
+		// evicted from the pool because it was mined
+		delete(m.evicted, txid)
+	}
+	return nil
+}
+
+func (m *MapStorage) BlockRemove(hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removeBlock(hash, true)
+	return nil
+}
+
+func (m *MapStorage) BlockStatsRemove(hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.processed[hash]; ok {
+		m.memSub(blockStatsSize)
+		delete(m.processed, hash)
+	}
+	if a, ok := m.audits[hash]; ok {
+		m.memSub(auditSize(a))
+		delete(m.audits, hash)
+	}
+	return nil
+}
+
+func (m *MapStorage) BlockStatsAdd(b block.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.processed[b.Hash]; !ok {
+		m.mem.Add(blockStatsSize)
+	}
+	m.processed[b.Hash] = b
 	return nil
 }
+
+func (m *MapStorage) BlockStatsList() ([]block.Block, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]block.Block, 0, len(m.processed))
+	for _, b := range m.processed {
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
+
+func (m *MapStorage) AuditGet(hash string) (*block.Audit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.audits[hash], nil
+}
+
+func (m *MapStorage) AuditAdd(a block.Audit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.audits[a.Hash]; ok {
+		m.memSub(auditSize(old))
+	}
+	m.audits[a.Hash] = &a
+	m.mem.Add(auditSize(&a))
+	return nil
+}
+
+func (m *MapStorage) Stats() storage.Stats {
+	m.mu.Lock()
+	ret := m.stats
+	ret.Confirmed = len(m.confirmed)
+	ret.Evicted = len(m.evicted)
+	ret.Blocks = len(m.blocks)
+	ret.Audits = len(m.audits)
+	m.mu.Unlock()
+	ret.MemBytes = m.mem.Load()
+	ret.MemLimit = m.retention.MemLimit
+	for _, s := range m.shards {
+		s.mu.Lock()
+		ret.Txs += len(s.txs)
+		ret.MemBytes += s.mem
+		ret.PrunedLRU += s.prunedLRU
+		s.mu.Unlock()
+	}
+	return ret
+}
+
+func (m *MapStorage) shard(txid string) *shard {
+	// fnv-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(txid); i++ {
+		h ^= uint32(txid[i])
+		h *= 16777619
+	}
+	return m.shards[h%shardsCount]
+}
+
+// call fn for every shard with its txids, shard is locked
+func (m *MapStorage) byShard(txids []string, fn func(s *shard, txids []string)) {
+	groups := make(map[*shard][]string)
+	for _, txid := range txids {
+		s := m.shard(txid)
+		groups[s] = append(groups[s], txid)
+	}
+	for s, txids := range groups {
+		s.mu.Lock()
+		fn(s, txids)
+		s.mu.Unlock()
+	}
+}
+
+// tx memory limit per shard, blocks and audits are taken from the total first
+func (m *MapStorage) shardLimit() uint64 {
+	if m.retention.MemLimit == 0 {
+		return 0
+	}
+	other := m.mem.Load()
+	if other >= m.retention.MemLimit {
+		// keep at least something in every shard
+		return 1
+	}
+	return (m.retention.MemLimit - other) / shardsCount
+}
+
+// drop the block and unmark its txs, optionally dropping the txs too
+// must be called with mu locked
+func (m *MapStorage) removeBlock(hash string, withTxs bool) {
+	txs, ok := m.blocks[hash]
+	if !ok {
+		return
+	}
+	drop := make([]string, 0, len(txs))
+	for _, txid := range txs {
+		// tx can be in another block after reorg
+		if m.confirmed[txid] != hash {
+			continue
+		}
+		delete(m.confirmed, txid)
+		drop = append(drop, txid)
+	}
+	if withTxs {
+		m.byShard(drop, func(s *shard, txids []string) {
+			for _, txid := range txids {
+				if s.delete(txid) {
+					m.stats.PrunedConfirmed++
+				}
+			}
+		})
+	}
+	m.memSub(uint64(len(txs)) * blockTxOverhead)
+	delete(m.blocks, hash)
+}
+
+// drop txs evicted longer than the grace period ago
+// must be called with mu locked
+func (m *MapStorage) pruneEvicted(now time.Time) {
+	drop := make([]string, 0)
+	for len(m.evictedQ) > 0 && now.Sub(m.evictedQ[0].at) > m.retention.EvictedGrace {
+		e := m.evictedQ[0]
+		m.evictedQ[0] = evictedTx{}
+		m.evictedQ = m.evictedQ[1:]
+		// evicted again later, deleted or confirmed meanwhile
+		if at, ok := m.evicted[e.txid]; !ok || !at.Equal(e.at) {
+			continue
+		}
+		delete(m.evicted, e.txid)
+		drop = append(drop, e.txid)
+	}
+	m.byShard(drop, func(s *shard, txids []string) {
+		for _, txid := range txids {
+			if s.delete(txid) {
+				m.stats.PrunedEvicted++
+			}
+		}
+	})
+}
+
+// must be called with the shard locked
+func (s *shard) get(txid string) (tx.Tx, bool) {
+	it, ok := s.txs[txid]
+	if !ok {
+		return tx.Tx{}, false
+	}
+	s.lru.MoveToFront(it.elem)
+	return it.tx, true
+}
+
+// must be called with the shard locked
+func (s *shard) add(t tx.Tx) {
+	size := uint64(unsafe.Sizeof(t)) + uint64(len(t.Hash)) + txOverhead
+	if it, ok := s.txs[t.Hash]; ok {
+		s.mem = s.mem - it.size + size
+		it.tx = t
+		it.size = size
+		s.lru.MoveToFront(it.elem)
+		return
+	}
+	s.txs[t.Hash] = &item{
+		tx:   t,
+		elem: s.lru.PushFront(t.Hash),
+		size: size,
+	}
+	s.mem += size
+}
+
+// must be called with the shard locked
+func (s *shard) delete(txid string) bool {
+	it, ok := s.txs[txid]
+	if !ok {
+		return false
+	}
+	s.lru.Remove(it.elem)
+	s.mem -= it.size
+	delete(s.txs, txid)
+	return true
+}
+
+// drop the least recently used txs above the limit, 0 - no limit
+// must be called with the shard locked
+func (s *shard) prune(limit uint64) {
+	if limit == 0 {
+		return
+	}
+	for s.mem > limit && s.lru.Len() > 0 {
+		if s.delete(s.lru.Back().Value.(string)) {
+			s.prunedLRU++
+		}
+	}
+}
+
+func (m *MapStorage) memSub(n uint64) {
+	m.mem.Add(^(n - 1))
+}
+
+func auditSize(a *block.Audit) uint64 {
+	return uint64(unsafe.Sizeof(*a)) + uint64(len(a.Missing)+len(a.Added)+len(a.Unseen))*auditTxOverhead
+}
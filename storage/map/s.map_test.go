@@ -0,0 +1,133 @@
+package storage_map
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/storage/storagetest"
+)
+
+func addTxs(t *testing.T, m *MapStorage, txids ...string) {
+	t.Helper()
+	for _, txid := range txids {
+		if err := m.TxAdd(tx.Tx{Hash: txid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func has(t *testing.T, m *MapStorage, txid string) bool {
+	t.Helper()
+	got, err := m.TxGet(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got != nil
+}
+
+func TestEvictedGrace(t *testing.T) {
+	m := New(storage.Retention{EvictedGrace: 10 * time.Millisecond})
+	addTxs(t, m, "mined", "dropped", "kept")
+	if err := m.BlockAdd("b1", []string{"mined"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.TxEvict([]string{"mined", "dropped"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// pruned on the next eviction
+	if err := m.TxEvict(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for txid, want := range map[string]bool{"mined": true, "dropped": false, "kept": true} {
+		if got := has(t, m, txid); got != want {
+			t.Errorf("tx %s: stored %v, want %v", txid, got, want)
+		}
+	}
+	if s := m.Stats(); s.PrunedEvicted != 1 || s.Evicted != 0 || s.Confirmed != 1 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+// txids of the same shard, the limit is split between the shards
+func sameShard(m *MapStorage, n int) []string {
+	ret := make([]string, 0, n)
+	for i := 0; len(ret) < n; i++ {
+		txid := fmt.Sprintf("tx%04d", i)
+		if len(ret) == 0 || m.shard(txid) == m.shard(ret[0]) {
+			ret = append(ret, txid)
+		}
+	}
+	return ret
+}
+
+func TestMemLimit(t *testing.T) {
+	m := New(storage.Retention{})
+	ids := sameShard(m, 4)
+	addTxs(t, m, ids[0])
+	size := m.Stats().MemBytes
+
+	// room for 3 txs of the same size in the shard
+	m = New(storage.Retention{MemLimit: size * 3 * shardsCount})
+	addTxs(t, m, ids[:3]...)
+	// used recently, the second one is the oldest now
+	has(t, m, ids[0])
+	addTxs(t, m, ids[3])
+
+	for i, want := range []bool{true, false, true, true} {
+		if got := has(t, m, ids[i]); got != want {
+			t.Errorf("tx %s: stored %v, want %v", ids[i], got, want)
+		}
+	}
+	if s := m.Stats(); s.PrunedLRU != 1 || s.MemBytes > s.MemLimit {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+func TestBlockRemoveKeepsAudit(t *testing.T) {
+	m := New(storage.Retention{})
+	txids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		txids = append(txids, fmt.Sprintf("tx%d", i))
+	}
+	addTxs(t, m, txids...)
+	if err := m.BlockAdd("b1", txids); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AuditAdd(block.Audit{Hash: "b1", Missing: []string{"tx9"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.BlockRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, txid := range txids {
+		if has(t, m, txid) {
+			t.Errorf("tx %s of the removed block is stored", txid)
+		}
+	}
+	if a, err := m.AuditGet("b1"); err != nil || a == nil {
+		t.Fatalf("audit of the removed block: %v, %v", a, err)
+	}
+
+	if err := m.BlockStatsRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if a, err := m.AuditGet("b1"); err != nil || a != nil {
+		t.Fatalf("audit after the stats removal: %v, %v", a, err)
+	}
+	if s := m.Stats(); s.MemBytes != 0 || s.PrunedConfirmed != 3 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Test(t, func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		return New(r), func() {}, nil
+	})
+}
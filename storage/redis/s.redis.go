@@ -1,30 +1,85 @@
 package storage_redis
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/1F47E/go-feesh/entity/models/block"
 	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/storage"
 
 	redis "github.com/redis/go-redis/v9"
 )
 
+// max keys per command in batch operations
+const batchSize = 1000
+
+// keys layout, all prefixed with Options.Prefix
+//
+//	tx:<txid>          parsed tx json, expires after TxTTL or the eviction grace period
+//	block:<hash>       block txids json
+//	blockstats:<hash>  processed block json
+//	audit:<hash>       block audit json
+//	blocks             set of stored block hashes
+//	blockstats         set of stored block stats hashes
+//	audits             set of stored audit hashes
+//	confirmed          hash txid -> block hash
+//	evicted            zset of txids left the pool, scored by unix time
+//	history:<series>   zset of "<unix ms>:<values json>", scored by unix ms
+const (
+	keyTx         = "tx:"
+	keyBlock      = "block:"
+	keyBlockStats = "blockstats:"
+	keyAudit      = "audit:"
+	keyBlocks     = "blocks"
+	keyStats      = "blockstats"
+	keyAudits     = "audits"
+	keyConfirmed  = "confirmed"
+	keyEvicted    = "evicted"
+	keyHistory    = "history:"
+)
+
+type Options struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	Prefix   string // separates networks and instances sharing the db, feesh:mainnet:
+	storage.Retention
+}
+
 type Redis struct {
-	ctx context.Context
-	db  *redis.Client
+	ctx    context.Context
+	db     *redis.Client
+	opts   Options
+	prefix string
+	// dropped keys counters
+	prunedConfirmed atomic.Uint64
+	prunedEvicted   atomic.Uint64
 }
 
-func New(ctx context.Context) (*Redis, error) {
+func New(ctx context.Context, opts Options) (*Redis, error) {
 	r := Redis{
-		ctx: ctx,
+		ctx:  ctx,
+		opts: opts,
 		db: redis.NewClient(&redis.Options{
-			Addr:     "localhost:6379",
-			Password: "",
-			DB:       0,
+			Addr:     opts.Addr,
+			Username: opts.Username,
+			Password: opts.Password,
+			DB:       opts.DB,
 		}),
+		prefix: opts.Prefix,
 	}
 	// check connection
-	err := r.db.Ping(context.Background()).Err()
+	err := r.db.Ping(ctx).Err()
 	if err != nil {
 		return nil, err
 	}
@@ -32,22 +87,468 @@ func New(ctx context.Context) (*Redis, error) {
 }
 
 func (r *Redis) TxGet(txid string) (*tx.Tx, error) {
-	val, err := r.db.Get(r.ctx, txid).Result()
+	val, err := r.db.Get(r.ctx, r.prefix+keyTx+txid).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t tx.Tx
+	err = json.Unmarshal(val, &t)
 	if err != nil {
 		return nil, err
 	}
-	var tx tx.Tx
-	err = json.Unmarshal([]byte(val), &tx)
+	return &t, nil
+}
+
+// found txs by txid, missing ones are skipped
+func (r *Redis) TxGetMany(txids []string) (map[string]tx.Tx, error) {
+	ret := make(map[string]tx.Tx, len(txids))
+	for _, batch := range batches(txids) {
+		keys := make([]string, len(batch))
+		for i, txid := range batch {
+			keys[i] = r.prefix + keyTx + txid
+		}
+		vals, err := r.db.MGet(r.ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			var t tx.Tx
+			if err := json.Unmarshal([]byte(s), &t); err != nil {
+				return nil, err
+			}
+			ret[t.Hash] = t
+		}
+	}
+	return ret, nil
+}
+
+func (r *Redis) TxAdd(t tx.Tx) error {
+	return r.TxAddMany([]tx.Tx{t})
+}
+
+func (r *Redis) TxAddMany(txs []tx.Tx) error {
+	_, err := r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, t := range txs {
+			data, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			pipe.Set(r.ctx, r.prefix+keyTx+t.Hash, data, r.opts.TxTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) TxEvict(txids []string) error {
+	if len(txids) == 0 {
+		return nil
+	}
+	confirmed, err := r.confirmedIn(txids)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	graceStart := now.Add(-r.opts.EvictedGrace).Unix()
+	_, err = r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		members := make([]redis.Z, 0, len(txids))
+		for _, txid := range txids {
+			// mined
+			if _, ok := confirmed[txid]; ok {
+				continue
+			}
+			pipe.Expire(r.ctx, r.prefix+keyTx+txid, r.opts.EvictedGrace)
+			members = append(members, redis.Z{Score: float64(now.Unix()), Member: txid})
+		}
+		if len(members) > 0 {
+			pipe.ZAdd(r.ctx, r.prefix+keyEvicted, members...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// keys are expired by redis, just forget them
+	n, err := r.db.ZRemRangeByScore(r.ctx, r.prefix+keyEvicted, "-inf", strconv.FormatInt(graceStart, 10)).Result()
+	if err != nil {
+		return err
+	}
+	r.prunedEvicted.Add(uint64(n))
+	return nil
+}
+
+func (r *Redis) TxDelete(txids []string) error {
+	_, err := r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, batch := range batches(txids) {
+			keys := make([]string, len(batch))
+			members := make([]interface{}, len(batch))
+			for i, txid := range batch {
+				keys[i] = r.prefix + keyTx + txid
+				members[i] = txid
+			}
+			pipe.Del(r.ctx, keys...)
+			pipe.ZRem(r.ctx, r.prefix+keyEvicted, members...)
+		}
+		return nil
+	})
+	return err
+}
+
+// scans the tx keys, txs added or removed during the scan may be missed
+func (r *Redis) TxIterate(fn func(t tx.Tx) bool) error {
+	var cursor uint64
+	// scan can return a key more than once
+	seen := make(map[string]struct{})
+	for {
+		keys, next, err := r.db.Scan(r.ctx, cursor, r.prefix+keyTx+"*", batchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			vals, err := r.db.MGet(r.ctx, keys...).Result()
+			if err != nil {
+				return err
+			}
+			for _, v := range vals {
+				// expired meanwhile
+				s, ok := v.(string)
+				if !ok {
+					continue
+				}
+				var t tx.Tx
+				if err := json.Unmarshal([]byte(s), &t); err != nil {
+					return err
+				}
+				if _, ok := seen[t.Hash]; ok {
+					continue
+				}
+				seen[t.Hash] = struct{}{}
+				if !fn(t) {
+					return nil
+				}
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *Redis) BlockExists(hash string) (bool, error) {
+	n, err := r.db.Exists(r.ctx, r.prefix+keyBlock+hash).Result()
 	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *Redis) BlockGet(hash string) ([]string, error) {
+	val, err := r.db.Get(r.ctx, r.prefix+keyBlock+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var txs []string
+	if err := json.Unmarshal(val, &txs); err != nil {
 		return nil, err
 	}
-	return &tx, nil
+	return txs, nil
 }
 
-func (r *Redis) TxAdd(tx *tx.Tx) error {
-	data, err := json.Marshal(tx)
+func (r *Redis) BlockList() ([]string, error) {
+	return r.db.SMembers(r.ctx, r.prefix+keyBlocks).Result()
+}
+
+func (r *Redis) BlockAdd(hash string, txs []string) error {
+	data, err := json.Marshal(txs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(r.ctx, r.prefix+keyBlock+hash, data, 0)
+		pipe.SAdd(r.ctx, r.prefix+keyBlocks, hash)
+		for _, batch := range batches(txs) {
+			values := make([]interface{}, 0, len(batch)*2)
+			members := make([]interface{}, 0, len(batch))
+			for _, txid := range batch {
+				values = append(values, txid, hash)
+				members = append(members, txid)
+			}
+			pipe.HSet(r.ctx, r.prefix+keyConfirmed, values...)
+			// evicted from the pool because it was mined
+			pipe.ZRem(r.ctx, r.prefix+keyEvicted, members...)
+		}
+		// drop the eviction ttl
+		for _, txid := range txs {
+			if r.opts.TxTTL > 0 {
+				pipe.Expire(r.ctx, r.prefix+keyTx+txid, r.opts.TxTTL)
+			} else {
+				pipe.Persist(r.ctx, r.prefix+keyTx+txid)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) BlockRemove(hash string) error {
+	txs, err := r.BlockGet(hash)
+	if err != nil {
+		return err
+	}
+	confirmed, err := r.confirmedIn(txs)
+	if err != nil {
+		return err
+	}
+	// tx can be in another block after reorg
+	own := make([]string, 0, len(txs))
+	for _, txid := range txs {
+		if confirmed[txid] == hash {
+			own = append(own, txid)
+		}
+	}
+	dels := make([]*redis.IntCmd, 0)
+	_, err = r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, batch := range batches(own) {
+			keys := make([]string, len(batch))
+			for i, txid := range batch {
+				keys[i] = r.prefix + keyTx + txid
+			}
+			pipe.HDel(r.ctx, r.prefix+keyConfirmed, batch...)
+			dels = append(dels, pipe.Del(r.ctx, keys...))
+		}
+		pipe.Del(r.ctx, r.prefix+keyBlock+hash)
+		pipe.SRem(r.ctx, r.prefix+keyBlocks, hash)
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	return r.db.Set(r.ctx, tx.Hash, data, 0).Err()
+	for _, d := range dels {
+		r.prunedConfirmed.Add(uint64(d.Val()))
+	}
+	return nil
+}
+
+func (r *Redis) BlockStatsAdd(b block.Block) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(r.ctx, r.prefix+keyBlockStats+b.Hash, data, 0)
+		pipe.SAdd(r.ctx, r.prefix+keyStats, b.Hash)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) BlockStatsList() ([]block.Block, error) {
+	hashes, err := r.db.SMembers(r.ctx, r.prefix+keyStats).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]block.Block, 0, len(hashes))
+	for _, batch := range batches(hashes) {
+		keys := make([]string, len(batch))
+		for i, hash := range batch {
+			keys[i] = r.prefix + keyBlockStats + hash
+		}
+		vals, err := r.db.MGet(r.ctx, keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			// fetched block is not processed yet
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			var b block.Block
+			if err := json.Unmarshal([]byte(s), &b); err != nil {
+				return nil, err
+			}
+			ret = append(ret, b)
+		}
+	}
+	return ret, nil
+}
+
+func (r *Redis) BlockStatsRemove(hash string) error {
+	_, err := r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(r.ctx, r.prefix+keyBlockStats+hash, r.prefix+keyAudit+hash)
+		pipe.SRem(r.ctx, r.prefix+keyStats, hash)
+		pipe.SRem(r.ctx, r.prefix+keyAudits, hash)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) AuditGet(hash string) (*block.Audit, error) {
+	val, err := r.db.Get(r.ctx, r.prefix+keyAudit+hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a block.Audit
+	if err := json.Unmarshal(val, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (r *Redis) AuditAdd(a block.Audit) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(r.ctx, r.prefix+keyAudit+a.Hash, data, 0)
+		pipe.SAdd(r.ctx, r.prefix+keyAudits, a.Hash)
+		return nil
+	})
+	return err
+}
+
+// points at the same time are replaced, times are kept in milliseconds
+func (r *Redis) HistoryAdd(series string, points []storage.Point) error {
+	key := r.prefix + keyHistory + series
+	_, err := r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		for _, p := range points {
+			data, err := json.Marshal(p.Values)
+			if err != nil {
+				return err
+			}
+			ms := p.Time.UnixMilli()
+			score := strconv.FormatInt(ms, 10)
+			pipe.ZRemRangeByScore(r.ctx, key, score, score)
+			pipe.ZAdd(r.ctx, key, redis.Z{Score: float64(ms), Member: score + ":" + string(data)})
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) HistoryRange(series string, from, to time.Time) ([]storage.Point, error) {
+	vals, err := r.db.ZRangeByScore(r.ctx, r.prefix+keyHistory+series, &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: strconv.FormatInt(to.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]storage.Point, 0, len(vals))
+	for _, v := range vals {
+		score, data, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid history point %q", v)
+		}
+		ms, err := strconv.ParseInt(score, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid history point %q: %w", v, err)
+		}
+		p := storage.Point{Time: time.UnixMilli(ms)}
+		if err := json.Unmarshal([]byte(data), &p.Values); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+func (r *Redis) HistoryTrim(series string, before time.Time) error {
+	return r.db.ZRemRangeByScore(r.ctx, r.prefix+keyHistory+series, "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)).Err()
+}
+
+// tx keys are not counted, memory is reported for the whole server
+func (r *Redis) Stats() storage.Stats {
+	log := logger.Log.WithField("context", "[redis]")
+	ret := storage.Stats{
+		PrunedConfirmed: r.prunedConfirmed.Load(),
+		PrunedEvicted:   r.prunedEvicted.Load(),
+	}
+	graceStart := time.Now().Add(-r.opts.EvictedGrace).Unix()
+	var confirmed, evicted, blocks, audits *redis.IntCmd
+	_, err := r.db.Pipelined(r.ctx, func(pipe redis.Pipeliner) error {
+		confirmed = pipe.HLen(r.ctx, r.prefix+keyConfirmed)
+		evicted = pipe.ZCount(r.ctx, r.prefix+keyEvicted, strconv.FormatInt(graceStart, 10), "+inf")
+		blocks = pipe.SCard(r.ctx, r.prefix+keyBlocks)
+		audits = pipe.SCard(r.ctx, r.prefix+keyAudits)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("error on stats: %v\n", err)
+		return ret
+	}
+	ret.Confirmed = int(confirmed.Val())
+	ret.Evicted = int(evicted.Val())
+	ret.Blocks = int(blocks.Val())
+	ret.Audits = int(audits.Val())
+	// not every redis compatible server supports it
+	info, err := r.db.Info(r.ctx, "memory").Result()
+	if err != nil {
+		log.Debugf("error on info memory: %v\n", err)
+		return ret
+	}
+	mem := parseInfo(info)
+	ret.MemBytes, _ = strconv.ParseUint(mem["used_memory"], 10, 64)
+	ret.MemLimit, _ = strconv.ParseUint(mem["maxmemory"], 10, 64)
+	return ret
+}
+
+func (r *Redis) Close() error {
+	return r.db.Close()
+}
+
+// block hashes of the confirmed txs
+func (r *Redis) confirmedIn(txids []string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, batch := range batches(txids) {
+		vals, err := r.db.HMGet(r.ctx, r.prefix+keyConfirmed, batch...).Result()
+		if err != nil {
+			return nil, fmt.Errorf("error on confirmed txs: %w", err)
+		}
+		for i, v := range vals {
+			if hash, ok := v.(string); ok {
+				ret[batch[i]] = hash
+			}
+		}
+	}
+	return ret, nil
+}
+
+// split into chunks of batchSize
+func batches(items []string) [][]string {
+	ret := make([][]string, 0, len(items)/batchSize+1)
+	for len(items) > 0 {
+		n := min(batchSize, len(items))
+		ret = append(ret, items[:n])
+		items = items[n:]
+	}
+	return ret
+}
+
+// INFO section as key-value
+func parseInfo(info string) map[string]string {
+	ret := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok {
+			ret[k] = v
+		}
+	}
+	return ret
 }
@@ -0,0 +1,221 @@
+package storage_redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/storage/storagetest"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ storage.HistoryRepository = (*Redis)(nil)
+
+// in-memory redis stand-in, a real server with TEST_REDIS_ADDR set
+func testAddr(t *testing.T) string {
+	if addr := os.Getenv("TEST_REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	m := miniredis.RunT(t)
+	// miniredis expires keys only when its clock is moved, follow the wall clock
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		const step = 50 * time.Millisecond
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				m.FastForward(step)
+			}
+		}
+	}()
+	return m.Addr()
+}
+
+func newTestRedis(t *testing.T, addr, prefix string, r storage.Retention) *Redis {
+	t.Helper()
+	s, err := New(context.Background(), Options{Addr: addr, Prefix: prefix, Retention: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func stored(t *testing.T, s *Redis, txid string) bool {
+	t.Helper()
+	got, err := s.TxGet(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got != nil
+}
+
+func TestBlocksKeepStats(t *testing.T) {
+	s := newTestRedis(t, testAddr(t), "feeshtest:", storage.Retention{EvictedGrace: time.Hour})
+	txids := []string{"tx1", "tx2"}
+	for _, txid := range txids {
+		if err := s.TxAdd(tx.Tx{Hash: txid, Fee: 100}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BlockAdd("b1", txids); err != nil {
+		t.Fatal(err)
+	}
+	b := block.Block{Hash: "b1", Height: 1, Txs: 2}
+	if err := s.BlockStatsAdd(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AuditAdd(block.Audit{Hash: "b1", Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.BlockGet("b1"); err != nil || !reflect.DeepEqual(got, txids) {
+		t.Fatalf("block txs: %v, %v", got, err)
+	}
+	if st := s.Stats(); st.Confirmed != 2 || st.Blocks != 1 || st.Audits != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+
+	// txs go with the block, the stats and audit stay
+	if err := s.BlockRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, txid := range txids {
+		if stored(t, s, txid) {
+			t.Errorf("tx %s of the removed block is stored", txid)
+		}
+	}
+	if ok, err := s.BlockExists("b1"); err != nil || ok {
+		t.Fatalf("removed block exists: %v, %v", ok, err)
+	}
+	if got, err := s.BlockStatsList(); err != nil || !reflect.DeepEqual(got, []block.Block{b}) {
+		t.Fatalf("block stats: %v, %v", got, err)
+	}
+	if a, err := s.AuditGet("b1"); err != nil || a == nil {
+		t.Fatalf("audit: %v, %v", a, err)
+	}
+
+	if err := s.BlockStatsRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.BlockStatsList(); err != nil || len(got) != 0 {
+		t.Fatalf("block stats after the removal: %v, %v", got, err)
+	}
+	if a, err := s.AuditGet("b1"); err != nil || a != nil {
+		t.Fatalf("audit after the removal: %v, %v", a, err)
+	}
+	if st := s.Stats(); st.PrunedConfirmed != 2 || st.Audits != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestEvictedGrace(t *testing.T) {
+	s := newTestRedis(t, testAddr(t), "feeshtest:", storage.Retention{EvictedGrace: time.Second})
+	for _, txid := range []string{"mined", "dropped"} {
+		if err := s.TxAdd(tx.Tx{Hash: txid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BlockAdd("b1", []string{"mined"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TxEvict([]string{"mined", "dropped"}); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.Evicted != 1 {
+		t.Fatalf("evicted: got %d, want 1", st.Evicted)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stored(t, s, "dropped") {
+		if time.Now().After(deadline) {
+			t.Fatal("evicted tx is kept after the grace period")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !stored(t, s, "mined") {
+		t.Fatal("confirmed tx is dropped with the evicted ones")
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	addr := testAddr(t)
+	a := newTestRedis(t, addr, "feeshtest:a:", storage.Retention{})
+	b := newTestRedis(t, addr, "feeshtest:b:", storage.Retention{})
+	if err := a.TxAdd(tx.Tx{Hash: "tx1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !stored(t, a, "tx1") || stored(t, b, "tx1") {
+		t.Fatal("tx is not separated by the prefix")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newTestRedis(t, testAddr(t), "feeshtest:", storage.Retention{})
+	start := time.UnixMilli(1700000000000)
+	points := make([]storage.Point, 0)
+	for i := 0; i < 5; i++ {
+		points = append(points, storage.Point{Time: start.Add(time.Duration(i) * time.Minute), Values: []float64{float64(i), 1}})
+	}
+	if err := s.HistoryAdd("pool", points); err != nil {
+		t.Fatal(err)
+	}
+	// same time is replaced
+	if err := s.HistoryAdd("pool", []storage.Point{{Time: points[4].Time, Values: []float64{42}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.HistoryRange("pool", points[1].Time, points[4].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || !got[0].Time.Equal(points[1].Time) || !reflect.DeepEqual(got[3].Values, []float64{42}) {
+		t.Fatalf("range: %+v", got)
+	}
+
+	if err := s.HistoryTrim("pool", points[2].Time); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.HistoryRange("pool", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !got[0].Time.Equal(points[2].Time) {
+		t.Fatalf("after trim: %+v", got)
+	}
+}
+
+// every case gets its own prefix, keys are deleted after
+func TestConformance(t *testing.T) {
+	addr := testAddr(t)
+	storagetest.Test(t, func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		ctx := context.Background()
+		prefix := fmt.Sprintf("feeshtest:%d:", time.Now().UnixNano())
+		s, err := New(ctx, Options{Addr: addr, Prefix: prefix, Retention: r})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			db := redis.NewClient(&redis.Options{Addr: addr})
+			defer db.Close()
+			iter := db.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+			for iter.Next(ctx) {
+				db.Del(ctx, iter.Val())
+			}
+		}, nil
+	})
+}
@@ -0,0 +1,19 @@
+package storage
+
+import "time"
+
+// time series point, scalar metrics have a single value
+type Point struct {
+	Time   time.Time `json:"time"`
+	Values []float64 `json:"values"`
+}
+
+// optional, implemented by persistent storages
+type HistoryRepository interface {
+	// a point at an existing time replaces it
+	HistoryAdd(series string, points []Point) error
+	// points within [from, to], oldest first
+	HistoryRange(series string, from, to time.Time) ([]Point, error)
+	// drop points older than before
+	HistoryTrim(series string, before time.Time) error
+}
@@ -1,13 +1,37 @@
 package storage
 
 import (
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
 	mtx "github.com/1F47E/go-feesh/entity/models/tx"
 )
 
 type PoolRepository interface {
 	TxGet(txid string) (*mtx.Tx, error)
+	// found txs by txid, missing ones are skipped
+	TxGetMany(txids []string) (map[string]mtx.Tx, error)
 	TxAdd(tx mtx.Tx) error
+	TxAddMany(txs []mtx.Tx) error
+	// txs left the pool. Dropped after the grace period unless they are confirmed by a stored block
+	TxEvict(txids []string) error
+	// drop txs right away, confirmed or not
+	TxDelete(txids []string) error
+	// stored txs in no particular order, until fn returns false
+	TxIterate(fn func(tx mtx.Tx) bool) error
 	BlockExists(hash string) (bool, error)
 	BlockGet(hash string) ([]string, error)
+	// stored block hashes
+	BlockList() ([]string, error)
 	BlockAdd(hash string, txs []string) error
+	// block is deeper than the parsing depth or orphaned, drop its txids and txs.
+	// Stats and audit are kept
+	BlockRemove(hash string) error
+	// processed blocks, to restore the window on restart
+	BlockStatsAdd(b mblock.Block) error
+	BlockStatsList() ([]mblock.Block, error)
+	// block left the history or orphaned, drop its stats and audit.
+	// Backends keeping the full history only take it out of the list
+	BlockStatsRemove(hash string) error
+	AuditGet(hash string) (*mblock.Audit, error)
+	AuditAdd(a mblock.Audit) error
+	Stats() Stats
 }
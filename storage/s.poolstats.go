@@ -0,0 +1,32 @@
+package storage
+
+import "time"
+
+// pool aggregates at a point in time
+type PoolStats struct {
+	Time   time.Time
+	Height int
+	Count  int
+	Size   uint64
+	Weight uint64
+	Fee    uint64
+	Amount uint64
+	// fee bucket upper bound in sat/vB -> txs count
+	FeeBuckets map[uint]uint
+	Projected  ProjectedBlock
+}
+
+// next block projected from the pool
+type ProjectedBlock struct {
+	Txs           int
+	Fee           uint64
+	Size          uint64
+	Weight        uint64
+	MinFeeRate    float64
+	MedianFeeRate float64
+}
+
+// optional, implemented by storages kept for fee history analytics
+type PoolStatsRepository interface {
+	PoolStatsAdd(s PoolStats) error
+}
@@ -0,0 +1,29 @@
+package storage
+
+import "time"
+
+// how long the stored data is kept
+type Retention struct {
+	EvictedGrace time.Duration // keep txs left the pool without being mined
+	MemLimit     uint64        // bytes, least recently used txs are dropped above it. 0 - no limit
+	// max tx lifetime for persistent storages, covers txs never evicted or confirmed
+	// (left the pool while the service was down). 0 - no limit
+	TxTTL time.Duration
+}
+
+type Stats struct {
+	Txs       int `json:"txs"`       // 0 if unknown
+	Confirmed int `json:"confirmed"` // txs of the stored blocks
+	Evicted   int `json:"evicted"`   // txs left the pool, waiting for the grace period
+	Blocks    int `json:"blocks"`
+	Audits    int `json:"audits"`
+	// estimated memory usage, whole server memory for redis
+	MemBytes  uint64 `json:"mem_bytes"`
+	MemLimit  uint64 `json:"mem_limit"`
+	DiskBytes uint64 `json:"disk_bytes"` // embedded storages file size
+	// dropped txs by the reason
+	PrunedConfirmed uint64 `json:"pruned_confirmed"`
+	PrunedEvicted   uint64 `json:"pruned_evicted"`
+	PrunedLRU       uint64 `json:"pruned_lru"`
+	PrunedTTL       uint64 `json:"pruned_ttl"`
+}
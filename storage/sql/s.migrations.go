@@ -0,0 +1,172 @@
+package storage_sql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/1F47E/go-feesh/storage"
+)
+
+// schema versions, applied in order and never edited once released.
+// Times are unix seconds, amounts in sat, fee rates in sat/vB
+var migrations = [][]string{
+	// 1: initial schema
+	{
+		`CREATE TABLE txs (
+			txid       TEXT PRIMARY KEY,
+			added_at   BIGINT NOT NULL,
+			time       BIGINT NOT NULL,
+			size       INTEGER NOT NULL,
+			weight     INTEGER NOT NULL,
+			fee        BIGINT NOT NULL,
+			amount_in  BIGINT NOT NULL,
+			amount_out BIGINT NOT NULL,
+			inputs     INTEGER NOT NULL,
+			outputs    INTEGER NOT NULL,
+			coinbase   BOOLEAN NOT NULL,
+			segwit     BOOLEAN NOT NULL,
+			taproot    BOOLEAN NOT NULL
+		)`,
+		`CREATE INDEX txs_added_at ON txs (added_at)`,
+		`CREATE TABLE evictions (
+			txid       TEXT PRIMARY KEY,
+			evicted_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX evictions_evicted_at ON evictions (evicted_at)`,
+		`CREATE TABLE blocks (
+			hash     TEXT PRIMARY KEY,
+			added_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE block_txs (
+			block_hash TEXT NOT NULL,
+			pos        INTEGER NOT NULL,
+			txid       TEXT NOT NULL,
+			PRIMARY KEY (block_hash, pos)
+		)`,
+		`CREATE INDEX block_txs_txid ON block_txs (txid)`,
+		// mined blocks stats, kept after the block leaves the window
+		`CREATE TABLE block_stats (
+			hash            TEXT PRIMARY KEY,
+			height          INTEGER NOT NULL,
+			time            BIGINT NOT NULL,
+			txs             BIGINT NOT NULL,
+			value           BIGINT NOT NULL,
+			fee             BIGINT NOT NULL,
+			weight          BIGINT NOT NULL,
+			size            BIGINT NOT NULL,
+			reward          BIGINT NOT NULL,
+			subsidy         BIGINT NOT NULL,
+			fee_rate_min    BIGINT NOT NULL,
+			fee_rate_median BIGINT NOT NULL,
+			fee_rate_max    BIGINT NOT NULL,
+			segwit_txs      BIGINT NOT NULL,
+			taproot_txs     BIGINT NOT NULL,
+			inputs          BIGINT NOT NULL,
+			outputs         BIGINT NOT NULL,
+			pool            TEXT NOT NULL,
+			in_window       BOOLEAN NOT NULL,
+			data            TEXT NOT NULL
+		)`,
+		`CREATE INDEX block_stats_height ON block_stats (height)`,
+		`CREATE INDEX block_stats_time ON block_stats (time)`,
+		`CREATE TABLE audits (
+			hash         TEXT PRIMARY KEY,
+			height       INTEGER NOT NULL,
+			time         BIGINT NOT NULL,
+			expected_txs INTEGER NOT NULL,
+			matched_txs  INTEGER NOT NULL,
+			expected_fee BIGINT NOT NULL,
+			actual_fee   BIGINT NOT NULL,
+			fee_delta    BIGINT NOT NULL,
+			health       DOUBLE PRECISION NOT NULL,
+			data         TEXT NOT NULL
+		)`,
+		// periodic pool aggregates with the projected next block
+		`CREATE TABLE pool_stats (
+			time                     BIGINT PRIMARY KEY,
+			height                   INTEGER NOT NULL,
+			count                    INTEGER NOT NULL,
+			size                     BIGINT NOT NULL,
+			weight                   BIGINT NOT NULL,
+			fee                      BIGINT NOT NULL,
+			amount                   BIGINT NOT NULL,
+			projected_txs            INTEGER NOT NULL,
+			projected_fee            BIGINT NOT NULL,
+			projected_size           BIGINT NOT NULL,
+			projected_weight         BIGINT NOT NULL,
+			projected_min_fee_rate   DOUBLE PRECISION NOT NULL,
+			projected_median_fee_rate DOUBLE PRECISION NOT NULL
+		)`,
+		`CREATE TABLE pool_fee_buckets (
+			time   BIGINT NOT NULL,
+			bucket INTEGER NOT NULL,
+			txs    INTEGER NOT NULL,
+			PRIMARY KEY (time, bucket)
+		)`,
+		`CREATE TABLE history (
+			series TEXT NOT NULL,
+			time   BIGINT NOT NULL,
+			vals   TEXT NOT NULL,
+			PRIMARY KEY (series, time)
+		)`,
+	},
+	// 2: replace by fee signaling
+	{
+		`ALTER TABLE txs ADD COLUMN rbf BOOLEAN NOT NULL DEFAULT FALSE`,
+	},
+}
+
+// apply pending migrations, each version in its own transaction
+func (s *SQL) migrate(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than supported %d", version, len(migrations))
+	}
+	for v := version + 1; v <= len(migrations); v++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			for _, stmt := range migrations[v-1] {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.q(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`), v, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error on migration %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
+// tables created by the migrations
+var tables = []string{"txs", "evictions", "blocks", "block_txs", "block_stats", "audits",
+	"pool_stats", "pool_fee_buckets", "history", "schema_migrations"}
+
+// drop all tables, used by the storage check
+func Drop(driver, dsn string) error {
+	s, err := New(driver, dsn, storage.Retention{})
+	if err != nil {
+		return err
+	}
+	defer s.db.Close()
+	for _, t := range tables {
+		if _, err := s.db.Exec(`DROP TABLE IF EXISTS ` + t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
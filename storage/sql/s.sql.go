@@ -0,0 +1,584 @@
+package storage_sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/logger"
+	"github.com/1F47E/go-feesh/storage"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// sql storage for the fee history analytics.
+// Keeps the same working set as the other storages, plus the stats of every mined block
+// and periodic pool aggregates. Block stats and audits stay after the block leaves the window,
+// only the window flag is cleared, so months of history can be queried in plain sql:
+//
+//	SELECT time, projected_min_fee_rate FROM pool_stats WHERE time > ?
+//	SELECT height, fee_rate_median, pool FROM block_stats ORDER BY height DESC
+const (
+	DriverSqlite   = "sqlite"
+	DriverPostgres = "postgres"
+
+	migrateTimeout = time.Minute
+)
+
+type SQL struct {
+	db        *sql.DB
+	driver    string
+	retention storage.Retention
+	// dropped txs counters
+	prunedConfirmed atomic.Uint64
+	prunedEvicted   atomic.Uint64
+	prunedTTL       atomic.Uint64
+}
+
+// driver is sqlite or postgres. Dsn is a file path for sqlite
+func New(driver, dsn string, r storage.Retention) (*SQL, error) {
+	var name string
+	switch driver {
+	case DriverSqlite:
+		name = "sqlite"
+	case DriverPostgres:
+		name = "pgx"
+	default:
+		return nil, fmt.Errorf("unknown sql driver: %s", driver)
+	}
+	db, err := sql.Open(name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQL{db: db, driver: driver, retention: r}
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+	if driver == DriverSqlite {
+		// single writer, pragmas are per connection
+		db.SetMaxOpenConns(1)
+		for _, p := range []string{"PRAGMA journal_mode = WAL", "PRAGMA synchronous = NORMAL", "PRAGMA busy_timeout = 5000"} {
+			if _, err := db.ExecContext(ctx, p); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("error on %s: %w", p, err)
+			}
+		}
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+const (
+	txColumns = `txid, time, size, weight, fee, amount_in, amount_out, inputs, outputs, coinbase, segwit, taproot, rbf`
+	// keeps the first added time for the ttl
+	txUpsert = `INSERT INTO txs (` + txColumns + `, added_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (txid) DO UPDATE SET time = excluded.time, size = excluded.size, weight = excluded.weight,
+			fee = excluded.fee, amount_in = excluded.amount_in, amount_out = excluded.amount_out,
+			inputs = excluded.inputs, outputs = excluded.outputs, coinbase = excluded.coinbase,
+			segwit = excluded.segwit, taproot = excluded.taproot, rbf = excluded.rbf`
+	// ids per IN query and rows per iteration query
+	batchSize = 500
+)
+
+func (s *SQL) TxGet(txid string) (*tx.Tx, error) {
+	t, err := scanTx(s.db.QueryRow(s.q(`SELECT `+txColumns+` FROM txs WHERE txid = ?`), txid))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *SQL) TxGetMany(txids []string) (map[string]tx.Tx, error) {
+	ret := make(map[string]tx.Tx, len(txids))
+	for len(txids) > 0 {
+		n := min(len(txids), batchSize)
+		args := make([]any, n)
+		for i, txid := range txids[:n] {
+			args[i] = txid
+		}
+		txids = txids[n:]
+		query := `SELECT ` + txColumns + ` FROM txs WHERE txid IN (?` + strings.Repeat(`, ?`, n-1) + `)`
+		err := s.query(s.q(query), args, func(rows *sql.Rows) error {
+			t, err := scanTx(rows)
+			if err == nil {
+				ret[t.Hash] = t
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (s *SQL) TxAdd(t tx.Tx) error {
+	_, err := s.db.Exec(s.q(txUpsert), txArgs(t, time.Now())...)
+	return err
+}
+
+func (s *SQL) TxAddMany(txs []tx.Tx) error {
+	now := time.Now()
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		stmt, err := dtx.Prepare(s.q(txUpsert))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, t := range txs {
+			if _, err := stmt.Exec(txArgs(t, now)...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQL) TxEvict(txids []string) error {
+	now := time.Now()
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		// mined txs are not evicted
+		stmt, err := dtx.Prepare(s.q(`INSERT INTO evictions (txid, evicted_at)
+			SELECT CAST(? AS TEXT), CAST(? AS BIGINT) WHERE NOT EXISTS (SELECT 1 FROM block_txs WHERE txid = ?)
+			ON CONFLICT (txid) DO UPDATE SET evicted_at = excluded.evicted_at`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, txid := range txids {
+			if _, err := stmt.Exec(txid, now.Unix(), txid); err != nil {
+				return err
+			}
+		}
+		return s.prune(dtx, now)
+	})
+}
+
+func (s *SQL) TxDelete(txids []string) error {
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		for _, query := range []string{`DELETE FROM txs WHERE txid = ?`, `DELETE FROM evictions WHERE txid = ?`} {
+			stmt, err := dtx.Prepare(s.q(query))
+			if err != nil {
+				return err
+			}
+			for _, txid := range txids {
+				if _, err := stmt.Exec(txid); err != nil {
+					stmt.Close()
+					return err
+				}
+			}
+			stmt.Close()
+		}
+		return nil
+	})
+}
+
+// pages by txid, fn is called between the queries
+func (s *SQL) TxIterate(fn func(t tx.Tx) bool) error {
+	after := ""
+	for {
+		chunk := make([]tx.Tx, 0, batchSize)
+		err := s.query(s.q(`SELECT `+txColumns+` FROM txs WHERE txid > ? ORDER BY txid LIMIT ?`), []any{after, batchSize}, func(rows *sql.Rows) error {
+			t, err := scanTx(rows)
+			if err == nil {
+				chunk = append(chunk, t)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range chunk {
+			if !fn(t) {
+				return nil
+			}
+		}
+		if len(chunk) < batchSize {
+			return nil
+		}
+		after = chunk[len(chunk)-1].Hash
+	}
+}
+
+func (s *SQL) BlockExists(hash string) (bool, error) {
+	var one int
+	err := s.db.QueryRow(s.q(`SELECT 1 FROM blocks WHERE hash = ?`), hash).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SQL) BlockGet(hash string) ([]string, error) {
+	rows, err := s.db.Query(s.q(`SELECT txid FROM block_txs WHERE block_hash = ? ORDER BY pos`), hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var txs []string
+	for rows.Next() {
+		var txid string
+		if err := rows.Scan(&txid); err != nil {
+			return nil, err
+		}
+		txs = append(txs, txid)
+	}
+	return txs, rows.Err()
+}
+
+func (s *SQL) BlockList() ([]string, error) {
+	ret := make([]string, 0)
+	err := s.query(`SELECT hash FROM blocks`, nil, func(rows *sql.Rows) error {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return err
+		}
+		ret = append(ret, hash)
+		return nil
+	})
+	return ret, err
+}
+
+func (s *SQL) BlockAdd(hash string, txs []string) error {
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		_, err := dtx.Exec(s.q(`INSERT INTO blocks (hash, added_at) VALUES (?, ?)
+			ON CONFLICT (hash) DO NOTHING`), hash, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		if _, err := dtx.Exec(s.q(`DELETE FROM block_txs WHERE block_hash = ?`), hash); err != nil {
+			return err
+		}
+		stmt, err := dtx.Prepare(s.q(`INSERT INTO block_txs (block_hash, pos, txid) VALUES (?, ?, ?)`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i, txid := range txs {
+			if _, err := stmt.Exec(hash, i, txid); err != nil {
+				return err
+			}
+		}
+		// evicted from the pool because it was mined
+		_, err = dtx.Exec(s.q(`DELETE FROM evictions WHERE txid IN (SELECT txid FROM block_txs WHERE block_hash = ?)`), hash)
+		return err
+	})
+}
+
+// drops the block txs, block stats and audit are kept for the history
+func (s *SQL) BlockRemove(hash string) error {
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		// tx can be in another block after reorg
+		res, err := dtx.Exec(s.q(`DELETE FROM txs WHERE txid IN (SELECT txid FROM block_txs WHERE block_hash = ?)
+			AND NOT EXISTS (SELECT 1 FROM block_txs o WHERE o.txid = txs.txid AND o.block_hash <> ?)`), hash, hash)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			s.prunedConfirmed.Add(uint64(n))
+		}
+		if _, err := dtx.Exec(s.q(`DELETE FROM block_txs WHERE block_hash = ?`), hash); err != nil {
+			return err
+		}
+		_, err = dtx.Exec(s.q(`DELETE FROM blocks WHERE hash = ?`), hash)
+		return err
+	})
+}
+
+func (s *SQL) BlockStatsAdd(b block.Block) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.q(`INSERT INTO block_stats (hash, height, time, txs, value, fee, weight, size, reward, subsidy,
+			fee_rate_min, fee_rate_median, fee_rate_max, segwit_txs, taproot_txs, inputs, outputs, pool, in_window, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET height = excluded.height, time = excluded.time, txs = excluded.txs,
+			value = excluded.value, fee = excluded.fee, weight = excluded.weight, size = excluded.size,
+			reward = excluded.reward, subsidy = excluded.subsidy, fee_rate_min = excluded.fee_rate_min,
+			fee_rate_median = excluded.fee_rate_median, fee_rate_max = excluded.fee_rate_max,
+			segwit_txs = excluded.segwit_txs, taproot_txs = excluded.taproot_txs, inputs = excluded.inputs,
+			outputs = excluded.outputs, pool = excluded.pool, in_window = excluded.in_window, data = excluded.data`),
+		b.Hash, b.Height, b.Time, int64(b.Txs), int64(b.Value), int64(b.Fee), int64(b.Weight), int64(b.Size),
+		int64(b.Reward), int64(b.Subsidy), int64(b.FeeRateMin), int64(b.FeeRateMedian), int64(b.FeeRateMax),
+		int64(b.SegwitTxs), int64(b.TaprootTxs), int64(b.Inputs), int64(b.Outputs), b.Pool, true, string(data))
+	return err
+}
+
+// block stats and audit are kept for the history, the block is only taken out of the window
+func (s *SQL) BlockStatsRemove(hash string) error {
+	_, err := s.db.Exec(s.q(`UPDATE block_stats SET in_window = ? WHERE hash = ?`), false, hash)
+	return err
+}
+
+// blocks in the window only
+func (s *SQL) BlockStatsList() ([]block.Block, error) {
+	rows, err := s.db.Query(s.q(`SELECT data FROM block_stats WHERE in_window = ? ORDER BY height`), true)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make([]block.Block, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var b block.Block
+		if err := json.Unmarshal([]byte(data), &b); err != nil {
+			return nil, err
+		}
+		ret = append(ret, b)
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQL) AuditGet(hash string) (*block.Audit, error) {
+	var data string
+	err := s.db.QueryRow(s.q(`SELECT data FROM audits WHERE hash = ?`), hash).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a block.Audit
+	if err := json.Unmarshal([]byte(data), &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *SQL) AuditAdd(a block.Audit) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.q(`INSERT INTO audits (hash, height, time, expected_txs, matched_txs, expected_fee, actual_fee, fee_delta, health, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET height = excluded.height, time = excluded.time,
+			expected_txs = excluded.expected_txs, matched_txs = excluded.matched_txs,
+			expected_fee = excluded.expected_fee, actual_fee = excluded.actual_fee,
+			fee_delta = excluded.fee_delta, health = excluded.health, data = excluded.data`),
+		a.Hash, a.Height, a.Time, a.ExpectedTxs, a.MatchedTxs, int64(a.ExpectedFee), int64(a.ActualFee), a.FeeDelta, a.Health, string(data))
+	return err
+}
+
+func (s *SQL) PoolStatsAdd(ps storage.PoolStats) error {
+	t := ps.Time.Unix()
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		_, err := dtx.Exec(s.q(`INSERT INTO pool_stats (time, height, count, size, weight, fee, amount,
+				projected_txs, projected_fee, projected_size, projected_weight, projected_min_fee_rate, projected_median_fee_rate)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (time) DO NOTHING`),
+			t, ps.Height, ps.Count, int64(ps.Size), int64(ps.Weight), int64(ps.Fee), int64(ps.Amount),
+			ps.Projected.Txs, int64(ps.Projected.Fee), int64(ps.Projected.Size), int64(ps.Projected.Weight),
+			ps.Projected.MinFeeRate, ps.Projected.MedianFeeRate)
+		if err != nil {
+			return err
+		}
+		for bucket, n := range ps.FeeBuckets {
+			_, err := dtx.Exec(s.q(`INSERT INTO pool_fee_buckets (time, bucket, txs) VALUES (?, ?, ?)
+				ON CONFLICT (time, bucket) DO NOTHING`), t, int64(bucket), int64(n))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQL) HistoryAdd(series string, points []storage.Point) error {
+	return s.inTx(context.Background(), func(dtx *sql.Tx) error {
+		for _, p := range points {
+			data, err := json.Marshal(p.Values)
+			if err != nil {
+				return err
+			}
+			_, err = dtx.Exec(s.q(`INSERT INTO history (series, time, vals) VALUES (?, ?, ?)
+				ON CONFLICT (series, time) DO UPDATE SET vals = excluded.vals`), series, p.Time.Unix(), string(data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SQL) HistoryRange(series string, from, to time.Time) ([]storage.Point, error) {
+	rows, err := s.db.Query(s.q(`SELECT time, vals FROM history WHERE series = ? AND time >= ? AND time <= ? ORDER BY time`),
+		series, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make([]storage.Point, 0)
+	for rows.Next() {
+		var t int64
+		var data string
+		if err := rows.Scan(&t, &data); err != nil {
+			return nil, err
+		}
+		p := storage.Point{Time: time.Unix(t, 0)}
+		if err := json.Unmarshal([]byte(data), &p.Values); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQL) HistoryTrim(series string, before time.Time) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM history WHERE series = ? AND time < ?`), series, before.Unix())
+	return err
+}
+
+func (s *SQL) Stats() storage.Stats {
+	log := logger.Log.WithField("context", "[sql]")
+	ret := storage.Stats{
+		PrunedConfirmed: s.prunedConfirmed.Load(),
+		PrunedEvicted:   s.prunedEvicted.Load(),
+		PrunedTTL:       s.prunedTTL.Load(),
+	}
+	counts := []struct {
+		dst   *int
+		query string
+	}{
+		{&ret.Txs, `SELECT COUNT(*) FROM txs`},
+		{&ret.Confirmed, `SELECT COUNT(*) FROM block_txs`},
+		{&ret.Evicted, `SELECT COUNT(*) FROM evictions`},
+		{&ret.Blocks, `SELECT COUNT(*) FROM blocks`},
+		{&ret.Audits, `SELECT COUNT(*) FROM audits`},
+	}
+	for _, c := range counts {
+		if err := s.db.QueryRow(c.query).Scan(c.dst); err != nil {
+			log.Errorf("error on stats: %v\n", err)
+			return ret
+		}
+	}
+	query := `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`
+	if s.driver == DriverPostgres {
+		query = `SELECT pg_database_size(current_database())`
+	}
+	var size int64
+	if err := s.db.QueryRow(query).Scan(&size); err != nil {
+		log.Errorf("error on db size: %v\n", err)
+	}
+	ret.DiskBytes = uint64(size)
+	return ret
+}
+
+func (s *SQL) Close() error {
+	if s.driver == DriverSqlite {
+		// refresh the query planner stats
+		_, _ = s.db.Exec(`PRAGMA optimize`)
+	}
+	return s.db.Close()
+}
+
+// drop txs evicted longer than the grace period ago and txs older than the ttl
+// must be called within the transaction
+func (s *SQL) prune(dtx *sql.Tx, now time.Time) error {
+	cutoff := now.Add(-s.retention.EvictedGrace).Unix()
+	res, err := dtx.Exec(s.q(`DELETE FROM txs WHERE txid IN (SELECT txid FROM evictions WHERE evicted_at < ?)`), cutoff)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		s.prunedEvicted.Add(uint64(n))
+	}
+	if _, err := dtx.Exec(s.q(`DELETE FROM evictions WHERE evicted_at < ?`), cutoff); err != nil {
+		return err
+	}
+	if s.retention.TxTTL == 0 {
+		return nil
+	}
+	// confirmed txs are dropped with the block
+	res, err = dtx.Exec(s.q(`DELETE FROM txs WHERE added_at < ?
+		AND NOT EXISTS (SELECT 1 FROM block_txs WHERE block_txs.txid = txs.txid)`), now.Add(-s.retention.TxTTL).Unix())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		s.prunedTTL.Add(uint64(n))
+	}
+	return nil
+}
+
+// call fn for every row
+func (s *SQL) query(query string, args []any, fn func(rows *sql.Rows) error) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQL) inTx(ctx context.Context, fn func(dtx *sql.Tx) error) error {
+	dtx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(dtx); err != nil {
+		_ = dtx.Rollback()
+		return err
+	}
+	return dtx.Commit()
+}
+
+// queries are written with ? placeholders, postgres needs $1, $2...
+func (s *SQL) q(query string) string {
+	if s.driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteByte('$')
+		b.WriteString(strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func scanTx(row interface{ Scan(dest ...any) error }) (tx.Tx, error) {
+	var t tx.Tx
+	var tm int64
+	err := row.Scan(&t.Hash, &tm, &t.Size, &t.Weight, &t.Fee, &t.AmountIn, &t.AmountOut, &t.Inputs, &t.Outputs,
+		&t.Coinbase, &t.Segwit, &t.Taproot, &t.RBF)
+	t.Time = time.Unix(tm, 0)
+	return t, err
+}
+
+func txArgs(t tx.Tx, added time.Time) []any {
+	return []any{t.Hash, t.Time.Unix(), t.Size, t.Weight, int64(t.Fee), int64(t.AmountIn), int64(t.AmountOut), t.Inputs, t.Outputs,
+		t.Coinbase, t.Segwit, t.Taproot, t.RBF, added.Unix()}
+}
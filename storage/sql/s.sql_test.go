@@ -0,0 +1,155 @@
+package storage_sql
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/entity/models/block"
+	"github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+	"github.com/1F47E/go-feesh/storage/storagetest"
+)
+
+var _ storage.HistoryRepository = (*SQL)(nil)
+
+func newTestSqlite(t *testing.T, path string) *SQL {
+	t.Helper()
+	s, err := New(DriverSqlite, path, storage.Retention{EvictedGrace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// migrations run once, the block stats and audits outlive the window
+func TestBlockHistorySqlite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feesh.sqlite")
+	s := newTestSqlite(t, path)
+	txids := []string{"tx1", "tx2"}
+	for _, txid := range txids {
+		if err := s.TxAdd(tx.Tx{Hash: txid, Fee: 100, Size: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.BlockAdd("b1", txids); err != nil {
+		t.Fatal(err)
+	}
+	b := block.Block{Hash: "b1", Height: 1, Txs: 2}
+	if err := s.BlockStatsAdd(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AuditAdd(block.Audit{Hash: "b1", Height: 1, Health: 99.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = newTestSqlite(t, path)
+	defer s.Close()
+	if got, err := s.TxGet("tx1"); err != nil || got == nil || got.Fee != 100 || got.Size != 200 {
+		t.Fatalf("tx after reopen: %+v, %v", got, err)
+	}
+	if got, err := s.BlockGet("b1"); err != nil || !reflect.DeepEqual(got, txids) {
+		t.Fatalf("block txs after reopen: %v, %v", got, err)
+	}
+
+	if err := s.BlockRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.TxGet("tx1"); err != nil || got != nil {
+		t.Fatalf("tx of the removed block: %+v, %v", got, err)
+	}
+	if got, err := s.BlockStatsList(); err != nil || !reflect.DeepEqual(got, []block.Block{b}) {
+		t.Fatalf("block stats: %v, %v", got, err)
+	}
+
+	// out of the window, kept for the analytics
+	if err := s.BlockStatsRemove("b1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.BlockStatsList(); err != nil || len(got) != 0 {
+		t.Fatalf("block stats after the removal: %v, %v", got, err)
+	}
+	if a, err := s.AuditGet("b1"); err != nil || a == nil || a.Health != 99.5 {
+		t.Fatalf("audit after the removal: %+v, %v", a, err)
+	}
+	if st := s.Stats(); st.PrunedConfirmed != 2 || st.Blocks != 0 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
+
+func TestHistorySqlite(t *testing.T) {
+	s := newTestSqlite(t, filepath.Join(t.TempDir(), "feesh.sqlite"))
+	defer s.Close()
+	start := time.Unix(1700000000, 0)
+	points := make([]storage.Point, 0)
+	for i := 0; i < 5; i++ {
+		points = append(points, storage.Point{Time: start.Add(time.Duration(i) * time.Minute), Values: []float64{float64(i), 1}})
+	}
+	if err := s.HistoryAdd("pool", points); err != nil {
+		t.Fatal(err)
+	}
+	// same time is replaced
+	if err := s.HistoryAdd("pool", []storage.Point{{Time: points[4].Time, Values: []float64{42}}}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.HistoryRange("pool", points[1].Time, points[4].Time)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 || !got[0].Time.Equal(points[1].Time) || !reflect.DeepEqual(got[3].Values, []float64{42}) {
+		t.Fatalf("range: %+v", got)
+	}
+	if err := s.HistoryTrim("pool", points[2].Time); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.HistoryRange("pool", start, start.Add(time.Hour)); err != nil || len(got) != 3 {
+		t.Fatalf("after trim: %+v, %v", got, err)
+	}
+
+	// pool aggregates are written once per time
+	ps := storage.PoolStats{Time: start, Height: 1, Count: 10, FeeBuckets: map[uint]uint{1: 3, 2: 7}}
+	for i := 0; i < 2; i++ {
+		if err := s.PoolStatsAdd(ps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pool_fee_buckets`).Scan(&n); err != nil || n != 2 {
+		t.Fatalf("fee buckets rows: %d, %v", n, err)
+	}
+}
+
+func TestConformanceSqlite(t *testing.T) {
+	storagetest.Test(t, func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		s, err := New(DriverSqlite, filepath.Join(t.TempDir(), "feesh.sqlite"), r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() { s.Close() }, nil
+	})
+}
+
+// all tables are dropped after every case, do not point it to a real database
+func TestConformancePostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	storagetest.Test(t, func(r storage.Retention) (storage.PoolRepository, func(), error) {
+		s, err := New(DriverPostgres, dsn, r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {
+			s.Close()
+			if err := Drop(DriverPostgres, dsn); err != nil {
+				t.Errorf("error on dropping tables: %v", err)
+			}
+		}, nil
+	})
+}
@@ -0,0 +1,610 @@
+// Package storagetest is the conformance suite for storage.PoolRepository backends.
+// Every backend should pass it, run by the backend tests with Test or with cmd/storagecheck.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	mblock "github.com/1F47E/go-feesh/entity/models/block"
+	mtx "github.com/1F47E/go-feesh/entity/models/tx"
+	"github.com/1F47E/go-feesh/storage"
+)
+
+// grace period used by the eviction case. Some backends keep times in seconds
+const evictedGrace = time.Second
+
+// new empty backend, cleanup closes it and drops its data
+type Factory func(r storage.Retention) (s storage.PoolRepository, cleanup func(), err error)
+
+type Result struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+type testCase struct {
+	name string
+	fn   func(s storage.PoolRepository) error
+}
+
+var cases = []testCase{
+	{"tx_get_add", txGetAdd},
+	{"tx_many", txMany},
+	{"tx_delete", txDelete},
+	{"tx_iterate", txIterate},
+	{"tx_evict", txEvict},
+	{"blocks", blocks},
+	{"block_reorg", blockReorg},
+	{"block_stats", blockStats},
+	{"audits", audits},
+	{"stats", stats},
+	{"history", history},
+}
+
+// run every case on a fresh backend
+func Run(f Factory) []Result {
+	ret := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		ret = append(ret, run(f, c))
+	}
+	return ret
+}
+
+// run every case as a subtest on a fresh backend
+func Test(t *testing.T, f Factory) {
+	t.Helper()
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if r := run(f, c); r.Err != nil {
+				t.Fatal(r.Err)
+			}
+		})
+	}
+}
+
+func run(f Factory, c testCase) (ret Result) {
+	ret.Name = c.name
+	now := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			ret.Err = fmt.Errorf("panic: %v", r)
+		}
+		ret.Duration = time.Since(now)
+	}()
+	s, cleanup, err := f(storage.Retention{EvictedGrace: evictedGrace})
+	if err != nil {
+		ret.Err = fmt.Errorf("error on creating storage: %w", err)
+		return ret
+	}
+	defer cleanup()
+	ret.Err = c.fn(s)
+	return ret
+}
+
+func txGetAdd(s storage.PoolRepository) error {
+	got, err := s.TxGet(txid(1))
+	if err != nil {
+		return err
+	}
+	if got != nil {
+		return errors.New("missing tx is found")
+	}
+	want := newTx(1)
+	if err := s.TxAdd(want); err != nil {
+		return err
+	}
+	if err := expectTx(s, want); err != nil {
+		return err
+	}
+	// overwrite
+	want.Fee *= 2
+	want.Outputs++
+	if err := s.TxAdd(want); err != nil {
+		return err
+	}
+	return expectTx(s, want)
+}
+
+func txMany(s storage.PoolRepository) error {
+	// more than a single batch in every backend
+	txs := make([]mtx.Tx, 2500)
+	ids := make([]string, 0, len(txs)+10)
+	for i := range txs {
+		txs[i] = newTx(i)
+		ids = append(ids, txs[i].Hash)
+	}
+	if err := s.TxAddMany(txs); err != nil {
+		return err
+	}
+	for i := 0; i < 10; i++ {
+		ids = append(ids, txid(100000+i))
+	}
+	got, err := s.TxGetMany(ids)
+	if err != nil {
+		return err
+	}
+	if len(got) != len(txs) {
+		return fmt.Errorf("got %d txs, want %d", len(got), len(txs))
+	}
+	for _, t := range txs {
+		g, ok := got[t.Hash]
+		if !ok {
+			return fmt.Errorf("tx %s is missing", t.Hash)
+		}
+		if err := equalTx(g, t); err != nil {
+			return err
+		}
+	}
+	got, err = s.TxGetMany(nil)
+	if err != nil {
+		return err
+	}
+	if len(got) != 0 {
+		return fmt.Errorf("got %d txs for empty ids", len(got))
+	}
+	return nil
+}
+
+func txDelete(s storage.PoolRepository) error {
+	if err := s.TxAddMany([]mtx.Tx{newTx(1), newTx(2), newTx(3)}); err != nil {
+		return err
+	}
+	if err := s.TxEvict([]string{txid(2)}); err != nil {
+		return err
+	}
+	// missing txid is not an error
+	if err := s.TxDelete([]string{txid(1), txid(2), txid(4)}); err != nil {
+		return err
+	}
+	got, err := s.TxGetMany([]string{txid(1), txid(2), txid(3)})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 {
+		return fmt.Errorf("got %d txs after delete, want 1", len(got))
+	}
+	if _, ok := got[txid(3)]; !ok {
+		return errors.New("not deleted tx is missing")
+	}
+	// added again after delete
+	if err := s.TxAdd(newTx(2)); err != nil {
+		return err
+	}
+	return expectTx(s, newTx(2))
+}
+
+func txIterate(s storage.PoolRepository) error {
+	txs := make([]mtx.Tx, 2100)
+	for i := range txs {
+		txs[i] = newTx(i)
+	}
+	if err := s.TxAddMany(txs); err != nil {
+		return err
+	}
+	seen := make(map[string]int)
+	err := s.TxIterate(func(t mtx.Tx) bool {
+		seen[t.Hash]++
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(seen) != len(txs) {
+		return fmt.Errorf("iterated %d txs, want %d", len(seen), len(txs))
+	}
+	for txid, n := range seen {
+		if n != 1 {
+			return fmt.Errorf("tx %s iterated %d times", txid, n)
+		}
+	}
+	// stop early
+	n := 0
+	err = s.TxIterate(func(t mtx.Tx) bool {
+		n++
+		return n < 3
+	})
+	if err != nil {
+		return err
+	}
+	if n != 3 {
+		return fmt.Errorf("iteration is not stopped, %d calls", n)
+	}
+	return nil
+}
+
+// evicted txs are dropped after the grace period, mined ones are kept
+func txEvict(s storage.PoolRepository) error {
+	if err := s.TxAddMany([]mtx.Tx{newTx(1), newTx(2), newTx(3)}); err != nil {
+		return err
+	}
+	if err := s.BlockAdd(blockHash(1), []string{txid(2)}); err != nil {
+		return err
+	}
+	if err := s.TxEvict([]string{txid(1), txid(2)}); err != nil {
+		return err
+	}
+	got, err := s.TxGetMany([]string{txid(1), txid(2), txid(3)})
+	if err != nil {
+		return err
+	}
+	if len(got) != 3 {
+		return fmt.Errorf("got %d txs within the grace period, want 3", len(got))
+	}
+	time.Sleep(2*evictedGrace + 200*time.Millisecond)
+	// pruning can be lazy
+	if err := s.TxEvict(nil); err != nil {
+		return err
+	}
+	got, err = s.TxGetMany([]string{txid(1), txid(2), txid(3)})
+	if err != nil {
+		return err
+	}
+	if _, ok := got[txid(1)]; ok {
+		return errors.New("evicted tx is kept after the grace period")
+	}
+	if _, ok := got[txid(2)]; !ok {
+		return errors.New("mined tx is dropped on eviction")
+	}
+	if _, ok := got[txid(3)]; !ok {
+		return errors.New("pool tx is dropped")
+	}
+	return nil
+}
+
+func blocks(s storage.PoolRepository) error {
+	ok, err := s.BlockExists(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("missing block exists")
+	}
+	txs, err := s.BlockGet(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if len(txs) != 0 {
+		return errors.New("missing block has txs")
+	}
+	// order is kept
+	want := []string{txid(5), txid(3), txid(9), txid(1)}
+	if err := s.BlockAdd(blockHash(1), want); err != nil {
+		return err
+	}
+	if err := s.BlockAdd(blockHash(2), []string{txid(7)}); err != nil {
+		return err
+	}
+	ok, err = s.BlockExists(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("added block does not exist")
+	}
+	txs, err = s.BlockGet(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(txs, want) {
+		return fmt.Errorf("block txs %v, want %v", txs, want)
+	}
+	if err := expectBlocks(s, blockHash(1), blockHash(2)); err != nil {
+		return err
+	}
+
+	// block txs are dropped with the block
+	if err := s.TxAddMany([]mtx.Tx{newTx(5), newTx(3), newTx(100)}); err != nil {
+		return err
+	}
+	if err := s.BlockRemove(blockHash(1)); err != nil {
+		return err
+	}
+	ok, err = s.BlockExists(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("removed block exists")
+	}
+	got, err := s.TxGetMany([]string{txid(5), txid(3), txid(100)})
+	if err != nil {
+		return err
+	}
+	if len(got) != 1 {
+		return fmt.Errorf("got %d txs after block remove, want 1", len(got))
+	}
+	if err := expectBlocks(s, blockHash(2)); err != nil {
+		return err
+	}
+	// missing block is not an error
+	return s.BlockRemove(blockHash(3))
+}
+
+// tx moved to another block is kept when the stale block is removed
+func blockReorg(s storage.PoolRepository) error {
+	if err := s.TxAddMany([]mtx.Tx{newTx(1), newTx(2)}); err != nil {
+		return err
+	}
+	if err := s.BlockAdd(blockHash(1), []string{txid(1), txid(2)}); err != nil {
+		return err
+	}
+	if err := s.BlockAdd(blockHash(2), []string{txid(1)}); err != nil {
+		return err
+	}
+	if err := s.BlockRemove(blockHash(1)); err != nil {
+		return err
+	}
+	got, err := s.TxGetMany([]string{txid(1), txid(2)})
+	if err != nil {
+		return err
+	}
+	if _, ok := got[txid(1)]; !ok {
+		return errors.New("tx in the new block is dropped with the stale one")
+	}
+	if _, ok := got[txid(2)]; ok {
+		return errors.New("tx of the stale block is kept")
+	}
+	return nil
+}
+
+func blockStats(s storage.PoolRepository) error {
+	want := []mblock.Block{newBlock(1), newBlock(2)}
+	for _, b := range want {
+		if err := s.BlockAdd(b.Hash, nil); err != nil {
+			return err
+		}
+		if err := s.BlockStatsAdd(b); err != nil {
+			return err
+		}
+	}
+	// overwrite
+	want[1].Fee++
+	if err := s.BlockStatsAdd(want[1]); err != nil {
+		return err
+	}
+	if err := expectBlockStats(s, want); err != nil {
+		return err
+	}
+	if err := s.AuditAdd(mblock.Audit{Hash: want[0].Hash}); err != nil {
+		return err
+	}
+	// stats and audit outlive the block txs
+	if err := s.BlockRemove(want[0].Hash); err != nil {
+		return err
+	}
+	if err := expectBlockStats(s, want); err != nil {
+		return err
+	}
+	a, err := s.AuditGet(want[0].Hash)
+	if err != nil {
+		return err
+	}
+	if a == nil {
+		return errors.New("audit is dropped with the block txs")
+	}
+	if err := s.BlockStatsRemove(want[0].Hash); err != nil {
+		return err
+	}
+	if err := expectBlockStats(s, want[1:]); err != nil {
+		return err
+	}
+	// missing block is not an error
+	return s.BlockStatsRemove(blockHash(3))
+}
+
+func audits(s storage.PoolRepository) error {
+	got, err := s.AuditGet(blockHash(1))
+	if err != nil {
+		return err
+	}
+	if got != nil {
+		return errors.New("missing audit is found")
+	}
+	want := mblock.Audit{
+		Hash:        blockHash(1),
+		Height:      800001,
+		Time:        time.Now().Unix(),
+		ExpectedTxs: 3,
+		MatchedTxs:  2,
+		Missing:     []string{txid(1)},
+		Added:       []string{txid(2)},
+		Unseen:      []string{txid(3)},
+		ExpectedFee: 3000,
+		ActualFee:   2500,
+		FeeDelta:    -500,
+		Health:      66.6,
+	}
+	if err := s.AuditAdd(want); err != nil {
+		return err
+	}
+	got, err = s.AuditGet(want.Hash)
+	if err != nil {
+		return err
+	}
+	if got == nil || !reflect.DeepEqual(*got, want) {
+		return fmt.Errorf("audit %+v, want %+v", got, want)
+	}
+	return nil
+}
+
+func stats(s storage.PoolRepository) error {
+	if err := s.TxAddMany([]mtx.Tx{newTx(1), newTx(2), newTx(3)}); err != nil {
+		return err
+	}
+	if err := s.BlockAdd(blockHash(1), []string{txid(1)}); err != nil {
+		return err
+	}
+	if err := s.AuditAdd(mblock.Audit{Hash: blockHash(1)}); err != nil {
+		return err
+	}
+	st := s.Stats()
+	// 0 if the backend can't count txs
+	if st.Txs != 0 && st.Txs != 3 {
+		return fmt.Errorf("txs %d, want 3", st.Txs)
+	}
+	if st.Blocks != 1 || st.Confirmed != 1 || st.Audits != 1 {
+		return fmt.Errorf("blocks %d, confirmed %d, audits %d, want 1", st.Blocks, st.Confirmed, st.Audits)
+	}
+	return nil
+}
+
+// optional interface, skipped if not implemented
+func history(s storage.PoolRepository) error {
+	hs, ok := s.(storage.HistoryRepository)
+	if !ok {
+		return nil
+	}
+	// whole seconds, some backends keep nothing finer
+	start := time.Now().Truncate(time.Second).Add(-time.Hour)
+	points := make([]storage.Point, 10)
+	for i := range points {
+		points[i] = storage.Point{Time: start.Add(time.Duration(i) * time.Minute), Values: []float64{float64(i), 0.5}}
+	}
+	if err := hs.HistoryAdd("check", points); err != nil {
+		return err
+	}
+	if err := hs.HistoryAdd("other", points[:1]); err != nil {
+		return err
+	}
+	got, err := hs.HistoryRange("check", points[2].Time, points[5].Time)
+	if err != nil {
+		return err
+	}
+	if err := equalPoints(got, points[2:6]); err != nil {
+		return err
+	}
+	if err := hs.HistoryTrim("check", points[8].Time); err != nil {
+		return err
+	}
+	got, err = hs.HistoryRange("check", start, start.Add(time.Hour))
+	if err != nil {
+		return err
+	}
+	if err := equalPoints(got, points[8:]); err != nil {
+		return err
+	}
+	got, err = hs.HistoryRange("other", start, start.Add(time.Hour))
+	if err != nil {
+		return err
+	}
+	return equalPoints(got, points[:1])
+}
+
+func expectTx(s storage.PoolRepository, want mtx.Tx) error {
+	got, err := s.TxGet(want.Hash)
+	if err != nil {
+		return err
+	}
+	if got == nil {
+		return fmt.Errorf("tx %s is missing", want.Hash)
+	}
+	return equalTx(*got, want)
+}
+
+// tx time is kept with seconds precision
+func equalTx(got, want mtx.Tx) error {
+	if got.Time.Unix() != want.Time.Unix() {
+		return fmt.Errorf("tx %s time %v, want %v", want.Hash, got.Time, want.Time)
+	}
+	got.Time, want.Time = time.Time{}, time.Time{}
+	if got != want {
+		return fmt.Errorf("tx %+v, want %+v", got, want)
+	}
+	return nil
+}
+
+func expectBlocks(s storage.PoolRepository, want ...string) error {
+	got, err := s.BlockList()
+	if err != nil {
+		return err
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("blocks %v, want %v", got, want)
+	}
+	return nil
+}
+
+func expectBlockStats(s storage.PoolRepository, want []mblock.Block) error {
+	got, err := s.BlockStatsList()
+	if err != nil {
+		return err
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].Height < got[j].Height })
+	if len(got) == 0 && len(want) == 0 {
+		return nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("block stats %+v, want %+v", got, want)
+	}
+	return nil
+}
+
+func equalPoints(got, want []storage.Point) error {
+	if len(got) != len(want) {
+		return fmt.Errorf("got %d points, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) || !reflect.DeepEqual(got[i].Values, want[i].Values) {
+			return fmt.Errorf("point %d %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	return nil
+}
+
+func txid(i int) string {
+	return fmt.Sprintf("%064x", i)
+}
+
+func blockHash(i int) string {
+	return fmt.Sprintf("%064x", 1<<40+i)
+}
+
+func newTx(i int) mtx.Tx {
+	return mtx.Tx{
+		Hash:      txid(i),
+		Time:      time.Unix(1700000000+int64(i), 0),
+		Size:      uint32(200 + i%300),
+		Weight:    uint32(560 + i%900),
+		Fee:       uint64(1000 + i),
+		AmountIn:  uint64(100000 + i),
+		AmountOut: uint64(99000 + i),
+		Inputs:    uint32(1 + i%3),
+		Outputs:   uint32(2 + i%2),
+		Segwit:    i%2 == 0,
+		Taproot:   i%5 == 0,
+		RBF:       i%4 == 0,
+	}
+}
+
+func newBlock(i int) mblock.Block {
+	return mblock.Block{
+		Hash:               blockHash(i),
+		Height:             800000 + i,
+		Time:               1700000000 + int64(i)*600,
+		Value:              5000000000,
+		Fee:                uint64(20000000 + i),
+		Weight:             3990000,
+		Size:               1500000,
+		Txs:                3000,
+		Reward:             645000000,
+		Subsidy:            625000000,
+		FeeRateMin:         1,
+		FeeRateMedian:      12,
+		FeeRateMax:         300,
+		FeeRatePercentiles: [5]uint64{2, 5, 12, 20, 40},
+		SegwitTxs:          2500,
+		TaprootTxs:         800,
+		Inputs:             7000,
+		Outputs:            9000,
+		Pool:               "Foundry USA",
+		PoolLink:           "https://foundrydigital.com",
+		NodeStats:          true,
+	}
+}
@@ -0,0 +1,252 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/logger"
+)
+
+const (
+	backoffMin = 1 * time.Second
+	backoffMax = 1 * time.Minute
+	// worker running longer than this without crash resets the backoff
+	backoffReset = 1 * time.Minute
+	// stale worker is considered dead after staleAfter * deadFactor
+	deadFactor = 5
+	// readiness waits for the first beat of the workers with stale period up to this,
+	// slow periodic ones are not waited for
+	readyGateMax = time.Minute
+)
+
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded"
+	StatusDown     Status = "down"
+)
+
+type WorkerHealth struct {
+	Name       string    `json:"name"`
+	Running    bool      `json:"running"`
+	Stale      bool      `json:"stale"`
+	Blocked    bool      `json:"blocked"` // waiting on backpressure, staleness is not counted
+	LastBeat   time.Time `json:"last_beat"`
+	StaleAfter string    `json:"stale_after,omitempty"`
+	Restarts   int       `json:"restarts"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+type Health struct {
+	Status  Status         `json:"status"`
+	Workers []WorkerHealth `json:"workers"`
+}
+
+type worker struct {
+	name       string
+	staleAfter time.Duration // 0 - no staleness check, idle worker
+	started    time.Time
+	lastBeat   time.Time
+	running    bool
+	restarts   int
+	lastErr    string
+	blocked    int // calls waiting on backpressure
+}
+
+// runs workers, restarts crashed ones with backoff and tracks their liveness
+type Supervisor struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	workers map[string]*worker
+}
+
+func New() *Supervisor {
+	return &Supervisor{
+		workers: make(map[string]*worker),
+	}
+}
+
+// run fn in a goroutine until ctx is done.
+// Worker should call Beat after every successful iteration,
+// if there are no beats for staleAfter the service is degraded
+func (s *Supervisor) Go(ctx context.Context, name string, staleAfter time.Duration, fn func(ctx context.Context)) {
+	s.mu.Lock()
+	s.workers[name] = &worker{name: name, staleAfter: staleAfter, started: time.Now()}
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(ctx, name, fn)
+	}()
+}
+
+// wait for all workers to stop after their context is done
+func (s *Supervisor) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("workers are still running: %w", ctx.Err())
+	}
+}
+
+func (s *Supervisor) run(ctx context.Context, name string, fn func(ctx context.Context)) {
+	log := logger.Log.WithField("context", "[supervisor]")
+	backoff := backoffMin
+	for {
+		started := time.Now()
+		s.update(name, func(w *worker) {
+			w.running = true
+			w.started = started
+		})
+		err := s.call(ctx, fn)
+		s.update(name, func(w *worker) {
+			w.running = false
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("worker exited")
+		}
+		if time.Since(started) > backoffReset {
+			backoff = backoffMin
+		}
+		log.Errorf("worker %s crashed: %v. restarting in %s\n", name, err, backoff)
+		s.update(name, func(w *worker) {
+			w.restarts++
+			w.lastErr = err.Error()
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > backoffMax {
+			backoff = backoffMax
+		}
+	}
+}
+
+// call fn, panic is returned as error
+func (s *Supervisor) call(ctx context.Context, fn func(ctx context.Context)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	fn(ctx)
+	return nil
+}
+
+// mark successful iteration of the worker
+func (s *Supervisor) Beat(name string) {
+	s.update(name, func(w *worker) {
+		w.lastBeat = time.Now()
+	})
+}
+
+// mark the worker as waiting on backpressure, like a full queue,
+// the wait is not counted as stale. Returned func ends the wait with a beat
+func (s *Supervisor) Blocked(name string) func() {
+	s.update(name, func(w *worker) {
+		w.blocked++
+	})
+	return func() {
+		s.update(name, func(w *worker) {
+			w.blocked--
+			w.lastBeat = time.Now()
+		})
+	}
+}
+
+func (s *Supervisor) update(name string, fn func(w *worker)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.workers[name]; ok {
+		fn(w)
+	}
+}
+
+// ok - all workers are running and fresh
+// degraded - some worker is stale or restarting, data can be outdated
+// down - some worker is stale for too long
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	ret := Health{
+		Status:  StatusOK,
+		Workers: make([]WorkerHealth, 0, len(s.workers)),
+	}
+	for _, w := range s.workers {
+		h := WorkerHealth{
+			Name:      w.name,
+			Running:   w.running,
+			LastBeat:  w.lastBeat,
+			Restarts:  w.restarts,
+			LastError: w.lastErr,
+			Blocked:   w.blocked > 0,
+		}
+		if !w.running {
+			ret.Status = worse(ret.Status, StatusDegraded)
+		}
+		if w.staleAfter > 0 {
+			h.StaleAfter = w.staleAfter.String()
+		}
+		if w.staleAfter > 0 && w.blocked == 0 {
+			// not started beating yet is counted from the worker start
+			last := w.lastBeat
+			if last.IsZero() {
+				last = w.started
+			}
+			age := now.Sub(last)
+			if age > w.staleAfter {
+				h.Stale = true
+				ret.Status = worse(ret.Status, StatusDegraded)
+			}
+			if age > w.staleAfter*deadFactor {
+				ret.Status = worse(ret.Status, StatusDown)
+			}
+		}
+		ret.Workers = append(ret.Workers, h)
+	}
+	sort.Slice(ret.Workers, func(i, j int) bool {
+		return ret.Workers[i].Name < ret.Workers[j].Name
+	})
+	return ret
+}
+
+// fast workers with staleness check have done at least one successful iteration
+// and none of the workers is stale
+func (s *Supervisor) Ready() bool {
+	if s.Health().Status != StatusOK {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.workers {
+		if w.staleAfter > 0 && w.staleAfter <= readyGateMax && w.lastBeat.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+func worse(a, b Status) Status {
+	rank := map[Status]int{StatusOK: 0, StatusDegraded: 1, StatusDown: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
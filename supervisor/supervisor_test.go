@@ -0,0 +1,72 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadyWaitsForFastWorkersOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New()
+	idle := func(ctx context.Context) { <-ctx.Done() }
+	s.Go(ctx, "fast", 30*time.Second, idle)
+	s.Go(ctx, "slow", 15*time.Minute, idle)
+	s.Go(ctx, "unchecked", 0, idle)
+
+	// workers are marked running by their goroutines
+	deadline := time.Now().Add(time.Second)
+	for s.Health().Status != StatusOK && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s.Ready() {
+		t.Fatal("ready before the first beat of the fast worker")
+	}
+	s.Beat("fast")
+	if !s.Ready() {
+		t.Fatalf("not ready after the fast worker beat: %+v", s.Health())
+	}
+}
+
+func TestBlockedIsNotStale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New()
+	const staleAfter = 50 * time.Millisecond
+	release := make(chan struct{})
+	s.Go(ctx, "pusher", staleAfter, func(ctx context.Context) {
+		s.Beat("pusher")
+		// full queue, blocked on push far longer than the dead period
+		done := s.Blocked("pusher")
+		<-release
+		done()
+		<-ctx.Done()
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for !s.Ready() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(staleAfter * deadFactor * 2)
+	h := s.Health()
+	if h.Status != StatusOK {
+		t.Fatalf("blocked worker is not ok: %+v", h)
+	}
+	if !h.Workers[0].Blocked || h.Workers[0].Stale {
+		t.Fatalf("worker health: %+v", h.Workers[0])
+	}
+
+	// the wait ends with a beat, staleness is counted from it
+	close(release)
+	for s.Health().Workers[0].Blocked {
+		time.Sleep(time.Millisecond)
+	}
+	if h := s.Health(); h.Status != StatusOK {
+		t.Fatalf("released worker health: %+v", h)
+	}
+	time.Sleep(staleAfter * deadFactor * 2)
+	if h := s.Health(); h.Status != StatusDown {
+		t.Fatalf("idle worker after the wait: got %s, want %s", h.Status, StatusDown)
+	}
+}
@@ -0,0 +1,311 @@
+// Package timeseries keeps pool metrics history at several resolutions.
+package timeseries
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1F47E/go-feesh/storage"
+)
+
+var (
+	ErrUnknownMetric     = errors.New("unknown metric")
+	ErrUnknownResolution = errors.New("unknown resolution")
+	ErrTooManyPoints     = errors.New("too many points")
+)
+
+// max points returned by a single query
+const MaxPoints = 5000
+
+type Resolution struct {
+	Name      string
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// finest first. Samples are added at the first resolution
+// and averaged into the coarser buckets
+var Resolutions = []Resolution{
+	{Name: "10s", Step: 10 * time.Second, Retention: 6 * time.Hour},
+	{Name: "1m", Step: time.Minute, Retention: 7 * 24 * time.Hour},
+	{Name: "1h", Step: time.Hour, Retention: 180 * 24 * time.Hour},
+	{Name: "1d", Step: 24 * time.Hour, Retention: 5 * 365 * 24 * time.Hour},
+}
+
+type Metric struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Unit        string   `json:"unit"`
+	Labels      []string `json:"labels,omitempty"` // value names of vector metrics
+}
+
+// metric points at a single resolution
+type level struct {
+	res    Resolution
+	points []storage.Point // oldest first, complete buckets only
+	// bucket being filled
+	start time.Time
+	sum   []float64
+	n     int
+}
+
+type series struct {
+	Metric
+	levels []*level
+}
+
+// multi resolution history of pool metrics.
+// Complete buckets are saved to the repository if it's set
+type Store struct {
+	mu     sync.RWMutex
+	series map[string]*series
+	order  []string
+	repo   storage.HistoryRepository
+}
+
+// repo can be nil, history is kept in memory then
+func New(metrics []Metric, repo storage.HistoryRepository) *Store {
+	s := &Store{
+		series: make(map[string]*series, len(metrics)),
+		repo:   repo,
+	}
+	for _, m := range metrics {
+		ser := &series{Metric: m, levels: make([]*level, len(Resolutions))}
+		for i, r := range Resolutions {
+			ser.levels[i] = &level{res: r}
+		}
+		s.series[m.Name] = ser
+		s.order = append(s.order, m.Name)
+	}
+	return s
+}
+
+func (s *Store) Metrics() []Metric {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make([]Metric, 0, len(s.order))
+	for _, name := range s.order {
+		ret = append(ret, s.series[name].Metric)
+	}
+	return ret
+}
+
+// restore the history within the retention.
+// Buckets of the current step saved by Flush are resumed
+func (s *Store) Load(now time.Time) error {
+	if s.repo == nil {
+		return nil
+	}
+	for _, name := range s.order {
+		ser := s.series[name]
+		for _, l := range ser.levels {
+			points, err := s.repo.HistoryRange(key(name, l.res), now.Add(-l.res.Retention), now)
+			if err != nil {
+				return fmt.Errorf("error on loading %s: %w", key(name, l.res), err)
+			}
+			s.mu.Lock()
+			l.points = points
+			// bucket saved unfinished on shutdown is filled further
+			if n := len(points); n > 0 && points[n-1].Time.Equal(now.Truncate(l.res.Step)) {
+				l.resume(points[n-1], now)
+				l.points = points[:n-1]
+			}
+			s.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// add a sample of every metric, missing metrics are skipped
+func (s *Store) Add(now time.Time, values map[string][]float64) error {
+	done := make(map[string][]storage.Point)
+	s.mu.Lock()
+	for name, v := range values {
+		ser, ok := s.series[name]
+		if !ok {
+			continue
+		}
+		for _, l := range ser.levels {
+			if p, ok := l.add(now, v); ok {
+				done[key(name, l.res)] = append(done[key(name, l.res)], p)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if s.repo == nil {
+		return nil
+	}
+	var errs []error
+	for k, points := range done {
+		if err := s.repo.HistoryAdd(k, points); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// drop the points older than the retention and save the partial buckets
+func (s *Store) Trim(now time.Time) error {
+	s.mu.Lock()
+	for _, ser := range s.series {
+		for _, l := range ser.levels {
+			from := now.Add(-l.res.Retention)
+			i := sort.Search(len(l.points), func(i int) bool {
+				return !l.points[i].Time.Before(from)
+			})
+			if i > 0 {
+				l.points = append(l.points[:0:0], l.points[i:]...)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if s.repo == nil {
+		return nil
+	}
+	var errs []error
+	for _, name := range s.order {
+		for _, r := range Resolutions {
+			if err := s.repo.HistoryTrim(key(name, r), now.Add(-r.Retention)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	// the partial buckets are saved too, a crash loses no more than a trim period
+	errs = append(errs, s.Flush())
+	return errors.Join(errs...)
+}
+
+// save the buckets being filled, they are replaced once complete.
+// Called on shutdown to keep the partial buckets over a restart
+func (s *Store) Flush() error {
+	if s.repo == nil {
+		return nil
+	}
+	partial := make(map[string]storage.Point)
+	s.mu.RLock()
+	for _, name := range s.order {
+		for _, l := range s.series[name].levels {
+			if l.n > 0 {
+				partial[key(name, l.res)] = l.current()
+			}
+		}
+	}
+	s.mu.RUnlock()
+	var errs []error
+	for k, p := range partial {
+		if err := s.repo.HistoryAdd(k, []storage.Point{p}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// points within [from, to], oldest first. The bucket being filled is included as the last point.
+// Empty resolution picks the finest one that keeps from and fits in MaxPoints
+func (s *Store) Range(metric, resolution string, from, to time.Time) ([]storage.Point, Resolution, error) {
+	res, err := pickResolution(resolution, from, to)
+	if err != nil {
+		return nil, res, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ser, ok := s.series[metric]
+	if !ok {
+		return nil, res, ErrUnknownMetric
+	}
+	var l *level
+	for _, lv := range ser.levels {
+		if lv.res.Name == res.Name {
+			l = lv
+		}
+	}
+	i := sort.Search(len(l.points), func(i int) bool {
+		return !l.points[i].Time.Before(from)
+	})
+	ret := make([]storage.Point, 0)
+	for ; i < len(l.points) && !l.points[i].Time.After(to); i++ {
+		ret = append(ret, l.points[i])
+	}
+	if l.n > 0 && !l.start.Before(from) && !l.start.After(to) {
+		ret = append(ret, l.current())
+	}
+	return ret, res, nil
+}
+
+func pickResolution(name string, from, to time.Time) (Resolution, error) {
+	points := func(r Resolution) int64 {
+		return int64(to.Sub(from) / r.Step)
+	}
+	if name != "" {
+		for _, r := range Resolutions {
+			if r.Name != name {
+				continue
+			}
+			if points(r) > MaxPoints {
+				return r, fmt.Errorf("%w, %s resolution allows up to %s", ErrTooManyPoints, r.Name, r.Step*MaxPoints)
+			}
+			return r, nil
+		}
+		return Resolution{}, ErrUnknownResolution
+	}
+	for _, r := range Resolutions {
+		if time.Since(from) <= r.Retention && points(r) <= MaxPoints {
+			return r, nil
+		}
+	}
+	return Resolutions[len(Resolutions)-1], nil
+}
+
+// add the sample to the current bucket, returns the previous bucket if it's complete
+func (l *level) add(now time.Time, v []float64) (storage.Point, bool) {
+	var ret storage.Point
+	var done bool
+	start := now.Truncate(l.res.Step)
+	if l.n > 0 && !start.Equal(l.start) {
+		ret = l.current()
+		done = true
+		l.points = append(l.points, ret)
+		l.n = 0
+	}
+	if l.n == 0 {
+		l.start = start
+		l.sum = make([]float64, len(v))
+	}
+	for i := range v {
+		if i < len(l.sum) {
+			l.sum[i] += v[i]
+		}
+	}
+	l.n++
+	return ret, done
+}
+
+// continue filling the bucket restored from the repository.
+// The sample count is not saved, the restored average weighs
+// as the samples of the finest step from the bucket start to now
+func (l *level) resume(p storage.Point, now time.Time) {
+	sample := Resolutions[0].Step
+	l.start = p.Time
+	l.n = int(min(max(now.Sub(p.Time)/sample, 1), l.res.Step/sample))
+	l.sum = make([]float64, len(p.Values))
+	for i, v := range p.Values {
+		l.sum[i] = v * float64(l.n)
+	}
+}
+
+// average of the bucket being filled
+func (l *level) current() storage.Point {
+	values := make([]float64, len(l.sum))
+	for i := range l.sum {
+		values[i] = l.sum[i] / float64(l.n)
+	}
+	return storage.Point{Time: l.start, Values: values}
+}
+
+// repository series name, pool_size:1m
+func key(metric string, r Resolution) string {
+	return metric + ":" + r.Name
+}
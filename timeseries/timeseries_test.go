@@ -0,0 +1,288 @@
+package timeseries
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/1F47E/go-feesh/storage"
+)
+
+// in memory history repository
+type memRepo map[string][]storage.Point
+
+func (m memRepo) HistoryAdd(series string, points []storage.Point) error {
+	for _, p := range points {
+		i := sort.Search(len(m[series]), func(i int) bool {
+			return !m[series][i].Time.Before(p.Time)
+		})
+		if i < len(m[series]) && m[series][i].Time.Equal(p.Time) {
+			m[series][i] = p
+			continue
+		}
+		m[series] = append(m[series][:i], append([]storage.Point{p}, m[series][i:]...)...)
+	}
+	return nil
+}
+
+func (m memRepo) HistoryRange(series string, from, to time.Time) ([]storage.Point, error) {
+	ret := make([]storage.Point, 0)
+	for _, p := range m[series] {
+		if !p.Time.Before(from) && !p.Time.After(to) {
+			ret = append(ret, p)
+		}
+	}
+	return ret, nil
+}
+
+func (m memRepo) HistoryTrim(series string, before time.Time) error {
+	ret := m[series][:0]
+	for _, p := range m[series] {
+		if !p.Time.Before(before) {
+			ret = append(ret, p)
+		}
+	}
+	m[series] = ret
+	return nil
+}
+
+var testMetrics = []Metric{
+	{Name: "size"},
+	{Name: "buckets", Labels: []string{"1", "2"}},
+}
+
+// start of a day, all the resolution buckets start there
+var day = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func TestLevelAdd(t *testing.T) {
+	l := &level{res: Resolution{Name: "1m", Step: time.Minute}}
+	tests := []struct {
+		at   time.Duration
+		v    []float64
+		want *storage.Point // completed bucket
+	}{
+		{0, []float64{1, 10}, nil},
+		{30 * time.Second, []float64{3, 20}, nil},
+		// last moment of the bucket
+		{time.Minute - time.Nanosecond, []float64{5, 30}, nil},
+		// boundary closes the bucket
+		{time.Minute, []float64{7, 40}, &storage.Point{Time: day, Values: []float64{3, 20}}},
+		// skipped bucket leaves no point
+		{3*time.Minute + time.Second, []float64{9, 50}, &storage.Point{Time: day.Add(time.Minute), Values: []float64{7, 40}}},
+		// short sample fills the known values only
+		{4 * time.Minute, []float64{1}, &storage.Point{Time: day.Add(3 * time.Minute), Values: []float64{9, 50}}},
+	}
+	for _, tt := range tests {
+		p, done := l.add(day.Add(tt.at), tt.v)
+		if done != (tt.want != nil) {
+			t.Fatalf("%s: done %v, want %v", tt.at, done, tt.want != nil)
+		}
+		if tt.want != nil && !reflect.DeepEqual(p, *tt.want) {
+			t.Fatalf("%s: got %v, want %v", tt.at, p, *tt.want)
+		}
+	}
+	if len(l.points) != 3 {
+		t.Fatalf("points: got %d, want 3", len(l.points))
+	}
+	if got := l.current(); !reflect.DeepEqual(got.Values, []float64{1}) {
+		t.Fatalf("current: got %v, want [1]", got.Values)
+	}
+}
+
+func TestAddRollup(t *testing.T) {
+	repo := memRepo{}
+	s := New(testMetrics, repo)
+	// a sample every 10s for two minutes and a bit, value is the minute
+	for i := 0; i <= 12; i++ {
+		at := day.Add(time.Duration(i) * 10 * time.Second)
+		v := float64(i / 6)
+		err := s.Add(at, map[string][]float64{
+			"size":    {v},
+			"buckets": {v, 2 * v},
+			"unknown": {1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(repo["size:10s"]); got != 12 {
+		t.Fatalf("10s points saved: got %d, want 12", got)
+	}
+	want := []storage.Point{
+		{Time: day, Values: []float64{0, 0}},
+		{Time: day.Add(time.Minute), Values: []float64{1, 2}},
+	}
+	if !reflect.DeepEqual(repo["buckets:1m"], want) {
+		t.Fatalf("1m points saved: got %v, want %v", repo["buckets:1m"], want)
+	}
+	if len(repo["size:1h"]) != 0 || len(repo["unknown:1m"]) != 0 {
+		t.Fatal("incomplete or unknown series saved")
+	}
+
+	// range adds the bucket being filled
+	points, res, err := s.Range("buckets", "1m", day, day.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, storage.Point{Time: day.Add(2 * time.Minute), Values: []float64{2, 4}})
+	if res.Name != "1m" || !reflect.DeepEqual(points, want) {
+		t.Fatalf("range: got %s %v, want %v", res.Name, points, want)
+	}
+	points, _, _ = s.Range("size", "1h", day, day.Add(time.Hour))
+	if len(points) != 1 || points[0].Values[0] != 8.0/13 {
+		t.Fatalf("1h range: got %v", points)
+	}
+	if _, _, err := s.Range("unknown", "1m", day, day.Add(time.Hour)); err != ErrUnknownMetric {
+		t.Fatalf("unknown metric: got %v", err)
+	}
+}
+
+func TestFlushResume(t *testing.T) {
+	repo := memRepo{}
+	s := New(testMetrics, repo)
+	// 30 minutes of 10s samples of 10, then shutdown
+	for i := 0; i < 180; i++ {
+		if err := s.Add(day.Add(time.Duration(i)*10*time.Second), map[string][]float64{"size": {10}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(repo["size:1h"]) != 0 {
+		t.Fatal("partial bucket saved before flush")
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []storage.Point{{Time: day, Values: []float64{10}}}; !reflect.DeepEqual(repo["size:1h"], want) {
+		t.Fatalf("flushed 1h: got %v, want %v", repo["size:1h"], want)
+	}
+
+	// restart right away, the same amount of samples of 20 completes the hour
+	restart := day.Add(30 * time.Minute)
+	s = New(testMetrics, repo)
+	if err := s.Load(restart); err != nil {
+		t.Fatal(err)
+	}
+	points, _, err := s.Range("size", "1h", day, restart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []storage.Point{{Time: day, Values: []float64{10}}}; !reflect.DeepEqual(points, want) {
+		t.Fatalf("resumed 1h: got %v, want %v", points, want)
+	}
+	for i := 0; i <= 180; i++ {
+		if err := s.Add(restart.Add(time.Duration(i)*10*time.Second), map[string][]float64{"size": {20}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the restored half weighs as the samples up to the restart
+	want := []storage.Point{{Time: day, Values: []float64{15}}}
+	if !reflect.DeepEqual(repo["size:1h"], want) {
+		t.Fatalf("completed 1h: got %v, want %v", repo["size:1h"], want)
+	}
+	points, _, _ = s.Range("size", "1h", day, day)
+	if !reflect.DeepEqual(points, want) {
+		t.Fatalf("completed 1h in memory: got %v, want %v", points, want)
+	}
+	// the finer buckets were complete, nothing to resume
+	points, _, _ = s.Range("size", "1m", day.Add(29*time.Minute), day.Add(31*time.Minute))
+	if len(points) != 3 || points[0].Values[0] != 10 || points[1].Values[0] != 20 {
+		t.Fatalf("1m around the restart: got %v", points)
+	}
+}
+
+func TestLoadOld(t *testing.T) {
+	repo := memRepo{}
+	s := New(testMetrics, repo)
+	for i := 0; i < 3; i++ {
+		_ = s.Add(day.Add(time.Duration(i)*time.Minute), map[string][]float64{"size": {float64(i)}})
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// restart in the next hour, the flushed hour is kept as a point
+	s = New(testMetrics, repo)
+	restart := day.Add(time.Hour + time.Minute)
+	if err := s.Load(restart); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Add(restart, map[string][]float64{"size": {7}})
+	points, _, _ := s.Range("size", "1h", day, restart)
+	want := []storage.Point{
+		{Time: day, Values: []float64{1}},
+		{Time: day.Add(time.Hour), Values: []float64{7}},
+	}
+	if !reflect.DeepEqual(points, want) {
+		t.Fatalf("got %v, want %v", points, want)
+	}
+}
+
+func TestPickResolution(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     string
+		err      error
+	}{
+		{"", now.Add(-time.Hour), now, "10s", nil},
+		// 10s keeps 6h only
+		{"", now.Add(-7 * time.Hour), now.Add(-6*time.Hour - 30*time.Minute), "1m", nil},
+		// a week of minutes is over max points
+		{"", now.Add(-6 * 24 * time.Hour), now, "1h", nil},
+		{"", now.Add(-365 * 24 * time.Hour), now, "1d", nil},
+		{"", now.Add(-50 * 365 * 24 * time.Hour), now, "1d", nil},
+		{"1m", now.Add(-24 * time.Hour), now, "1m", nil},
+		{"10s", now.Add(-10 * time.Second * MaxPoints), now, "10s", nil},
+		{"10s", now.Add(-10*time.Second*MaxPoints - 10*time.Second), now, "10s", ErrTooManyPoints},
+		{"1m", now.Add(-7 * 24 * time.Hour), now, "1m", ErrTooManyPoints},
+		{"5m", now.Add(-time.Hour), now, "", ErrUnknownResolution},
+	}
+	for _, tt := range tests {
+		r, err := pickResolution(tt.name, tt.from, tt.to)
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%q %s: got error %v, want %v", tt.name, tt.to.Sub(tt.from), err, tt.err)
+		}
+		if r.Name != tt.want {
+			t.Fatalf("%q %s: got %s, want %s", tt.name, tt.to.Sub(tt.from), r.Name, tt.want)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	repo := memRepo{}
+	s := New(testMetrics, repo)
+	for i := 0; i <= 8*60; i++ {
+		_ = s.Add(day.Add(time.Duration(i)*time.Minute), map[string][]float64{"size": {float64(i)}})
+	}
+	now := day.Add(8 * time.Hour)
+	if err := s.Trim(now); err != nil {
+		t.Fatal(err)
+	}
+	// 10s keeps 6h, coarser ones keep everything
+	from := now.Add(-6 * time.Hour)
+	for _, k := range []string{"size:10s", "size:1m", "size:1h"} {
+		if len(repo[k]) == 0 {
+			t.Fatalf("%s is empty", k)
+		}
+	}
+	if repo["size:10s"][0].Time.Before(from) {
+		t.Fatalf("10s point %s saved before %s", repo["size:10s"][0].Time, from)
+	}
+	if !repo["size:1m"][0].Time.Equal(day) {
+		t.Fatalf("1m trimmed: first point %s", repo["size:1m"][0].Time)
+	}
+	points, _, _ := s.Range("size", "10s", day, now)
+	if len(points) == 0 || points[0].Time.Before(from) {
+		t.Fatalf("10s in memory: got %d points", len(points))
+	}
+	points, _, _ = s.Range("size", "1m", day, now)
+	if len(points) != 8*60+1 {
+		t.Fatalf("1m in memory: got %d, want %d", len(points), 8*60+1)
+	}
+	// trim saves the partial buckets
+	if got := repo["size:1d"]; len(got) != 1 || !got[0].Time.Equal(day) || got[0].Values[0] != 240 {
+		t.Fatalf("partial 1d bucket: got %v", got)
+	}
+}